@@ -0,0 +1,386 @@
+// Package admission protects a node from being saturated by its clients. The
+// requests of the clients are limited by token buckets, for the whole node and
+// for each client, and the number of requests served at the same time is
+// bounded. The requests above the bound wait in a queue, from which the ones
+// of the clients are taken before the background traffic of the cluster, and
+// are shed if the queue is full or they wait for too long.
+package admission
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/sadath-12/keywave/internal/env"
+)
+
+// maxClients is the number of clients whose token buckets are kept before the
+// idle ones are forgotten.
+const maxClients = 10000
+
+// Priority is the class of a request, which decides the order the requests
+// waiting in the queue are admitted in.
+type Priority int
+
+const (
+	// PriorityClient is the class of the requests made by the clients.
+	PriorityClient Priority = iota
+	// PriorityBackground is the class of the traffic of the background tasks,
+	// such as the rebalancing, the backups and the removal of the chunks.
+	PriorityBackground
+
+	numPriorities
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityClient:
+		return "client"
+	case PriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+// Config is the configuration of the limits. The zero value disables them.
+type Config struct {
+	// Rate is the number of client requests per second admitted by the node,
+	// and Burst the number admitted at once. Zero rate disables the limit.
+	Rate  float64
+	Burst int
+	// ClientRate and ClientBurst are the same limit for each client.
+	ClientRate  float64
+	ClientBurst int
+	// MaxInFlight is the number of requests served at the same time. Zero
+	// disables the limit, along with the queue.
+	MaxInFlight int
+	// MaxQueue is the number of requests waiting to be served. The requests
+	// arriving when the queue is full are shed.
+	MaxQueue int
+	// QueueTimeout is the longest time a request waits in the queue. Zero
+	// means the request waits until its deadline.
+	QueueTimeout time.Duration
+	// BackgroundShare is the fraction of MaxInFlight the background traffic
+	// may use, so that the rest is left to the clients. At least one
+	// background request is served at a time.
+	BackgroundShare float64
+}
+
+// Stats is a snapshot of the state of the controller.
+type Stats struct {
+	InFlight           int   `json:"in_flight"`
+	InFlightBackground int   `json:"in_flight_background"`
+	Queued             int   `json:"queued"`
+	Admitted           int64 `json:"admitted"`
+	RateLimited        int64 `json:"rate_limited"`
+	Shed               int64 `json:"shed"`
+}
+
+type waiter struct {
+	priority Priority
+	ready    chan struct{}
+	admitted bool
+}
+
+// Controller decides whether the requests are admitted. It is safe for
+// concurrent use.
+type Controller struct {
+	conf  Config
+	clock env.Clock
+	// gateway is the secret of the calls made by the HTTP gateway of the node.
+	gateway string
+
+	mut      sync.Mutex
+	global   *bucket
+	clients  map[string]*bucket
+	inFlight [numPriorities]int
+	queue    [numPriorities][]*waiter
+	queued   int
+
+	admitted, rateLimited, shed atomic.Int64
+}
+
+// New returns a controller enforcing the limits of the configuration.
+func New(conf Config, clock env.Clock) *Controller {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	c := &Controller{
+		conf:    conf,
+		clock:   clock,
+		gateway: hex.EncodeToString(secret),
+		clients: make(map[string]*bucket),
+	}
+
+	if conf.Rate > 0 {
+		c.global = newBucket(conf.Rate, conf.Burst, clock.Now())
+	}
+
+	return c
+}
+
+// rejected returns the error of a request that has not been admitted, with
+// the time after which it may be retried.
+func rejected(msg string, retryAfter time.Duration) error {
+	st, err := status.New(codes.ResourceExhausted, msg).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	})
+
+	if err != nil {
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+
+	return st.Err()
+}
+
+// RetryAfter reports whether the error is the rejection of a request by the
+// admission control, and returns the time after which it may be retried. The
+// other ResourceExhausted errors, such as the exceeded quotas, are not retried.
+func RetryAfter(err error) (time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return 0, false
+	}
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+
+	return 0, false
+}
+
+// Admit admits a request of the client, waiting in the queue if the node is
+// busy. The client is only used for the requests of PriorityClient, which are
+// subject to the rate limits. The returned function must be called once the
+// request is served.
+func (c *Controller) Admit(ctx context.Context, p Priority, client string) (func(), error) {
+	c.mut.Lock()
+
+	if p == PriorityClient {
+		if delay := c.takeToken(client); delay > 0 {
+			c.mut.Unlock()
+			c.rateLimited.Add(1)
+
+			return nil, rejected("rate limit exceeded", delay)
+		}
+	}
+
+	if c.canRun(p) && !c.waiting(p) {
+		c.inFlight[p]++
+		c.mut.Unlock()
+		c.admitted.Add(1)
+
+		return c.releaseFunc(p), nil
+	}
+
+	if c.queued >= c.conf.MaxQueue {
+		c.mut.Unlock()
+		c.shed.Add(1)
+
+		return nil, rejected("node is overloaded", c.retryDelay())
+	}
+
+	w := &waiter{priority: p, ready: make(chan struct{})}
+	c.queue[p] = append(c.queue[p], w)
+	c.queued++
+	c.mut.Unlock()
+
+	timeout := make(chan struct{})
+
+	if c.conf.QueueTimeout > 0 {
+		t := c.clock.AfterFunc(c.conf.QueueTimeout, func() { close(timeout) })
+		defer t.Stop()
+	}
+
+	select {
+	case <-w.ready:
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	// The request may have been admitted while it was giving up.
+	if w.admitted {
+		c.admitted.Add(1)
+		return c.releaseFunc(p), nil
+	}
+
+	c.dequeue(w)
+	c.shed.Add(1)
+
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	return nil, rejected("request timed out in the admission queue", c.retryDelay())
+}
+
+// Stats returns a snapshot of the state of the controller.
+func (c *Controller) Stats() Stats {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return Stats{
+		InFlight:           c.inFlight[PriorityClient] + c.inFlight[PriorityBackground],
+		InFlightBackground: c.inFlight[PriorityBackground],
+		Queued:             c.queued,
+		Admitted:           c.admitted.Load(),
+		RateLimited:        c.rateLimited.Load(),
+		Shed:               c.shed.Load(),
+	}
+}
+
+// takeToken takes a token from the buckets of the client and of the node, or
+// returns the time until both have one.
+func (c *Controller) takeToken(client string) time.Duration {
+	var (
+		now = c.clock.Now()
+		b   *bucket
+	)
+
+	if c.conf.ClientRate > 0 {
+		b = c.clients[client]
+
+		if b == nil {
+			if len(c.clients) >= maxClients {
+				c.forgetIdleClients(now)
+			}
+
+			b = newBucket(c.conf.ClientRate, c.conf.ClientBurst, now)
+			c.clients[client] = b
+		}
+	}
+
+	var delay time.Duration
+
+	if b != nil {
+		delay = b.delay(now)
+	}
+
+	if c.global != nil {
+		delay = max(delay, c.global.delay(now))
+	}
+
+	if delay > 0 {
+		return delay
+	}
+
+	if b != nil {
+		b.take()
+	}
+
+	if c.global != nil {
+		c.global.take()
+	}
+
+	return 0
+}
+
+func (c *Controller) forgetIdleClients(now time.Time) {
+	for client, b := range c.clients {
+		if b.full(now) {
+			delete(c.clients, client)
+		}
+	}
+}
+
+// retryDelay is the time after which a shed request may be retried.
+func (c *Controller) retryDelay() time.Duration {
+	if c.conf.QueueTimeout > 0 {
+		return c.conf.QueueTimeout
+	}
+
+	return time.Second
+}
+
+// canRun reports whether a request of the priority fits in the bound of the
+// requests served at the same time.
+func (c *Controller) canRun(p Priority) bool {
+	if c.conf.MaxInFlight <= 0 {
+		return true
+	}
+
+	if c.inFlight[PriorityClient]+c.inFlight[PriorityBackground] >= c.conf.MaxInFlight {
+		return false
+	}
+
+	if p == PriorityBackground {
+		limit := max(1, int(float64(c.conf.MaxInFlight)*c.conf.BackgroundShare))
+		return c.inFlight[PriorityBackground] < limit
+	}
+
+	return true
+}
+
+// waiting reports whether there are queued requests to be admitted before a
+// request of the priority.
+func (c *Controller) waiting(p Priority) bool {
+	for q := PriorityClient; q <= p; q++ {
+		if len(c.queue[q]) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Controller) dequeue(w *waiter) {
+	queue := c.queue[w.priority]
+
+	for i := range queue {
+		if queue[i] == w {
+			c.queue[w.priority] = append(queue[:i], queue[i+1:]...)
+			c.queued--
+
+			return
+		}
+	}
+}
+
+func (c *Controller) releaseFunc(p Priority) func() {
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			c.mut.Lock()
+			defer c.mut.Unlock()
+
+			c.inFlight[p]--
+			c.dispatch()
+		})
+	}
+}
+
+// dispatch admits the queued requests that fit, the clients first.
+func (c *Controller) dispatch() {
+	for p := PriorityClient; p < numPriorities; p++ {
+		for len(c.queue[p]) > 0 && c.canRun(p) {
+			w := c.queue[p][0]
+			c.queue[p] = c.queue[p][1:]
+			c.queued--
+			c.inFlight[p]++
+
+			w.admitted = true
+			close(w.ready)
+		}
+
+		if len(c.queue[p]) > 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package admission
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/internal/env"
+)
+
+var start = time.Unix(1_700_000_000, 0)
+
+func admit(t *testing.T, c *Controller, p Priority, client string) func() {
+	t.Helper()
+
+	release, err := c.Admit(context.Background(), p, client)
+	if err != nil {
+		t.Fatalf("%s request of %q: %v", p, client, err)
+	}
+
+	return release
+}
+
+func expectRejected(t *testing.T, err error, retryAfter time.Duration) {
+	t.Helper()
+
+	after, ok := RetryAfter(err)
+	if !ok || status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected a rejection, got %v", err)
+	}
+
+	if after != retryAfter {
+		t.Errorf("expected a retry after %v, got %v", retryAfter, after)
+	}
+}
+
+// waitQueued waits until the number of requests in the queue is n.
+func waitQueued(t *testing.T, c *Controller, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for c.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued requests, got %d", n, c.Stats().Queued)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	clock := env.NewSimClock(start)
+	c := New(Config{Rate: 10, Burst: 3, ClientRate: 1, ClientBurst: 2}, clock)
+
+	admit(t, c, PriorityClient, "a")()
+	admit(t, c, PriorityClient, "a")()
+
+	// The client has used its burst, while the others still have theirs.
+	_, err := c.Admit(context.Background(), PriorityClient, "a")
+	expectRejected(t, err, time.Second)
+
+	admit(t, c, PriorityClient, "b")()
+
+	// The node has used its burst.
+	_, err = c.Admit(context.Background(), PriorityClient, "c")
+	expectRejected(t, err, 100*time.Millisecond)
+
+	// The background traffic is not rate limited.
+	admit(t, c, PriorityBackground, "")()
+
+	clock.Advance(time.Second)
+
+	admit(t, c, PriorityClient, "a")()
+
+	if stats := c.Stats(); stats.RateLimited != 2 || stats.Admitted != 5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestIdleClientsForgotten(t *testing.T) {
+	clock := env.NewSimClock(start)
+	c := New(Config{ClientRate: 1, ClientBurst: 1}, clock)
+
+	for i := 0; i < maxClients; i++ {
+		c.clients[string(rune(i))] = newBucket(1, 1, start)
+	}
+
+	admit(t, c, PriorityClient, "busy")()
+	clock.Advance(time.Second)
+	admit(t, c, PriorityClient, "new")()
+
+	if len(c.clients) != 2 {
+		t.Errorf("expected the idle clients to be forgotten, got %d clients", len(c.clients))
+	}
+}
+
+func TestQueue(t *testing.T) {
+	c := New(Config{MaxInFlight: 3, MaxQueue: 2, BackgroundShare: 0.4}, env.RealClock{})
+
+	release1 := admit(t, c, PriorityBackground, "")
+	release2 := admit(t, c, PriorityClient, "a")
+	release3 := admit(t, c, PriorityClient, "x")
+
+	type result struct {
+		p       Priority
+		release func()
+	}
+
+	results := make(chan result, 2)
+
+	// The background request is queued first, but the client request is
+	// admitted before it.
+	go func() { results <- result{PriorityBackground, admit(t, c, PriorityBackground, "")} }()
+	waitQueued(t, c, 1)
+
+	go func() { results <- result{PriorityClient, admit(t, c, PriorityClient, "b")} }()
+	waitQueued(t, c, 2)
+
+	// The queue is full.
+	_, err := c.Admit(context.Background(), PriorityClient, "c")
+	expectRejected(t, err, time.Second)
+
+	release2()
+
+	if res := <-results; res.p != PriorityClient {
+		t.Fatalf("expected the client request to be admitted, got %s", res.p)
+	} else {
+		defer res.release()
+	}
+
+	// The background requests are limited to their share, so the second one
+	// waits for the first even though there is room for a client request.
+	release3()
+	admit(t, c, PriorityClient, "d")()
+
+	release1()
+
+	if res := <-results; res.p != PriorityBackground {
+		t.Fatalf("expected the background request to be admitted, got %s", res.p)
+	} else {
+		res.release()
+	}
+
+	if stats := c.Stats(); stats.InFlight != 1 || stats.InFlightBackground != 0 || stats.Queued != 0 || stats.Shed != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	clock := env.NewSimClock(start)
+	c := New(Config{MaxInFlight: 1, MaxQueue: 10, QueueTimeout: time.Second}, clock)
+
+	release := admit(t, c, PriorityClient, "a")
+	defer release()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := c.Admit(context.Background(), PriorityClient, "b")
+		errs <- err
+	}()
+
+	waitQueued(t, c, 1)
+
+	for clock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(time.Second)
+
+	expectRejected(t, <-errs, time.Second)
+
+	// A request whose context is canceled leaves the queue with the error of
+	// the context.
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		_, err := c.Admit(ctx, PriorityClient, "b")
+		errs <- err
+	}()
+
+	waitQueued(t, c, 1)
+	cancel()
+
+	if err := <-errs; status.Code(err) != codes.Canceled {
+		t.Errorf("expected the request to be canceled, got %v", err)
+	}
+
+	if queued := c.Stats().Queued; queued != 0 {
+		t.Errorf("expected an empty queue, got %d requests", queued)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if _, ok := RetryAfter(status.Error(codes.ResourceExhausted, "namespace key quota exceeded")); ok {
+		t.Error("expected a quota error not to be retried")
+	}
+
+	if after, ok := RetryAfter(rejected("rate limit exceeded", 1500*time.Millisecond)); !ok || after != 1500*time.Millisecond {
+		t.Errorf("unexpected retry after %v, %v", after, ok)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	methods := map[string]struct{}{"/replication.Replication/Get": {}}
+
+	var (
+		background = metadata.NewIncomingContext(context.Background(), metadata.Pairs(priorityHeader, "background"))
+		admitted   = metadata.NewIncomingContext(context.Background(), metadata.Pairs(priorityHeader, admittedPriority))
+	)
+
+	tests := []struct {
+		ctx      context.Context
+		method   string
+		priority Priority
+		admitted bool
+	}{
+		{context.Background(), "/replication.Replication/Get", PriorityClient, true},
+		// The clients cannot lower their priority.
+		{background, "/replication.Replication/Get", PriorityClient, true},
+		{context.Background(), "/storage.StorageService/Put", PriorityBackground, true},
+		{background, "/storage.StorageService/Scan", PriorityBackground, true},
+		{admitted, "/storage.StorageService/Put", 0, false},
+		{context.Background(), "/membership.Membership/Ping", 0, false},
+		{background, "/membership.Membership/PullPushState", PriorityBackground, true},
+	}
+
+	for _, tt := range tests {
+		p, ok := classify(tt.ctx, tt.method, methods)
+		if p != tt.priority || ok != tt.admitted {
+			t.Errorf("classify(%s) = %s, %v", tt.method, p, ok)
+		}
+	}
+
+	// The requests made while serving an admitted request are not admitted
+	// again, even from a task marking its context as background before.
+	md, _ := metadata.FromOutgoingContext(withAdmitted(WithPriority(context.Background(), PriorityBackground)))
+	if p, ok := classify(metadata.NewIncomingContext(context.Background(), md), "/storage.StorageService/Put", methods); ok {
+		t.Errorf("expected the request not to be admitted, got %s", p)
+	}
+
+	// The priority set on the outgoing context is read from the incoming one.
+	md, _ = metadata.FromOutgoingContext(WithPriority(context.Background(), PriorityBackground))
+	if p, ok := classify(metadata.NewIncomingContext(context.Background(), md), "/membership.Membership/PullPushState", methods); !ok || p != PriorityBackground {
+		t.Errorf("expected the background priority, got %s, %v", p, ok)
+	}
+}
+
+func TestClientID(t *testing.T) {
+	c := New(Config{}, env.RealClock{})
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321},
+	})
+
+	if id := c.clientID(ctx); id != "addr:10.0.0.1" {
+		t.Errorf("unexpected id of the peer: %q", id)
+	}
+
+	// The forwarded address is not trusted on the calls of the other clients.
+	spoofed := metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "192.168.1.2", gatewayHeader, "guess"))
+	if id := c.clientID(spoofed); id != "addr:10.0.0.1" {
+		t.Errorf("unexpected id of the client forwarding an address: %q", id)
+	}
+
+	// The gateway appends the address of the connection to the addresses
+	// forwarded by its client.
+	gateway, _ := c.GatewayCredentials().GetRequestMetadata(context.Background())
+	md := metadata.Join(metadata.New(gateway), metadata.Pairs("x-forwarded-for", "172.16.0.1, 192.168.1.2"))
+
+	ctx = metadata.NewIncomingContext(ctx, md)
+	if id := c.clientID(ctx); id != "addr:192.168.1.2" {
+		t.Errorf("unexpected id of the forwarded client: %q", id)
+	}
+
+	// The other front-ends forward the address of the connection of their
+	// client the same way.
+	forwarded, _ := metadata.FromOutgoingContext(ForwardClient(context.Background(), "192.168.1.3:5000"))
+	if id := c.clientID(metadata.NewIncomingContext(ctx, metadata.Join(metadata.New(gateway), forwarded))); id != "addr:192.168.1.3" {
+		t.Errorf("unexpected id of the client of a front-end: %q", id)
+	}
+
+	ctx = auth.NewContext(ctx, "token", &auth.Identity{Subject: "billing"})
+	if id := c.clientID(ctx); id != "subject:billing" {
+		t.Errorf("unexpected id of the authenticated client: %q", id)
+	}
+}
+
+func TestSpoofedAddressesShareBucket(t *testing.T) {
+	c := New(Config{ClientRate: 1, ClientBurst: 1}, env.NewSimClock(start))
+	interceptor := UnaryServerInterceptor(c, map[string]struct{}{"/replication.Replication/Get": {}})
+	info := &grpc.UnaryServerInfo{FullMethod: "/replication.Replication/Get"}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4321},
+	})
+
+	handler := func(ctx context.Context, req any) (any, error) { return nil, nil }
+
+	// Every request claims to be forwarded for another client, but they all
+	// come from the same peer, so they are limited together.
+	for i, addr := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+		md := metadata.Pairs("x-forwarded-for", addr, gatewayHeader, "guess")
+
+		_, err := interceptor(metadata.NewIncomingContext(ctx, md), nil, info, handler)
+		if i == 0 && err != nil {
+			t.Fatalf("request of %s: %v", addr, err)
+		}
+
+		if i > 0 {
+			expectRejected(t, err, time.Second)
+		}
+	}
+}
+
+func TestAdmittedRequestsMarked(t *testing.T) {
+	c := New(Config{}, env.RealClock{})
+	interceptor := UnaryServerInterceptor(c, map[string]struct{}{"/replication.Replication/Put": {}})
+
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/replication.Replication/Put"},
+		func(ctx context.Context, req any) (any, error) {
+			// The writes to the replicas are not admitted again.
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if p, ok := classify(metadata.NewIncomingContext(context.Background(), md), "/storage.StorageService/Put", nil); ok {
+				t.Errorf("expected the write to the replica not to be admitted, got %s", p)
+			}
+
+			return nil, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package admission
+
+import (
+	"time"
+)
+
+// bucket is a token bucket refilled at a constant rate up to its burst size.
+type bucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+// newBucket returns a full bucket. The burst is at least one token, so that a
+// request can ever be admitted.
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	b := float64(max(burst, 1))
+
+	return &bucket{rate: rate, burst: b, tokens: b, updated: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.updated = now
+	}
+}
+
+// delay returns the time until a token is available, zero if there is one.
+func (b *bucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take removes a token, which must be available.
+func (b *bucket) take() {
+	b.tokens--
+}
+
+// full reports whether the bucket has not been used for as long as it takes
+// to refill, in which case it can be forgotten.
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+
+	return b.tokens >= b.burst
+}
@@ -0,0 +1,239 @@
+package admission
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/sadath-12/keywave/auth"
+)
+
+// priorityHeader is the metadata key overriding the priority of the requests
+// to the internal methods.
+const priorityHeader = "x-keywave-priority"
+
+// admittedPriority is the value of the priority header of the requests made
+// while serving a request already admitted, such as the reads and the writes
+// of the coordinator on the replicas, which are not admitted again.
+const admittedPriority = "admitted"
+
+// storageMethods is the prefix of the methods of the storage service, the
+// internal methods subject to the admission.
+const storageMethods = "/storage.StorageService/"
+
+// gatewayHeader is the metadata key carrying the secret of the calls made by
+// the HTTP gateway of the node.
+const gatewayHeader = "x-keywave-gateway"
+
+// forwardedHeader is the metadata key carrying the addresses of the clients on
+// whose behalf the calls are made.
+const forwardedHeader = "x-forwarded-for"
+
+// WithPriority returns a copy of the context whose outgoing requests carry the
+// priority, so that the nodes receiving them admit them accordingly.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, priorityHeader, p.String())
+}
+
+// GatewayCredentials returns the credentials of the connection of the HTTP
+// gateway and of the other front-ends to the node. The addresses of the clients
+// forwarded by the front-ends are only trusted on the calls carrying them, so
+// that the other callers cannot pass for other clients. The secret is only
+// known to the process.
+func (c *Controller) GatewayCredentials() credentials.PerRPCCredentials {
+	return gatewayCredentials(c.gateway)
+}
+
+// ForwardClient returns a copy of the context whose outgoing requests are made
+// on behalf of the client at the address, so that the clients of a front-end
+// are admitted under their own rate limits, as the ones of the HTTP gateway.
+// The address is only trusted on the connections with the GatewayCredentials.
+func ForwardClient(ctx context.Context, addr string) context.Context {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, forwardedHeader, host)
+}
+
+type gatewayCredentials string
+
+func (g gatewayCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{gatewayHeader: string(g)}, nil
+}
+
+func (g gatewayCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor admitting the requests to
+// the given methods, which are the ones called by the clients, with
+// PriorityClient. The requests to the methods of the storage service are
+// admitted with PriorityBackground, unless they are made while serving a
+// request already admitted, which the interceptor marks on the outgoing
+// requests of the handler. The other internal methods, such as the ones of the
+// failure detection, are not admitted, unless the requests carry an explicit
+// PriorityBackground.
+//
+// The interceptor must follow the authentication, so that the clients are
+// told apart by their identity. Without it, they are told apart by the
+// address they connect from, as forwarded by the HTTP gateway for its calls.
+func UnaryServerInterceptor(c *Controller, clientMethods map[string]struct{}) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		p, ok := classify(ctx, info.FullMethod, clientMethods)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		release, err := c.Admit(ctx, p, c.clientID(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		defer release()
+
+		return handler(withAdmitted(ctx), req)
+	}
+}
+
+// StreamServerInterceptor is the counterpart of UnaryServerInterceptor for the
+// streaming methods. The stream is admitted once its first message is received,
+// when the identity of the client is known, and holds its place until it ends.
+func StreamServerInterceptor(c *Controller, clientMethods map[string]struct{}) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		p, ok := classify(ss.Context(), info.FullMethod, clientMethods)
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		as := &admittedStream{ServerStream: ss, controller: c, priority: p}
+		defer as.release()
+
+		return handler(srv, as)
+	}
+}
+
+type admittedStream struct {
+	grpc.ServerStream
+	controller *Controller
+	priority   Priority
+	done       func()
+	ctx        context.Context
+}
+
+func (s *admittedStream) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+
+	return s.ServerStream.Context()
+}
+
+func (s *admittedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if s.done == nil {
+		ctx := s.Context()
+
+		done, err := s.controller.Admit(ctx, s.priority, s.controller.clientID(ctx))
+		if err != nil {
+			return err
+		}
+
+		s.done = done
+		s.ctx = withAdmitted(ctx)
+	}
+
+	return nil
+}
+
+func (s *admittedStream) release() {
+	if s.done != nil {
+		s.done()
+	}
+}
+
+// fromGateway reports whether the call carries the secret of the gateway. The
+// clients of the gateway may forward the same key, so the secret is looked up
+// among all its values.
+func (c *Controller) fromGateway(md metadata.MD) bool {
+	for _, value := range md.Get(gatewayHeader) {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(c.gateway)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withAdmitted returns a copy of the context whose outgoing requests are not
+// admitted again by the nodes receiving them.
+func withAdmitted(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, priorityHeader, admittedPriority)
+}
+
+// classify returns the priority of the request to the method, or false if the
+// request is not subject to the admission. The priority of the clients cannot
+// be overridden, while the header of the internal methods, whose callers are
+// the nodes and the administrators, takes precedence over the default. The
+// last value of the header wins, as the tasks may mark the contexts derived
+// from the ones already marked.
+func classify(ctx context.Context, method string, clientMethods map[string]struct{}) (Priority, bool) {
+	if _, ok := clientMethods[method]; ok {
+		return PriorityClient, true
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(priorityHeader); len(values) > 0 {
+			switch values[len(values)-1] {
+			case PriorityBackground.String():
+				return PriorityBackground, true
+			case admittedPriority:
+				return 0, false
+			}
+		}
+	}
+
+	if strings.HasPrefix(method, storageMethods) {
+		return PriorityBackground, true
+	}
+
+	return 0, false
+}
+
+// clientID returns the name the rate limits of the client are kept under. The
+// forwarded address is only used on the calls of the gateway, which appends the
+// address of the connection to the addresses forwarded by the client itself,
+// so only the last one is trusted.
+func (c *Controller) clientID(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok && id.Subject != "" {
+		return "subject:" + id.Subject
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok && c.fromGateway(md) {
+		if values := md.Get(forwardedHeader); len(values) > 0 {
+			hops := strings.Split(values[len(values)-1], ",")
+			return "addr:" + strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+
+		return "addr:" + host
+	}
+
+	return ""
+}
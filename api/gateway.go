@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/api/handler"
+	replicationpb "github.com/sadath-12/keywave/replication/proto"
+)
+
+// newGateway returns the HTTP/JSON gateway generated from the annotations of
+// replication.proto, which forwards the requests to the replication service
+// through the client, along with the authorization header. The fields keep the
+// names of the proto definition, as in the OpenAPI description.
+func newGateway(client replicationpb.ReplicationClient) http.Handler {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				UseProtoNames:   true,
+				EmitUnpopulated: true,
+			},
+			UnmarshalOptions: protojson.UnmarshalOptions{
+				DiscardUnknown: true,
+			},
+		}),
+		runtime.WithErrorHandler(gatewayError),
+	)
+
+	if err := replicationpb.RegisterReplicationHandlerClient(context.Background(), mux, client); err != nil {
+		panic(fmt.Sprintf("failed to register replication gateway: %v", err))
+	}
+
+	return mux
+}
+
+// gatewayError writes the error with the default status codes of the gateway,
+// which sheds the requests rejected by the admission control with 429, and
+// tells the client when to retry them.
+func gatewayError(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	if after, ok := admission.RetryAfter(err); ok {
+		handler.SetRetryAfter(w, after)
+	}
+
+	runtime.DefaultHTTPErrorHandler(ctx, mux, m, w, r, err)
+}
+
+// serveOpenAPI writes the OpenAPI description of the gateway.
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(replicationpb.OpenAPI)
+}
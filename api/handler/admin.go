@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/sadath-12/keywave/api/model"
+	"github.com/sadath-12/keywave/hotkeys"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/rebalance"
+)
+
+// defaultHotKeysLimit is the number of keys listed by /admin/hotkeys unless
+// the limit is given.
+const defaultHotKeysLimit = 20
+
+type AdminHandler struct {
+	cluster        membership.Cluster
+	decommissioner *rebalance.Decommissioner
+	hotKeys        *hotkeys.Detector
+}
+
+func NewAdminHandler(cluster membership.Cluster, decommissioner *rebalance.Decommissioner, hotKeys *hotkeys.Detector) *AdminHandler {
+	return &AdminHandler{
+		cluster:        cluster,
+		decommissioner: decommissioner,
+		hotKeys:        hotKeys,
+	}
+}
+
+func (api *AdminHandler) Register(r chi.Router) {
+	r.Get("/admin/decommission", api.getDecommission)
+	r.Post("/admin/decommission", api.startDecommission)
+	r.Delete("/admin/nodes/{id}", api.removeNode)
+	r.Get("/admin/hotkeys", api.getHotKeys)
+}
+
+func toDecommissionProgress(p rebalance.Progress) model.DecommissionProgress {
+	res := model.DecommissionProgress{
+		State:       string(p.State),
+		KeysScanned: p.KeysScanned,
+		KeysSent:    p.KeysSent,
+		Failed:      p.Failed,
+		Error:       p.Error,
+	}
+
+	if !p.StartedAt.IsZero() {
+		res.StartedAt = &p.StartedAt
+	}
+
+	if !p.FinishedAt.IsZero() {
+		res.FinishedAt = &p.FinishedAt
+	}
+
+	return res
+}
+
+func (api *AdminHandler) getDecommission(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, toDecommissionProgress(api.decommissioner.Progress()))
+}
+
+func (api *AdminHandler) startDecommission(w http.ResponseWriter, r *http.Request) {
+	// The decommission outlives the request, so it must not use the request context.
+	if err := api.decommissioner.Start(context.Background()); err != nil {
+		if errors.Is(err, rebalance.ErrAlreadyRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, toDecommissionProgress(api.decommissioner.Progress()))
+}
+
+// removeNode forcibly removes a node that is never going to return, e.g. after
+// its disk was lost. Nodes that are still running must be decommissioned instead.
+func (api *AdminHandler) removeNode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid node id", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.cluster.Remove(membership.NodeID(id)); err != nil {
+		switch {
+		case errors.Is(err, membership.ErrNodeNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, membership.ErrRemoveSelf):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getHotKeys lists the keys read the most often through the local node, with
+// the estimated rates of their reads per second.
+func (api *AdminHandler) getHotKeys(w http.ResponseWriter, r *http.Request) {
+	limit := defaultHotKeysLimit
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		limit = n
+	}
+
+	var (
+		top       = api.hotKeys.Top(limit)
+		threshold = api.hotKeys.Threshold()
+		res       = make([]model.HotKey, 0, len(top))
+	)
+
+	for _, k := range top {
+		ns, key, _ := namespace.SplitKey(k.Key)
+
+		res = append(res, model.HotKey{
+			Namespace: ns,
+			Key:       key,
+			Rate:      k.Rate,
+			Hot:       threshold > 0 && k.Rate >= threshold,
+		})
+	}
+
+	render.JSON(w, r, res)
+}
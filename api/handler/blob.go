@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/api/model"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// versionHeader carries the version of the raw values, which have no room for
+// it in the body.
+const versionHeader = "X-Version"
+
+// errResponded stops the stream once the response has been written.
+var errResponded = errors.New("response written")
+
+// putBlob stores the request body as the raw value of the key. The body is
+// streamed, so it may be sent with the chunked transfer encoding and exceed
+// the limits of the regular values. The version is taken from the X-Version
+// header or the "version" query parameter.
+func (api *KeyValueHandler) putBlob(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	ctx := admission.ForwardClient(auth.OutgoingContext(r.Context()), r.RemoteAddr)
+
+	version := r.Header.Get(versionHeader)
+	if version == "" {
+		version = r.URL.Query().Get("version")
+	}
+
+	res, err := api.local.PutKeyStream(ctx, key, r.Body, version, keyOpts(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(sessionHeader, res.SessionToken)
+	render.JSON(w, r, &model.PutKeyResponse{
+		Acknowledged: res.Acknowledged,
+		Version:      res.Version,
+		SessionToken: res.SessionToken,
+	})
+}
+
+// getBlob streams the raw value of the key, with its version in the X-Version
+// header. A key with concurrent values cannot be streamed as a single body, so
+// it results in a conflict, and is to be resolved with a write.
+func (api *KeyValueHandler) getBlob(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	ctx := admission.ForwardClient(auth.OutgoingContext(r.Context()), r.RemoteAddr)
+	started := false
+
+	err := api.local.GetKeyStream(ctx, key, keyOpts(r), func(part nodeapi.KeyPart) error {
+		if !started {
+			started = true
+
+			switch {
+			case part.Count == 0:
+				http.Error(w, "key not found", http.StatusNotFound)
+				return errResponded
+			case part.Count > 1:
+				w.Header().Set(versionHeader, part.Version)
+				http.Error(w, fmt.Sprintf("key has %d concurrent values", part.Count), http.StatusConflict)
+
+				return errResponded
+			}
+
+			w.Header().Set(versionHeader, part.Version)
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.WriteHeader(http.StatusOK)
+		}
+
+		_, err := w.Write(part.Data)
+
+		return err
+	})
+
+	switch {
+	case err == nil, errors.Is(err, errResponded):
+	case !started:
+		writeError(w, err)
+	default:
+		// The status has already been sent, so the connection is aborted for
+		// the client not to mistake the partial body for the whole value.
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -1,87 +1,247 @@
 package handler
 
 import (
-	"context"
-	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"google.golang.org/grpc/codes"
 
+	"github.com/sadath-12/keywave/admission"
 	"github.com/sadath-12/keywave/api/model"
-	"github.com/sadath-12/keywave/nodeapi/grpc"
-	storagepb "github.com/sadath-12/keywave/storage/proto"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/nodeapi"
 )
 
+// KeyValueHandler serves the raw values of the keys, streamed in the bodies,
+// which the gateway of the replication API cannot do. The keys are otherwise
+// read and written through the gateway. The deprecated routes of the earlier
+// API are still served, as their bodies differ from the ones of the gateway,
+// apart from the ones of the raw values, which are redirected.
 type KeyValueHandler struct {
+	local nodeapi.Client
 }
 
-func NewKeyValueHandler() *KeyValueHandler {
-	return &KeyValueHandler{}
+// NewKeyValueHandler returns the handler calling the local node over the
+// connection, which carries the credentials of the gateway, so that the calls
+// are admitted under the rate limits of the clients.
+func NewKeyValueHandler(local nodeapi.Client) *KeyValueHandler {
+	return &KeyValueHandler{
+		local: local,
+	}
 }
 
 func (api *KeyValueHandler) Register(r chi.Router) {
+	// The raw values are deleted the same way as the regular ones.
+	r.Get("/v1/blobs/{key}", api.getBlob)
+	r.Put("/v1/blobs/{key}", api.putBlob)
+	r.Get("/v1/namespaces/{namespace}/blobs/{key}", api.getBlob)
+	r.Put("/v1/namespaces/{namespace}/blobs/{key}", api.putBlob)
+
+	// Deprecated: the routes of the earlier API point to their counterparts
+	// under /v1, whose bodies follow the OpenAPI description.
 	r.Get("/kv/{key}", api.getKey)
 	r.Put("/kv/{key}", api.putKey)
+	r.Delete("/kv/{key}", api.deleteKey)
+	r.Get("/ns/{namespace}/kv/{key}", api.getKey)
+	r.Put("/ns/{namespace}/kv/{key}", api.putKey)
+	r.Delete("/ns/{namespace}/kv/{key}", api.deleteKey)
+	r.HandleFunc("/blob/{key}", redirect("blobs"))
+	r.HandleFunc("/ns/{namespace}/blob/{key}", redirect("blobs"))
+}
+
+// successor returns the route under /v1 replacing the deprecated one. The
+// "level" and "session" query parameters are renamed after the fields of the
+// requests of the gateway.
+func successor(r *http.Request, resource string) string {
+	target := "/v1"
+	if ns := chi.URLParam(r, "namespace"); ns != "" {
+		target += "/namespaces/" + url.PathEscape(ns)
+	}
+
+	target += "/" + resource + "/" + url.PathEscape(chi.URLParam(r, "key"))
+
+	query := r.URL.Query()
+
+	if level := query.Get("level"); level != "" {
+		query.Del("level")
+		query.Set("consistency", strings.ToUpper(level))
+	}
+
+	if session := query.Get("session"); session != "" {
+		query.Del("session")
+		query.Set("session_token", session)
+	}
+
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	return target
+}
+
+// deprecate marks the response of a deprecated route, pointing the client to
+// its successor.
+func deprecate(w http.ResponseWriter, target string) {
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "<"+target+`>; rel="successor-version"`)
+}
+
+// redirect returns the handler redirecting a deprecated route to its
+// counterpart under /v1 with the method and the body kept, which is only
+// possible when the bodies of both are the same.
+func redirect(resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := successor(r, resource)
+
+		deprecate(w, target)
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}
+}
+
+// sessionHeader carries the session token returned by a write to the next
+// reads and writes of the session, as an alternative to the "session" query
+// parameter.
+const sessionHeader = "X-Session-Token"
+
+// keyOpts extracts the namespace from the URL, the consistency level from the
+// "level" query parameter and the session token from the "session" query
+// parameter or the X-Session-Token header. All of them are optional.
+func keyOpts(r *http.Request) nodeapi.KeyOpts {
+	session := r.URL.Query().Get("session")
+	if session == "" {
+		session = r.Header.Get(sessionHeader)
+	}
+
+	return nodeapi.KeyOpts{
+		Namespace:    chi.URLParam(r, "namespace"),
+		Level:        r.URL.Query().Get("level"),
+		SessionToken: session,
+	}
+}
+
+// SetRetryAfter sets the Retry-After header to the delay, in whole seconds.
+func SetRetryAfter(w http.ResponseWriter, after time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(after.Seconds()))))
+}
+
+// writeError converts an error returned by the replication service into
+// an HTTP response with the matching status code. The requests rejected by
+// the admission control are told when to retry, unlike the exceeded quotas.
+func writeError(w http.ResponseWriter, err error) {
+	if after, ok := admission.RetryAfter(err); ok {
+		SetRetryAfter(w, after)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+
+		return
+	}
+
+	var code int
+
+	switch grpcutil.ErrorCode(err) {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.ResourceExhausted:
+		code = http.StatusInsufficientStorage
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.AlreadyExists:
+		code = http.StatusConflict
+	case codes.FailedPrecondition, codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	default:
+		code = http.StatusInternalServerError
+	}
+
+	http.Error(w, err.Error(), code)
 }
 
 func (api *KeyValueHandler) putKey(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
-	ctx := context.Background()
-	conn, err := grpc.Dial(ctx, "127.0.0.1:3000")
+	ctx := admission.ForwardClient(auth.OutgoingContext(r.Context()), r.RemoteAddr)
 
-	if err != nil {
-		fmt.Println("err: ",err)
-	}
+	deprecate(w, successor(r, "keys"))
 
 	var params model.PutKeyParams
 	if err := render.DecodeJSON(r.Body, &params); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	res, err := conn.Put(ctx, &storagepb.PutRequest{
-		Key: key,
-		Value: &storagepb.VersionedValue{
-			Data:    []byte(params.Value),
-			Version: params.Version,
-		},
-	})
 
+	res, err := api.local.PutKey(ctx, key, []byte(params.Value), params.Version, keyOpts(r))
 	if err != nil {
-		fmt.Println("err", err)
+		writeError(w, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
+	w.Header().Set(sessionHeader, res.SessionToken)
 	render.JSON(w, r, &model.PutKeyResponse{
-		Acknowledged: 1,
+		Acknowledged: res.Acknowledged,
 		Version:      res.Version,
+		SessionToken: res.SessionToken,
 	})
 }
 
 func (api *KeyValueHandler) getKey(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
-	ctx := context.Background()
-	conn, err := grpc.Dial(ctx, "127.0.0.1:3000")
+	ctx := admission.ForwardClient(auth.OutgoingContext(r.Context()), r.RemoteAddr)
+
+	deprecate(w, successor(r, "keys"))
 
+	res, err := api.local.GetKey(ctx, key, keyOpts(r))
 	if err != nil {
-		fmt.Println("cant dial ")
+		writeError(w, err)
+		return
 	}
 
-	res, err := conn.Get(ctx, &storagepb.GetRequest{
-		Key: key,
-	})
-
 	response := model.GetKeyResponse{
-		Version: "1",
-		Values:  []string{string(res.Value[0].Data)},
-		Exists:  true,
-		Value:   string(string(res.Value[0].Data)),
+		Version: res.Version,
+		Exists:  len(res.Values) > 0,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	if len(res.Values) == 1 {
+		response.Value = string(res.Values[0])
+	} else {
+		for _, v := range res.Values {
+			response.Values = append(response.Values, string(v))
+		}
+	}
 
 	render.JSON(w, r, response)
 }
+
+func (api *KeyValueHandler) deleteKey(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	ctx := admission.ForwardClient(auth.OutgoingContext(r.Context()), r.RemoteAddr)
+
+	deprecate(w, successor(r, "keys"))
+
+	var params model.DeleteKeyParams
+	if err := render.DecodeJSON(r.Body, &params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := api.local.DeleteKey(ctx, key, params.Version, keyOpts(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(sessionHeader, res.SessionToken)
+	render.JSON(w, r, &model.DeleteKeyResponse{
+		Acknowledged: res.Acknowledged,
+		Version:      res.Version,
+		SessionToken: res.SessionToken,
+	})
+}
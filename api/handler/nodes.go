@@ -34,6 +34,7 @@ func (api *NodesHandler) getNodes(w http.ResponseWriter, r *http.Request) {
 			Addr:   node.PublicAddr,
 			Status: node.Status.String(),
 			Error:  node.Error,
+			Tags:   node.Tags,
 		}
 	}
 
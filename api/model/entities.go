@@ -1,9 +1,29 @@
 package model
 
+import "time"
+
 type Node struct {
-	ID     uint32 `json:"ID"`
-	Name   string `json:"Name"`
-	Addr   string `json:"Addr"`
-	Status string `json:"Status"`
-	Error  string `json:",omitempty"`
+	ID     uint32            `json:"ID"`
+	Name   string            `json:"Name"`
+	Addr   string            `json:"Addr"`
+	Status string            `json:"Status"`
+	Error  string            `json:",omitempty"`
+	Tags   map[string]string `json:"Tags,omitempty"`
+}
+
+type HotKey struct {
+	Namespace string  `json:"Namespace"`
+	Key       string  `json:"Key"`
+	Rate      float64 `json:"Rate"`
+	Hot       bool    `json:"Hot"`
+}
+
+type DecommissionProgress struct {
+	State       string     `json:"State"`
+	KeysScanned int64      `json:"KeysScanned"`
+	KeysSent    int64      `json:"KeysSent"`
+	Failed      int64      `json:"Failed"`
+	StartedAt   *time.Time `json:"StartedAt,omitempty"`
+	FinishedAt  *time.Time `json:"FinishedAt,omitempty"`
+	Error       string     `json:"Error,omitempty"`
 }
@@ -8,6 +8,7 @@ type PutKeyParams struct {
 type PutKeyResponse struct {
 	Version      string `json:"Version"`
 	Acknowledged int    `json:"Acknowledged"`
+	SessionToken string `json:"SessionToken"`
 }
 
 type DeleteKeyParams struct {
@@ -17,6 +18,7 @@ type DeleteKeyParams struct {
 type DeleteKeyResponse struct {
 	Version      string `json:"Version"`
 	Acknowledged int    `json:"Acknowledged"`
+	SessionToken string `json:"SessionToken"`
 }
 
 type GetKeyResponse struct {
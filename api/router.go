@@ -1,15 +1,52 @@
 package api
 
 import (
+	"expvar"
+	"net/http"
+
 	chi "github.com/go-chi/chi/v5"
+	kitlog "github.com/go-kit/log"
+	"google.golang.org/grpc"
+
 	"github.com/sadath-12/keywave/api/handler"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/hotkeys"
 	"github.com/sadath-12/keywave/membership"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+	"github.com/sadath-12/keywave/rebalance"
+	replicationpb "github.com/sadath-12/keywave/replication/proto"
 )
 
-func CreateRouter(cluster membership.Cluster) *chi.Mux {
+// CreateRouter returns the handler of the REST API. The keys are read and
+// written through the connection to the local node, which is expected to carry
+// the admission.Controller.GatewayCredentials.
+func CreateRouter(
+	cluster membership.Cluster,
+	decommissioner *rebalance.Decommissioner,
+	hotKeys *hotkeys.Detector,
+	local grpc.ClientConnInterface,
+	authn auth.Authenticator,
+	logger kitlog.Logger,
+) *chi.Mux {
 	r := chi.NewRouter()
-	handler.NewKeyValueHandler().Register(r)
-	handler.NewNodesHandler(cluster).Register(r)
+
+	// The description of the API is public, so that the clients can be
+	// generated without a token.
+	r.Get("/openapi.json", serveOpenAPI)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(authn, logger))
+
+		handler.NewKeyValueHandler(nodeapigrpc.NewClient(local)).Register(r)
+		handler.NewNodesHandler(cluster).Register(r)
+		r.Handle("/v1/*", newGateway(replicationpb.NewReplicationClient(local)))
+
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireAdmin(logger))
+			handler.NewAdminHandler(cluster, decommissioner, hotKeys).Register(r)
+			r.Method(http.MethodGet, "/debug/vars", expvar.Handler())
+		})
+	})
 
 	return r
 }
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/internal/clustertest"
+)
+
+func startRouter(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	h := clustertest.New(t, clustertest.Options{Nodes: 1})
+
+	conn, err := h.Node(1).Conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	srv := httptest.NewServer(CreateRouter(h.Node(1).Cluster, nil, nil, conn, nil, kitlog.NewNopLogger()))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// do sends the request, and decodes the JSON body of the response into res,
+// unless it is nil.
+func do(t *testing.T, client *http.Client, method, url string, body string, res any) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode/100 != 3 {
+		t.Fatalf("%s %s: %s: %s", method, url, resp.Status, data)
+	}
+
+	if res != nil {
+		if err := json.Unmarshal(data, res); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, url, err, data)
+		}
+	}
+
+	return resp
+}
+
+func TestOpenAPI(t *testing.T) {
+	srv := startRouter(t)
+
+	var spec struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+
+	resp := do(t, srv.Client(), http.MethodGet, srv.URL+"/openapi.json", "", &spec)
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	for path, methods := range map[string][]string{
+		"/v1/keys/{key}":                                 {"get", "put", "delete"},
+		"/v1/namespaces/{namespace}/keys/{key}":          {"get", "put", "delete"},
+		"/v1/namespaces/{namespace}/index/{field}:query": {"post"},
+	} {
+		for _, method := range methods {
+			if _, ok := spec.Paths[path][method]; !ok {
+				t.Errorf("%s %s is not described", method, path)
+			}
+		}
+	}
+}
+
+func TestGateway(t *testing.T) {
+	srv := startRouter(t)
+
+	type value struct {
+		Data string `json:"data"`
+	}
+
+	var put struct {
+		Version string `json:"version"`
+	}
+
+	do(t, srv.Client(), http.MethodPut, srv.URL+"/v1/keys/a/b", `{"value": {"data": "aGVsbG8="}}`, &put)
+
+	if put.Version == "" {
+		t.Fatal("the write returned no version")
+	}
+
+	var get struct {
+		Values  []value `json:"values"`
+		Version string  `json:"version"`
+	}
+
+	do(t, srv.Client(), http.MethodGet, srv.URL+"/v1/keys/a/b", "", &get)
+
+	if len(get.Values) != 1 || get.Values[0].Data != "aGVsbG8=" || get.Version != put.Version {
+		t.Errorf("unexpected read %+v", get)
+	}
+
+	do(t, srv.Client(), http.MethodDelete, srv.URL+"/v1/keys/a/b?version="+put.Version, "", nil)
+	do(t, srv.Client(), http.MethodGet, srv.URL+"/v1/keys/a/b", "", &get)
+
+	if len(get.Values) != 0 {
+		t.Errorf("expected the key to be deleted, got %+v", get)
+	}
+}
+
+func TestDeprecatedRoutes(t *testing.T) {
+	srv := startRouter(t)
+
+	// The keys are still read and written with the bodies of the earlier API.
+	var put struct {
+		Version string
+	}
+
+	resp := do(t, srv.Client(), http.MethodPut, srv.URL+"/kv/key?level=one", `{"Value": "hello"}`, &put)
+
+	if resp.Header.Get("Deprecation") != "true" || resp.Header.Get("Link") != `</v1/keys/key?consistency=ONE>; rel="successor-version"` {
+		t.Errorf("unexpected deprecation headers %v", resp.Header)
+	}
+
+	var get struct {
+		Value   string
+		Version string
+		Exists  bool
+	}
+
+	do(t, srv.Client(), http.MethodGet, srv.URL+"/kv/key", "", &get)
+
+	if !get.Exists || get.Value != "hello" || get.Version != put.Version {
+		t.Errorf("unexpected read %+v", get)
+	}
+
+	// The same key is read through the gateway.
+	var values struct {
+		Values []struct {
+			Data string `json:"data"`
+		} `json:"values"`
+	}
+
+	do(t, srv.Client(), http.MethodGet, srv.URL+"/v1/keys/key", "", &values)
+
+	if len(values.Values) != 1 || values.Values[0].Data != base64.StdEncoding.EncodeToString([]byte("hello")) {
+		t.Errorf("unexpected read through the gateway %+v", values)
+	}
+
+	do(t, srv.Client(), http.MethodDelete, srv.URL+"/kv/key", `{"Version": "`+put.Version+`"}`, nil)
+	do(t, srv.Client(), http.MethodGet, srv.URL+"/kv/key", "", &get)
+
+	if get.Exists {
+		t.Errorf("expected the key to be deleted, got %+v", get)
+	}
+
+	// The raw values are redirected, and the body is sent again.
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp = do(t, noRedirect, http.MethodPut, srv.URL+"/ns/default/blob/raw?session=token", "raw", nil)
+
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != "/v1/namespaces/default/blobs/raw?session_token=token" {
+		t.Errorf("unexpected redirect %s to %q", resp.Status, resp.Header.Get("Location"))
+	}
+
+	do(t, srv.Client(), http.MethodPut, srv.URL+"/blob/raw", "raw", nil)
+
+	resp, err := srv.Client().Get(srv.URL + "/v1/blobs/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if data, _ := io.ReadAll(resp.Body); string(data) != "raw" {
+		t.Errorf("unexpected raw value %q", data)
+	}
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoToken is returned when the request does not carry a bearer token.
+	ErrNoToken = errors.New("missing bearer token")
+	// ErrInvalidToken is returned when the token is unknown, malformed or expired.
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Permission is a set of operations allowed on a key.
+type Permission uint8
+
+const (
+	// PermRead allows reading the key.
+	PermRead Permission = 1 << iota
+	// PermWrite allows creating and overwriting the key.
+	PermWrite
+	// PermDelete allows deleting the key.
+	PermDelete
+	// PermAdmin allows cluster management operations. It is not bound to keys, so
+	// the prefix and the namespace of the rule granting it are ignored.
+	PermAdmin
+)
+
+// String returns the string representation of the permission set.
+func (p Permission) String() string {
+	var names []string
+
+	if p&PermRead != 0 {
+		names = append(names, "read")
+	}
+
+	if p&PermWrite != 0 {
+		names = append(names, "write")
+	}
+
+	if p&PermDelete != 0 {
+		names = append(names, "delete")
+	}
+
+	if p&PermAdmin != 0 {
+		names = append(names, "admin")
+	}
+
+	return strings.Join(names, ",")
+}
+
+// ParsePermissions converts a list of permission names into a permission set.
+func ParsePermissions(names []string) (Permission, error) {
+	var perm Permission
+
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "read":
+			perm |= PermRead
+		case "write":
+			perm |= PermWrite
+		case "delete":
+			perm |= PermDelete
+		case "admin":
+			perm |= PermAdmin
+		case "all", "*":
+			perm |= PermRead | PermWrite | PermDelete
+		default:
+			return 0, fmt.Errorf("unknown permission: %q", name)
+		}
+	}
+
+	return perm, nil
+}
+
+// Rule grants a set of permissions on all keys starting with the prefix.
+// An empty prefix matches every key, and an empty namespace matches every
+// namespace.
+type Rule struct {
+	Namespace string
+	Prefix    string
+	Perms     Permission
+}
+
+// ruleJSON is the representation of a rule in token files and JWT claims.
+type ruleJSON struct {
+	Namespace   string   `json:"namespace"`
+	Prefix      string   `json:"prefix"`
+	Permissions []string `json:"permissions"`
+}
+
+func fromRulesJSON(rules []ruleJSON) ([]Rule, error) {
+	res := make([]Rule, len(rules))
+
+	for i, r := range rules {
+		perms, err := ParsePermissions(r.Permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		res[i] = Rule{
+			Namespace: r.Namespace,
+			Prefix:    r.Prefix,
+			Perms:     perms,
+		}
+	}
+
+	return res, nil
+}
+
+// Identity is an authenticated client along with the rules granted to it.
+type Identity struct {
+	Subject string
+	Rules   []Rule
+}
+
+// Allowed reports whether the identity has the permission on the key of the
+// namespace. When several rules match the key, the one with the longest prefix
+// wins, so that a narrower rule can restrict a broader one. A rule bound to the
+// namespace takes precedence over a rule with the same prefix for any namespace.
+func (id *Identity) Allowed(perm Permission, ns, key string) bool {
+	var (
+		matched bool
+		best    Rule
+	)
+
+	for _, rule := range id.Rules {
+		if rule.Namespace != "" && rule.Namespace != ns {
+			continue
+		}
+
+		if !strings.HasPrefix(key, rule.Prefix) {
+			continue
+		}
+
+		if !matched || len(rule.Prefix) > len(best.Prefix) ||
+			(len(rule.Prefix) == len(best.Prefix) && best.Namespace == "") {
+			best = rule
+			matched = true
+		}
+	}
+
+	return matched && best.Perms&perm == perm
+}
+
+// IsAdmin reports whether any of the rules grants the admin permission.
+func (id *Identity) IsAdmin() bool {
+	for _, rule := range id.Rules {
+		if rule.Perms&PermAdmin != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticator verifies a bearer token and returns the identity it belongs to.
+type Authenticator interface {
+	Authenticate(token string) (*Identity, error)
+}
+
+type contextKey struct{}
+
+type contextValue struct {
+	token    string
+	identity *Identity
+}
+
+// NewContext returns a copy of the context carrying the token and the identity.
+func NewContext(ctx context.Context, token string, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, contextValue{token: token, identity: id})
+}
+
+// FromContext returns the identity stored in the context, if any.
+func FromContext(ctx context.Context) (*Identity, bool) {
+	v, ok := ctx.Value(contextKey{}).(contextValue)
+	if !ok {
+		return nil, false
+	}
+
+	return v.identity, true
+}
+
+// parseBearer extracts the token from the value of an authorization header.
+func parseBearer(header string) (string, error) {
+	const prefix = "bearer "
+
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", ErrNoToken
+	}
+
+	token := strings.TrimSpace(header[len(prefix):])
+	if token == "" {
+		return "", ErrNoToken
+	}
+
+	return token, nil
+}
@@ -0,0 +1,85 @@
+package auth
+
+import "testing"
+
+func TestAllowed(t *testing.T) {
+	id := &Identity{
+		Subject: "billing",
+		Rules: []Rule{
+			{Prefix: "", Perms: PermRead},
+			{Prefix: "billing/", Perms: PermRead | PermWrite | PermDelete},
+			{Prefix: "billing/frozen/", Perms: PermRead},
+			{Namespace: "archive", Prefix: "billing/", Perms: PermRead},
+		},
+	}
+
+	tests := []struct {
+		perm    Permission
+		ns, key string
+		allowed bool
+	}{
+		{PermRead, "default", "users/1", true},
+		{PermWrite, "default", "users/1", false},
+		{PermWrite, "default", "billing/1", true},
+		{PermRead | PermDelete, "default", "billing/1", true},
+		// The longest prefix restricts the broader rule.
+		{PermWrite, "default", "billing/frozen/1", false},
+		{PermRead, "default", "billing/frozen/1", true},
+		// The rule bound to the namespace wins over the one with the same prefix.
+		{PermWrite, "archive", "billing/1", false},
+		{PermRead, "archive", "billing/1", true},
+		{PermAdmin, "default", "billing/1", false},
+	}
+
+	for _, tt := range tests {
+		if allowed := id.Allowed(tt.perm, tt.ns, tt.key); allowed != tt.allowed {
+			t.Errorf("Allowed(%s, %s, %s) = %v, expected %v", tt.perm, tt.ns, tt.key, allowed, tt.allowed)
+		}
+	}
+
+	if id.IsAdmin() {
+		t.Error("expected the identity not to be an admin")
+	}
+
+	if (&Identity{}).Allowed(PermRead, "default", "key") {
+		t.Error("expected an identity without rules to be denied")
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	tests := []struct {
+		names []string
+		perm  Permission
+	}{
+		{nil, 0},
+		{[]string{"read"}, PermRead},
+		{[]string{" Write ", "DELETE"}, PermWrite | PermDelete},
+		{[]string{"all"}, PermRead | PermWrite | PermDelete},
+		{[]string{"*", "admin"}, PermRead | PermWrite | PermDelete | PermAdmin},
+	}
+
+	for _, tt := range tests {
+		perm, err := ParsePermissions(tt.names)
+		if err != nil {
+			t.Errorf("ParsePermissions(%q): %v", tt.names, err)
+		} else if perm != tt.perm {
+			t.Errorf("ParsePermissions(%q) = %s, expected %s", tt.names, perm, tt.perm)
+		}
+	}
+
+	if _, err := ParsePermissions([]string{"read", "execute"}); err == nil {
+		t.Error("expected an unknown permission to fail")
+	}
+}
+
+func TestParseBearer(t *testing.T) {
+	if token, err := parseBearer("bearer  s3cr3t "); err != nil || token != "s3cr3t" {
+		t.Errorf("unexpected token %q, %v", token, err)
+	}
+
+	for _, header := range []string{"", "Bearer ", "Basic dXNlcjpwYXNz", "s3cr3t"} {
+		if _, err := parseBearer(header); err != ErrNoToken {
+			t.Errorf("expected no token in %q, got %v", header, err)
+		}
+	}
+}
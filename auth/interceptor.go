@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/sadath-12/keywave/namespace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// keyedRequest is implemented by all generated request messages having a key field.
+type keyedRequest interface {
+	GetKey() string
+}
+
+// namespacedRequest is implemented by the request messages scoped to a namespace.
+type namespacedRequest interface {
+	GetNamespace() string
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor that enforces the access
+// rules for the given methods. The methods map contains full method names, such
+// as "/replication.Replication/Get", and the permission required to call them.
+// Methods not present in the map are considered internal, and are only open to
+// the nodes of the cluster, which carry the secret, and to the administrators.
+// They are not checked if the secret is not set.
+func UnaryServerInterceptor(authn Authenticator, secret NodeSecret, methods map[string]Permission, logger kitlog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		perm, ok := methods[info.FullMethod]
+		if !ok {
+			if err := authorizeInternal(ctx, authn, secret, info.FullMethod, logger); err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, req)
+		}
+
+		if authn == nil {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authorize(ctx, authn, perm, info.FullMethod, req, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the counterpart of UnaryServerInterceptor for the
+// streaming methods. The key and the namespace are taken from the first message
+// of the stream, so the access is checked once it is received, and the stream
+// fails if the access is denied. The streams of the internal methods are checked
+// when they start.
+func StreamServerInterceptor(authn Authenticator, secret NodeSecret, methods map[string]Permission, logger kitlog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		perm, ok := methods[info.FullMethod]
+		if !ok {
+			if err := authorizeInternal(ss.Context(), authn, secret, info.FullMethod, logger); err != nil {
+				return err
+			}
+
+			return handler(srv, ss)
+		}
+
+		if authn == nil {
+			return handler(srv, ss)
+		}
+
+		return handler(srv, &authorizedStream{
+			ServerStream: ss,
+			ctx:          ss.Context(),
+			authorize: func(ctx context.Context, req any) (context.Context, error) {
+				return authorize(ctx, authn, perm, info.FullMethod, req, logger)
+			},
+		})
+	}
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx        context.Context
+	authorize  func(ctx context.Context, req any) (context.Context, error)
+	authorized bool
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if !s.authorized {
+		ctx, err := s.authorize(s.ctx, m)
+		if err != nil {
+			return err
+		}
+
+		s.ctx = ctx
+		s.authorized = true
+	}
+
+	return nil
+}
+
+// authorize checks that the token of the request grants the permission to
+// access the key of the request, and returns the context with the identity.
+func authorize(ctx context.Context, authn Authenticator, perm Permission, method string, req any, logger kitlog.Logger) (context.Context, error) {
+	var key, remote string
+
+	ns := namespace.Default
+
+	if kr, ok := req.(keyedRequest); ok {
+		key = kr.GetKey()
+	}
+
+	if nr, ok := req.(namespacedRequest); ok && nr.GetNamespace() != "" {
+		ns = nr.GetNamespace()
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+
+	token, err := parseBearer(header)
+	if err != nil {
+		auditDenied(logger, "", method, key, remote, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	id, err := authn.Authenticate(token)
+	if err != nil {
+		auditDenied(logger, "", method, key, remote, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if !id.Allowed(perm, ns, key) {
+		reason := fmt.Sprintf("%s permission required", perm)
+		auditDenied(logger, id.Subject, method, key, remote, reason)
+
+		return nil, status.Error(codes.PermissionDenied, reason)
+	}
+
+	return NewContext(ctx, token, id), nil
+}
+
+// authorizeInternal checks that the call of an internal method comes from a node
+// of the cluster, which carries the secret, or from an administrator, such as
+// the backup tool.
+func authorizeInternal(ctx context.Context, authn Authenticator, secret NodeSecret, method string, logger kitlog.Logger) error {
+	if len(secret) == 0 {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if secret.matches(md) {
+		return nil
+	}
+
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+
+	var header string
+	if values := md.Get("authorization"); len(values) > 0 {
+		header = values[0]
+	}
+
+	if authn == nil || header == "" {
+		auditDenied(logger, "", method, "", remote, "node secret required")
+		return status.Error(codes.Unauthenticated, "node secret or admin token required")
+	}
+
+	token, err := parseBearer(header)
+	if err != nil {
+		auditDenied(logger, "", method, "", remote, err.Error())
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	id, err := authn.Authenticate(token)
+	if err != nil {
+		auditDenied(logger, "", method, "", remote, err.Error())
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if !id.IsAdmin() {
+		auditDenied(logger, id.Subject, method, "", remote, "admin permission required")
+		return status.Error(codes.PermissionDenied, "admin permission required")
+	}
+
+	return nil
+}
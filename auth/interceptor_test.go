@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	getMethod  = "/replication.Replication/Get"
+	scanMethod = "/storage.StorageService/Scan"
+)
+
+type request struct {
+	key string
+}
+
+func (r *request) GetKey() string       { return r.key }
+func (r *request) GetNamespace() string { return "" }
+
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *stream) Context() context.Context { return s.ctx }
+func (s *stream) RecvMsg(m any) error {
+	*m.(*request) = request{key: "billing/1"}
+	return nil
+}
+
+func testAuthenticator() Authenticator {
+	return &TokenFile{tokens: map[string]*Identity{
+		"billing": {Subject: "billing", Rules: []Rule{{Prefix: "billing/", Perms: PermRead}}},
+		"ops":     {Subject: "ops", Rules: []Rule{{Perms: PermAdmin}}},
+	}}
+}
+
+func incoming(pairs ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(testAuthenticator(), NodeSecret("s3cr3t"),
+		map[string]Permission{getMethod: PermRead}, kitlog.NewNopLogger())
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		key    string
+		code   codes.Code
+	}{
+		{"no token", context.Background(), getMethod, "billing/1", codes.Unauthenticated},
+		{"not a bearer token", incoming("authorization", "billing"), getMethod, "billing/1", codes.Unauthenticated},
+		{"unknown token", incoming("authorization", "Bearer guess"), getMethod, "billing/1", codes.Unauthenticated},
+		{"other key", incoming("authorization", "Bearer billing"), getMethod, "users/1", codes.PermissionDenied},
+		{"allowed", incoming("authorization", "Bearer billing"), getMethod, "billing/1", codes.OK},
+		{"internal without secret", context.Background(), scanMethod, "", codes.Unauthenticated},
+		{"internal with other secret", incoming(nodeSecretHeader, "guess"), scanMethod, "", codes.Unauthenticated},
+		{"internal with client token", incoming("authorization", "Bearer billing"), scanMethod, "", codes.PermissionDenied},
+		{"internal with unknown token", incoming("authorization", "Bearer guess"), scanMethod, "", codes.Unauthenticated},
+		{"internal with secret", incoming(nodeSecretHeader, "s3cr3t"), scanMethod, "", codes.OK},
+		{"internal with admin token", incoming("authorization", "Bearer ops"), scanMethod, "", codes.OK},
+	}
+
+	for _, tt := range tests {
+		var subject string
+
+		_, err := interceptor(tt.ctx, &request{key: tt.key}, &grpc.UnaryServerInfo{FullMethod: tt.method},
+			func(ctx context.Context, req any) (any, error) {
+				if id, ok := FromContext(ctx); ok {
+					subject = id.Subject
+				}
+
+				return nil, nil
+			})
+
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.name, tt.code, err)
+		}
+
+		if tt.code == codes.OK && tt.method == getMethod && subject != "billing" {
+			t.Errorf("%s: expected the identity in the context, got %q", tt.name, subject)
+		}
+	}
+}
+
+func TestUnaryServerInterceptorDisabled(t *testing.T) {
+	// Without an authenticator nor a secret, every method is open.
+	interceptor := UnaryServerInterceptor(nil, nil, map[string]Permission{getMethod: PermRead}, kitlog.NewNopLogger())
+
+	for _, method := range []string{getMethod, scanMethod} {
+		_, err := interceptor(context.Background(), &request{}, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req any) (any, error) { return nil, nil })
+		if err != nil {
+			t.Errorf("%s: %v", method, err)
+		}
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(testAuthenticator(), NodeSecret("s3cr3t"),
+		map[string]Permission{getMethod: PermRead}, kitlog.NewNopLogger())
+
+	handler := func(srv any, ss grpc.ServerStream) error {
+		return ss.RecvMsg(&request{})
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		code   codes.Code
+	}{
+		{"no token", context.Background(), getMethod, codes.Unauthenticated},
+		{"unknown token", incoming("authorization", "Bearer guess"), getMethod, codes.Unauthenticated},
+		{"allowed", incoming("authorization", "Bearer billing"), getMethod, codes.OK},
+		{"internal without secret", context.Background(), scanMethod, codes.Unauthenticated},
+		{"internal with secret", incoming(nodeSecretHeader, "s3cr3t"), scanMethod, codes.OK},
+	}
+
+	for _, tt := range tests {
+		err := interceptor(nil, &stream{ctx: tt.ctx}, &grpc.StreamServerInfo{FullMethod: tt.method}, handler)
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("%s: expected %s, got %v", tt.name, tt.code, err)
+		}
+	}
+}
+
+func TestNodeSecretCredentials(t *testing.T) {
+	md, err := NodeSecret("s3cr3t").GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !NodeSecret("s3cr3t").matches(metadata.New(md)) {
+		t.Errorf("expected the metadata to carry the secret, got %v", md)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	handler := Middleware(testAuthenticator(), logger)(RequireAdmin(logger)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tests := []struct {
+		header string
+		code   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic b3BzOg==", http.StatusUnauthorized},
+		{"Bearer guess", http.StatusUnauthorized},
+		{"Bearer billing", http.StatusForbidden},
+		{"Bearer ops", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/nodes", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.code {
+			t.Errorf("%q: expected %d, got %d", tt.header, tt.code, rec.Code)
+		}
+
+		if tt.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%q: expected the authentication scheme", tt.header)
+		}
+	}
+
+	// Without an authenticator, the requests are let through.
+	rec := httptest.NewRecorder()
+	Middleware(nil, logger)(RequireAdmin(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/nodes", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the request to be let through, got %d", rec.Code)
+	}
+}
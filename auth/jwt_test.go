@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1_700_000_000, 0)
+
+// sign returns a token with the header and the claims, signed with HS256.
+func sign(t *testing.T, secret string, header, claims any) string {
+	t.Helper()
+
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWT(t *testing.T) {
+	v, err := LoadJWTVerifier(writeFile(t, "s3cr3t\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v.now = func() time.Time { return now }
+
+	hs256 := jwtHeader{Alg: "HS256", Typ: "JWT"}
+	acl := []ruleJSON{{Prefix: "billing/", Permissions: []string{"read"}}}
+
+	token := sign(t, "s3cr3t", hs256, jwtClaims{
+		Subject:   "billing",
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		NotBefore: now.Add(-time.Minute).Unix(),
+		ACL:       acl,
+	})
+
+	id, err := v.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Subject != "billing" || !id.Allowed(PermRead, "default", "billing/1") || id.Allowed(PermWrite, "default", "billing/1") {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+
+	rejected := map[string]string{
+		"expired": sign(t, "s3cr3t", hs256, jwtClaims{Subject: "billing", ExpiresAt: now.Unix(), ACL: acl}),
+		"not yet valid": sign(t, "s3cr3t", hs256, jwtClaims{
+			Subject: "billing", NotBefore: now.Add(time.Second).Unix(), ACL: acl,
+		}),
+		"other algorithm": sign(t, "s3cr3t", jwtHeader{Alg: "HS384", Typ: "JWT"}, jwtClaims{Subject: "billing", ACL: acl}),
+		"no algorithm":    sign(t, "s3cr3t", jwtHeader{Alg: "none", Typ: "JWT"}, jwtClaims{Subject: "billing", ACL: acl}),
+		"other key":       sign(t, "guess", hs256, jwtClaims{Subject: "billing", ACL: acl}),
+		"unknown permission": sign(t, "s3cr3t", hs256, jwtClaims{
+			Subject: "billing", ACL: []ruleJSON{{Permissions: []string{"execute"}}},
+		}),
+		"malformed": "s3cr3t",
+	}
+
+	for name, token := range rejected {
+		if _, err := v.Authenticate(token); err != ErrInvalidToken {
+			t.Errorf("%s: expected the token to be rejected, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/metadata"
+)
+
+// Middleware returns an HTTP middleware that authenticates the bearer token of
+// each request and stores the resulting identity in the request context. The
+// per-key authorization happens later in the replication service, which the
+// REST handlers call with the same token. If authn is nil, the middleware does
+// nothing, which keeps the API open when authentication is not configured.
+func Middleware(authn Authenticator, logger kitlog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if authn == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := parseBearer(r.Header.Get("Authorization"))
+			if err != nil {
+				auditDenied(logger, "", r.Method+" "+r.URL.Path, "", r.RemoteAddr, err.Error())
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+
+				return
+			}
+
+			id, err := authn.Authenticate(token)
+			if err != nil {
+				auditDenied(logger, "", r.Method+" "+r.URL.Path, "", r.RemoteAddr, err.Error())
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+
+				return
+			}
+
+			ctx := NewContext(r.Context(), token, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireAdmin returns an HTTP middleware that only lets through the clients
+// having the admin permission. It must be used after Middleware. Requests without
+// an identity are allowed, since they only reach it when authentication is disabled.
+func RequireAdmin(logger kitlog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := FromContext(r.Context())
+			if ok && !id.IsAdmin() {
+				auditDenied(logger, id.Subject, r.Method+" "+r.URL.Path, "", r.RemoteAddr, "admin permission required")
+				http.Error(w, "admin permission required", http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// OutgoingContext attaches the token stored in the context to the outgoing gRPC
+// metadata, so that the identity of the client is preserved when the request is
+// forwarded to the replication service.
+func OutgoingContext(ctx context.Context) context.Context {
+	v, ok := ctx.Value(contextKey{}).(contextValue)
+	if !ok || v.token == "" {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+v.token)
+}
+
+// auditDenied writes a record about a rejected request to the audit log.
+func auditDenied(logger kitlog.Logger, subject, method, key, remote, reason string) {
+	level.Warn(logger).Log(
+		"msg", "access denied",
+		"subject", subject,
+		"method", method,
+		"key", key,
+		"remote", remote,
+		"reason", reason,
+	)
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// nodeSecretHeader is the metadata key carrying the secret of the nodes.
+const nodeSecretHeader = "x-keywave-node-secret"
+
+// ErrNoNodeSecret is returned when the node secret file is empty.
+var ErrNoNodeSecret = errors.New("node secret is empty")
+
+var _ credentials.PerRPCCredentials = NodeSecret(nil)
+
+// NodeSecret is the secret shared by the nodes of a cluster. It authenticates
+// the calls of the internal services, i.e. the storage and the membership
+// services, the nodes make to each other. Used as the credentials of a gRPC
+// connection, it is attached to every call.
+type NodeSecret []byte
+
+// LoadNodeSecret reads the secret from the file, without the surrounding
+// whitespace.
+func LoadNodeSecret(path string) (NodeSecret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read node secret: %w", err)
+	}
+
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return nil, ErrNoNodeSecret
+	}
+
+	return NodeSecret(secret), nil
+}
+
+func (s NodeSecret) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{nodeSecretHeader: string(s)}, nil
+}
+
+func (s NodeSecret) RequireTransportSecurity() bool {
+	return false
+}
+
+// matches reports whether the incoming metadata carries the secret.
+func (s NodeSecret) matches(md metadata.MD) bool {
+	values := md.Get(nodeSecretHeader)
+
+	return len(s) > 0 && len(values) > 0 && subtle.ConstantTimeCompare([]byte(values[0]), s) == 1
+}
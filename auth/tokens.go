@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+var _ Authenticator = (*TokenFile)(nil)
+
+// TokenFile authenticates clients against a static list of tokens loaded from a
+// JSON file of the following form:
+//
+//	{
+//	  "tokens": [
+//	    {
+//	      "token": "s3cr3t",
+//	      "subject": "billing",
+//	      "rules": [{"prefix": "billing/", "permissions": ["read", "write", "delete"]}]
+//	    }
+//	  ]
+//	}
+type TokenFile struct {
+	tokens map[string]*Identity
+}
+
+type tokenFileJSON struct {
+	Tokens []struct {
+		Token   string     `json:"token"`
+		Subject string     `json:"subject"`
+		Rules   []ruleJSON `json:"rules"`
+	} `json:"tokens"`
+}
+
+// LoadTokenFile reads the token file from the given path.
+func LoadTokenFile(path string) (*TokenFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read token file: %w", err)
+	}
+
+	var parsed tokenFileJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parse token file: %w", err)
+	}
+
+	tf := &TokenFile{
+		tokens: make(map[string]*Identity, len(parsed.Tokens)),
+	}
+
+	for idx, t := range parsed.Tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("token #%d is empty", idx)
+		}
+
+		rules, err := fromRulesJSON(t.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("token #%d: %w", idx, err)
+		}
+
+		tf.tokens[t.Token] = &Identity{
+			Subject: t.Subject,
+			Rules:   rules,
+		}
+	}
+
+	return tf, nil
+}
+
+// Authenticate returns the identity associated with the token.
+func (tf *TokenFile) Authenticate(token string) (*Identity, error) {
+	for known, id := range tf.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return id, nil
+		}
+	}
+
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	tf, err := LoadTokenFile(writeFile(t, `{
+		"tokens": [
+			{"token": "s3cr3t", "subject": "billing", "rules": [{"prefix": "billing/", "permissions": ["read", "write"]}]},
+			{"token": "r00t", "subject": "ops", "rules": [{"permissions": ["admin"]}]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := tf.Authenticate("s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Subject != "billing" || !id.Allowed(PermWrite, "default", "billing/1") || id.Allowed(PermRead, "default", "users/1") {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+
+	if id, err := tf.Authenticate("r00t"); err != nil || !id.IsAdmin() {
+		t.Errorf("expected an admin, got %+v, %v", id, err)
+	}
+
+	if _, err := tf.Authenticate("guess"); err != ErrInvalidToken {
+		t.Errorf("expected an unknown token to be invalid, got %v", err)
+	}
+}
+
+func TestLoadTokenFileErrors(t *testing.T) {
+	for name, data := range map[string]string{
+		"malformed":          `{"tokens": [`,
+		"empty token":        `{"tokens": [{"token": "", "subject": "billing"}]}`,
+		"unknown permission": `{"tokens": [{"token": "s3cr3t", "rules": [{"permissions": ["execute"]}]}]}`,
+	} {
+		if _, err := LoadTokenFile(writeFile(t, data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := LoadTokenFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
+
+func TestLoadNodeSecret(t *testing.T) {
+	secret, err := LoadNodeSecret(writeFile(t, " s3cr3t\n"))
+	if err != nil || string(secret) != "s3cr3t" {
+		t.Errorf("unexpected secret %q, %v", secret, err)
+	}
+
+	if _, err := LoadNodeSecret(writeFile(t, "\n")); err != ErrNoNodeSecret {
+		t.Errorf("expected an empty secret to fail, got %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+// Package backup takes an online backup of a cluster and restores it into
+// another cluster, preserving the versions of the keys.
+//
+// A backup is a directory with a data file per node and a manifest:
+//
+//	manifest.json
+//	node-1.jsonl.gz
+//	node-2.jsonl.gz
+//
+// Each data file is a gzip-compressed stream of JSON records, one per line, with
+// every key stored on the node in lexicographical order, including the internal
+// namespaces, the tombstones and the concurrent versions:
+//
+//	{"key":"default/user:1","versions":[{"version":"<vclock>","data":"<base64>"}]}
+//	{"key":"default/user:2","versions":[{"version":"<vclock>","tombstone":true}]}
+//
+// The manifest lists the data files with the number of keys and versions in
+// each, and the SHA-256 checksum of the compressed file. It is written last, so
+// a directory without the manifest is an incomplete backup.
+//
+// The nodes are scanned concurrently but not at the same instant, so the backup
+// is not a point-in-time snapshot: a key written during the backup may be
+// captured on some replicas only, in which case the restore keeps the newest
+// version, the same way a read repair would.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// FormatVersion is the version of the backup format written by this package.
+const FormatVersion = 1
+
+const (
+	manifestFile     = "manifest.json"
+	progressLogEvery = 10000
+)
+
+var (
+	// ErrExists is returned when the backup directory already holds a backup.
+	ErrExists = errors.New("backup already exists")
+	// ErrChecksumMismatch is returned when a data file does not match the
+	// checksum recorded in the manifest.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrUnsupportedFormat is returned when the backup was written in a format
+	// this version does not read.
+	ErrUnsupportedFormat = errors.New("unsupported backup format")
+)
+
+// Manifest describes the backup.
+type Manifest struct {
+	Format    int        `json:"format"`
+	CreatedAt time.Time  `json:"created_at"`
+	Nodes     []NodeFile `json:"nodes"`
+}
+
+// NodeFile is the data file holding the keys of a single node.
+type NodeFile struct {
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Addr     string `json:"addr"`
+	File     string `json:"file"`
+	Keys     int64  `json:"keys"`
+	Versions int64  `json:"versions"`
+	SHA256   string `json:"sha256"`
+}
+
+// Record is a key with all versions stored on the node.
+type Record struct {
+	Key      string    `json:"key"`
+	Versions []Version `json:"versions"`
+}
+
+// Version is a single version of a key.
+type Version struct {
+	// Version is the encoded vector clock of the version.
+	Version   string `json:"version"`
+	Data      []byte `json:"data,omitempty"`
+	Tombstone bool   `json:"tombstone,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Chunked   bool   `json:"chunked,omitempty"`
+}
+
+// Create scans every node of the cluster and writes the backup to the directory.
+// All nodes that have not left the cluster must be reachable, since the keys
+// are not necessarily stored on every node.
+func Create(ctx context.Context, dir string, nodes []membership.Node, dial nodeapi.Dialer, logger kitlog.Logger) (*Manifest, error) {
+	if _, err := os.Stat(filepath.Join(dir, manifestFile)); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrExists, dir)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create backup directory: %w", err)
+	}
+
+	manifest := &Manifest{
+		Format:    FormatVersion,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	for _, node := range nodes {
+		if node.Status == membership.StatusLeft {
+			continue
+		}
+
+		manifest.Nodes = append(manifest.Nodes, NodeFile{
+			ID:   uint32(node.ID),
+			Name: node.Name,
+			Addr: node.PublicAddr,
+			File: fmt.Sprintf("node-%d.jsonl.gz", node.ID),
+		})
+	}
+
+	// The backup runs against a live cluster, whose clients come first.
+	errg, ctx := errgroup.WithContext(admission.WithPriority(ctx, admission.PriorityBackground))
+
+	for i := range manifest.Nodes {
+		nf := &manifest.Nodes[i]
+
+		errg.Go(func() error {
+			if err := backupNode(ctx, dir, nf, dial, logger); err != nil {
+				return fmt.Errorf("node %d: %w", nf.ID, err)
+			}
+
+			return nil
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err := writeManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// backupNode writes the keys of the node to its data file, and fills in the
+// counters and the checksum of the file.
+func backupNode(ctx context.Context, dir string, nf *NodeFile, dial nodeapi.Dialer, logger kitlog.Logger) error {
+	conn, err := dial(ctx, nf.Addr)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+
+	defer conn.Close()
+
+	f, err := os.Create(filepath.Join(dir, nf.File))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	var (
+		hash = sha256.New()
+		bw   = bufio.NewWriter(io.MultiWriter(f, hash))
+		zw   = gzip.NewWriter(bw)
+		enc  = json.NewEncoder(zw)
+	)
+
+	err = conn.StorageScan(ctx, "", func(item nodeapi.StorageScanItem) error {
+		rec := Record{
+			Key:      item.Key,
+			Versions: make([]Version, len(item.Versions)),
+		}
+
+		for i, v := range item.Versions {
+			rec.Versions[i] = Version{
+				Version:   v.Version,
+				Data:      v.Data,
+				Tombstone: v.Tombstone,
+				ExpiresAt: v.ExpiresAt,
+				Chunked:   v.Chunked,
+			}
+		}
+
+		nf.Keys++
+		nf.Versions += int64(len(rec.Versions))
+
+		if nf.Keys%progressLogEvery == 0 {
+			level.Info(logger).Log("msg", "backup in progress", "node_id", nf.ID, "keys", nf.Keys)
+		}
+
+		return enc.Encode(rec)
+	})
+
+	if err != nil {
+		return fmt.Errorf("scan: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	nf.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	level.Info(logger).Log("msg", "node backed up", "node_id", nf.ID, "keys", nf.Keys, "versions", nf.Versions)
+
+	return nil
+}
+
+// writeManifest writes the manifest via a temporary file, so that the manifest
+// is never seen partially written.
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, manifestFile+".tmp")
+
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, manifestFile)); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	return nil
+}
+
+// ReadManifest reads the manifest of the backup and checks the data files
+// against their checksums.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+
+	if m.Format != FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedFormat, m.Format)
+	}
+
+	for _, nf := range m.Nodes {
+		if err := verifyFile(filepath.Join(dir, nf.File), nf.SHA256); err != nil {
+			return nil, err
+		}
+	}
+
+	return &m, nil
+}
+
+func verifyFile(path, checksum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != checksum {
+		return fmt.Errorf("%w: %s", ErrChecksumMismatch, path)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/backup"
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication/service"
+	"github.com/sadath-12/keywave/storage"
+)
+
+func scanAll(t *testing.T, engine storage.Engine) map[string][]storage.Value {
+	t.Helper()
+
+	res := make(map[string][]storage.Value)
+	it := engine.(storage.Scannable).Scan("")
+
+	for it.Next() == nil {
+		key, values := it.Item()
+		res[key] = values
+	}
+
+	return res
+}
+
+func clusterNodes(h *clustertest.Harness) []membership.Node {
+	return h.Node(1).Cluster.Nodes()
+}
+
+func TestBackupRestore(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		logger     = kitlog.NewNopLogger()
+		dir        = t.TempDir()
+		namespaces = namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{
+			Name:              "users",
+			ReplicationFactor: 2,
+		})
+		source = clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+		target = clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+	)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_, err := source.Node(1).Client().PutKey(ctx, key, []byte("value of "+key), "", nodeapi.KeyOpts{Namespace: "users", Level: "all"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := source.Node(1).Client().PutKey(ctx, "deleted", []byte("value"), "", nodeapi.KeyOpts{Level: "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Node(2).Client().DeleteKey(ctx, "deleted", res.Version, nodeapi.KeyOpts{Level: "all"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The concurrent versions are written to different nodes, so the restored
+	// key only has both siblings if the copies of all nodes are replayed.
+	for _, id := range []membership.NodeID{1, 2} {
+		_, err := source.Node(id).Client().StoragePut(ctx, namespace.Key(namespace.Default, "conflict"), nodeapi.VersionedValue{
+			Version: vclock.Encode(vclock.Version{uint32(id): 1}),
+			Data:    []byte{byte(id)},
+		}, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest, err := backup.Create(ctx, dir, clusterNodes(source), source.Dialer(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Nodes) != 3 {
+		t.Fatalf("backed up %d nodes", len(manifest.Nodes))
+	}
+
+	if _, err := backup.Create(ctx, dir, clusterNodes(source), source.Dialer(), logger); !errors.Is(err, backup.ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+
+	stats, err := backup.Restore(ctx, dir, clusterNodes(target), namespaces, target.Dialer(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Written == 0 || stats.Skipped == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// The nodes have the same IDs in both clusters, so each key is restored to
+	// the same nodes it was backed up from. The siblings stored on different
+	// nodes are merged, so every replica gets both.
+	conflict := namespace.Key(namespace.Default, "conflict")
+
+	for _, id := range source.IDs() {
+		want := scanAll(t, source.Node(id).Engine)
+		got := scanAll(t, target.Node(id).Engine)
+
+		if siblings := got[conflict]; len(siblings) != 2 {
+			t.Errorf("node %d: restored %d siblings", id, len(siblings))
+		}
+
+		delete(want, conflict)
+		delete(got, conflict)
+
+		if len(got) != len(want) {
+			t.Errorf("node %d: restored %d keys, expected %d", id, len(got), len(want))
+		}
+
+		for key, values := range want {
+			restored := got[key]
+			if len(restored) != len(values) {
+				t.Errorf("node %d: %s restored with %d versions, expected %d", id, key, len(restored), len(values))
+				continue
+			}
+
+			for i := range values {
+				if vclock.Compare(restored[i].Version, values[i].Version) != vclock.Equal ||
+					!bytes.Equal(restored[i].Data, values[i].Data) ||
+					restored[i].Tombstone != values[i].Tombstone {
+					t.Errorf("node %d: %s restored as %+v, expected %+v", id, key, restored[i], values[i])
+				}
+			}
+		}
+	}
+
+	data := filepath.Join(dir, manifest.Nodes[0].File)
+
+	content, err := os.ReadFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content[len(content)/2] ^= 0xff
+
+	if err := os.WriteFile(data, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backup.Restore(ctx, dir, clusterNodes(target), namespaces, target.Dialer(), logger); !errors.Is(err, backup.ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication"
+)
+
+const restoreParallelism = 8
+
+// RestoreStats is the outcome of a restore.
+type RestoreStats struct {
+	// Keys is the number of records replayed. A key stored on several nodes
+	// of the backed up cluster is counted once per node.
+	Keys int64
+	// Written is the number of versions written to the replicas.
+	Written int64
+	// Skipped is the number of versions already superseded on the replicas,
+	// usually by the copy of the same key restored from another node.
+	Skipped int64
+}
+
+// Restore replays the backup into the cluster. Every version is written to the
+// replicas of its key in the target cluster with its original vector clock, so
+// the restored keys have the same versions and siblings as the backed up ones.
+// The replicas are chosen with the replication factors of the namespaces, which
+// must match the configuration of the target cluster. The data files are checked
+// against their checksums before anything is written.
+func Restore(
+	ctx context.Context,
+	dir string,
+	nodes []membership.Node,
+	namespaces *namespace.Registry,
+	dial nodeapi.Dialer,
+	logger kitlog.Logger,
+) (RestoreStats, error) {
+	var (
+		stats                  RestoreStats
+		keys, written, skipped atomic.Int64
+	)
+
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return stats, err
+	}
+
+	conns := make(map[membership.NodeID]nodeapi.Client, len(nodes))
+
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for _, node := range nodes {
+		if node.Status == membership.StatusLeft {
+			continue
+		}
+
+		conn, err := dial(ctx, node.PublicAddr)
+		if err != nil {
+			return stats, fmt.Errorf("connect to node %d: %w", node.ID, err)
+		}
+
+		conns[node.ID] = conn
+	}
+
+	errg, ctx := errgroup.WithContext(admission.WithPriority(ctx, admission.PriorityBackground))
+	errg.SetLimit(restoreParallelism)
+
+	replay := func(rec Record) {
+		errg.Go(func() error {
+			rf := replicationFactor(namespaces, rec.Key)
+
+			for _, node := range replication.ReplicaSet(nodes, rec.Key, rf) {
+				for _, v := range rec.Versions {
+					_, err := conns[node.ID].StoragePut(ctx, rec.Key, nodeapi.VersionedValue{
+						Version:   v.Version,
+						Data:      v.Data,
+						Tombstone: v.Tombstone,
+						ExpiresAt: v.ExpiresAt,
+						Chunked:   v.Chunked,
+					}, false)
+
+					switch {
+					case err == nil:
+						written.Add(1)
+					case grpcutil.ErrorCode(err) == codes.AlreadyExists:
+						skipped.Add(1)
+					default:
+						return fmt.Errorf("restore %s to node %d: %w", rec.Key, node.ID, err)
+					}
+				}
+			}
+
+			if n := keys.Add(1); n%progressLogEvery == 0 {
+				level.Info(logger).Log("msg", "restore in progress", "keys", n)
+			}
+
+			return nil
+		})
+	}
+
+	for _, nf := range manifest.Nodes {
+		err := readRecords(filepath.Join(dir, nf.File), func(rec Record) error {
+			replay(rec)
+			return ctx.Err()
+		})
+
+		if err != nil {
+			// The reading is stopped by a failed write as well, in which case
+			// the write error is the one to report.
+			if werr := errg.Wait(); werr != nil {
+				return stats, werr
+			}
+
+			return stats, fmt.Errorf("read %s: %w", nf.File, err)
+		}
+	}
+
+	err = errg.Wait()
+
+	stats.Keys = keys.Load()
+	stats.Written = written.Load()
+	stats.Skipped = skipped.Load()
+
+	return stats, err
+}
+
+func replicationFactor(namespaces *namespace.Registry, key string) int {
+	name, _, _ := namespace.SplitKey(key)
+
+	ns, err := namespaces.Get(name)
+	if err != nil {
+		ns, _ = namespaces.Get(namespace.Default)
+	}
+
+	return ns.ReplicationFactor
+}
+
+// readRecords calls fn for every record of the data file.
+func readRecords(path string, fn func(rec Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(zr)
+
+	for {
+		var rec Record
+
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package chunk stores the large values in chunks. A value larger than a chunk
+// is split into the chunks of at most Size bytes, each stored as a regular key
+// of the internal namespace, keyed by the hash of its content. The value itself
+// is replaced with the manifest listing the chunks, so the identical chunks of
+// different values are only stored once. The chunks no longer referred to by
+// any manifest are removed by the Collector.
+package chunk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+)
+
+// Size is the maximum size of a chunk. The values of up to this size are stored
+// as they are.
+const Size = 1 << 20
+
+// ErrCorrupted is returned when the content of a chunk does not match its hash.
+var ErrCorrupted = errors.New("chunk is corrupted")
+
+// Manifest is stored in place of a value split in chunks.
+type Manifest struct {
+	// Size is the total size of the value.
+	Size int64 `json:"size"`
+	// Chunks are the hashes of the chunks, in the order of the value.
+	Chunks []string `json:"chunks"`
+}
+
+func (m Manifest) Encode() []byte {
+	data, _ := json.Marshal(m) //nolint:errchkjson
+
+	return data
+}
+
+func DecodeManifest(data []byte) (Manifest, error) {
+	var m Manifest
+
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// ValueSize returns the size of the value, which is the size of the whole value
+// rather than of the manifest if it is stored in chunks.
+func ValueSize(v storage.Value) int64 {
+	if v.Chunked {
+		if m, err := DecodeManifest(v.Data); err == nil {
+			return m.Size
+		}
+	}
+
+	return int64(len(v.Data))
+}
+
+// Hash returns the hash of the content of the chunk, which is its key within
+// the chunk namespace.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify returns ErrCorrupted if the content of the chunk does not match the hash.
+func Verify(hash string, data []byte) error {
+	if Hash(data) != hash {
+		return fmt.Errorf("%w: %s", ErrCorrupted, hash)
+	}
+
+	return nil
+}
+
+// Refs returns the hashes of the chunks referred to by the manifests stored
+// in the engine. The manifests of all live versions are taken into account,
+// including the expired ones, as they may still be read until overwritten.
+func Refs(st storage.Scannable) (map[string]struct{}, error) {
+	refs := make(map[string]struct{})
+	it := st.Scan("")
+
+	for {
+		if err := it.Next(); err != nil {
+			if errors.Is(err, storage.ErrNoMoreItems) {
+				return refs, nil
+			}
+
+			return nil, err
+		}
+
+		_, values := it.Item()
+
+		for _, v := range values {
+			if v.Tombstone || !v.Chunked {
+				continue
+			}
+
+			m, err := DecodeManifest(v.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, hash := range m.Chunks {
+				refs[hash] = struct{}{}
+			}
+		}
+	}
+}
+
+// localChunks returns the hashes of the chunks stored in the engine that have
+// at least one live version, with all versions of each.
+func localChunks(st storage.Scannable) (map[string][]storage.Value, error) {
+	var (
+		prefix = namespace.Prefix(namespace.Chunks)
+		chunks = make(map[string][]storage.Value)
+		it     = st.Scan(prefix)
+	)
+
+	for {
+		if err := it.Next(); err != nil {
+			if errors.Is(err, storage.ErrNoMoreItems) {
+				return chunks, nil
+			}
+
+			return nil, err
+		}
+
+		key, values := it.Item()
+		if !strings.HasPrefix(key, prefix) {
+			return chunks, nil
+		}
+
+		for _, v := range values {
+			if !v.Tombstone {
+				chunks[strings.TrimPrefix(key, prefix)] = values
+				break
+			}
+		}
+	}
+}
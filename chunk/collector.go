@@ -0,0 +1,203 @@
+package chunk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+)
+
+// Collector removes the local copies of the chunks no longer referred to by any
+// manifest in the cluster. A chunk is only removed once it has been orphaned for
+// the grace period, since the chunks of a value are written before its manifest.
+// Every node collects its own copies, so the chunks are removed from all
+// replicas without being replicated. A chunk reused by a new value is written
+// again, which restarts its grace period.
+type Collector struct {
+	cluster membership.Cluster
+	engine  storage.Engine
+	logger  kitlog.Logger
+	grace   time.Duration
+
+	mut     sync.Mutex
+	orphans map[string]orphan
+}
+
+// orphan is a chunk found without references, along with the version it had
+// then.
+type orphan struct {
+	since   time.Time
+	version vclock.Version
+}
+
+func NewCollector(cluster membership.Cluster, engine storage.Engine, grace time.Duration, logger kitlog.Logger) *Collector {
+	return &Collector{
+		cluster: cluster,
+		engine:  engine,
+		logger:  logger,
+		grace:   grace,
+		orphans: make(map[string]orphan),
+	}
+}
+
+// Run collects the chunks periodically until the context is canceled.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := env.NewTicker(c.cluster.Env().Clock, func() time.Duration { return interval }, func() {
+		removed, err := c.Collect(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				level.Warn(c.logger).Log("msg", "chunk collection skipped", "err", err)
+			}
+
+			return
+		}
+
+		if removed > 0 {
+			level.Info(c.logger).Log("msg", "orphaned chunks removed", "count", removed)
+		}
+	})
+
+	<-ctx.Done()
+	ticker.Stop()
+}
+
+// Collect removes the chunks that have been orphaned for the grace period, and
+// returns their number. The references are collected from all nodes, so nothing
+// is removed unless every node of the cluster replies.
+func (c *Collector) Collect(ctx context.Context) (int, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	st, ok := c.engine.(storage.Scannable)
+	if !ok {
+		return 0, errors.New("storage engine does not support scans")
+	}
+
+	chunks, err := localChunks(st)
+	if err != nil {
+		return 0, fmt.Errorf("list chunks: %w", err)
+	}
+
+	for hash := range c.orphans {
+		if _, ok := chunks[hash]; !ok {
+			delete(c.orphans, hash)
+		}
+	}
+
+	if len(chunks) == 0 {
+		return 0, nil
+	}
+
+	refs, err := c.clusterRefs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		now     = c.cluster.Env().Clock.Now()
+		removed int
+	)
+
+	for hash := range chunks {
+		if _, ok := refs[hash]; ok {
+			delete(c.orphans, hash)
+			continue
+		}
+
+		// The chunk is read again, as it may have been written while the
+		// references were collected.
+		key := namespace.Key(namespace.Chunks, hash)
+
+		values, err := c.engine.Get(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			delete(c.orphans, hash)
+			continue
+		}
+
+		if err != nil {
+			return removed, fmt.Errorf("read chunk %s: %w", hash, err)
+		}
+
+		version := vclock.Empty()
+		for _, v := range values {
+			version = vclock.Merge(version, v.Version)
+		}
+
+		// The chunk written since it was found orphaned is about to be referred
+		// to by the manifest of a new value.
+		prev, ok := c.orphans[hash]
+		if !ok || !vclock.IsEqual(prev.version, version) {
+			c.orphans[hash] = orphan{since: now, version: version}
+			continue
+		}
+
+		if now.Sub(prev.since) < c.grace {
+			continue
+		}
+
+		// The tombstone supersedes the versions seen, but not a version written
+		// concurrently by a new value referring to the chunk again.
+		version.Increment(uint32(c.cluster.SelfID()))
+
+		err = c.engine.Put(key, storage.Value{
+			Version:   version,
+			Tombstone: true,
+		})
+
+		if err != nil && !errors.Is(err, storage.ErrObsolete) {
+			return removed, fmt.Errorf("remove chunk %s: %w", hash, err)
+		}
+
+		delete(c.orphans, hash)
+
+		if err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// clusterRefs returns the chunks referred to by the manifests stored on any
+// node of the cluster.
+func (c *Collector) clusterRefs(ctx context.Context) (map[string]struct{}, error) {
+	refs := make(map[string]struct{})
+	ctx = admission.WithPriority(ctx, admission.PriorityBackground)
+
+	for _, node := range c.cluster.Nodes() {
+		if node.Status == membership.StatusLeft {
+			continue
+		}
+
+		if !node.IsReachable() {
+			return nil, fmt.Errorf("node %d is unreachable", node.ID)
+		}
+
+		conn, err := c.cluster.ConnContext(ctx, node.ID)
+		if err != nil {
+			return nil, fmt.Errorf("connect to node %d: %w", node.ID, err)
+		}
+
+		res, err := conn.StorageChunkRefs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("chunk refs of node %d: %w", node.ID, err)
+		}
+
+		for _, hash := range res.Hashes {
+			refs[hash] = struct{}{}
+		}
+	}
+
+	return refs, nil
+}
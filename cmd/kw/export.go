@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+const (
+	exportBatchSize  = 64
+	progressLogEvery = 10000
+)
+
+// readKey reads the values of the key through the replication layer. The values
+// stored in chunks are streamed, as they cannot be read with a single request.
+func readKey(ctx context.Context, conn nodeapi.Client, key string, opts nodeapi.KeyOpts) ([][]byte, string, error) {
+	res, err := conn.GetKey(ctx, key, opts)
+	if err == nil {
+		return res.Values, res.Version, nil
+	}
+
+	if grpcutil.ErrorCode(err) != codes.FailedPrecondition {
+		return nil, "", err
+	}
+
+	var (
+		values  [][]byte
+		version string
+	)
+
+	err = conn.GetKeyStream(ctx, key, opts, func(part nodeapi.KeyPart) error {
+		if part.Count > 0 {
+			values = make([][]byte, part.Count)
+			version = part.Version
+		}
+
+		if part.Index < len(values) {
+			values[part.Index] = append(values[part.Index], part.Data...)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return values, version, nil
+}
+
+// exporter writes the keys of a range in the order of the keys. The values of
+// each batch of keys are read concurrently.
+type exporter struct {
+	conn   nodeapi.Client
+	nodes  []nodeapi.Client
+	opts   nodeapi.KeyOpts
+	w      recordWriter
+	logger kitlog.Logger
+
+	batch    []string
+	exported int
+}
+
+// Run exports the keys of the range, and returns the number of keys written.
+// The keys without values, such as the deleted ones, are skipped.
+func (e *exporter) Run(ctx context.Context, r keyRange) (int, error) {
+	err := scanKeys(ctx, e.nodes, r, func(key string) error {
+		e.batch = append(e.batch, key)
+
+		if len(e.batch) < exportBatchSize {
+			return nil
+		}
+
+		return e.flush(ctx)
+	})
+
+	if err != nil {
+		return e.exported, err
+	}
+
+	if err := e.flush(ctx); err != nil {
+		return e.exported, err
+	}
+
+	return e.exported, e.w.Flush()
+}
+
+func (e *exporter) flush(ctx context.Context) error {
+	records := make([]*record, len(e.batch))
+	errg, ctx := errgroup.WithContext(ctx)
+
+	for i, key := range e.batch {
+		i, key := i, key
+
+		errg.Go(func() error {
+			values, version, err := readKey(ctx, e.conn, key, e.opts)
+			if err != nil {
+				return fmt.Errorf("read %s: %w", key, err)
+			}
+
+			if len(values) > 0 {
+				rec := newRecord(key, version, values)
+				records[i] = &rec
+			}
+
+			return nil
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+
+	e.batch = e.batch[:0]
+
+	for _, rec := range records {
+		if rec == nil {
+			continue
+		}
+
+		if err := e.w.Write(*rec); err != nil {
+			return err
+		}
+
+		if e.exported++; e.exported%progressLogEvery == 0 {
+			level.Info(e.logger).Log("msg", "export in progress", "keys", e.exported)
+		}
+	}
+
+	return nil
+}
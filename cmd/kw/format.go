@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+
+	encodingBase64 = "base64"
+)
+
+var errInvalidHeader = errors.New(`csv header must be "key,value"`)
+
+// record is a key with its concurrent values. In JSON Lines, each record is a
+// line of the following form, where the values are base64-encoded if any of
+// them is not valid UTF-8:
+//
+//	{"key":"user:1","version":"{1=2}","values":["..."]}
+//	{"key":"user:2","version":"{1=1, 2=1}","encoding":"base64","values":["...","..."]}
+//
+// The version is the version of the key in the exported cluster. It is only
+// informational, as the versions are not preserved by the import.
+type record struct {
+	Key      string   `json:"key"`
+	Version  string   `json:"version,omitempty"`
+	Encoding string   `json:"encoding,omitempty"`
+	Values   []string `json:"values"`
+}
+
+func newRecord(key, version string, values [][]byte) record {
+	rec := record{
+		Key:     key,
+		Version: version,
+		Values:  make([]string, len(values)),
+	}
+
+	for _, v := range values {
+		if !utf8.Valid(v) {
+			rec.Encoding = encodingBase64
+			break
+		}
+	}
+
+	for i, v := range values {
+		if rec.Encoding == encodingBase64 {
+			rec.Values[i] = base64.StdEncoding.EncodeToString(v)
+		} else {
+			rec.Values[i] = string(v)
+		}
+	}
+
+	return rec
+}
+
+func (r record) decodeValues() ([][]byte, error) {
+	values := make([][]byte, len(r.Values))
+
+	for i, v := range r.Values {
+		switch r.Encoding {
+		case "":
+			values[i] = []byte(v)
+		case encodingBase64:
+			data, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", r.Key, err)
+			}
+
+			values[i] = data
+		default:
+			return nil, fmt.Errorf("key %s: unknown encoding %q", r.Key, r.Encoding)
+		}
+	}
+
+	return values, nil
+}
+
+type recordWriter interface {
+	Write(rec record) error
+	Flush() error
+}
+
+type recordReader interface {
+	// Read returns the next record, or io.EOF at the end of the input.
+	Read() (record, error)
+}
+
+func newRecordWriter(format string, w io.Writer) (recordWriter, error) {
+	switch format {
+	case formatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case formatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func newRecordReader(format string, r io.Reader) (recordReader, error) {
+	switch format {
+	case formatJSONL:
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case formatCSV:
+		return &csvReader{r: csv.NewReader(r)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(rec record) error {
+	return w.enc.Encode(rec)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return nil
+}
+
+type jsonlReader struct {
+	dec *json.Decoder
+}
+
+func (r *jsonlReader) Read() (record, error) {
+	var rec record
+
+	err := r.dec.Decode(&rec)
+
+	return rec, err
+}
+
+// csvWriter writes a row per value with the key and the raw value, so a key
+// with concurrent values takes several rows, and the version is not kept.
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(rec record) error {
+	if !w.headerWritten {
+		if err := w.w.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+
+		w.headerWritten = true
+	}
+
+	values, err := rec.decodeValues()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if err := w.w.Write([]string{rec.Key, string(v)}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// csvReader reads a record with a single value from each row.
+type csvReader struct {
+	r          *csv.Reader
+	headerRead bool
+}
+
+func (r *csvReader) Read() (record, error) {
+	if !r.headerRead {
+		header, err := r.r.Read()
+		if err != nil {
+			return record{}, err
+		}
+
+		if len(header) != 2 || header[0] != "key" || header[1] != "value" {
+			return record{}, errInvalidHeader
+		}
+
+		r.headerRead = true
+	}
+
+	row, err := r.r.Read()
+	if err != nil {
+		return record{}, err
+	}
+
+	return record{Key: row[0], Values: []string{row[1]}}, nil
+}
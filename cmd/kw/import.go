@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+const (
+	siblingsSkip  = "skip"
+	siblingsFirst = "first"
+
+	retryBackoff = 100 * time.Millisecond
+)
+
+// importStats is the outcome of an import.
+type importStats struct {
+	// Skipped is the number of records skipped as already imported by an
+	// earlier run, according to the checkpoint.
+	Skipped int64
+	// Imported is the number of keys written.
+	Imported int64
+	// Existing is the number of keys left intact as they already had a value.
+	Existing int64
+	// Conflicts is the number of records not imported as they had several
+	// concurrent values.
+	Conflicts int64
+}
+
+// importer writes the records through the replication layer in batches. The
+// records of a batch are written concurrently, and the number of records
+// processed is saved to the checkpoint file after each batch, so an import that
+// failed is resumed from the last complete batch.
+type importer struct {
+	conn        nodeapi.Client
+	opts        nodeapi.KeyOpts
+	batchSize   int
+	parallelism int
+	// rate limits the number of records written per second. Zero means no limit.
+	rate       int
+	retries    int
+	overwrite  bool
+	siblings   string
+	checkpoint string
+	logger     kitlog.Logger
+
+	imported, existing, conflicts atomic.Int64
+}
+
+func (im *importer) Run(ctx context.Context, r recordReader) (importStats, error) {
+	done, err := im.readCheckpoint()
+	if err != nil {
+		return importStats{}, err
+	}
+
+	if done > 0 {
+		level.Info(im.logger).Log("msg", "resuming import", "records_done", done)
+	}
+
+	var (
+		start     = time.Now()
+		processed int64
+		skipped   int64
+		batch     = make([]record, 0, im.batchSize)
+		eof       bool
+	)
+
+	for !eof {
+		batch = batch[:0]
+
+		for len(batch) < im.batchSize {
+			rec, err := r.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					eof = true
+					break
+				}
+
+				return im.stats(skipped), fmt.Errorf("read record %d: %w", skipped+processed+int64(len(batch))+1, err)
+			}
+
+			if skipped < done {
+				skipped++
+				continue
+			}
+
+			batch = append(batch, rec)
+		}
+
+		if err := im.writeBatch(ctx, batch); err != nil {
+			return im.stats(skipped), err
+		}
+
+		processed += int64(len(batch))
+
+		if err := im.saveCheckpoint(skipped + processed); err != nil {
+			return im.stats(skipped), err
+		}
+
+		level.Debug(im.logger).Log("msg", "batch imported", "records", skipped+processed)
+
+		if im.rate > 0 {
+			due := start.Add(time.Duration(processed) * time.Second / time.Duration(im.rate))
+
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return im.stats(skipped), ctx.Err()
+			}
+		}
+	}
+
+	// The import is complete, so a later run starts from the beginning.
+	if im.checkpoint != "" {
+		if err := os.Remove(im.checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return im.stats(skipped), err
+		}
+	}
+
+	return im.stats(skipped), nil
+}
+
+func (im *importer) stats(skipped int64) importStats {
+	return importStats{
+		Skipped:   skipped,
+		Imported:  im.imported.Load(),
+		Existing:  im.existing.Load(),
+		Conflicts: im.conflicts.Load(),
+	}
+}
+
+func (im *importer) writeBatch(ctx context.Context, batch []record) error {
+	errg, ctx := errgroup.WithContext(ctx)
+	errg.SetLimit(im.parallelism)
+
+	for _, rec := range batch {
+		rec := rec
+
+		errg.Go(func() error {
+			var err error
+
+			for attempt := 0; attempt <= im.retries; attempt++ {
+				if attempt > 0 {
+					level.Debug(im.logger).Log("msg", "retrying import of key", "key", rec.Key, "err", err)
+
+					select {
+					case <-time.After(retryBackoff * time.Duration(attempt)):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+
+				if err = im.writeRecord(ctx, rec); err == nil {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("import %s: %w", rec.Key, err)
+		})
+	}
+
+	return errg.Wait()
+}
+
+func (im *importer) writeRecord(ctx context.Context, rec record) error {
+	values, err := rec.decodeValues()
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	if len(values) > 1 && im.siblings == siblingsSkip {
+		level.Warn(im.logger).Log("msg", "key with concurrent values skipped", "key", rec.Key, "values", len(values))
+		im.conflicts.Add(1)
+
+		return nil
+	}
+
+	// The version of the key in the target cluster is read first, since a write
+	// without it would be rejected as obsolete by the replicas that have seen
+	// an earlier write of the key, including a deletion.
+	current, version, err := readKey(ctx, im.conn, rec.Key, im.opts)
+	if err != nil {
+		return err
+	}
+
+	if len(current) > 0 && !im.overwrite {
+		im.existing.Add(1)
+		return nil
+	}
+
+	if len(values[0]) > chunk.Size {
+		_, err = im.conn.PutKeyStream(ctx, rec.Key, bytes.NewReader(values[0]), version, im.opts)
+	} else {
+		_, err = im.conn.PutKey(ctx, rec.Key, values[0], version, im.opts)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	im.imported.Add(1)
+
+	return nil
+}
+
+// readCheckpoint returns the number of records processed by an earlier run.
+func (im *importer) readCheckpoint() (int64, error) {
+	if im.checkpoint == "" {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(im.checkpoint)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("read checkpoint: %w", err)
+	}
+
+	done, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+
+	return done, nil
+}
+
+func (im *importer) saveCheckpoint(done int64) error {
+	if im.checkpoint == "" {
+		return nil
+	}
+
+	tmp := im.checkpoint + ".tmp"
+
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(done, 10)+"\n"), 0o644); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmp, im.checkpoint); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+
+	return nil
+}
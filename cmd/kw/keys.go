@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+var errEndOfRange = errors.New("end of range")
+
+// keyRange selects the keys of a namespace starting with the prefix, from the
+// start key inclusive to the end key exclusive. Empty bounds are open.
+type keyRange struct {
+	namespace string
+	prefix    string
+	start     string
+	end       string
+}
+
+// first returns the storage key the range starts from.
+func (r keyRange) first() string {
+	return namespace.Key(r.namespace, max(r.prefix, r.start))
+}
+
+// check reports whether the storage key is within the range, and whether the
+// keys following it may still be.
+func (r keyRange) check(storageKey string) (inRange, more bool) {
+	ns, key, ok := namespace.SplitKey(storageKey)
+	if !ok || ns != r.namespace || !strings.HasPrefix(key, r.prefix) {
+		return false, false
+	}
+
+	if r.end != "" && key >= r.end {
+		return false, false
+	}
+
+	return key >= r.start, true
+}
+
+// scanKeys calls fn for every key of the range stored on any of the nodes, in
+// lexicographical order. The keys are scanned on all nodes at once and merged,
+// since none of the nodes necessarily stores all of them.
+func scanKeys(ctx context.Context, conns []nodeapi.Client, r keyRange, fn func(key string) error) error {
+	var (
+		wg      sync.WaitGroup
+		sources = make([]chan string, len(conns))
+		errs    = make([]error, len(conns))
+	)
+
+	// The scans are canceled before waiting for them, as they may be blocked
+	// on sending the keys no longer read.
+	defer wg.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i, conn := range conns {
+		ch := make(chan string, 64)
+		sources[i] = ch
+
+		wg.Add(1)
+
+		go func(i int, conn nodeapi.Client) {
+			defer wg.Done()
+			defer close(ch)
+
+			err := conn.StorageScan(ctx, r.first(), func(item nodeapi.StorageScanItem) error {
+				inRange, more := r.check(item.Key)
+				if !more {
+					return errEndOfRange
+				}
+
+				if !inRange {
+					return nil
+				}
+
+				select {
+				case ch <- item.Key:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+
+			if err != nil && !errors.Is(err, errEndOfRange) {
+				errs[i] = err
+			}
+		}(i, conn)
+	}
+
+	heads := make([]string, len(sources))
+	live := make([]bool, len(sources))
+
+	for i, ch := range sources {
+		heads[i], live[i] = <-ch
+	}
+
+	for {
+		var (
+			next  string
+			found bool
+		)
+
+		for i := range sources {
+			if live[i] && (!found || heads[i] < next) {
+				next, found = heads[i], true
+			}
+		}
+
+		if !found {
+			break
+		}
+
+		// Every node holding the key is advanced past it at once, so the key
+		// is only reported once.
+		for i, ch := range sources {
+			if live[i] && heads[i] == next {
+				heads[i], live[i] = <-ch
+			}
+		}
+
+		_, key, _ := namespace.SplitKey(next)
+
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+
+	// A node that failed in the middle of the scan has closed its source early,
+	// so the keys it was yet to send are missing.
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
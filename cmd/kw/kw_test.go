@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication/service"
+)
+
+func exportKeys(t *testing.T, h *clustertest.Harness, format string, r keyRange) string {
+	t.Helper()
+
+	var (
+		buf   bytes.Buffer
+		nodes []nodeapi.Client
+	)
+
+	for _, node := range h.Nodes() {
+		nodes = append(nodes, node.Client())
+	}
+
+	w, err := newRecordWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &exporter{
+		conn:   h.Node(1).Client(),
+		nodes:  nodes,
+		opts:   nodeapi.KeyOpts{Namespace: r.namespace, Level: "quorum"},
+		w:      w,
+		logger: kitlog.NewNopLogger(),
+	}
+
+	if _, err := e.Run(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func newImporter(h *clustertest.Harness, ns string) *importer {
+	return &importer{
+		conn:        h.Node(2).Client(),
+		opts:        nodeapi.KeyOpts{Namespace: ns, Level: "quorum"},
+		batchSize:   2,
+		parallelism: 2,
+		siblings:    siblingsSkip,
+		logger:      kitlog.NewNopLogger(),
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		namespaces = namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{
+			Name:              "users",
+			ReplicationFactor: 1,
+		})
+		source = clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+		target = clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+		opts   = nodeapi.KeyOpts{Namespace: "users", Level: "quorum"}
+	)
+
+	// With a single replica of each key, no node stores all of them.
+	for _, kv := range [][2]string{
+		{"user:1", "one"}, {"user:2", "two"}, {"user:3", "three"},
+		{"user:4", "\xff\xfe"}, {"other:1", "other"},
+	} {
+		if _, err := source.Node(1).Client().PutKey(ctx, kv[0], []byte(kv[1]), "", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := source.Node(1).Client().PutKey(ctx, "user:5", []byte("deleted"), "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := source.Node(1).Client().DeleteKey(ctx, "user:5", res.Version, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent values of the same key on every replica.
+	for _, node := range source.Nodes() {
+		for _, id := range []membership.NodeID{1, 2} {
+			_, err := node.Client().StoragePut(ctx, namespace.Key("users", "user:6"), nodeapi.VersionedValue{
+				Version: vclock.Encode(vclock.Version{uint32(id): 1}),
+				Data:    []byte{'a' + byte(id)},
+			}, false)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	exported := exportKeys(t, source, formatJSONL, keyRange{namespace: "users", prefix: "user:", start: "user:2"})
+	lines := strings.Split(strings.TrimSpace(exported), "\n")
+
+	wantKeys := []string{"user:2", "user:3", "user:4", "user:6"}
+	if len(lines) != len(wantKeys) {
+		t.Fatalf("exported %d keys:\n%s", len(lines), exported)
+	}
+
+	for i, key := range wantKeys {
+		if !strings.HasPrefix(lines[i], `{"key":"`+key+`"`) {
+			t.Errorf("line %d: %s", i, lines[i])
+		}
+	}
+
+	if !strings.Contains(lines[2], `"encoding":"base64"`) {
+		t.Errorf("binary value exported as %s", lines[2])
+	}
+
+	// The first batch has been imported by an earlier run.
+	dir := t.TempDir()
+	checkpoint := filepath.Join(dir, "checkpoint")
+
+	if err := os.WriteFile(checkpoint, []byte("2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	im := newImporter(target, "users")
+	im.checkpoint = checkpoint
+
+	r, _ := newRecordReader(formatJSONL, strings.NewReader(exported))
+
+	stats, err := im.Run(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats != (importStats{Skipped: 2, Imported: 1, Conflicts: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("checkpoint is left after the import: %v", err)
+	}
+
+	// The keys are exported from the target without the ones skipped.
+	if got := exportKeys(t, target, formatCSV, keyRange{namespace: "users"}); got != "key,value\nuser:4,\xff\xfe\n" {
+		t.Errorf("unexpected csv export %q", got)
+	}
+
+	// A full run leaves the existing keys intact unless told to overwrite them.
+	r, _ = newRecordReader(formatCSV, strings.NewReader("key,value\nuser:2,two\nuser:4,four\n"))
+
+	stats, err = newImporter(target, "users").Run(ctx, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats != (importStats{Imported: 1, Existing: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	im = newImporter(target, "users")
+	im.overwrite = true
+	r, _ = newRecordReader(formatCSV, strings.NewReader("key,value\nuser:4,four\n"))
+
+	if _, err := im.Run(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := exportKeys(t, target, formatCSV, keyRange{namespace: "users"}); got != "key,value\nuser:2,two\nuser:4,four\n" {
+		t.Errorf("unexpected csv export %q", got)
+	}
+}
@@ -0,0 +1,249 @@
+// Command kw exports the keys of a cluster to JSON Lines or CSV, and imports
+// such files into a cluster. The values are read and written through the
+// replication layer, at the consistency level given, so the tool is subject to
+// the same access control as any other client.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jessevdk/go-flags"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+)
+
+const dialTimeout = 10 * time.Second
+
+var opts struct {
+	Addr    string `long:"addr" description:"gRPC address of any node of the cluster" env:"ADDR" required:"true"`
+	Token   string `long:"token" description:"bearer token of the client, if the cluster requires authentication" env:"TOKEN"`
+	Verbose bool   `long:"verbose" description:"verbose mode" env:"VERBOSE"`
+
+	Export exportCommand `command:"export" description:"export a range of keys"`
+	Import importCommand `command:"import" description:"import keys exported by kw export"`
+}
+
+type exportCommand struct {
+	Namespace string `long:"namespace" description:"namespace of the keys" env:"NAMESPACE"`
+	Prefix    string `long:"prefix" description:"only export the keys starting with the prefix" env:"PREFIX"`
+	Start     string `long:"start" description:"first key of the range (inclusive)" env:"START"`
+	End       string `long:"end" description:"end of the range (exclusive)" env:"END"`
+	Format    string `long:"format" description:"output format, csv only keeps the keys and the values" env:"FORMAT" choice:"jsonl" choice:"csv" default:"jsonl"`
+	Output    string `long:"output" description:"path of the output file, - for stdout" env:"OUTPUT" default:"-"`
+	Level     string `long:"level" description:"read consistency level, the default level of the namespace if not set" env:"LEVEL"`
+}
+
+type importCommand struct {
+	Namespace   string `long:"namespace" description:"namespace to import the keys into" env:"NAMESPACE"`
+	Format      string `long:"format" description:"input format" env:"FORMAT" choice:"jsonl" choice:"csv" default:"jsonl"`
+	Input       string `long:"input" description:"path of the input file, - for stdin" env:"INPUT" default:"-"`
+	Level       string `long:"level" description:"consistency level of the reads and writes, the default levels of the namespace if not set" env:"LEVEL"`
+	BatchSize   int    `long:"batch-size" description:"number of records imported between the checkpoints" env:"BATCH_SIZE" default:"100"`
+	Parallelism int    `long:"parallelism" description:"number of records of a batch written concurrently" env:"PARALLELISM" default:"8"`
+	Rate        int    `long:"rate" description:"maximum number of records imported per second, 0 for no limit" env:"RATE"`
+	Retries     int    `long:"retries" description:"number of retries of a failed write before the import stops" env:"RETRIES" default:"3"`
+	Checkpoint  string `long:"checkpoint" description:"file keeping the number of records imported, to resume a failed import from" env:"CHECKPOINT"`
+	Overwrite   bool   `long:"overwrite" description:"overwrite the keys that already have a value, they are left intact otherwise" env:"OVERWRITE"`
+	Siblings    string `long:"siblings" description:"what to do with the keys that have concurrent values" env:"SIBLINGS" choice:"skip" choice:"first" default:"skip"`
+}
+
+var logger kitlog.Logger
+
+func main() {
+	p := flags.NewParser(&opts, flags.Default)
+
+	p.CommandHandler = func(cmd flags.Commander, args []string) error {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+		if !opts.Verbose {
+			logger = level.NewFilter(logger, level.AllowInfo())
+		}
+
+		return cmd.Execute(args)
+	}
+
+	if _, err := p.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok {
+			if flagsErr.Type != flags.ErrHelp {
+				fmt.Println("cli error:", err)
+			}
+
+			os.Exit(2)
+		}
+
+		level.Error(logger).Log("msg", "command failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+func (c *exportCommand) Execute([]string) error {
+	ctx := clientContext()
+
+	out := io.Writer(os.Stdout)
+
+	if c.Output != "-" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		out = f
+	}
+
+	w, err := newRecordWriter(c.Format, out)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dial(ctx, opts.Addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	nodes, err := dialNodes(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, node := range nodes {
+			node.Close()
+		}
+	}()
+
+	e := &exporter{
+		conn:   conn,
+		nodes:  nodes,
+		opts:   nodeapi.KeyOpts{Namespace: c.Namespace, Level: c.Level},
+		w:      w,
+		logger: logger,
+	}
+
+	ns := c.Namespace
+	if ns == "" {
+		ns = namespace.Default
+	}
+
+	exported, err := e.Run(ctx, keyRange{namespace: ns, prefix: c.Prefix, start: c.Start, end: c.End})
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "export complete", "keys", exported)
+
+	return nil
+}
+
+func (c *importCommand) Execute([]string) error {
+	ctx := clientContext()
+
+	in := io.Reader(os.Stdin)
+
+	if c.Input != "-" {
+		f, err := os.Open(c.Input)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		in = f
+	}
+
+	r, err := newRecordReader(c.Format, in)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dial(ctx, opts.Addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	im := &importer{
+		conn:        conn,
+		opts:        nodeapi.KeyOpts{Namespace: c.Namespace, Level: c.Level},
+		batchSize:   max(c.BatchSize, 1),
+		parallelism: max(c.Parallelism, 1),
+		rate:        c.Rate,
+		retries:     c.Retries,
+		overwrite:   c.Overwrite,
+		siblings:    c.Siblings,
+		checkpoint:  c.Checkpoint,
+		logger:      logger,
+	}
+
+	stats, err := im.Run(ctx, r)
+
+	level.Info(logger).Log("msg", "import finished", "skipped", stats.Skipped, "imported", stats.Imported,
+		"existing", stats.Existing, "conflicts", stats.Conflicts)
+
+	return err
+}
+
+// clientContext returns the context carrying the token of the client.
+func clientContext() context.Context {
+	ctx := context.Background()
+
+	if opts.Token != "" {
+		ctx = auth.OutgoingContext(auth.NewContext(ctx, opts.Token, nil))
+	}
+
+	return ctx
+}
+
+func dial(ctx context.Context, addr string) (nodeapi.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	conn, err := nodeapigrpc.Dial(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", addr, err)
+	}
+
+	return conn, nil
+}
+
+// dialNodes connects to every node of the cluster that has not left it, so that
+// the keys can be listed.
+func dialNodes(ctx context.Context, conn nodeapi.Client) ([]nodeapi.Client, error) {
+	nodes, err := conn.PullPushState(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster nodes: %w", err)
+	}
+
+	conns := make([]nodeapi.Client, 0, len(nodes))
+
+	for _, node := range nodes {
+		if node.Status == nodeapi.NodeStatusLeft {
+			continue
+		}
+
+		c, err := dial(ctx, node.Addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+
+			return nil, err
+		}
+
+		conns = append(conns, c)
+	}
+
+	return conns, nil
+}
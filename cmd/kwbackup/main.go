@@ -0,0 +1,169 @@
+// Command kwbackup takes an online backup of a cluster into a local directory,
+// and restores it into another cluster with the original versions of the keys.
+// The nodes are discovered from the node given with --addr, so the advertised
+// addresses of all nodes must be reachable from where the command runs. See
+// package backup for the format of the backup.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jessevdk/go-flags"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/backup"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+	replicationsvc "github.com/sadath-12/keywave/replication/service"
+)
+
+const dialTimeout = 10 * time.Second
+
+var opts struct {
+	Addr    string `long:"addr" description:"gRPC address of any node of the cluster" env:"ADDR" required:"true"`
+	Token   string `long:"token" description:"admin token, if the cluster requires authentication" env:"TOKEN"`
+	Verbose bool   `long:"verbose" description:"verbose mode" env:"VERBOSE"`
+
+	Backup  backupCommand  `command:"backup" description:"back up all nodes of the cluster into a directory"`
+	Restore restoreCommand `command:"restore" description:"restore a backup into the cluster"`
+}
+
+type backupCommand struct {
+	Dir string `long:"dir" description:"directory to write the backup to, must not hold another backup" env:"DIR" required:"true"`
+}
+
+type restoreCommand struct {
+	Dir           string `long:"dir" description:"directory of the backup" env:"DIR" required:"true"`
+	NamespaceFile string `long:"namespace-file" description:"namespace file of the target cluster, used to place the keys on their replicas" env:"NAMESPACE_FILE"`
+}
+
+var logger kitlog.Logger
+
+func main() {
+	p := flags.NewParser(&opts, flags.Default)
+
+	p.CommandHandler = func(cmd flags.Commander, args []string) error {
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+		if !opts.Verbose {
+			logger = level.NewFilter(logger, level.AllowInfo())
+		}
+
+		return cmd.Execute(args)
+	}
+
+	if _, err := p.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok {
+			if flagsErr.Type != flags.ErrHelp {
+				fmt.Println("cli error:", err)
+			}
+
+			os.Exit(2)
+		}
+
+		level.Error(logger).Log("msg", "command failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+func (c *backupCommand) Execute([]string) error {
+	ctx := clientContext()
+
+	nodes, err := clusterNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	dial := dialer()
+	start := time.Now()
+
+	manifest, err := backup.Create(ctx, c.Dir, nodes, dial, logger)
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "backup complete", "dir", c.Dir,
+		"nodes", len(manifest.Nodes), "took", time.Since(start))
+
+	return nil
+}
+
+func (c *restoreCommand) Execute([]string) error {
+	ctx := clientContext()
+
+	namespaces := namespace.NewRegistry(replicationsvc.DefaultNamespace())
+
+	if c.NamespaceFile != "" {
+		var err error
+
+		namespaces, err = namespace.LoadFile(c.NamespaceFile, replicationsvc.DefaultNamespace())
+		if err != nil {
+			return err
+		}
+	}
+
+	nodes, err := clusterNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	dial := dialer()
+	start := time.Now()
+
+	stats, err := backup.Restore(ctx, c.Dir, nodes, namespaces, dial, logger)
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "restore complete", "keys", stats.Keys,
+		"written", stats.Written, "skipped", stats.Skipped, "took", time.Since(start))
+
+	return nil
+}
+
+// clientContext returns the context carrying the token of the client. The
+// storage and membership services the backup uses are only open to the nodes
+// and to the administrators.
+func clientContext() context.Context {
+	ctx := context.Background()
+
+	if opts.Token != "" {
+		ctx = auth.OutgoingContext(auth.NewContext(ctx, opts.Token, nil))
+	}
+
+	return ctx
+}
+
+// dialer returns the dialer giving up on the nodes that do not respond in time.
+func dialer() nodeapi.Dialer {
+	return func(ctx context.Context, addr string) (nodeapi.Client, error) {
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+
+		return nodeapigrpc.Dial(ctx, addr)
+	}
+}
+
+// clusterNodes returns the nodes of the cluster as seen by the node at --addr.
+func clusterNodes(ctx context.Context) ([]membership.Node, error) {
+	conn, err := dialer()(ctx, opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", opts.Addr, err)
+	}
+
+	defer conn.Close()
+
+	// Pushing an empty state only pulls the state of the node.
+	nodes, err := conn.PullPushState(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster nodes: %w", err)
+	}
+
+	return membership.FromAPINodes(nodes), nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Verdict is the result of a single check.
+type Verdict string
+
+const (
+	VerdictPass         Verdict = "pass"
+	VerdictFail         Verdict = "fail"
+	VerdictInconclusive Verdict = "inconclusive"
+)
+
+// Violation is an anomaly found in the history.
+type Violation struct {
+	Key     string `json:"key"`
+	Ops     []int  `json:"ops,omitempty"`
+	Message string `json:"message"`
+}
+
+// CheckResult is the result of a single check over all keys.
+type CheckResult struct {
+	Name       string      `json:"name"`
+	Verdict    Verdict     `json:"verdict"`
+	Violations []Violation `json:"violations,omitempty"`
+	// Inconclusive are the keys the check could not decide on.
+	Inconclusive []string `json:"inconclusive,omitempty"`
+}
+
+func (r *CheckResult) fail(v Violation) {
+	r.Verdict = VerdictFail
+	r.Violations = append(r.Violations, v)
+}
+
+func (r *CheckResult) giveUp(key string) {
+	if r.Verdict == VerdictPass {
+		r.Verdict = VerdictInconclusive
+	}
+
+	r.Inconclusive = append(r.Inconclusive, key)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// checkLinearizability checks that each key behaves like a single register,
+// where every read returns the value of the latest write.
+func checkLinearizability(ops []Op, budget int) CheckResult {
+	res := CheckResult{Name: "linearizability", Verdict: VerdictPass}
+	perKey := byKey(ops)
+
+	for _, key := range sortedKeys(perKey) {
+		lin := checkLinearizable(perKey[key], budget)
+
+		switch {
+		case lin.inconclusive:
+			res.giveUp(key)
+		case !lin.ok:
+			v := Violation{Key: key, Message: "no linearization exists"}
+
+			if lin.stuck != nil {
+				v.Ops = []int{lin.stuck.ID}
+				v.Message = fmt.Sprintf("%s could not be linearized", describe(lin.stuck))
+			}
+
+			res.fail(v)
+		}
+	}
+
+	return res
+}
+
+// checkReadYourWrites checks that once a client has written a key, its following
+// reads of the key observe either that write or a write that may have happened
+// after it. Writes with an unknown outcome do not constrain the reads.
+func checkReadYourWrites(ops []Op) CheckResult {
+	res := CheckResult{Name: "read-your-writes", Verdict: VerdictPass}
+
+	writes := make(map[string]map[string]*Op) // key -> value -> put
+	deletes := make(map[string][]*Op)
+
+	for i := range ops {
+		op := &ops[i]
+		if op.Outcome == OutcomeFail {
+			continue
+		}
+
+		switch op.Kind {
+		case OpPut:
+			if writes[op.Key] == nil {
+				writes[op.Key] = make(map[string]*Op)
+			}
+
+			writes[op.Key][op.Value] = op
+		case OpDelete:
+			deletes[op.Key] = append(deletes[op.Key], op)
+		}
+	}
+
+	// notBefore returns true if the write can be ordered after the given one,
+	// i.e. it did not complete before the given write started.
+	notBefore := func(w, last *Op) bool {
+		return w != nil && w.Complete() >= last.Start
+	}
+
+	type session struct {
+		client int
+		key    string
+	}
+
+	last := make(map[session]*Op)
+
+	for i := range ops {
+		op := &ops[i]
+		s := session{op.Client, op.Key}
+
+		if op.IsWrite() {
+			switch op.Outcome {
+			case OutcomeOK:
+				last[s] = op
+			case OutcomeUnknown:
+				delete(last, s)
+			}
+
+			continue
+		}
+
+		w, ok := last[s]
+		if !ok || op.Outcome != OutcomeOK {
+			continue
+		}
+
+		observed := len(op.Values) == 0 && w.Kind == OpDelete
+
+		for _, v := range op.Values {
+			if (w.Kind == OpPut && v == w.Value) || notBefore(writes[op.Key][v], w) {
+				observed = true
+				break
+			}
+		}
+
+		if len(op.Values) == 0 && !observed {
+			for _, d := range deletes[op.Key] {
+				if notBefore(d, w) {
+					observed = true
+					break
+				}
+			}
+		}
+
+		if !observed {
+			res.fail(Violation{
+				Key:     op.Key,
+				Ops:     []int{w.ID, op.ID},
+				Message: fmt.Sprintf("%s did not observe the preceding %s", describe(op), describe(w)),
+			})
+		}
+	}
+
+	return res
+}
+
+// checkSiblings checks that no acknowledged write is lost. A write is allowed to
+// disappear only if it was superseded, i.e. another write was based on a read
+// that observed it. All other acknowledged values must survive in the final
+// state of the key, as siblings if there were concurrent writes. The final state
+// must not contain values that were never written either.
+func checkSiblings(ops []Op, final map[string][]string) CheckResult {
+	res := CheckResult{Name: "sibling-preservation", Verdict: VerdictPass}
+	perKey := byKey(ops)
+
+	for _, key := range sortedKeys(perKey) {
+		values, ok := final[key]
+		if !ok {
+			res.giveUp(key)
+			continue
+		}
+
+		var (
+			present    = make(map[string]bool, len(values))
+			superseded = make(map[string]bool)
+			written    = make(map[string]bool)
+			lost       []string
+			lostOps    []int
+		)
+
+		for _, v := range values {
+			present[v] = true
+		}
+
+		// Writes with an unknown outcome may have been applied, so the values
+		// they observed may be legitimately gone.
+		for _, op := range perKey[key] {
+			if op.IsWrite() && op.Outcome != OutcomeFail {
+				for _, v := range op.Observed {
+					superseded[v] = true
+				}
+
+				if op.Kind == OpPut {
+					written[op.Value] = true
+				}
+			}
+		}
+
+		for _, op := range perKey[key] {
+			if op.Kind == OpPut && op.Outcome == OutcomeOK && !superseded[op.Value] && !present[op.Value] {
+				lost = append(lost, op.Value)
+				lostOps = append(lostOps, op.ID)
+			}
+		}
+
+		if len(lost) > 0 {
+			res.fail(Violation{
+				Key:     key,
+				Ops:     lostOps,
+				Message: fmt.Sprintf("acknowledged writes lost: %s (final values: %s)", strings.Join(lost, ", "), formatValues(values)),
+			})
+		}
+
+		for _, v := range values {
+			if !written[v] {
+				res.fail(Violation{
+					Key:     key,
+					Message: fmt.Sprintf("final state contains the value %q that was never written", v),
+				})
+			}
+		}
+	}
+
+	return res
+}
+
+func describe(op *Op) string {
+	switch op.Kind {
+	case OpPut:
+		return fmt.Sprintf("op %d (client %d put %q via %s)", op.ID, op.Client, op.Value, op.Node)
+	case OpDelete:
+		return fmt.Sprintf("op %d (client %d delete via %s)", op.ID, op.Client, op.Node)
+	default:
+		return fmt.Sprintf("op %d (client %d get %s via %s)", op.ID, op.Client, formatValues(op.Values), op.Node)
+	}
+}
+
+func formatValues(values []string) string {
+	if len(values) == 0 {
+		return "nothing"
+	}
+
+	return "[" + strings.Join(values, " ") + "]"
+}
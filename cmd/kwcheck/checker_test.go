@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func put(id, client int, value string, start, end time.Duration, observed ...string) Op {
+	return Op{ID: id, Client: client, Kind: OpPut, Key: "k", Value: value, Observed: observed, Start: start, End: end, Outcome: OutcomeOK}
+}
+
+func get(id, client int, start, end time.Duration, values ...string) Op {
+	return Op{ID: id, Client: client, Kind: OpGet, Key: "k", Values: values, Start: start, End: end, Outcome: OutcomeOK}
+}
+
+func unknown(op Op) Op {
+	op.Outcome = OutcomeUnknown
+	return op
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	tests := map[string]struct {
+		ops  []Op
+		want bool
+	}{
+		"sequential": {
+			ops: []Op{
+				put(1, 1, "a", 0, 10),
+				get(2, 2, 20, 30, "a"),
+			},
+			want: true,
+		},
+		"stale read": {
+			ops: []Op{
+				put(1, 1, "a", 0, 10),
+				put(2, 1, "b", 20, 30),
+				get(3, 2, 40, 50, "a"),
+			},
+			want: false,
+		},
+		"concurrent read sees either": {
+			ops: []Op{
+				put(1, 1, "a", 0, 10),
+				put(2, 1, "b", 20, 40),
+				get(3, 2, 25, 30, "a"),
+				get(4, 3, 35, 50, "b"),
+			},
+			want: true,
+		},
+		"read goes back in time": {
+			ops: []Op{
+				put(1, 1, "a", 0, 10),
+				put(2, 1, "b", 20, 60),
+				get(3, 2, 25, 30, "b"),
+				get(4, 3, 35, 50, "a"),
+			},
+			want: false,
+		},
+		"siblings": {
+			ops: []Op{
+				put(1, 1, "a", 0, 10),
+				put(2, 2, "b", 0, 10),
+				get(3, 3, 20, 30, "a", "b"),
+			},
+			want: false,
+		},
+		"unknown write may apply later": {
+			ops: []Op{
+				unknown(put(1, 1, "a", 0, 10)),
+				get(2, 2, 20, 30),
+				get(3, 2, 40, 50, "a"),
+			},
+			want: true,
+		},
+		"unknown write may never apply": {
+			ops: []Op{
+				unknown(put(1, 1, "a", 0, 10)),
+				get(2, 2, 20, 30),
+			},
+			want: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			res := checkLinearizable(tt.ops, 1000)
+			if res.inconclusive {
+				t.Fatal("search budget exhausted")
+			}
+
+			if res.ok != tt.want {
+				t.Errorf("got %v, want %v", res.ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLinearizableBudget(t *testing.T) {
+	var ops []Op
+	for i := 0; i < 20; i++ {
+		ops = append(ops, put(i+1, i, string(rune('a'+i)), 0, 100))
+	}
+
+	ops = append(ops, get(21, 21, 200, 300, "x"))
+
+	if res := checkLinearizable(ops, 100); !res.inconclusive {
+		t.Errorf("expected inconclusive result, got %+v", res)
+	}
+}
+
+func TestCheckReadYourWrites(t *testing.T) {
+	ok := checkReadYourWrites([]Op{
+		put(1, 1, "a", 0, 10),
+		put(2, 2, "b", 5, 30),
+		get(3, 1, 40, 50, "b"),
+	})
+	if ok.Verdict != VerdictPass {
+		t.Errorf("concurrent overwrite: got %s", ok.Verdict)
+	}
+
+	stale := checkReadYourWrites([]Op{
+		put(1, 2, "b", 0, 5),
+		put(2, 1, "a", 10, 20),
+		get(3, 1, 30, 40, "b"),
+	})
+	if stale.Verdict != VerdictFail {
+		t.Errorf("stale read: got %s", stale.Verdict)
+	}
+}
+
+func TestCheckSiblings(t *testing.T) {
+	ops := []Op{
+		put(1, 1, "a", 0, 10),
+		put(2, 2, "b", 0, 10),
+		put(3, 3, "c", 20, 30, "a"),
+	}
+
+	if res := checkSiblings(ops, map[string][]string{"k": {"b", "c"}}); res.Verdict != VerdictPass {
+		t.Errorf("superseded value: got %s %v", res.Verdict, res.Violations)
+	}
+
+	if res := checkSiblings(ops, map[string][]string{"k": {"c"}}); res.Verdict != VerdictFail {
+		t.Errorf("lost sibling: got %s", res.Verdict)
+	}
+
+	if res := checkSiblings(ops, map[string][]string{}); res.Verdict != VerdictInconclusive {
+		t.Errorf("missing final state: got %s", res.Verdict)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/internal/multierror"
+)
+
+const stopTimeout = 10 * time.Second
+
+// process is a single node of the local cluster.
+type process struct {
+	id       int
+	grpcAddr string
+	restAddr string
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	paused   bool
+}
+
+// localCluster manages the server processes running on the local machine, so
+// that the faults can be injected by sending signals to them.
+type localCluster struct {
+	bin    string
+	logDir string
+	args   []string
+	logger kitlog.Logger
+
+	mut   sync.Mutex
+	nodes []*process
+}
+
+func newLocalCluster(bin, logDir string, nodes, basePort int, args []string, logger kitlog.Logger) *localCluster {
+	lc := &localCluster{
+		bin:    bin,
+		logDir: logDir,
+		args:   args,
+		logger: logger,
+	}
+
+	for i := 1; i <= nodes; i++ {
+		lc.nodes = append(lc.nodes, &process{
+			id:       i,
+			grpcAddr: fmt.Sprintf("127.0.0.1:%d", basePort+i-1),
+			restAddr: fmt.Sprintf("127.0.0.1:%d", basePort+i-1+1000),
+		})
+	}
+
+	return lc
+}
+
+// Addrs returns the gRPC addresses of the nodes.
+func (lc *localCluster) Addrs() []string {
+	addrs := make([]string, len(lc.nodes))
+	for i, p := range lc.nodes {
+		addrs[i] = p.grpcAddr
+	}
+
+	return addrs
+}
+
+// Size returns the number of nodes.
+func (lc *localCluster) Size() int {
+	return len(lc.nodes)
+}
+
+// Start starts all nodes, each of them joining the others.
+func (lc *localCluster) Start() error {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	for _, p := range lc.nodes {
+		if err := lc.start(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (lc *localCluster) start(p *process) error {
+	var peers []string
+
+	for _, other := range lc.nodes {
+		if other != p {
+			peers = append(peers, other.grpcAddr)
+		}
+	}
+
+	args := append([]string{
+		fmt.Sprintf("--node.id=%d", p.id),
+		fmt.Sprintf("--node.name=kwcheck-%d", p.id),
+		"--grpc.bind-addr=" + p.grpcAddr,
+		"--grpc.local-addr=" + p.grpcAddr,
+		"--grpc.public-addr=" + p.grpcAddr,
+		"--restapi.bind-addr=" + p.restAddr,
+		"--cluster.join-addrs=" + strings.Join(peers, ","),
+	}, lc.args...)
+
+	logFile, err := os.OpenFile(
+		filepath.Join(lc.logDir, fmt.Sprintf("kwcheck-node-%d.log", p.id)),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		0o644,
+	)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+
+	cmd := exec.Command(lc.bin, args...) //nolint:gosec
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return fmt.Errorf("start node %d: %w", p.id, err)
+	}
+
+	p.cmd = cmd
+	p.paused = false
+	p.exited = make(chan struct{})
+
+	go func(exited chan struct{}) {
+		_ = cmd.Wait()
+		logFile.Close()
+		close(exited)
+	}(p.exited)
+
+	level.Info(lc.logger).Log("msg", "node started", "node_id", p.id, "pid", cmd.Process.Pid)
+
+	return nil
+}
+
+// Kill stops the node abruptly. The node loses its in-memory data.
+func (lc *localCluster) Kill(id int) error {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	p := lc.nodes[id-1]
+
+	if err := p.cmd.Process.Signal(syscall.SIGKILL); err != nil {
+		return fmt.Errorf("kill node %d: %w", id, err)
+	}
+
+	<-p.exited
+
+	return nil
+}
+
+// Restart starts the killed node again.
+func (lc *localCluster) Restart(id int) error {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	return lc.start(lc.nodes[id-1])
+}
+
+// Pause freezes the node, which stops responding without closing its connections.
+func (lc *localCluster) Pause(id int) error {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	p := lc.nodes[id-1]
+
+	if err := p.cmd.Process.Signal(syscall.SIGSTOP); err != nil {
+		return fmt.Errorf("pause node %d: %w", id, err)
+	}
+
+	p.paused = true
+
+	return nil
+}
+
+// Resume continues the paused node.
+func (lc *localCluster) Resume(id int) error {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	return lc.resume(lc.nodes[id-1])
+}
+
+func (lc *localCluster) resume(p *process) error {
+	if !p.paused {
+		return nil
+	}
+
+	if err := p.cmd.Process.Signal(syscall.SIGCONT); err != nil {
+		return fmt.Errorf("resume node %d: %w", p.id, err)
+	}
+
+	p.paused = false
+
+	return nil
+}
+
+// Stop shuts down all nodes gracefully, killing the ones that do not stop in time.
+func (lc *localCluster) Stop() error {
+	lc.mut.Lock()
+	defer lc.mut.Unlock()
+
+	errs := multierror.New[int]()
+
+	for _, p := range lc.nodes {
+		if p.cmd == nil {
+			continue
+		}
+
+		select {
+		case <-p.exited:
+			continue
+		default:
+		}
+
+		if err := lc.resume(p); err != nil {
+			errs.Add(p.id, err)
+		}
+
+		if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			errs.Add(p.id, err)
+			continue
+		}
+
+		select {
+		case <-p.exited:
+		case <-time.After(stopTimeout):
+			level.Warn(lc.logger).Log("msg", "node did not stop in time, killing it", "node_id", p.id)
+
+			_ = p.cmd.Process.Kill()
+			<-p.exited
+		}
+	}
+
+	return errs.Combined()
+}
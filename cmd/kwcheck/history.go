@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OpKind is the kind of the operation performed by a client.
+type OpKind string
+
+const (
+	OpGet    OpKind = "get"
+	OpPut    OpKind = "put"
+	OpDelete OpKind = "delete"
+)
+
+// Outcome is what the client knows about the effect of the operation.
+type Outcome string
+
+const (
+	// OutcomeOK means the operation succeeded.
+	OutcomeOK Outcome = "ok"
+	// OutcomeFail means the operation definitely had no effect.
+	OutcomeFail Outcome = "fail"
+	// OutcomeUnknown means the operation may or may not have taken effect, e.g.
+	// when it timed out after the primary replica accepted the write.
+	OutcomeUnknown Outcome = "unknown"
+)
+
+// infinity is the completion time of the operations with an unknown outcome,
+// which may take effect at any point after they were invoked.
+const infinity = time.Duration(math.MaxInt64)
+
+// Op is a single operation of the history.
+type Op struct {
+	ID     int    `json:"id"`
+	Client int    `json:"client"`
+	Node   string `json:"node"`
+	Kind   OpKind `json:"kind"`
+	Key    string `json:"key"`
+	// Value is the value written by a put.
+	Value string `json:"value,omitempty"`
+	// Values are the values returned by a get, more than one if there are siblings.
+	Values []string `json:"values,omitempty"`
+	// Observed are the values returned by the get the version of a put or a
+	// delete was taken from. The write supersedes them.
+	Observed []string      `json:"observed,omitempty"`
+	Start    time.Duration `json:"start"`
+	End      time.Duration `json:"end"`
+	Outcome  Outcome       `json:"outcome"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// IsWrite returns true if the operation modifies the key.
+func (op *Op) IsWrite() bool {
+	return op.Kind == OpPut || op.Kind == OpDelete
+}
+
+// Complete returns the time the operation is known to be complete by, which is
+// infinity if the outcome is unknown.
+func (op *Op) Complete() time.Duration {
+	if op.Outcome == OutcomeUnknown {
+		return infinity
+	}
+
+	return op.End
+}
+
+// History records the operations of all clients. Times are relative to the
+// creation of the history.
+type History struct {
+	mut   sync.Mutex
+	start time.Time
+	ops   []Op
+}
+
+func NewHistory() *History {
+	return &History{start: time.Now()}
+}
+
+// Now returns the time since the start of the history.
+func (h *History) Now() time.Duration {
+	return time.Since(h.start)
+}
+
+// Add appends the completed operation to the history and assigns it an ID.
+func (h *History) Add(op Op) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	op.ID = len(h.ops) + 1
+	h.ops = append(h.ops, op)
+}
+
+// Ops returns the operations ordered by their start time.
+func (h *History) Ops() []Op {
+	h.mut.Lock()
+	ops := make([]Op, len(h.ops))
+	copy(ops, h.ops)
+	h.mut.Unlock()
+
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].Start < ops[j].Start
+	})
+
+	return ops
+}
+
+// byKey groups the operations by key, preserving their order.
+func byKey(ops []Op) map[string][]Op {
+	res := make(map[string][]Op)
+	for _, op := range ops {
+		res[op.Key] = append(res[op.Key], op)
+	}
+
+	return res
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// registerState is the value of the key, empty if the key does not exist. The
+// values written by the workload are never empty.
+type registerState = string
+
+// step applies the operation to the register, and returns false if the
+// operation is not possible in the state. A read is expected to return exactly
+// the current value, so siblings are never linearizable.
+func step(state registerState, op *Op) (registerState, bool) {
+	switch op.Kind {
+	case OpPut:
+		return op.Value, true
+	case OpDelete:
+		return "", true
+	default:
+		if state == "" {
+			return state, len(op.Values) == 0
+		}
+
+		return state, len(op.Values) == 1 && op.Values[0] == state
+	}
+}
+
+// entry is either the invocation or the completion of an operation. The entries
+// form a doubly linked list ordered by time, so that the linearized operations
+// can be cheaply removed from it and put back when backtracking.
+type entry struct {
+	op         int
+	call       bool
+	time       int64
+	match      *entry
+	prev, next *entry
+}
+
+func (e *entry) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+
+	m := e.match
+	m.prev.next = m.next
+
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+func (e *entry) unlift() {
+	m := e.match
+	m.prev.next = m
+
+	if m.next != nil {
+		m.next.prev = m
+	}
+
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)   { b[i/64] |= 1 << (i % 64) }
+func (b bitset) clear(i int) { b[i/64] &^= 1 << (i % 64) }
+
+func (b bitset) key(state registerState) string {
+	var sb strings.Builder
+
+	for _, w := range b {
+		for i := 0; i < 8; i++ {
+			sb.WriteByte(byte(w >> (8 * i)))
+		}
+	}
+
+	sb.WriteString(state)
+
+	return sb.String()
+}
+
+// linearizabilityResult is the outcome of the search for a linearization.
+type linearizabilityResult struct {
+	ok bool
+	// inconclusive is set if the search budget was exhausted.
+	inconclusive bool
+	// stuck is the operation that could not be linearized in the longest
+	// linearizable prefix found.
+	stuck *Op
+}
+
+// checkLinearizable checks whether the history of a single key is linearizable
+// with respect to a register, using the algorithm by Wing & Gong with the
+// memoization by Lowe. Operations with an unknown outcome complete at infinity,
+// so they can be linearized at any point after their invocation, including the
+// very end, which is the same as never taking effect. Failed operations have no
+// effect and are ignored.
+func checkLinearizable(history []Op, budget int) linearizabilityResult {
+	var ops []Op
+
+	for _, op := range history {
+		if op.Outcome == OutcomeOK || (op.Outcome == OutcomeUnknown && op.IsWrite()) {
+			ops = append(ops, op)
+		}
+	}
+
+	if len(ops) == 0 {
+		return linearizabilityResult{ok: true}
+	}
+
+	entries := make([]*entry, 0, 2*len(ops))
+
+	for i := range ops {
+		call := &entry{op: i, call: true, time: int64(ops[i].Start)}
+		ret := &entry{op: i, time: int64(ops[i].Complete())}
+		call.match = ret
+		entries = append(entries, call, ret)
+	}
+
+	// Invocations go before the completions with the same timestamp, treating
+	// such operations as concurrent, which never produces a false violation.
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+
+		return entries[i].call && !entries[j].call
+	})
+
+	head := &entry{}
+	prev := head
+
+	for _, e := range entries {
+		e.prev = prev
+		prev.next = e
+		prev = e
+	}
+
+	type frame struct {
+		entry *entry
+		state registerState
+	}
+
+	var (
+		state      registerState
+		linearized = newBitset(len(ops))
+		cache      = make(map[string]struct{})
+		stack      []frame
+		best       = -1
+		stuck      *Op
+		iterations int
+	)
+
+	e := head.next
+
+	for head.next != nil {
+		if iterations++; iterations > budget {
+			return linearizabilityResult{inconclusive: true}
+		}
+
+		if e.call {
+			if next, ok := step(state, &ops[e.op]); ok {
+				linearized.set(e.op)
+				key := linearized.key(next)
+
+				if _, seen := cache[key]; !seen {
+					cache[key] = struct{}{}
+					stack = append(stack, frame{e, state})
+					state = next
+					e.lift()
+					e = head.next
+
+					continue
+				}
+
+				linearized.clear(e.op)
+			}
+
+			e = e.next
+
+			continue
+		}
+
+		// A completion is reached before the operation was linearized, so the
+		// current prefix cannot be extended, and the search has to backtrack.
+		if len(stack) > best {
+			best = len(stack)
+			stuck = &ops[e.op]
+		}
+
+		if len(stack) == 0 {
+			return linearizabilityResult{stuck: stuck}
+		}
+
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		state = top.state
+		linearized.clear(top.entry.op)
+		top.entry.unlift()
+		e = top.entry.next
+	}
+
+	return linearizabilityResult{ok: true}
+}
@@ -0,0 +1,312 @@
+// Command kwcheck runs concurrent histories of gets, puts and deletes against a
+// cluster while injecting faults into it, and checks the recorded histories for
+// linearizability, read-your-writes and preservation of concurrent writes. It is
+// meant to show which guarantees each combination of the read and write
+// consistency levels actually provides.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/jessevdk/go-flags"
+
+	"github.com/sadath-12/keywave/nodeapi"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+	"github.com/sadath-12/keywave/replication/consistency"
+)
+
+const (
+	readyTimeout     = 30 * time.Second
+	finalReadTimeout = 30 * time.Second
+)
+
+var errReady = errors.New("ready")
+
+func main() {
+	p := flags.NewParser(&opts, flags.Default)
+
+	if _, err := p.Parse(); err != nil {
+		if err.(*flags.Error).Type != flags.ErrHelp {
+			fmt.Println("cli error:", err)
+		}
+
+		os.Exit(2)
+	}
+
+	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+	if !opts.Verbose {
+		logger = level.NewFilter(logger, level.AllowInfo())
+	}
+
+	report, err := run(logger)
+	if err != nil {
+		level.Error(logger).Log("msg", "check failed to run", "err", err)
+		os.Exit(2)
+	}
+
+	report.Print(os.Stdout)
+
+	if opts.Check.Report != "" {
+		if err := report.WriteFile(opts.Check.Report); err != nil {
+			level.Error(logger).Log("msg", "failed to write report", "err", err)
+		}
+	}
+
+	if report.Failed() {
+		os.Exit(1)
+	}
+}
+
+func run(logger kitlog.Logger) (*Report, error) {
+	readLevels, err := parseLevels(opts.Workload.ReadLevels)
+	if err != nil {
+		return nil, err
+	}
+
+	writeLevels, err := parseLevels(opts.Workload.WriteLevels)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := opts.Workload.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	level.Info(logger).Log("msg", "starting check", "seed", seed)
+
+	var (
+		addrs   = splitList(opts.Cluster.Addrs)
+		cluster *localCluster
+	)
+
+	if len(addrs) == 0 {
+		cluster = newLocalCluster(
+			opts.Cluster.ServerBin,
+			opts.Cluster.LogDir,
+			opts.Cluster.Nodes,
+			opts.Cluster.BasePort,
+			opts.Cluster.ServerArgs,
+			logger,
+		)
+
+		if err := cluster.Start(); err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			if err := cluster.Stop(); err != nil {
+				level.Warn(logger).Log("msg", "failed to stop cluster", "err", err)
+			}
+		}()
+
+		addrs = cluster.Addrs()
+	} else if opts.Nemesis.Kind != "none" {
+		level.Warn(logger).Log("msg", "faults cannot be injected into an external cluster, running without them")
+		opts.Nemesis.Kind = "none"
+	}
+
+	conns, err := dialAll(addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	if err := waitReady(conns, len(addrs)); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Seed: seed}
+	rnd := rand.New(rand.NewSource(seed)) //nolint:gosec
+
+	for _, readLevel := range readLevels {
+		for _, writeLevel := range writeLevels {
+			res := runCombination(conns, addrs, cluster, readLevel, writeLevel, rnd.Int63(), logger)
+			report.Runs = append(report.Runs, res)
+		}
+	}
+
+	return report, nil
+}
+
+// runCombination runs the workload with the given levels on a fresh set of keys,
+// lets the cluster recover from the faults, reads the final state of the keys
+// and checks the history.
+func runCombination(
+	conns []nodeapi.Client,
+	addrs []string,
+	cluster *localCluster,
+	readLevel, writeLevel consistency.Level,
+	seed int64,
+	logger kitlog.Logger,
+) *RunReport {
+	logger = kitlog.With(logger, "read_level", readLevel, "write_level", writeLevel)
+
+	keys := make([]string, opts.Workload.Keys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("kwcheck-%d-%d", seed, i)
+	}
+
+	w := &workload{
+		conns:       conns,
+		addrs:       addrs,
+		keys:        keys,
+		clients:     opts.Workload.Clients,
+		readRatio:   opts.Workload.ReadRatio,
+		deleteRatio: opts.Workload.DeleteRatio,
+		opTimeout:   time.Duration(opts.Workload.OpTimeout) * time.Millisecond,
+		readOpts:    nodeapi.KeyOpts{Namespace: opts.Workload.Namespace, Level: readLevel.String()},
+		writeOpts:   nodeapi.KeyOpts{Namespace: opts.Workload.Namespace, Level: writeLevel.String()},
+		sessions:    opts.Workload.Sessions,
+		seed:        seed,
+		logger:      logger,
+	}
+
+	n := &nemesis{
+		cluster:  cluster,
+		kind:     opts.Nemesis.Kind,
+		interval: time.Duration(opts.Nemesis.Interval) * time.Millisecond,
+		length:   time.Duration(opts.Nemesis.Length) * time.Millisecond,
+		rand:     rand.New(rand.NewSource(seed)), //nolint:gosec
+		logger:   logger,
+	}
+
+	level.Info(logger).Log("msg", "running workload")
+
+	history := NewHistory()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(opts.Workload.Duration)*time.Millisecond)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		n.Run(ctx, history)
+	}()
+
+	w.Run(ctx, history)
+	cancel()
+	<-done
+
+	level.Info(logger).Log("msg", "waiting for the cluster to recover")
+	time.Sleep(time.Duration(opts.Check.Settle) * time.Millisecond)
+
+	ops := history.Ops()
+	report := newRunReport(readLevel.String(), writeLevel.String(), opts.Nemesis.Kind, ops)
+	report.Faults = n.faults
+	report.Final = finalState(conns, keys, logger)
+
+	report.Checks = []CheckResult{
+		checkLinearizability(ops, opts.Check.MaxSearch),
+		checkReadYourWrites(ops),
+		checkSiblings(ops, report.Final),
+	}
+
+	return report
+}
+
+func dialAll(addrs []string) ([]nodeapi.Client, error) {
+	conns := make([]nodeapi.Client, 0, len(addrs))
+
+	for _, addr := range addrs {
+		ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+		conn, err := nodeapigrpc.Dial(ctx, addr)
+		cancel()
+
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+
+			return nil, fmt.Errorf("dial %s: %w", addr, err)
+		}
+
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
+
+// waitReady waits until every node sees the expected number of healthy nodes.
+func waitReady(conns []nodeapi.Client, size int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+
+	for _, conn := range conns {
+		for {
+			healthy := make(map[nodeapi.NodeID]bool)
+
+			err := conn.WatchNodes(ctx, true, func(ev nodeapi.NodeEvent) error {
+				healthy[ev.Node.ID] = ev.Node.Status == nodeapi.NodeStatusHealthy
+
+				count := 0
+				for _, ok := range healthy {
+					if ok {
+						count++
+					}
+				}
+
+				if count >= size {
+					return errReady
+				}
+
+				return nil
+			})
+
+			if errors.Is(err, errReady) {
+				break
+			}
+
+			if ctx.Err() != nil {
+				return fmt.Errorf("cluster is not ready: %w", ctx.Err())
+			}
+
+			// The node may not be listening yet.
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	return nil
+}
+
+// finalState reads every key with the consistency level all, retrying through
+// the nodes until it succeeds. Keys that could not be read are left out.
+func finalState(conns []nodeapi.Client, keys []string, logger kitlog.Logger) map[string][]string {
+	ctx, cancel := context.WithTimeout(context.Background(), finalReadTimeout)
+	defer cancel()
+
+	res := make(map[string][]string, len(keys))
+	keyOpts := nodeapi.KeyOpts{Namespace: opts.Workload.Namespace, Level: consistency.All.String()}
+
+	for _, key := range keys {
+		for attempt := 0; ctx.Err() == nil; attempt++ {
+			conn := conns[attempt%len(conns)]
+
+			got, err := conn.GetKey(ctx, key, keyOpts)
+			if err == nil {
+				res[key] = toStrings(got.Values)
+				break
+			}
+
+			level.Debug(logger).Log("msg", "final read failed", "key", key, "err", err)
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if _, ok := res[key]; !ok {
+			level.Warn(logger).Log("msg", "failed to read the final state", "key", key)
+		}
+	}
+
+	return res
+}
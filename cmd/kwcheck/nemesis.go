@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Fault is a fault injected into the cluster, recorded along with the history.
+type Fault struct {
+	Node   int           `json:"node"`
+	Action string        `json:"action"`
+	Time   time.Duration `json:"time"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// nemesis periodically disrupts a random node of the cluster, one at a time.
+type nemesis struct {
+	cluster  *localCluster
+	kind     string
+	interval time.Duration
+	length   time.Duration
+	rand     *rand.Rand
+	logger   kitlog.Logger
+	faults   []Fault
+}
+
+// Run injects the faults until the context is canceled. The last fault is
+// always healed before it returns.
+func (n *nemesis) Run(ctx context.Context, history *History) {
+	if n.kind == "none" {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(n.interval):
+		}
+
+		node := n.rand.Intn(n.cluster.Size()) + 1
+
+		switch n.kind {
+		case "kill":
+			n.record(history, node, "kill", n.cluster.Kill(node))
+		case "pause":
+			n.record(history, node, "pause", n.cluster.Pause(node))
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(n.length):
+		}
+
+		switch n.kind {
+		case "kill":
+			n.record(history, node, "restart", n.cluster.Restart(node))
+		case "pause":
+			n.record(history, node, "resume", n.cluster.Resume(node))
+		}
+	}
+}
+
+func (n *nemesis) record(history *History, node int, action string, err error) {
+	fault := Fault{
+		Node:   node,
+		Action: action,
+		Time:   history.Now(),
+	}
+
+	if err != nil {
+		fault.Error = err.Error()
+		level.Error(n.logger).Log("msg", "failed to inject fault", "node_id", node, "action", action, "err", err)
+	} else {
+		level.Info(n.logger).Log("msg", "fault injected", "node_id", node, "action", action)
+	}
+
+	n.faults = append(n.faults, fault)
+}
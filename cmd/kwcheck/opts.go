@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sadath-12/keywave/replication/consistency"
+)
+
+var opts struct {
+	Cluster struct {
+		Addrs      string   `long:"addrs" description:"comma-separated gRPC addresses of an already running cluster, no nodes are started and no faults are injected if set" env:"ADDRS"`
+		ServerBin  string   `long:"server-bin" description:"path to the server binary" env:"SERVER_BIN" default:"./server"`
+		Nodes      int      `long:"nodes" description:"number of nodes to start" env:"NODES" default:"3"`
+		BasePort   int      `long:"base-port" description:"gRPC port of the first node, the REST API ports start at base port + 1000" env:"BASE_PORT" default:"13001"`
+		LogDir     string   `long:"log-dir" description:"directory for the logs of the started nodes" env:"LOG_DIR" default:"."`
+		ServerArgs []string `long:"server-arg" description:"extra argument passed to every started node (can be repeated)" env:"SERVER_ARGS" env-delim:" "`
+	} `group:"cluster" namespace:"cluster" env-namespace:"CLUSTER"`
+
+	Workload struct {
+		Clients     int    `long:"clients" description:"number of concurrent clients" env:"CLIENTS" default:"8"`
+		Keys        int    `long:"keys" description:"number of keys the clients work on" env:"KEYS" default:"4"`
+		Duration    int    `long:"duration" description:"duration of the workload for each combination of levels (ms)" env:"DURATION" default:"20000"`
+		OpTimeout   int    `long:"op-timeout" description:"timeout of a single operation (ms)" env:"OP_TIMEOUT" default:"1000"`
+		ReadRatio   int    `long:"read-ratio" description:"percentage of reads among the operations" env:"READ_RATIO" default:"50"`
+		DeleteRatio int    `long:"delete-ratio" description:"percentage of deletes among the operations" env:"DELETE_RATIO" default:"5"`
+		ReadLevels  string `long:"read-levels" description:"comma-separated read consistency levels to check" env:"READ_LEVELS" default:"quorum"`
+		WriteLevels string `long:"write-levels" description:"comma-separated write consistency levels to check" env:"WRITE_LEVELS" default:"quorum"`
+		Namespace   string `long:"namespace" description:"namespace of the keys" env:"NAMESPACE"`
+		Seed        int64  `long:"seed" description:"seed of the random choices, 0 picks a random one" env:"SEED"`
+		Sessions    bool   `long:"sessions" description:"pass the session token of the writes of a client to its later reads and writes" env:"SESSIONS"`
+	} `group:"workload" namespace:"workload" env-namespace:"WORKLOAD"`
+
+	Nemesis struct {
+		Kind     string `long:"kind" description:"fault injected into the cluster" env:"KIND" choice:"none" choice:"kill" choice:"pause" default:"kill"`
+		Interval int    `long:"interval" description:"interval between the faults (ms)" env:"INTERVAL" default:"5000"`
+		Length   int    `long:"length" description:"how long a fault lasts (ms)" env:"LENGTH" default:"3000"`
+	} `group:"nemesis" namespace:"nemesis" env-namespace:"NEMESIS"`
+
+	Check struct {
+		Settle    int    `long:"settle" description:"time given to the cluster to recover before the final reads (ms)" env:"SETTLE" default:"5000"`
+		MaxSearch int    `long:"max-search" description:"search budget of the linearizability checker per key, the result is inconclusive when exceeded" env:"MAX_SEARCH" default:"1000000"`
+		Report    string `long:"report" description:"path to write the JSON report with the full histories to" env:"REPORT"`
+	} `group:"check" namespace:"check" env-namespace:"CHECK"`
+
+	Verbose bool `long:"verbose" description:"verbose mode" env:"VERBOSE"`
+}
+
+func splitList(s string) []string {
+	sl := strings.Split(s, ",")
+	res := make([]string, 0, len(sl))
+
+	for _, item := range sl {
+		trimmed := strings.TrimSpace(item)
+		if trimmed != "" {
+			res = append(res, trimmed)
+		}
+	}
+
+	return res
+}
+
+func parseLevels(levels string) ([]consistency.Level, error) {
+	var res []consistency.Level
+
+	for _, s := range splitList(levels) {
+		l, ok := consistency.FromString(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid consistency level %q", s)
+		}
+
+		res = append(res, l)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("no consistency levels given")
+	}
+
+	return res, nil
+}
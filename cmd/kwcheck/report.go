@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxPrintedViolations limits the violations printed per check, the full list is
+// available in the JSON report.
+const maxPrintedViolations = 5
+
+// RunReport is the result of the workload run with one combination of the
+// consistency levels.
+type RunReport struct {
+	ReadLevel  string                     `json:"read_level"`
+	WriteLevel string                     `json:"write_level"`
+	Nemesis    string                     `json:"nemesis"`
+	Stats      map[OpKind]map[Outcome]int `json:"stats"`
+	Checks     []CheckResult              `json:"checks"`
+	Faults     []Fault                    `json:"faults,omitempty"`
+	Final      map[string][]string        `json:"final"`
+	History    []Op                       `json:"history"`
+}
+
+func newRunReport(readLevel, writeLevel, nemesis string, ops []Op) *RunReport {
+	stats := make(map[OpKind]map[Outcome]int)
+
+	for _, op := range ops {
+		if stats[op.Kind] == nil {
+			stats[op.Kind] = make(map[Outcome]int)
+		}
+
+		stats[op.Kind][op.Outcome]++
+	}
+
+	return &RunReport{
+		ReadLevel:  readLevel,
+		WriteLevel: writeLevel,
+		Nemesis:    nemesis,
+		Stats:      stats,
+		History:    ops,
+	}
+}
+
+// Failed returns true if any of the checks found a violation.
+func (r *RunReport) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Verdict == VerdictFail {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report is the result of all runs.
+type Report struct {
+	Seed int64        `json:"seed"`
+	Runs []*RunReport `json:"runs"`
+}
+
+// Failed returns true if any of the runs failed.
+func (r *Report) Failed() bool {
+	for _, run := range r.Runs {
+		if run.Failed() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Print writes the human-readable report: the details of every run followed by
+// the summary table with a row per combination of the levels.
+func (r *Report) Print(w io.Writer) {
+	for _, run := range r.Runs {
+		fmt.Fprintf(w, "read=%s write=%s nemesis=%s\n", run.ReadLevel, run.WriteLevel, run.Nemesis)
+
+		for _, kind := range []OpKind{OpGet, OpPut, OpDelete} {
+			s := run.Stats[kind]
+			fmt.Fprintf(w, "  %-7s ok=%d fail=%d unknown=%d\n",
+				kind, s[OutcomeOK], s[OutcomeFail], s[OutcomeUnknown])
+		}
+
+		fmt.Fprintf(w, "  faults  %d\n", len(run.Faults))
+
+		for _, check := range run.Checks {
+			fmt.Fprintf(w, "  %-22s %s\n", check.Name, check.Verdict)
+
+			for i, v := range check.Violations {
+				if i == maxPrintedViolations {
+					fmt.Fprintf(w, "    ... and %d more\n", len(check.Violations)-i)
+					break
+				}
+
+				fmt.Fprintf(w, "    %s: %s\n", v.Key, v.Message)
+			}
+
+			if len(check.Inconclusive) > 0 {
+				fmt.Fprintf(w, "    inconclusive keys: %s\n", strings.Join(check.Inconclusive, ", "))
+			}
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "READ\tWRITE")
+
+	if len(r.Runs) > 0 {
+		for _, check := range r.Runs[0].Checks {
+			fmt.Fprintf(tw, "\t%s", strings.ToUpper(check.Name))
+		}
+	}
+
+	fmt.Fprintln(tw)
+
+	for _, run := range r.Runs {
+		fmt.Fprintf(tw, "%s\t%s", run.ReadLevel, run.WriteLevel)
+
+		for _, check := range run.Checks {
+			fmt.Fprintf(tw, "\t%s", check.Verdict)
+		}
+
+		fmt.Fprintln(tw)
+	}
+
+	tw.Flush()
+}
+
+// WriteFile writes the full report, including the histories, as JSON.
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil { //nolint:gosec
+		return fmt.Errorf("write report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// workload runs the concurrent clients against the cluster and records their
+// operations. Every write is preceded by a read of the key, which provides the
+// version the write is based on, the way a real client would do it.
+type workload struct {
+	conns       []nodeapi.Client
+	addrs       []string
+	keys        []string
+	clients     int
+	readRatio   int
+	deleteRatio int
+	opTimeout   time.Duration
+	readOpts    nodeapi.KeyOpts
+	writeOpts   nodeapi.KeyOpts
+	sessions    bool
+	seed        int64
+	logger      kitlog.Logger
+}
+
+// Run runs the clients until the context is canceled.
+func (w *workload) Run(ctx context.Context, history *History) {
+	wg := sync.WaitGroup{}
+
+	for i := 1; i <= w.clients; i++ {
+		c := &client{
+			id:       i,
+			workload: w,
+			history:  history,
+			rand:     rand.New(rand.NewSource(w.seed + int64(i))), //nolint:gosec
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			c.run(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+type client struct {
+	id       int
+	workload *workload
+	history  *History
+	rand     *rand.Rand
+	seq      int
+	// session is the token of the session of the client, covering its last
+	// successful writes.
+	session string
+}
+
+func (c *client) run(ctx context.Context) {
+	w := c.workload
+
+	for ctx.Err() == nil {
+		var (
+			key    = w.keys[c.rand.Intn(len(w.keys))]
+			node   = c.rand.Intn(len(w.conns))
+			action = c.rand.Intn(100)
+		)
+
+		read, ok := c.get(ctx, node, key)
+		if !ok || action < w.readRatio {
+			continue
+		}
+
+		if action < w.readRatio+w.deleteRatio {
+			// Deleting requires a version, so there is nothing to delete if the
+			// key does not exist.
+			if read.Version != "" {
+				c.delete(ctx, node, key, read)
+			}
+
+			continue
+		}
+
+		c.put(ctx, node, key, read)
+	}
+}
+
+func (c *client) get(ctx context.Context, node int, key string) (*nodeapi.GetKeyResult, bool) {
+	w := c.workload
+
+	ctx, cancel := context.WithTimeout(ctx, w.opTimeout)
+	defer cancel()
+
+	op := Op{
+		Client: c.id,
+		Node:   w.addrs[node],
+		Kind:   OpGet,
+		Key:    key,
+		Start:  c.history.Now(),
+	}
+
+	res, err := w.conns[node].GetKey(ctx, key, c.opts(w.readOpts))
+	op.End = c.history.Now()
+
+	if c.record(ctx, op, err, func(op *Op) {
+		op.Values = toStrings(res.Values)
+	}) != OutcomeOK {
+		return nil, false
+	}
+
+	return res, true
+}
+
+func (c *client) put(ctx context.Context, node int, key string, read *nodeapi.GetKeyResult) {
+	w := c.workload
+
+	ctx, cancel := context.WithTimeout(ctx, w.opTimeout)
+	defer cancel()
+
+	c.seq++
+
+	op := Op{
+		Client:   c.id,
+		Node:     w.addrs[node],
+		Kind:     OpPut,
+		Key:      key,
+		Value:    fmt.Sprintf("%d-%d", c.id, c.seq),
+		Observed: toStrings(read.Values),
+		Start:    c.history.Now(),
+	}
+
+	res, err := w.conns[node].PutKey(ctx, key, []byte(op.Value), read.Version, c.opts(w.writeOpts))
+	op.End = c.history.Now()
+
+	c.record(ctx, op, err, func(*Op) {
+		c.session = res.SessionToken
+	})
+}
+
+func (c *client) delete(ctx context.Context, node int, key string, read *nodeapi.GetKeyResult) {
+	w := c.workload
+
+	ctx, cancel := context.WithTimeout(ctx, w.opTimeout)
+	defer cancel()
+
+	op := Op{
+		Client:   c.id,
+		Node:     w.addrs[node],
+		Kind:     OpDelete,
+		Key:      key,
+		Observed: toStrings(read.Values),
+		Start:    c.history.Now(),
+	}
+
+	res, err := w.conns[node].DeleteKey(ctx, key, read.Version, c.opts(w.writeOpts))
+	op.End = c.history.Now()
+
+	c.record(ctx, op, err, func(*Op) {
+		c.session = res.SessionToken
+	})
+}
+
+// opts returns the options of a request of the client, with the token of its
+// session if the sessions are enabled.
+func (c *client) opts(opts nodeapi.KeyOpts) nodeapi.KeyOpts {
+	if c.workload.sessions {
+		opts.SessionToken = c.session
+	}
+
+	return opts
+}
+
+// record adds the operation to the history and returns its outcome. The callback
+// fills in the results of a successful operation.
+func (c *client) record(ctx context.Context, op Op, err error, fill func(op *Op)) Outcome {
+	op.Outcome = classify(op.Kind, err)
+
+	switch {
+	case err == nil:
+		if fill != nil {
+			fill(&op)
+		}
+	case ctx.Err() != nil && !op.IsWrite():
+		// A read interrupted by the deadline or by the end of the workload has
+		// no effect, so there is no point in recording it.
+		return op.Outcome
+	default:
+		op.Error = err.Error()
+
+		level.Debug(c.workload.logger).Log(
+			"msg", "operation failed",
+			"client", c.id,
+			"kind", op.Kind,
+			"key", op.Key,
+			"node", op.Node,
+			"err", err,
+		)
+	}
+
+	c.history.Add(op)
+
+	return op.Outcome
+}
+
+// classify determines the outcome of the operation from the error. Failed reads
+// have no effect, so they are always definite. A write is known to have failed
+// only if it was rejected before any replica accepted it. Any other error, such
+// as a timeout or an unsatisfied consistency level, leaves the write applied to
+// some of the replicas, which may or may not propagate further.
+func classify(kind OpKind, err error) Outcome {
+	if err == nil {
+		return OutcomeOK
+	}
+
+	if kind == OpGet {
+		return OutcomeFail
+	}
+
+	switch grpcutil.ErrorCode(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition,
+		codes.ResourceExhausted, codes.PermissionDenied, codes.Unauthenticated:
+		return OutcomeFail
+	default:
+		return OutcomeUnknown
+	}
+}
+
+func toStrings(values [][]byte) []string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	res := make([]string, len(values))
+	for i, v := range values {
+		res[i] = string(v)
+	}
+
+	return res
+}
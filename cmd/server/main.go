@@ -30,20 +30,36 @@ func main() {
 
 	// Initialize all components.
 	logger, closeLogger := setupLogger()
-	cluster, closeCluster := setupCluster(logger)
-	engine, closeEngine := setupEngine(logger)
-	_, closeGRPCServer := setupGRPCServer(&wg, engine, cluster, logger)
+	authn := setupAuth(logger)
+	secret := setupNodeSecret(authn, logger)
+	cluster, closeCluster := setupCluster(secret, logger)
+	namespaces := setupNamespaces(logger)
+	engine, closeEngine := setupEngine(namespaces, logger)
+	hotKeys := setupHotKeys(cluster)
+	admissionController := setupAdmission(cluster)
+	_, closeGRPCServer := setupGRPCServer(&wg, engine, cluster, namespaces, authn, secret, admissionController, hotKeys, logger)
+
+	closeDiscovery := setupDiscovery(&wg, cluster, logger)
+	closeBootstrap := setupBootstrap(&wg, cluster, engine, namespaces, logger)
+	closeChunkCollector := setupChunkCollector(&wg, cluster, engine, logger)
 
 	// Components must be shut down in a particular order.
 	shutdownOrder := []shutdownFunc{
+		closeDiscovery,
+		closeBootstrap,
+		closeChunkCollector,
 		closeGRPCServer,
 		closeEngine,
 		closeLogger,
 		closeCluster,
 	}
 
-	_, closeAPIServer := setupAPIServer(&wg, cluster, logger)
-	shutdownOrder = append([]shutdownFunc{closeAPIServer}, shutdownOrder...)
+	decommissioner := setupDecommissioner(cluster, engine, namespaces, logger)
+	frontendConn, closeFrontendConn := setupFrontendConn(admissionController)
+	_, closeAPIServer := setupAPIServer(&wg, cluster, decommissioner, hotKeys, frontendConn, authn, logger)
+	closeRedisServer := setupRedisServer(&wg, cluster, frontendConn, authn, logger)
+	closeMemcacheServer := setupMemcacheServer(&wg, cluster, frontendConn, authn, logger)
+	shutdownOrder = append([]shutdownFunc{closeAPIServer, closeRedisServer, closeMemcacheServer, closeFrontendConn}, shutdownOrder...)
 
 	// Block until we receive a signal to shut down.
 	<-interrupt
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"strings"
+
+	"github.com/sadath-12/keywave/replication"
+	"github.com/sadath-12/keywave/replication/consistency"
 )
 
 var opts struct {
 	Node struct {
-		ID   uint32 `long:"id" env:"ID" required:"true" description:"unique node id"`
-		Name string `long:"name" env:"NAME" required:"true" description:"node name"`
+		ID   uint32   `long:"id" env:"ID" required:"true" description:"unique node id"`
+		Name string   `long:"name" env:"NAME" required:"true" description:"node name"`
+		Tags []string `long:"tag" env:"TAGS" env-delim:"," description:"node tag in key=value form, e.g. zone=eu-west-1a (can be repeated)"`
 	} `group:"node" namespace:"node" env-namespace:"NODE"`
 
 	RestAPI struct {
@@ -18,12 +23,67 @@ var opts struct {
 		LocalAddr  string `long:"local-addr" description:"address to connect to local grpc server" env:"LOCAL_ADDR" default:"127.0.0.1:3000"`
 		PublicAddr string `long:"public-addr" description:"address to advertise to other nodes" env:"PUBLIC_ADDR" required:"true"`
 	} `group:"grpc" namespace:"grpc" env-namespace:"GRPC"`
+	Redis struct {
+		BindAddr string `long:"bind-addr" description:"address to bind the Redis protocol server, the server is disabled if not set" env:"BIND_ADDR"`
+	} `group:"redis" namespace:"redis" env-namespace:"REDIS"`
+	Memcache struct {
+		BindAddr  string `long:"bind-addr" description:"address to bind the memcached protocol server, the server is disabled if not set" env:"BIND_ADDR"`
+		Namespace string `long:"namespace" description:"namespace of the keys accessed with the memcached protocol" env:"NAMESPACE"`
+	} `group:"memcache" namespace:"memcache" env-namespace:"MEMCACHE"`
 	Cluster struct {
 		JoinAddrs          string `long:"join-addrs" description:"comma-separated list of nodes to join" env:"JOIN_ADDRS"`
+		JoinDNS            string `long:"join-dns" description:"DNS name to discover nodes (SRV if it starts with an underscore, A/AAAA otherwise)" env:"JOIN_DNS"`
+		JoinDNSPort        int    `long:"join-dns-port" description:"port of the nodes discovered via A/AAAA records" env:"JOIN_DNS_PORT" default:"3000"`
+		JoinFile           string `long:"join-file" description:"path to a file with node addresses, one per line" env:"JOIN_FILE"`
+		JoinInterval       int    `long:"join-interval" description:"interval between discovery attempts (ms)" env:"JOIN_INTERVAL" default:"10000"`
+		DNSResolver        string `long:"dns-resolver" description:"address of the DNS server used for discovery instead of the system resolver" env:"DNS_RESOLVER"`
 		ProbeTimeout       int    `long:"probe-timeout" description:"failure detection timeout (ms)" env:"PROBE_TIMEOUT" default:"5000"`
 		ProbeInterval      int    `long:"probe-interval" description:"failure detection interval (ms)" env:"PROBE_INTERVAL" default:"1000"`
 		ProbeIndirectNodes int    `long:"probe-indirect-nodes" description:"number nodes for indirect probe" env:"PROBE_INDIRECT_NODES" default:"1"`
+		SuspicionTimeout   int    `long:"suspicion-timeout" description:"time before a suspected node is declared unhealthy (ms)" env:"SUSPICION_TIMEOUT" default:"5000"`
+		MaxLocalHealth     int    `long:"max-local-health" description:"upper bound of the local health multiplier scaling the probe timeouts" env:"MAX_LOCAL_HEALTH" default:"8"`
+		RetransmitMult     int    `long:"retransmit-mult" description:"multiplier of the number of times a membership update is gossiped" env:"RETRANSMIT_MULT" default:"4"`
+		PushPullInterval   int    `long:"push-pull-interval" description:"minimum interval between full state exchanges (ms)" env:"PUSH_PULL_INTERVAL" default:"30000"`
+		GCInterval         int    `long:"gc-interval" description:"interval between reaping removed nodes and closing their connections (ms)" env:"GC_INTERVAL" default:"30000"`
+		LeftTimeout        int    `long:"left-timeout" description:"time before a node that left is removed from the state (ms)" env:"LEFT_TIMEOUT" default:"60000"`
+		DeadTimeout        int    `long:"dead-timeout" description:"time before an unhealthy node is declared dead and removed, 0 to disable (ms)" env:"DEAD_TIMEOUT" default:"1800000"`
+		TombstoneTTL       int    `long:"tombstone-ttl" description:"time the removed nodes are remembered to prevent their resurrection (ms)" env:"TOMBSTONE_TTL" default:"3600000"`
+		Bootstrap          bool   `long:"bootstrap" description:"stay in the joining state until the data is pulled from the existing replicas" env:"BOOTSTRAP"`
 	} `group:"cluster" namespace:"cluster" env-namespace:"CLUSTER"`
+	Replication struct {
+		SpeculativeRetry []string `long:"speculative-retry" description:"speculative retry policy of the reads in level=policy form, where the policy is none, a latency percentile (e.g. 99p) or a fixed delay (e.g. 50ms); the reads at the levels without a policy are sent to all replicas (can be repeated)" env:"SPECULATIVE_RETRY" env-delim:"," default:"one=99p" default:"two=99p" default:"quorum=99p"`
+	} `group:"replication" namespace:"replication" env-namespace:"REPLICATION"`
+	Chunks struct {
+		GCInterval int `long:"gc-interval" description:"interval between removals of the chunks no longer referred to by any value, 0 to disable (ms)" env:"GC_INTERVAL" default:"600000"`
+		GCGrace    int `long:"gc-grace" description:"time a chunk must stay unreferenced before it is removed (ms)" env:"GC_GRACE" default:"3600000"`
+	} `group:"chunks" namespace:"chunks" env-namespace:"CHUNKS"`
+	Admission struct {
+		Rate            int `long:"rate" description:"client requests per second admitted by the node, 0 to disable" env:"RATE"`
+		Burst           int `long:"burst" description:"client requests admitted at once by the node above its rate" env:"BURST" default:"100"`
+		ClientRate      int `long:"client-rate" description:"requests per second admitted from each client, 0 to disable" env:"CLIENT_RATE"`
+		ClientBurst     int `long:"client-burst" description:"requests admitted at once from each client above its rate" env:"CLIENT_BURST" default:"20"`
+		MaxInFlight     int `long:"max-in-flight" description:"requests served by the node at the same time, 0 to disable the limit and the queue" env:"MAX_IN_FLIGHT" default:"1024"`
+		MaxQueue        int `long:"max-queue" description:"requests waiting to be served, the requests above it are shed" env:"MAX_QUEUE" default:"4096"`
+		QueueTimeout    int `long:"queue-timeout" description:"time a request waits to be served before it is shed (ms)" env:"QUEUE_TIMEOUT" default:"1000"`
+		BackgroundShare int `long:"background-share" description:"percentage of the requests served at the same time that the rebalancing, backups and chunk collection may use" env:"BACKGROUND_SHARE" default:"25"`
+	} `group:"admission" namespace:"admission" env-namespace:"ADMISSION"`
+	HotKeys struct {
+		SampleRate int `long:"sample-rate" description:"number of reads per read sampled by the hot key detector" env:"SAMPLE_RATE" default:"16"`
+		Capacity   int `long:"capacity" description:"number of keys tracked by the hot key detector" env:"CAPACITY" default:"256"`
+		HalfLife   int `long:"half-life" description:"time after which the read count of a key no longer read is halved (ms)" env:"HALF_LIFE" default:"10000"`
+		Threshold  int `long:"threshold" description:"reads per second from which a key is hot" env:"THRESHOLD" default:"1000"`
+		CacheTTL   int `long:"cache-ttl" description:"time the reads at level one of the hot keys are cached by the coordinator, 0 to disable the cache (ms)" env:"CACHE_TTL"`
+		CacheSize  int `long:"cache-size" description:"number of hot keys whose reads are cached" env:"CACHE_SIZE" default:"1024"`
+	} `group:"hotkeys" namespace:"hotkeys" env-namespace:"HOTKEYS"`
+	Namespace struct {
+		File string `long:"file" description:"path to a JSON file declaring namespaces and their policies" env:"FILE"`
+	} `group:"namespace" namespace:"namespace" env-namespace:"NAMESPACE"`
+	Auth struct {
+		TokenFile  string `long:"token-file" description:"path to a JSON file with static tokens and their ACL rules" env:"TOKEN_FILE"`
+		JWTKeyFile string `long:"jwt-key-file" description:"path to a JWT verification key (PEM public key or HMAC secret)" env:"JWT_KEY_FILE"`
+		// NodeSecretFile holds the secret shared by all nodes of the cluster.
+		NodeSecretFile string `long:"node-secret-file" description:"path to a file with the secret shared by the nodes, required by the storage and membership services (required with authentication)" env:"NODE_SECRET_FILE"`
+	} `group:"auth" namespace:"auth" env-namespace:"AUTH"`
 
 	Verbose bool `long:"verbose" description:"verbose mode" env:"VERBOSE"`
 }
@@ -41,3 +101,43 @@ func parseAddrs(addrs string) []string {
 
 	return res
 }
+
+func parseTags(tags []string) (map[string]string, error) {
+	res := make(map[string]string, len(tags))
+
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", tag)
+		}
+
+		res[key] = strings.TrimSpace(value)
+	}
+
+	return res, nil
+}
+
+func parseSpeculativeRetry(policies []string) (map[consistency.Level]replication.SpeculativeRetry, error) {
+	res := make(map[consistency.Level]replication.SpeculativeRetry, len(policies))
+
+	for _, p := range policies {
+		name, policy, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid speculative retry %q, expected level=policy", p)
+		}
+
+		level, ok := consistency.FromString(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown consistency level %q", name)
+		}
+
+		retry, err := replication.ParseSpeculativeRetry(policy)
+		if err != nil {
+			return nil, err
+		}
+
+		res[level] = retry
+	}
+
+	return res, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
@@ -12,18 +13,31 @@ import (
 	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/sadath-12/keywave/membership"
 
+	"github.com/sadath-12/keywave/admission"
 	"github.com/sadath-12/keywave/api"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/discovery"
+	"github.com/sadath-12/keywave/hotkeys"
 	membershippb "github.com/sadath-12/keywave/membership/proto"
 	membershipsvc "github.com/sadath-12/keywave/membership/service"
+	"github.com/sadath-12/keywave/memcache"
+	"github.com/sadath-12/keywave/namespace"
 	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+	"github.com/sadath-12/keywave/rebalance"
+	replicationpb "github.com/sadath-12/keywave/replication/proto"
+	replicationsvc "github.com/sadath-12/keywave/replication/service"
+	"github.com/sadath-12/keywave/resp"
 
 	"github.com/sadath-12/keywave/storage"
 	"github.com/sadath-12/keywave/storage/inmemory"
 	storagepb "github.com/sadath-12/keywave/storage/proto"
 	storagesvc "github.com/sadath-12/keywave/storage/service"
+	"github.com/sadath-12/keywave/storage/stack"
 )
 
 type shutdownFunc func(ctx context.Context) error
@@ -40,16 +54,110 @@ func setupLogger() (kitlog.Logger, shutdownFunc) {
 	return logger, noopShutdown
 }
 
-func setupCluster(logger kitlog.Logger) (*membership.SWIMCluster, shutdownFunc) {
+// setupAuth creates the authenticator configured by the auth options. It returns
+// nil if neither a token file nor a JWT key is given, which disables authentication.
+func setupAuth(logger kitlog.Logger) auth.Authenticator {
+	switch {
+	case opts.Auth.TokenFile != "" && opts.Auth.JWTKeyFile != "":
+		panic("only one of token file and jwt key can be configured")
+	case opts.Auth.TokenFile != "":
+		level.Info(logger).Log("msg", "using static token authentication")
+
+		tf, err := auth.LoadTokenFile(opts.Auth.TokenFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load token file: %v", err))
+		}
+
+		return tf
+	case opts.Auth.JWTKeyFile != "":
+		level.Info(logger).Log("msg", "using jwt authentication")
+
+		v, err := auth.LoadJWTVerifier(opts.Auth.JWTKeyFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load jwt key: %v", err))
+		}
+
+		return v
+	default:
+		level.Warn(logger).Log("msg", "authentication is disabled")
+		return nil
+	}
+}
+
+// setupNodeSecret loads the secret the nodes authenticate each other with. It
+// is required along with the authentication of the clients, since the internal
+// services would otherwise be open to them.
+func setupNodeSecret(authn auth.Authenticator, logger kitlog.Logger) auth.NodeSecret {
+	if opts.Auth.NodeSecretFile == "" {
+		if authn != nil {
+			panic("node secret file is required when authentication is enabled")
+		}
+
+		level.Warn(logger).Log("msg", "internal services are not authenticated")
+
+		return nil
+	}
+
+	secret, err := auth.LoadNodeSecret(opts.Auth.NodeSecretFile)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load node secret: %v", err))
+	}
+
+	return secret
+}
+
+func setupNamespaces(logger kitlog.Logger) *namespace.Registry {
+	defaults := replicationsvc.DefaultNamespace()
+
+	if opts.Namespace.File == "" {
+		return namespace.NewRegistry(defaults)
+	}
+
+	registry, err := namespace.LoadFile(opts.Namespace.File, defaults)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load namespaces: %v", err))
+	}
+
+	for _, ns := range registry.List() {
+		level.Info(logger).Log("msg", "namespace configured", "name", ns.Name,
+			"read_level", ns.ReadLevel, "write_level", ns.WriteLevel, "replication_factor", ns.ReplicationFactor)
+	}
+
+	return registry
+}
+
+func setupCluster(secret auth.NodeSecret, logger kitlog.Logger) (*membership.SWIMCluster, shutdownFunc) {
 	conf := membership.DefaultConfig()
 	conf.NodeID = membership.NodeID(opts.Node.ID)
 	conf.NodeName = opts.Node.Name
+
+	tags, err := parseTags(opts.Node.Tags)
+	if err != nil {
+		panic(err)
+	}
+
+	conf.NodeTags = tags
 	conf.LocalAddr = opts.GRPC.LocalAddr
 	conf.PublicAddr = opts.GRPC.PublicAddr
 	conf.ProbeTimeout = time.Millisecond * time.Duration(opts.Cluster.ProbeTimeout)
 	conf.ProbeInterval = time.Millisecond * time.Duration(opts.Cluster.ProbeInterval)
 	conf.IndirectNodes = opts.Cluster.ProbeIndirectNodes
+	conf.SuspicionTimeout = time.Millisecond * time.Duration(opts.Cluster.SuspicionTimeout)
+	conf.MaxLocalHealth = opts.Cluster.MaxLocalHealth
+	conf.RetransmitMult = opts.Cluster.RetransmitMult
+	conf.PushPullInterval = time.Millisecond * time.Duration(opts.Cluster.PushPullInterval)
+	conf.GCInterval = time.Millisecond * time.Duration(opts.Cluster.GCInterval)
+	conf.LeftTimeout = time.Millisecond * time.Duration(opts.Cluster.LeftTimeout)
+	conf.DeadTimeout = time.Millisecond * time.Duration(opts.Cluster.DeadTimeout)
+	conf.TombstoneTTL = time.Millisecond * time.Duration(opts.Cluster.TombstoneTTL)
+	conf.Bootstrap = opts.Cluster.Bootstrap
 	conf.Dialer = nodeapigrpc.Dial
+
+	// The calls to the other nodes carry the secret, which the internal services
+	// require from the callers.
+	if len(secret) > 0 {
+		conf.Dialer = nodeapigrpc.NewDialer(grpc.WithPerRPCCredentials(secret))
+	}
 	conf.Logger = logger
 
 	cluster := membership.NewSWIM(conf)
@@ -68,10 +176,159 @@ func setupCluster(logger kitlog.Logger) (*membership.SWIMCluster, shutdownFunc)
 	return cluster, shutdown
 }
 
-func setupAPIServer(wg *sync.WaitGroup, cluster membership.Cluster, logger kitlog.Logger) (*http.Server, shutdownFunc) {
+func setupDecommissioner(
+	cluster membership.Cluster,
+	engine storage.Engine,
+	namespaces *namespace.Registry,
+	logger kitlog.Logger,
+) *rebalance.Decommissioner {
+	scannable, ok := engine.(storage.Scannable)
+	if !ok {
+		panic("storage engine does not support scans")
+	}
+
+	return rebalance.NewDecommissioner(cluster, scannable, namespaces, logger)
+}
+
+func setupDiscovery(wg *sync.WaitGroup, cluster membership.Cluster, logger kitlog.Logger) shutdownFunc {
+	var providers discovery.Multi
+
+	if addrs := parseAddrs(opts.Cluster.JoinAddrs); len(addrs) > 0 {
+		providers = append(providers, discovery.Static(addrs))
+	}
+
+	if opts.Cluster.JoinDNS != "" {
+		providers = append(providers, discovery.NewDNS(opts.Cluster.JoinDNS, opts.Cluster.JoinDNSPort, opts.Cluster.DNSResolver))
+	}
+
+	if opts.Cluster.JoinFile != "" {
+		providers = append(providers, discovery.File(opts.Cluster.JoinFile))
+	}
+
+	if len(providers) == 0 {
+		return noopShutdown
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	interval := time.Millisecond * time.Duration(opts.Cluster.JoinInterval)
+	joiner := discovery.NewJoiner(cluster, providers, interval, logger)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := joiner.Run(ctx); err != nil && ctx.Err() == nil {
+			level.Error(logger).Log("msg", "discovery failed", "err", err)
+		}
+	}()
+
+	shutdown := func(ctx context.Context) error {
+		cancel()
+		return nil
+	}
+
+	return shutdown
+}
+
+func setupBootstrap(
+	wg *sync.WaitGroup,
+	cluster membership.Cluster,
+	engine storage.Engine,
+	namespaces *namespace.Registry,
+	logger kitlog.Logger,
+) shutdownFunc {
+	if !opts.Cluster.Bootstrap {
+		return noopShutdown
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bootstrapper := rebalance.NewBootstrapper(cluster, engine, namespaces, logger)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := bootstrapper.Run(ctx); err != nil && ctx.Err() == nil {
+			level.Error(logger).Log("msg", "bootstrap failed", "err", err)
+		}
+	}()
+
+	shutdown := func(ctx context.Context) error {
+		cancel()
+		return nil
+	}
+
+	return shutdown
+}
+
+func setupChunkCollector(
+	wg *sync.WaitGroup,
+	cluster membership.Cluster,
+	engine storage.Engine,
+	logger kitlog.Logger,
+) shutdownFunc {
+	if opts.Chunks.GCInterval <= 0 {
+		return noopShutdown
+	}
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		grace       = time.Duration(opts.Chunks.GCGrace) * time.Millisecond
+		interval    = time.Duration(opts.Chunks.GCInterval) * time.Millisecond
+		collector   = chunk.NewCollector(cluster, engine, grace, kitlog.With(logger, "component", "chunk_gc"))
+	)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		collector.Run(ctx, interval)
+	}()
+
+	shutdown := func(ctx context.Context) error {
+		cancel()
+		return nil
+	}
+
+	return shutdown
+}
+
+// setupFrontendConn connects the front-ends of the node, the HTTP gateway and
+// the Redis and memcached protocol servers, to the local node over gRPC, so
+// that the requests go through the same access control and admission, which
+// trusts the addresses of the clients forwarded by the front-ends.
+func setupFrontendConn(admissionController *admission.Controller) (*grpc.ClientConn, shutdownFunc) {
+	conn, err := grpc.Dial(opts.GRPC.LocalAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(admissionController.GatewayCredentials()),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("failed to connect the front-ends to the GRPC server: %v", err))
+	}
+
+	shutdown := func(ctx context.Context) error {
+		return conn.Close()
+	}
+
+	return conn, shutdown
+}
+
+func setupAPIServer(
+	wg *sync.WaitGroup,
+	cluster membership.Cluster,
+	decommissioner *rebalance.Decommissioner,
+	hotKeys *hotkeys.Detector,
+	frontendConn *grpc.ClientConn,
+	authn auth.Authenticator,
+	logger kitlog.Logger,
+) (*http.Server, shutdownFunc) {
+	auditLogger := kitlog.With(logger, "component", "audit")
+
 	restAPI := &http.Server{
 		Addr:    opts.RestAPI.BindAddr,
-		Handler: api.CreateRouter(cluster),
+		Handler: api.CreateRouter(cluster, decommissioner, hotKeys, frontendConn, authn, auditLogger),
 	}
 
 	wg.Add(1)
@@ -99,14 +356,166 @@ func setupAPIServer(wg *sync.WaitGroup, cluster membership.Cluster, logger kitlo
 	return restAPI, shutdown
 }
 
+func setupRedisServer(
+	wg *sync.WaitGroup,
+	cluster membership.Cluster,
+	frontendConn *grpc.ClientConn,
+	authn auth.Authenticator,
+	logger kitlog.Logger,
+) shutdownFunc {
+	if opts.Redis.BindAddr == "" {
+		return noopShutdown
+	}
+
+	listener, err := net.Listen("tcp", opts.Redis.BindAddr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create Redis protocol listener: %v", err))
+	}
+
+	server := resp.NewServer(cluster, authn, kitlog.With(logger, "component", "resp"))
+	server.SetLocalConn(nodeapigrpc.NewClient(frontendConn))
+
+	level.Info(logger).Log("msg", "serving Redis protocol", "addr", listener.Addr())
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := server.Serve(listener); err != nil && err != resp.ErrServerClosed {
+			panic(fmt.Sprintf("failed to start Redis protocol server: %v", err))
+		}
+	}()
+
+	shutdown := func(ctx context.Context) error {
+		logger.Log("msg", "shutting down Redis protocol server")
+
+		if err := server.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown Redis protocol server: %w", err)
+		}
+
+		return nil
+	}
+
+	return shutdown
+}
+
+func setupMemcacheServer(
+	wg *sync.WaitGroup,
+	cluster membership.Cluster,
+	frontendConn *grpc.ClientConn,
+	authn auth.Authenticator,
+	logger kitlog.Logger,
+) shutdownFunc {
+	if opts.Memcache.BindAddr == "" {
+		return noopShutdown
+	}
+
+	listener, err := net.Listen("tcp", opts.Memcache.BindAddr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create memcached protocol listener: %v", err))
+	}
+
+	server := memcache.NewServer(cluster, authn, opts.Memcache.Namespace, kitlog.With(logger, "component", "memcache"))
+	server.SetLocalConn(nodeapigrpc.NewClient(frontendConn))
+
+	level.Info(logger).Log("msg", "serving memcached protocol", "addr", listener.Addr(), "namespace", opts.Memcache.Namespace)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := server.Serve(listener); err != nil && err != memcache.ErrServerClosed {
+			panic(fmt.Sprintf("failed to start memcached protocol server: %v", err))
+		}
+	}()
+
+	shutdown := func(ctx context.Context) error {
+		logger.Log("msg", "shutting down memcached protocol server")
+
+		if err := server.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to shutdown memcached protocol server: %w", err)
+		}
+
+		return nil
+	}
+
+	return shutdown
+}
+
+func setupHotKeys(cluster membership.Cluster) *hotkeys.Detector {
+	detector := hotkeys.New(hotkeys.Config{
+		SampleRate: opts.HotKeys.SampleRate,
+		Capacity:   opts.HotKeys.Capacity,
+		HalfLife:   time.Duration(opts.HotKeys.HalfLife) * time.Millisecond,
+		Threshold:  float64(opts.HotKeys.Threshold),
+	}, cluster.Env())
+
+	expvar.Publish("hotkeys", expvar.Func(func() any { return detector.Stats() }))
+
+	return detector
+}
+
+func setupAdmission(cluster membership.Cluster) *admission.Controller {
+	controller := admission.New(admission.Config{
+		Rate:            float64(opts.Admission.Rate),
+		Burst:           opts.Admission.Burst,
+		ClientRate:      float64(opts.Admission.ClientRate),
+		ClientBurst:     opts.Admission.ClientBurst,
+		MaxInFlight:     opts.Admission.MaxInFlight,
+		MaxQueue:        opts.Admission.MaxQueue,
+		QueueTimeout:    time.Duration(opts.Admission.QueueTimeout) * time.Millisecond,
+		BackgroundShare: float64(opts.Admission.BackgroundShare) / 100,
+	}, cluster.Env().Clock)
+
+	expvar.Publish("admission", expvar.Func(func() any { return controller.Stats() }))
+
+	return controller
+}
+
 func setupGRPCServer(
 	wg *sync.WaitGroup,
 	engine storage.Engine,
 	cluster membership.Cluster,
-
+	namespaces *namespace.Registry,
+	authn auth.Authenticator,
+	secret auth.NodeSecret,
+	admissionController *admission.Controller,
+	hotKeys *hotkeys.Detector,
 	logger kitlog.Logger,
 ) (*grpc.Server, shutdownFunc) {
-	grpcServer := grpc.NewServer()
+	// The public replication API is subject to the rules of the clients. The
+	// storage and membership services, used for the communication between the
+	// nodes, require the node secret or an admin token.
+	permissions := map[string]auth.Permission{
+		"/replication.Replication/Get":        auth.PermRead,
+		"/replication.Replication/Put":        auth.PermWrite,
+		"/replication.Replication/Delete":     auth.PermDelete,
+		"/replication.Replication/QueryIndex": auth.PermRead,
+		"/replication.Replication/GetStream":  auth.PermRead,
+		"/replication.Replication/PutStream":  auth.PermWrite,
+	}
+
+	auditLogger := kitlog.With(logger, "component", "audit")
+
+	// The same methods are the ones admitted as the requests of the clients.
+	// The admission follows the authentication, which identifies the clients.
+	clientMethods := make(map[string]struct{}, len(permissions))
+	for method := range permissions {
+		clientMethods[method] = struct{}{}
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			auth.UnaryServerInterceptor(authn, secret, permissions, auditLogger),
+			admission.UnaryServerInterceptor(admissionController, clientMethods),
+		),
+		grpc.ChainStreamInterceptor(
+			auth.StreamServerInterceptor(authn, secret, permissions, auditLogger),
+			admission.StreamServerInterceptor(admissionController, clientMethods),
+		),
+	)
 
 	storageService := storagesvc.New(engine, opts.Node.ID)
 	storagepb.RegisterStorageServiceServer(grpcServer, storageService)
@@ -114,7 +523,21 @@ func setupGRPCServer(
 	membershipService := membershipsvc.NewMembershipService(cluster)
 	membershippb.RegisterMembershipServer(grpcServer, membershipService)
 
-	
+	speculative, err := parseSpeculativeRetry(opts.Replication.SpeculativeRetry)
+	if err != nil {
+		panic(err)
+	}
+
+	replicationService := replicationsvc.New(cluster, namespaces, logger)
+	replicationService.SetSpeculativeRetry(speculative)
+	replicationService.SetHotKeys(hotKeys)
+
+	if opts.HotKeys.CacheTTL > 0 {
+		replicationService.SetReadCache(time.Duration(opts.HotKeys.CacheTTL)*time.Millisecond, opts.HotKeys.CacheSize)
+		expvar.Publish("read_cache", expvar.Func(func() any { return replicationService.CacheStats() }))
+	}
+
+	replicationpb.RegisterReplicationServer(grpcServer, replicationService)
 
 	wg.Add(1)
 
@@ -140,13 +563,17 @@ func setupGRPCServer(
 	return grpcServer, shutdown
 }
 
-func setupEngine(logger kitlog.Logger) (storage.Engine, shutdownFunc) {
+func setupEngine(namespaces *namespace.Registry, logger kitlog.Logger) (storage.Engine, shutdownFunc) {
 	fmt.Println("opts is", opts)
 	// if opts.Storage.InMemory {
 
 	fmt.Println("using memory true------------")
 	level.Info(logger).Log("msg", "using in-memory storage engine")
-	return inmemory.New(), noopShutdown
+
+	engine := stack.New(inmemory.New(), namespaces)
+	expvar.Publish("compression", expvar.Func(func() any { return engine.CompressionStats() }))
+
+	return engine, noopShutdown
 	// }
 
 	// config := lsmtree.DefaultConfig()
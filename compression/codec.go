@@ -0,0 +1,131 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/sadath-12/keywave/namespace"
+)
+
+const (
+	// MinSize is the size below which the values are stored as they are, since
+	// the codec overhead outweighs the gain.
+	MinSize = 64
+	// ZstdSize is the size from which CompressionAuto uses zstd instead of
+	// snappy. The larger values compress better with zstd, which is worth the
+	// extra CPU time.
+	ZstdSize = 16 << 10
+)
+
+// ErrUnknownCodec is returned when the stored value is encoded with a codec
+// this node does not know.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec identifies how a stored value is encoded. It is stored in the first
+// byte of the value, so the values written with different codecs can be read
+// regardless of the current namespace configuration.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecZstd
+
+	numCodecs
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecSnappy:
+		return "snappy"
+	case CodecZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+// The encoder and the decoder are safe for concurrent use when only EncodeAll
+// and DecodeAll are called, and the options are static, so they never fail.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// choose returns the codec for the value of the given size.
+func choose(c namespace.Compression, size int) Codec {
+	if size < MinSize {
+		return CodecNone
+	}
+
+	switch c {
+	case namespace.CompressionNone:
+		return CodecNone
+	case namespace.CompressionSnappy:
+		return CodecSnappy
+	case namespace.CompressionZstd:
+		return CodecZstd
+	default:
+		if size >= ZstdSize {
+			return CodecZstd
+		}
+
+		return CodecSnappy
+	}
+}
+
+// encode returns the data prefixed with the codec byte, and the codec used.
+// The data is stored as is if compressing it does not make it smaller. Empty
+// data, e.g. of a tombstone, is left empty.
+func encode(c namespace.Compression, data []byte) ([]byte, Codec) {
+	if len(data) == 0 {
+		return data, CodecNone
+	}
+
+	codec := choose(c, len(data))
+
+	var out []byte
+
+	switch codec {
+	case CodecSnappy:
+		enc := s2.EncodeSnappy(nil, data)
+		out = append(make([]byte, 1, 1+len(enc)), enc...)
+	case CodecZstd:
+		out = zstdEncoder.EncodeAll(data, make([]byte, 1, 1+len(data)))
+	}
+
+	if codec == CodecNone || len(out) > len(data) {
+		out = make([]byte, 1+len(data))
+		copy(out[1:], data)
+		codec = CodecNone
+	}
+
+	out[0] = byte(codec)
+
+	return out, codec
+}
+
+// decode returns the data without the codec byte, decompressed.
+func decode(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return stored, nil
+	}
+
+	payload := stored[1:]
+
+	switch Codec(stored[0]) {
+	case CodecNone:
+		return payload, nil
+	case CodecSnappy:
+		return s2.Decode(nil, payload)
+	case CodecZstd:
+		return zstdDecoder.DecodeAll(payload, nil)
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, stored[0])
+	}
+}
@@ -0,0 +1,189 @@
+// Package compression compresses the values in the storage engine. Each value
+// is compressed on its own with the codec selected by the compression of its
+// namespace, and is stored prefixed with the codec byte, so it is decompressed
+// on read regardless of the configuration it was written with.
+package compression
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+)
+
+var (
+	_ storage.Engine    = (*Compressor)(nil)
+	_ storage.Scannable = (*Compressor)(nil)
+)
+
+// CodecStats is the amount of data written with a codec.
+type CodecStats struct {
+	Values      int64 `json:"values"`
+	RawBytes    int64 `json:"raw_bytes"`
+	StoredBytes int64 `json:"stored_bytes"`
+}
+
+// Stats is the amount of data written through the compressor since the start,
+// including the values overwritten since then.
+type Stats struct {
+	Codecs map[string]CodecStats `json:"codecs"`
+	// Ratio is the total size of the values written divided by the size they
+	// are stored with.
+	Ratio float64 `json:"ratio"`
+}
+
+type codecCounters struct {
+	values, rawBytes, storedBytes atomic.Int64
+}
+
+// Compressor wraps a storage engine and compresses the values written through
+// it. The values are decompressed before being returned, so the wrappers above
+// it, such as the usage tracker and the indexes, see the original data.
+type Compressor struct {
+	engine     storage.Engine
+	namespaces *namespace.Registry
+	counters   [numCodecs]codecCounters
+}
+
+// New wraps the engine with compression configured by the namespaces. The keys
+// outside of any declared namespace use CompressionAuto.
+func New(engine storage.Engine, namespaces *namespace.Registry) *Compressor {
+	return &Compressor{
+		engine:     engine,
+		namespaces: namespaces,
+	}
+}
+
+func (c *Compressor) Get(key string) ([]storage.Value, error) {
+	values, err := c.engine.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeValues(key, values)
+}
+
+func (c *Compressor) Put(key string, value storage.Value) error {
+	var policy namespace.Compression
+
+	if ns, _, ok := namespace.SplitKey(key); ok {
+		if conf, err := c.namespaces.Get(ns); err == nil {
+			policy = conf.Compression
+		}
+	}
+
+	data, codec := encode(policy, value.Data)
+
+	if err := c.engine.Put(key, storage.Value{
+		Version:   value.Version,
+		Data:      data,
+		Tombstone: value.Tombstone,
+		ExpiresAt: value.ExpiresAt,
+		Chunked:   value.Chunked,
+	}); err != nil {
+		return err
+	}
+
+	if len(value.Data) > 0 {
+		cnt := &c.counters[codec]
+		cnt.values.Add(1)
+		cnt.rawBytes.Add(int64(len(value.Data)))
+		cnt.storedBytes.Add(int64(len(data)))
+	}
+
+	return nil
+}
+
+// Scan returns an iterator over the underlying engine that decompresses the
+// values. If the engine does not support range scans, the iterator is always
+// empty.
+func (c *Compressor) Scan(key string) storage.ScanIterator {
+	s, ok := c.engine.(storage.Scannable)
+	if !ok {
+		return emptyIterator{}
+	}
+
+	return &iterator{it: s.Scan(key)}
+}
+
+// Stats returns a snapshot of the amount of data written with each codec.
+func (c *Compressor) Stats() Stats {
+	var (
+		stats       = Stats{Codecs: make(map[string]CodecStats, numCodecs), Ratio: 1}
+		raw, stored int64
+	)
+
+	for i := range c.counters {
+		cs := CodecStats{
+			Values:      c.counters[i].values.Load(),
+			RawBytes:    c.counters[i].rawBytes.Load(),
+			StoredBytes: c.counters[i].storedBytes.Load(),
+		}
+
+		stats.Codecs[Codec(i).String()] = cs
+		raw += cs.RawBytes
+		stored += cs.StoredBytes
+	}
+
+	if stored > 0 {
+		stats.Ratio = float64(raw) / float64(stored)
+	}
+
+	return stats
+}
+
+// decodeValues returns a copy of the values with the data decompressed, leaving
+// the values held by the engine intact.
+func decodeValues(key string, values []storage.Value) ([]storage.Value, error) {
+	res := make([]storage.Value, len(values))
+
+	for i, v := range values {
+		data, err := decode(v.Data)
+		if err != nil {
+			return nil, fmt.Errorf("decode value of %s: %w", key, err)
+		}
+
+		v.Data = data
+		res[i] = v
+	}
+
+	return res, nil
+}
+
+type iterator struct {
+	it     storage.ScanIterator
+	key    string
+	values []storage.Value
+}
+
+func (i *iterator) Next() error {
+	if err := i.it.Next(); err != nil {
+		return err
+	}
+
+	key, values := i.it.Item()
+
+	decoded, err := decodeValues(key, values)
+	if err != nil {
+		return err
+	}
+
+	i.key, i.values = key, decoded
+
+	return nil
+}
+
+func (i *iterator) Item() (string, []storage.Value) {
+	return i.key, i.values
+}
+
+type emptyIterator struct{}
+
+func (emptyIterator) Next() error {
+	return storage.ErrNoMoreItems
+}
+
+func (emptyIterator) Item() (string, []storage.Value) {
+	return "", nil
+}
@@ -0,0 +1,101 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+	"github.com/sadath-12/keywave/storage/inmemory"
+)
+
+func TestCompressor(t *testing.T) {
+	var (
+		raw = inmemory.New()
+		c   = New(raw, namespace.NewRegistry(namespace.Config{},
+			namespace.Config{Name: "plain", Compression: namespace.CompressionNone},
+			namespace.Config{Name: "fast", Compression: namespace.CompressionSnappy},
+		))
+		small = []byte(`{"id": 1}`)
+		json  = []byte(strings.Repeat(`{"name": "keywave", "tags": ["a", "b"]}, `, 100))
+		large = []byte(strings.Repeat(`{"name": "keywave", "tags": ["a", "b"]}, `, 1000))
+	)
+
+	tests := []struct {
+		key   string
+		data  []byte
+		codec Codec
+	}{
+		{namespace.Key("default", "small"), small, CodecNone},
+		{namespace.Key("default", "json"), json, CodecSnappy},
+		{namespace.Key("default", "large"), large, CodecZstd},
+		{namespace.Key("plain", "json"), json, CodecNone},
+		{namespace.Key("fast", "large"), large, CodecSnappy},
+		{"unscoped", json, CodecSnappy},
+	}
+
+	for _, tt := range tests {
+		if err := c.Put(tt.key, storage.Value{Version: vclock.Version{1: 1}, Data: tt.data}); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, _ := raw.Get(tt.key)
+		if codec := Codec(stored[0].Data[0]); codec != tt.codec {
+			t.Errorf("%s: stored with %s, expected %s", tt.key, codec, tt.codec)
+		}
+
+		values, err := c.Get(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(values[0].Data, tt.data) {
+			t.Errorf("%s: read %q", tt.key, values[0].Data)
+		}
+	}
+
+	// Tombstones carry no data.
+	if err := c.Put(namespace.Key("default", "json"), storage.Value{Version: vclock.Version{1: 2}, Tombstone: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	it := c.Scan(namespace.Prefix("default"))
+	found := 0
+
+	for it.Next() == nil {
+		key, values := it.Item()
+		if !strings.HasPrefix(key, namespace.Prefix("default")) {
+			break
+		}
+
+		found++
+
+		if key == namespace.Key("default", "large") && !bytes.Equal(values[0].Data, large) {
+			t.Errorf("scanned %s with %d bytes", key, len(values[0].Data))
+		}
+
+		if key == namespace.Key("default", "json") && (!values[0].Tombstone || len(values[0].Data) != 0) {
+			t.Errorf("scanned %s as %+v", key, values[0])
+		}
+	}
+
+	if found != 3 {
+		t.Errorf("scanned %d keys", found)
+	}
+
+	stats := c.Stats()
+	if stats.Codecs["zstd"].Values != 1 || stats.Codecs["snappy"].Values != 3 || stats.Ratio < 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if err := raw.Put("corrupted", storage.Value{Version: vclock.Version{1: 1}, Data: []byte{42, 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get("corrupted"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec, got %v", err)
+	}
+}
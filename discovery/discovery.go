@@ -0,0 +1,160 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sadath-12/keywave/internal/multierror"
+)
+
+// Provider returns the addresses of the nodes to join the cluster through.
+type Provider interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// Static is a fixed list of addresses.
+type Static []string
+
+// Discover returns the configured addresses.
+func (s Static) Discover(context.Context) ([]string, error) {
+	return s, nil
+}
+
+// DNS resolves the peer addresses from DNS records. A name in the
+// _service._proto.domain form is looked up as a SRV record, which provides
+// both the hosts and the ports. Any other name is looked up as A/AAAA records,
+// and the default port is appended to each address.
+type DNS struct {
+	Name     string
+	Port     int
+	resolver *net.Resolver
+}
+
+// NewDNS creates a DNS provider. If the resolver address is not empty, the
+// queries are sent to that server instead of the system resolver, which is
+// useful for local resolvers such as Consul or CoreDNS.
+func NewDNS(name string, port int, resolverAddr string) *DNS {
+	resolver := net.DefaultResolver
+
+	if resolverAddr != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: 5 * time.Second}
+				return d.DialContext(ctx, network, resolverAddr)
+			},
+		}
+	}
+
+	return &DNS{
+		Name:     name,
+		Port:     port,
+		resolver: resolver,
+	}
+}
+
+// Discover resolves the name into a list of addresses.
+func (d *DNS) Discover(ctx context.Context) ([]string, error) {
+	if strings.HasPrefix(d.Name, "_") {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("lookup srv %s: %w", d.Name, err)
+		}
+
+		addrs := make([]string, 0, len(records))
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(r.Port))))
+		}
+
+		return addrs, nil
+	}
+
+	hosts, err := d.resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup host %s: %w", d.Name, err)
+	}
+
+	addrs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(d.Port)))
+	}
+
+	return addrs, nil
+}
+
+// File reads the peer addresses from a file, one per line. Empty lines and
+// lines starting with # are ignored. The file is read on every call, so it
+// can be updated without restarting the node, e.g. by a configuration
+// management tool.
+type File string
+
+// Discover reads the addresses from the file.
+func (f File) Discover(context.Context) ([]string, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		addrs   []string
+		scanner = bufio.NewScanner(file)
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		addrs = append(addrs, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", f, err)
+	}
+
+	return addrs, nil
+}
+
+// Multi combines the addresses returned by several providers. It fails only if
+// all the providers fail, so that a broken DNS does not prevent joining via the
+// static seeds.
+type Multi []Provider
+
+// Discover returns the deduplicated addresses from all providers.
+func (m Multi) Discover(ctx context.Context) ([]string, error) {
+	var (
+		addrs []string
+		seen  = make(map[string]struct{})
+		errs  = multierror.New[int]()
+	)
+
+	for i, p := range m {
+		res, err := p.Discover(ctx)
+		if err != nil {
+			errs.Add(i, err)
+			continue
+		}
+
+		for _, addr := range res {
+			if _, ok := seen[addr]; !ok {
+				seen[addr] = struct{}{}
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	if len(m) > 0 && errs.Len() == len(m) {
+		return nil, errs
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,196 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds")
+
+	if _, err := File(path).Discover(context.Background()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing file to fail, got %v", err)
+	}
+
+	content := "# seeds\n10.0.0.1:3000\n\n  10.0.0.2:3000  \n#10.0.0.3:3000\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := File(path).Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"10.0.0.1:3000", "10.0.0.2:3000"}; !slices.Equal(addrs, want) {
+		t.Errorf("expected %v, got %v", want, addrs)
+	}
+
+	// A line longer than the buffer of the scanner fails the whole file rather
+	// than being truncated.
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 1<<17)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := File(path).Discover(context.Background()); err == nil {
+		t.Error("expected a line too long to fail")
+	}
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Discover(context.Context) ([]string, error) {
+	return nil, errors.New("lookup failed")
+}
+
+func TestMulti(t *testing.T) {
+	m := Multi{Static{"a", "b"}, failingProvider{}, Static{"b", "c", "a"}}
+
+	addrs, err := m.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "b", "c"}; !slices.Equal(addrs, want) {
+		t.Errorf("expected %v, got %v", want, addrs)
+	}
+
+	if _, err := (Multi{failingProvider{}, failingProvider{}}).Discover(context.Background()); err == nil {
+		t.Error("expected the discovery to fail when all the providers fail")
+	}
+}
+
+// joinCluster is a node whose joins fail until the given number of attempts,
+// after which it sees the seed as a reachable peer.
+type joinCluster struct {
+	membership.Cluster
+	clock *env.SimClock
+	fails int
+
+	mut      sync.Mutex
+	attempts int
+	joined   bool
+	calls    chan string
+}
+
+func (c *joinCluster) Env() env.Env {
+	return env.Env{Clock: c.clock}.WithDefaults()
+}
+
+func (c *joinCluster) SelfID() membership.NodeID {
+	return 1
+}
+
+func (c *joinCluster) Self() membership.Node {
+	return membership.Node{ID: 1, PublicAddr: "self", Status: membership.StatusHealthy}
+}
+
+func (c *joinCluster) Nodes() []membership.Node {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	nodes := []membership.Node{c.Self()}
+	if c.joined {
+		nodes = append(nodes, membership.Node{ID: 2, PublicAddr: "seed", Status: membership.StatusHealthy})
+	}
+
+	return nodes
+}
+
+func (c *joinCluster) Join(_ context.Context, addr string) error {
+	c.mut.Lock()
+	c.attempts++
+	ok := c.attempts > c.fails
+	c.joined = c.joined || ok
+	c.mut.Unlock()
+
+	c.calls <- addr
+
+	if !ok {
+		return errors.New("connection refused")
+	}
+
+	return nil
+}
+
+func TestJoinerRetries(t *testing.T) {
+	cluster := &joinCluster{
+		clock: env.NewSimClock(time.Unix(1_700_000_000, 0)),
+		fails: 2,
+		calls: make(chan string),
+	}
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		joiner      = NewJoiner(cluster, Static{"self", "seed"}, time.Minute, kitlog.NewNopLogger())
+		done        = make(chan error, 1)
+	)
+
+	go func() { done <- joiner.Run(ctx) }()
+
+	// The local node is not joined, and the seed is retried with exponential
+	// backoff until the join succeeds.
+	for _, wait := range []time.Duration{0, joinMinBackoff, 2 * joinMinBackoff} {
+		if wait > 0 {
+			waitTimer(t, cluster.clock)
+			cluster.clock.Advance(wait)
+		}
+
+		select {
+		case addr := <-cluster.calls:
+			if addr != "seed" {
+				t.Fatalf("expected to join the seed, got %s", addr)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected to join the seed after %s", wait)
+		}
+	}
+
+	// Once the seed is a reachable peer, it is not joined again.
+	waitTimer(t, cluster.clock)
+	cluster.clock.Advance(time.Minute)
+	waitTimer(t, cluster.clock)
+
+	select {
+	case addr := <-cluster.calls:
+		t.Errorf("expected the reachable seed not to be joined, got %s", addr)
+	default:
+	}
+
+	cancel()
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the joiner to stop, got %v", err)
+	}
+
+	if pending := cluster.clock.Pending(); pending != 0 {
+		t.Errorf("expected the timer to be stopped, got %d", pending)
+	}
+}
+
+// waitTimer waits until the joiner waits for the clock.
+func waitTimer(t *testing.T, clock *env.SimClock) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for clock.Pending() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the joiner does not wait")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
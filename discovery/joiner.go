@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/internal/multierror"
+	"github.com/sadath-12/keywave/membership"
+)
+
+const (
+	joinMinBackoff = time.Second
+	joinMaxBackoff = 30 * time.Second
+	joinTimeout    = 10 * time.Second
+)
+
+// Joiner keeps the node connected to the cluster. It periodically discovers the
+// seed addresses and joins the nodes that are not known to be reachable. This
+// covers the initial join when the seeds are not up yet, the rejoin after the
+// node lost all its peers, and merging the clusters formed on both sides of a
+// partition once it heals.
+type Joiner struct {
+	cluster  membership.Cluster
+	provider Provider
+	interval time.Duration
+	logger   kitlog.Logger
+}
+
+func NewJoiner(cluster membership.Cluster, provider Provider, interval time.Duration, logger kitlog.Logger) *Joiner {
+	return &Joiner{
+		cluster:  cluster,
+		provider: provider,
+		interval: interval,
+		logger:   kitlog.With(logger, "component", "discovery"),
+	}
+}
+
+// Run joins the cluster until the context is canceled. While the node has no
+// reachable peers, failed attempts are retried with exponential backoff.
+func (j *Joiner) Run(ctx context.Context) error {
+	backoff := joinMinBackoff
+
+	for {
+		wait := j.interval
+
+		if err := j.join(ctx); err != nil {
+			level.Warn(j.logger).Log("msg", "failed to join the cluster", "err", err, "backoff", backoff)
+
+			wait = backoff
+
+			if backoff *= 2; backoff > joinMaxBackoff {
+				backoff = joinMaxBackoff
+			}
+		} else {
+			backoff = joinMinBackoff
+		}
+
+		elapsed := make(chan struct{})
+		timer := j.cluster.Env().Clock.AfterFunc(wait, func() { close(elapsed) })
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-elapsed:
+		}
+	}
+}
+
+// join attempts to join every discovered address that does not belong to a
+// reachable member. It only fails if the node is left without reachable peers.
+func (j *Joiner) join(ctx context.Context) error {
+	addrs, err := j.provider.Discover(ctx)
+	if err != nil {
+		if j.hasPeers() {
+			level.Debug(j.logger).Log("msg", "discovery failed", "err", err)
+			return nil
+		}
+
+		return fmt.Errorf("discover: %w", err)
+	}
+
+	var (
+		reachable = make(map[string]struct{})
+		self      = j.cluster.Self()
+		errs      = multierror.New[string]()
+	)
+
+	for _, node := range j.cluster.Nodes() {
+		if node.IsReachable() {
+			reachable[node.PublicAddr] = struct{}{}
+		}
+	}
+
+	for _, addr := range addrs {
+		if _, ok := reachable[addr]; ok || addr == self.PublicAddr {
+			continue
+		}
+
+		if err := j.joinAddr(ctx, addr); err != nil {
+			level.Debug(j.logger).Log("msg", "failed to join", "addr", addr, "err", err)
+			errs.Add(addr, err)
+
+			continue
+		}
+
+		level.Info(j.logger).Log("msg", "joined the cluster", "addr", addr)
+	}
+
+	if j.hasPeers() {
+		return nil
+	}
+
+	if errs.Len() > 0 {
+		return errs
+	}
+
+	// There is nothing to join when the local node is the only seed, in which
+	// case it is the first node of the cluster.
+	return nil
+}
+
+func (j *Joiner) joinAddr(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, joinTimeout)
+	defer cancel()
+
+	return j.cluster.Join(ctx, addr)
+}
+
+func (j *Joiner) hasPeers() bool {
+	selfID := j.cluster.SelfID()
+
+	for _, node := range j.cluster.Nodes() {
+		if node.ID != selfID && node.IsReachable() {
+			return true
+		}
+	}
+
+	return false
+}
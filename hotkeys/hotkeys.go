@@ -0,0 +1,230 @@
+// Package hotkeys finds the keys read the most often. A fraction of the reads
+// is sampled, and the sampled reads are counted for a bounded number of keys
+// with the space-saving algorithm: a key seen when all the counters are taken
+// replaces the least read one and inherits its count, so that a hot key is
+// never missed, at the cost of overestimating the rate of the keys tracked
+// for a short time. The counts decay exponentially, so the rates follow the
+// recent reads.
+package hotkeys
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sadath-12/keywave/internal/env"
+)
+
+const (
+	defaultSampleRate = 16
+	defaultCapacity   = 256
+	defaultHalfLife   = 10 * time.Second
+)
+
+// Config is the configuration of the detector. The zero values are replaced
+// by the defaults.
+type Config struct {
+	// SampleRate is the number of reads per sampled read.
+	SampleRate int
+	// Capacity is the number of keys tracked.
+	Capacity int
+	// HalfLife is the time after which the count of a key no longer read is
+	// halved.
+	HalfLife time.Duration
+	// Threshold is the rate of reads per second from which a key is hot. Zero
+	// means no key is hot, while the rates are still tracked.
+	Threshold float64
+}
+
+// Key is a key along with the estimated rate of its reads per second.
+type Key struct {
+	Key  string  `json:"key"`
+	Rate float64 `json:"rate"`
+}
+
+// Stats is a snapshot of the state of the detector.
+type Stats struct {
+	Tracked int   `json:"tracked"`
+	Hot     []Key `json:"hot"`
+}
+
+type counter struct {
+	count   float64
+	updated time.Time
+}
+
+// Detector tracks the rates of the reads of the keys. It is safe for
+// concurrent use.
+type Detector struct {
+	conf    Config
+	environ env.Env
+	// tau is the time constant of the decay.
+	tau time.Duration
+
+	mut      sync.Mutex
+	counters map[string]*counter
+}
+
+// New returns a detector with the configuration, in the environment.
+func New(conf Config, environ env.Env) *Detector {
+	if conf.SampleRate <= 0 {
+		conf.SampleRate = defaultSampleRate
+	}
+
+	if conf.Capacity <= 0 {
+		conf.Capacity = defaultCapacity
+	}
+
+	if conf.HalfLife <= 0 {
+		conf.HalfLife = defaultHalfLife
+	}
+
+	return &Detector{
+		conf:     conf,
+		environ:  environ.WithDefaults(),
+		tau:      time.Duration(float64(conf.HalfLife) / math.Ln2),
+		counters: make(map[string]*counter, conf.Capacity),
+	}
+}
+
+// decayed returns the count of the counter at the time.
+func (d *Detector) decayed(c *counter, now time.Time) float64 {
+	elapsed := now.Sub(c.updated)
+	if elapsed <= 0 {
+		return c.count
+	}
+
+	return c.count * math.Exp(-float64(elapsed)/float64(d.tau))
+}
+
+// rate converts a count of sampled reads into a rate of reads per second. In
+// the steady state, the count is the rate of the sampled reads times tau.
+func (d *Detector) rate(count float64) float64 {
+	return count * float64(d.conf.SampleRate) / d.tau.Seconds()
+}
+
+// Record counts a read of the key, if it is sampled.
+func (d *Detector) Record(key string) {
+	if d.conf.SampleRate > 1 && d.environ.Rand.Intn(d.conf.SampleRate) != 0 {
+		return
+	}
+
+	now := d.environ.Clock.Now()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if c, ok := d.counters[key]; ok {
+		c.count = d.decayed(c, now) + 1
+		c.updated = now
+
+		return
+	}
+
+	var inherited float64
+
+	if len(d.counters) >= d.conf.Capacity {
+		var (
+			minKey   string
+			minCount = math.Inf(1)
+		)
+
+		for k, c := range d.counters {
+			if count := d.decayed(c, now); count < minCount {
+				minKey, minCount = k, count
+			}
+		}
+
+		delete(d.counters, minKey)
+		inherited = minCount
+	}
+
+	d.counters[key] = &counter{count: inherited + 1, updated: now}
+}
+
+// Rate returns the estimated rate of the reads of the key per second, zero if
+// the key is not tracked.
+func (d *Detector) Rate(key string) float64 {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	c, ok := d.counters[key]
+	if !ok {
+		return 0
+	}
+
+	return d.rate(d.decayed(c, d.environ.Clock.Now()))
+}
+
+// Threshold returns the rate of reads per second from which a key is hot, zero
+// if no key is.
+func (d *Detector) Threshold() float64 {
+	return d.conf.Threshold
+}
+
+// IsHot reports whether the rate of the reads of the key reaches the
+// threshold.
+func (d *Detector) IsHot(key string) bool {
+	return d.conf.Threshold > 0 && d.Rate(key) >= d.conf.Threshold
+}
+
+// Top returns up to n keys with the highest rates, the highest first.
+func (d *Detector) Top(n int) []Key {
+	d.mut.Lock()
+
+	var (
+		now  = d.environ.Clock.Now()
+		keys = make([]Key, 0, len(d.counters))
+	)
+
+	for k, c := range d.counters {
+		keys = append(keys, Key{Key: k, Rate: d.rate(d.decayed(c, now))})
+	}
+
+	d.mut.Unlock()
+
+	slices.SortFunc(keys, func(a, b Key) int {
+		switch {
+		case a.Rate > b.Rate:
+			return -1
+		case a.Rate < b.Rate:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	if n >= 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
+// Hot returns the keys whose rates reach the threshold, the hottest first.
+func (d *Detector) Hot() []Key {
+	keys := d.Top(-1)
+
+	if d.conf.Threshold <= 0 {
+		return keys[:0]
+	}
+
+	for i, k := range keys {
+		if k.Rate < d.conf.Threshold {
+			return keys[:i]
+		}
+	}
+
+	return keys
+}
+
+// Stats returns a snapshot of the state of the detector.
+func (d *Detector) Stats() Stats {
+	hot := d.Hot()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	return Stats{Tracked: len(d.counters), Hot: hot}
+}
@@ -0,0 +1,79 @@
+package hotkeys
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sadath-12/keywave/internal/env"
+)
+
+func simEnv() (env.Env, *env.SimClock) {
+	clock := env.NewSimClock(time.Unix(1_700_000_000, 0))
+	return env.Env{Clock: clock, Rand: env.NewRand(1)}, clock
+}
+
+// readFor reads the keys at the given rates per second for the duration, in
+// steps of 10ms.
+func readFor(d *Detector, clock *env.SimClock, rates map[string]int, duration time.Duration) {
+	const step = 10 * time.Millisecond
+
+	for elapsed := time.Duration(0); elapsed < duration; elapsed += step {
+		for key, rate := range rates {
+			for i := 0; i < rate/100; i++ {
+				d.Record(key)
+			}
+		}
+
+		clock.Advance(step)
+	}
+}
+
+func TestRates(t *testing.T) {
+	environ, clock := simEnv()
+	d := New(Config{SampleRate: 4, Capacity: 8, HalfLife: time.Second, Threshold: 1000}, environ)
+
+	readFor(d, clock, map[string]int{"hot": 5000, "warm": 500}, 10*time.Second)
+
+	if rate := d.Rate("hot"); math.Abs(rate-5000) > 500 {
+		t.Errorf("expected the rate of the hot key to be about 5000, got %.0f", rate)
+	}
+
+	if rate := d.Rate("warm"); math.Abs(rate-500) > 150 {
+		t.Errorf("expected the rate of the warm key to be about 500, got %.0f", rate)
+	}
+
+	if !d.IsHot("hot") || d.IsHot("warm") || d.IsHot("unknown") {
+		t.Errorf("unexpected hot keys: %v", d.Hot())
+	}
+
+	top := d.Top(1)
+	if len(top) != 1 || top[0].Key != "hot" {
+		t.Errorf("unexpected top keys: %v", top)
+	}
+
+	// The key no longer read cools down.
+	readFor(d, clock, map[string]int{"warm": 500}, 5*time.Second)
+
+	if d.IsHot("hot") {
+		t.Errorf("expected the key to cool down, its rate is %.0f", d.Rate("hot"))
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	environ, clock := simEnv()
+	d := New(Config{SampleRate: 1, Capacity: 4, HalfLife: time.Second, Threshold: 1000}, environ)
+
+	// The hot key stays tracked among many keys read once.
+	for i := 0; i < 3000; i++ {
+		d.Record("hot")
+		d.Record("hot")
+		d.Record("cold" + strconv.Itoa(i))
+		clock.Advance(time.Millisecond)
+	}
+
+	if stats := d.Stats(); stats.Tracked != 4 || len(stats.Hot) != 1 || stats.Hot[0].Key != "hot" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,237 @@
+// Package index maintains the secondary indexes over the fields of the JSON
+// values. The indexes are declared per namespace, and each node only indexes
+// the values it stores, so a query is sent to all nodes and the results are
+// merged by the coordinator.
+package index
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sadath-12/keywave/internal/lockmap"
+	"github.com/sadath-12/keywave/internal/skiplist"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+)
+
+var (
+	_ storage.Engine     = (*Indexer)(nil)
+	_ storage.Scannable  = (*Indexer)(nil)
+	_ namespace.Reporter = (*Indexer)(nil)
+	_ Querier            = (*Indexer)(nil)
+)
+
+// ErrNoIndex is returned when the field of the namespace is not indexed.
+var ErrNoIndex = errors.New("field is not indexed")
+
+// Item is a key matching the query, with all versions of the key stored on
+// the node, including the siblings that do not match it.
+type Item struct {
+	Key    string
+	Values []storage.Value
+}
+
+// Querier is implemented by storage engines that maintain secondary indexes.
+type Querier interface {
+	// Query returns up to limit storage keys of the namespace whose field falls
+	// within the range, ordered by the value of the field and then by key.
+	Query(ns, field string, r Range, limit int) ([]Item, error)
+}
+
+type indexID struct {
+	ns, field string
+}
+
+// entry is a single value of the field of a key. A key with several values,
+// either from the concurrent versions or an array, has an entry for each.
+type entry struct {
+	term, key string
+}
+
+func compareEntries(a, b entry) int {
+	if c := strings.Compare(a.term, b.term); c != 0 {
+		return c
+	}
+
+	return strings.Compare(a.key, b.key)
+}
+
+type fieldIndex struct {
+	namespace.Index
+	entries *skiplist.Skiplist[entry, struct{}]
+}
+
+// Indexer wraps a storage engine and maintains the indexes declared in the
+// namespaces of the values written through it. A key is indexed by the values
+// of all its versions except for the tombstones, so a key with the concurrent
+// versions matches the values of every sibling.
+type Indexer struct {
+	engine  storage.Engine
+	locks   *lockmap.Map[string]
+	indexes map[indexID]*fieldIndex
+	byNS    map[string][]*fieldIndex
+}
+
+// New wraps the engine with the indexes declared in the namespaces. The engine
+// is expected to be empty, since the existing data is not indexed.
+func New(engine storage.Engine, namespaces *namespace.Registry) *Indexer {
+	ix := &Indexer{
+		engine:  engine,
+		locks:   lockmap.New[string](),
+		indexes: make(map[indexID]*fieldIndex),
+		byNS:    make(map[string][]*fieldIndex),
+	}
+
+	for _, ns := range namespaces.List() {
+		for _, decl := range ns.Indexes {
+			fi := &fieldIndex{
+				Index:   decl,
+				entries: skiplist.New[entry, struct{}](compareEntries),
+			}
+
+			ix.indexes[indexID{ns.Name, decl.Field}] = fi
+			ix.byNS[ns.Name] = append(ix.byNS[ns.Name], fi)
+		}
+	}
+
+	return ix
+}
+
+func (ix *Indexer) Get(key string) ([]storage.Value, error) {
+	return ix.engine.Get(key)
+}
+
+func (ix *Indexer) Put(key string, value storage.Value) error {
+	ns, nsKey, ok := namespace.SplitKey(key)
+	if !ok || len(ix.byNS[ns]) == 0 {
+		return ix.engine.Put(key, value)
+	}
+
+	// The key is locked so that the entries removed are the ones added by the
+	// previous write of the key.
+	ix.locks.Lock(key)
+	defer ix.locks.Unlock(key)
+
+	before, _ := ix.engine.Get(key)
+
+	if err := ix.engine.Put(key, value); err != nil {
+		return err
+	}
+
+	after, _ := ix.engine.Get(key)
+
+	for _, fi := range ix.byNS[ns] {
+		if !strings.HasPrefix(nsKey, fi.Prefix) {
+			continue
+		}
+
+		oldTerms := valueTerms(before, fi.Field)
+		newTerms := valueTerms(after, fi.Field)
+
+		for term := range oldTerms {
+			if _, ok := newTerms[term]; !ok {
+				fi.entries.Remove(entry{term, key})
+			}
+		}
+
+		for term := range newTerms {
+			if _, ok := oldTerms[term]; !ok {
+				fi.entries.Insert(entry{term, key}, struct{}{})
+			}
+		}
+	}
+
+	return nil
+}
+
+// valueTerms returns the terms of the field of all versions except for the
+// tombstones and the values stored in chunks, which are not indexed.
+func valueTerms(values []storage.Value, field string) map[string]struct{} {
+	terms := make(map[string]struct{})
+
+	for _, v := range values {
+		if v.Tombstone || v.Chunked {
+			continue
+		}
+
+		for _, term := range fieldTerms(v.Data, field) {
+			terms[term] = struct{}{}
+		}
+	}
+
+	return terms
+}
+
+// Query returns the keys whose field falls within the range. The values of the
+// keys are read after the index, so a key updated in the meantime may no longer
+// match the query.
+func (ix *Indexer) Query(ns, field string, r Range, limit int) ([]Item, error) {
+	fi, ok := ix.indexes[indexID{ns, field}]
+	if !ok {
+		return nil, ErrNoIndex
+	}
+
+	var (
+		items = make([]Item, 0)
+		seen  = make(map[string]struct{})
+	)
+
+	it := fi.entries.ScanFrom(entry{term: r.min})
+
+	for it.HasNext() && (limit <= 0 || len(items) < limit) {
+		e, _ := it.Next()
+		if e.term > r.max {
+			break
+		}
+
+		// A key is listed once, by its smallest matching term.
+		if _, ok := seen[e.key]; ok {
+			continue
+		}
+
+		seen[e.key] = struct{}{}
+
+		values, err := ix.engine.Get(e.key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		items = append(items, Item{Key: e.key, Values: values})
+	}
+
+	return items, nil
+}
+
+// Scan returns an iterator over the underlying engine. If the engine does not
+// support range scans, the iterator is always empty.
+func (ix *Indexer) Scan(key string) storage.ScanIterator {
+	if s, ok := ix.engine.(storage.Scannable); ok {
+		return s.Scan(key)
+	}
+
+	return emptyIterator{}
+}
+
+// Usage returns the namespace usage of the underlying engine, if it keeps
+// track of it.
+func (ix *Indexer) Usage() map[string]namespace.Usage {
+	if r, ok := ix.engine.(namespace.Reporter); ok {
+		return r.Usage()
+	}
+
+	return map[string]namespace.Usage{}
+}
+
+type emptyIterator struct{}
+
+func (emptyIterator) Next() error {
+	return storage.ErrNoMoreItems
+}
+
+func (emptyIterator) Item() (string, []storage.Value) {
+	return "", nil
+}
@@ -0,0 +1,102 @@
+package index
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+	"github.com/sadath-12/keywave/storage/inmemory"
+)
+
+func keys(t *testing.T, ix *Indexer, r Range) []string {
+	t.Helper()
+
+	items, err := ix.Query("users", "age", r, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := make([]string, len(items))
+	for i, item := range items {
+		res[i] = item.Key
+	}
+
+	return res
+}
+
+func TestIndexer(t *testing.T) {
+	ix := New(inmemory.New(), namespace.NewRegistry(namespace.Config{}, namespace.Config{
+		Name:    "users",
+		Indexes: []namespace.Index{{Field: "age", Prefix: "user:"}},
+	}))
+
+	put := func(key, data string, version vclock.Version, tombstone bool) {
+		t.Helper()
+
+		value := storage.Value{Version: version, Data: []byte(data), Tombstone: tombstone}
+		if err := ix.Put(namespace.Key("users", key), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("user:a", `{"age": 30}`, vclock.Version{1: 1}, false)
+	put("user:b", `{"age": 25}`, vclock.Version{1: 1}, false)
+	put("user:c", `{"age": "30"}`, vclock.Version{1: 1}, false)
+	put("other:d", `{"age": 30}`, vclock.Version{1: 1}, false)
+
+	thirty, _ := Equal([]byte("30"))
+	if got := keys(t, ix, thirty); !slices.Equal(got, []string{"users/user:a"}) {
+		t.Errorf("age = 30: %v", got)
+	}
+
+	from20, _ := Between([]byte("20"), nil)
+	if got := keys(t, ix, from20); !slices.Equal(got, []string{"users/user:b", "users/user:a"}) {
+		t.Errorf("age >= 20: %v", got)
+	}
+
+	// Both concurrent versions are indexed.
+	put("user:b", `{"age": 30}`, vclock.Version{2: 1}, false)
+
+	if got := keys(t, ix, thirty); !slices.Equal(got, []string{"users/user:a", "users/user:b"}) {
+		t.Errorf("age = 30 with siblings: %v", got)
+	}
+
+	// The update replaces the entries of the versions it overwrites.
+	put("user:a", `{"age": 31}`, vclock.Version{1: 2}, false)
+
+	if got := keys(t, ix, thirty); !slices.Equal(got, []string{"users/user:b"}) {
+		t.Errorf("age = 30 after update: %v", got)
+	}
+
+	put("user:b", ``, vclock.Version{1: 2, 2: 1}, true)
+
+	if got := keys(t, ix, from20); !slices.Equal(got, []string{"users/user:a"}) {
+		t.Errorf("age >= 20 after delete: %v", got)
+	}
+
+	if _, err := ix.Query("users", "name", thirty, 0); err != ErrNoIndex {
+		t.Errorf("expected ErrNoIndex, got %v", err)
+	}
+}
+
+func TestTermOrder(t *testing.T) {
+	values := []string{`false`, `true`, `-1e10`, `-2.5`, `0`, `3`, `1e10`, `""`, `"a"`, `"ab"`, `"b"`}
+
+	for i := 1; i < len(values); i++ {
+		a, err := parseTerm([]byte(values[i-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := parseTerm([]byte(values[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a >= b {
+			t.Errorf("%s is not ordered before %s", values[i-1], values[i])
+		}
+	}
+}
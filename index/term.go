@@ -0,0 +1,198 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// The terms of the different JSON types never compare equal, and are ordered by
+// the type first: false < true < numbers < strings.
+const (
+	tagBool   = '1'
+	tagNumber = '2'
+	tagString = '3'
+)
+
+var (
+	// ErrInvalidValue is returned when the queried value is not a JSON string,
+	// number or boolean.
+	ErrInvalidValue = errors.New("index value must be a JSON string, number or boolean")
+	// ErrInvalidRange is returned when the bounds of the range are missing or
+	// of different types.
+	ErrInvalidRange = errors.New("invalid index range")
+)
+
+// encodeTerm encodes the scalar JSON value so that the encoded terms are
+// ordered the same way as the values of the same type. Objects, arrays and nulls
+// are not indexed.
+func encodeTerm(v any) (string, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return string(tagBool) + "1", true
+		}
+
+		return string(tagBool) + "0", true
+	case float64:
+		// Flipping the sign bit of the positive numbers and all bits of the
+		// negative ones makes the bits sort as the numbers do.
+		bits := math.Float64bits(v)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+
+		return fmt.Sprintf("%c%016x", tagNumber, bits), true
+	case string:
+		return string(tagString) + v, true
+	default:
+		return "", false
+	}
+}
+
+// fieldTerms returns the terms of the field of the JSON document. The elements
+// of an array of scalars are indexed individually. Documents that are not JSON
+// objects or lack the field have no terms.
+func fieldTerms(data []byte, field string) []string {
+	var doc any
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil
+	}
+
+	for _, name := range strings.Split(field, ".") {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return nil
+		}
+
+		if doc, ok = obj[name]; !ok {
+			return nil
+		}
+	}
+
+	if arr, ok := doc.([]any); ok {
+		terms := make([]string, 0, len(arr))
+
+		for _, elem := range arr {
+			if term, ok := encodeTerm(elem); ok {
+				terms = append(terms, term)
+			}
+		}
+
+		return terms
+	}
+
+	if term, ok := encodeTerm(doc); ok {
+		return []string{term}
+	}
+
+	return nil
+}
+
+func parseTerm(value []byte) (string, error) {
+	var v any
+
+	if err := json.Unmarshal(value, &v); err != nil {
+		return "", ErrInvalidValue
+	}
+
+	term, ok := encodeTerm(v)
+	if !ok {
+		return "", ErrInvalidValue
+	}
+
+	return term, nil
+}
+
+// Range is the inclusive range of the values of an indexed field.
+type Range struct {
+	min, max string
+}
+
+// NewRange returns the range matching the JSON value if it is set, or the
+// range between the JSON bounds otherwise.
+func NewRange(value, min, max []byte) (Range, error) {
+	if len(value) > 0 {
+		return Equal(value)
+	}
+
+	return Between(min, max)
+}
+
+// Equal returns the range matching the single JSON value.
+func Equal(value []byte) (Range, error) {
+	term, err := parseTerm(value)
+	if err != nil {
+		return Range{}, err
+	}
+
+	return Range{min: term, max: term}, nil
+}
+
+// Between returns the inclusive range between the JSON values. Either bound may
+// be omitted, in which case the range is only limited by the type of the other
+// one, e.g. a range with the minimum of 10 matches all numbers from 10 up.
+func Between(min, max []byte) (Range, error) {
+	var r Range
+
+	if len(min) == 0 && len(max) == 0 {
+		return r, fmt.Errorf("%w: no bounds", ErrInvalidRange)
+	}
+
+	if len(min) > 0 {
+		term, err := parseTerm(min)
+		if err != nil {
+			return r, err
+		}
+
+		r.min = term
+	}
+
+	if len(max) > 0 {
+		term, err := parseTerm(max)
+		if err != nil {
+			return r, err
+		}
+
+		r.max = term
+	}
+
+	switch {
+	case r.min == "":
+		r.min = r.max[:1]
+	case r.max == "":
+		// No term of the type starts with 0xff, as it is not valid UTF-8 and
+		// the numbers are hex encoded.
+		r.max = r.min[:1] + "\xff"
+	case r.min[0] != r.max[0]:
+		return r, fmt.Errorf("%w: bounds of different types", ErrInvalidRange)
+	}
+
+	return r, nil
+}
+
+func (r Range) contains(term string) bool {
+	return term >= r.min && term <= r.max
+}
+
+// Match returns the smallest term of the field of the JSON document that falls
+// within the range. The terms order the documents the same way as the index.
+func (r Range) Match(data []byte, field string) (string, bool) {
+	var (
+		first string
+		found bool
+	)
+
+	for _, term := range fieldTerms(data, field) {
+		if r.contains(term) && (!found || term < first) {
+			first, found = term, true
+		}
+	}
+
+	return first, found
+}
@@ -0,0 +1,436 @@
+// Package clustertest runs a cluster of nodes in a single process, connected
+// by an in-memory network that can drop, delay and partition the messages
+// between the nodes. It is meant for testing the failure detection and the
+// replication under network failures.
+package clustertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+	membershippb "github.com/sadath-12/keywave/membership/proto"
+	membershipsvc "github.com/sadath-12/keywave/membership/service"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+	replicationpb "github.com/sadath-12/keywave/replication/proto"
+	replicationsvc "github.com/sadath-12/keywave/replication/service"
+	"github.com/sadath-12/keywave/storage"
+	"github.com/sadath-12/keywave/storage/inmemory"
+	storagepb "github.com/sadath-12/keywave/storage/proto"
+	storagesvc "github.com/sadath-12/keywave/storage/service"
+	"github.com/sadath-12/keywave/storage/stack"
+)
+
+const bufSize = 1 << 20
+
+// pollInterval is how often Eventually checks its condition.
+const pollInterval = 10 * time.Millisecond
+
+// Options configure the harness.
+type Options struct {
+	// Nodes is the number of nodes, with IDs from 1 to Nodes.
+	Nodes int
+	// Seed makes the random message drops reproducible.
+	Seed int64
+	// Namespaces are the namespaces of the cluster. The default namespace of the
+	// replication service is used if not set.
+	Namespaces *namespace.Registry
+	// Configure adjusts the membership configuration of every node.
+	Configure func(conf *membership.Config)
+	// ConfigureService adjusts the replication service of every node.
+	ConfigureService func(svc *replicationsvc.ReplicationService)
+	// ServerOptions are applied to the gRPC server of every node, e.g. the
+	// interceptors of the admission control.
+	ServerOptions []grpc.ServerOption
+	// Logger receives the logs of all nodes. They are discarded if not set.
+	Logger kitlog.Logger
+	// Env is the environment of the nodes, whose clock the harness waits on as
+	// well. The missing parts default to the real environment. A simulated clock
+	// is advanced by the harness while it waits, and only then, so the calls
+	// that need the time to pass, such as the ones over a delayed link, never
+	// complete with it.
+	Env env.Env
+}
+
+// DefaultConfig returns the membership configuration with the timeouts short
+// enough for the failures to be detected within a test.
+func DefaultConfig() membership.Config {
+	conf := membership.DefaultConfig()
+	conf.DialTimeout = time.Second
+	conf.ProbeInterval = 20 * time.Millisecond
+	conf.ProbeTimeout = 100 * time.Millisecond
+	conf.SuspicionTimeout = 200 * time.Millisecond
+	conf.MaxLocalHealth = 1
+	conf.PushPullInterval = 200 * time.Millisecond
+	conf.GCInterval = 100 * time.Millisecond
+
+	return conf
+}
+
+// Node is a single node of the harness.
+type Node struct {
+	ID          membership.NodeID
+	Addr        string
+	Cluster     *membership.SWIMCluster
+	Engine      storage.Engine
+	Replication *replicationsvc.ReplicationService
+
+	server   *grpc.Server
+	listener *bufconn.Listener
+}
+
+// Client returns a connection to the node made by the node itself, so it is not
+// affected by the network faults.
+func (n *Node) Client() nodeapi.Client {
+	return n.Cluster.LocalConn()
+}
+
+// Dial connects to the node from outside of the cluster with the extra options,
+// e.g. the credentials of a front-end of the node. The connection is not
+// affected by the network faults, and must be closed by the caller.
+func (n *Node) Dial(ctx context.Context, opts ...grpc.DialOption) (nodeapi.Client, error) {
+	opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return n.listener.DialContext(ctx)
+	}))
+
+	return nodeapigrpc.DialWithOptions(ctx, n.Addr, opts...)
+}
+
+// Conn returns a gRPC connection to the node from outside of the cluster with
+// the extra options, e.g. for the HTTP gateway. It must be closed by the caller.
+func (n *Node) Conn(opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return n.listener.DialContext(ctx)
+		}),
+	}, opts...)
+
+	return grpc.Dial(n.Addr, opts...)
+}
+
+// advancer is a simulated clock, which moves only when it is advanced.
+type advancer interface {
+	Advance(d time.Duration)
+}
+
+// Harness is an in-process cluster.
+type Harness struct {
+	t       testing.TB
+	env     env.Env
+	network *Network
+	nodes   map[membership.NodeID]*Node
+
+	mut       sync.Mutex
+	listeners map[string]*bufconn.Listener
+}
+
+// New starts the nodes, joins them into a single cluster and waits until every
+// node sees all others as healthy. The cluster is stopped when the test ends.
+func New(t testing.TB, opts Options) *Harness {
+	t.Helper()
+
+	if opts.Logger == nil {
+		opts.Logger = kitlog.NewNopLogger()
+	}
+
+	if opts.Namespaces == nil {
+		opts.Namespaces = namespace.NewRegistry(replicationsvc.DefaultNamespace())
+	}
+
+	environ := opts.Env.WithDefaults()
+
+	h := &Harness{
+		t:         t,
+		env:       environ,
+		network:   NewNetwork(opts.Seed, environ.Clock),
+		nodes:     make(map[membership.NodeID]*Node, opts.Nodes),
+		listeners: make(map[string]*bufconn.Listener, opts.Nodes),
+	}
+
+	t.Cleanup(h.close)
+
+	for i := 1; i <= opts.Nodes; i++ {
+		h.start(membership.NodeID(i), opts)
+	}
+
+	ctx, cancel := h.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, node := range h.Nodes()[1:] {
+		if err := node.Cluster.Join(ctx, h.nodes[1].Addr); err != nil {
+			t.Fatalf("node %d failed to join: %v", node.ID, err)
+		}
+	}
+
+	if err := h.WaitConverged(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func (h *Harness) start(id membership.NodeID, opts Options) {
+	var (
+		addr     = fmt.Sprintf("node-%d", id)
+		listener = bufconn.Listen(bufSize)
+		logger   = kitlog.With(opts.Logger, "node_id", id)
+	)
+
+	h.mut.Lock()
+	h.listeners[addr] = listener
+	h.mut.Unlock()
+
+	conf := DefaultConfig()
+	conf.NodeID = id
+	conf.NodeName = addr
+	conf.PublicAddr = addr
+	conf.LocalAddr = addr
+	conf.Logger = logger
+	conf.Dialer = h.dialer(id)
+	conf.Env = h.env
+
+	if opts.Configure != nil {
+		opts.Configure(&conf)
+	}
+
+	cluster := membership.NewSWIM(conf)
+	engine := stack.New(inmemory.New(), opts.Namespaces)
+
+	server := grpc.NewServer(opts.ServerOptions...)
+	storagepb.RegisterStorageServiceServer(server, storagesvc.New(engine, uint32(id)))
+	membershippb.RegisterMembershipServer(server, membershipsvc.NewMembershipService(cluster))
+
+	replication := replicationsvc.New(cluster, opts.Namespaces, logger)
+	if opts.ConfigureService != nil {
+		opts.ConfigureService(replication)
+	}
+
+	replicationpb.RegisterReplicationServer(server, replication)
+
+	go server.Serve(listener) //nolint:errcheck
+
+	cluster.Start()
+
+	h.nodes[id] = &Node{
+		ID:          id,
+		Addr:        addr,
+		Cluster:     cluster,
+		Engine:      engine,
+		Replication: replication,
+		server:      server,
+		listener:    listener,
+	}
+}
+
+// dialer returns the dialer of the node, which connects to the other nodes via
+// the in-memory listeners and applies the network faults to every call.
+func (h *Harness) dialer(from membership.NodeID) nodeapi.Dialer {
+	return func(ctx context.Context, addr string) (nodeapi.Client, error) {
+		h.mut.Lock()
+		listener, ok := h.listeners[addr]
+		h.mut.Unlock()
+
+		if !ok {
+			return nil, fmt.Errorf("unknown address %s", addr)
+		}
+
+		var to membership.NodeID
+		if _, err := fmt.Sscanf(addr, "node-%d", &to); err != nil {
+			return nil, err
+		}
+
+		opts := append(h.network.interceptors(from, to),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+		)
+
+		return nodeapigrpc.DialWithOptions(ctx, addr, opts...)
+	}
+}
+
+// Dialer returns a dialer connecting to the nodes from outside of the cluster,
+// e.g. for a tool that is given the addresses of the nodes.
+func (h *Harness) Dialer() nodeapi.Dialer {
+	return h.dialer(0)
+}
+
+func (h *Harness) close() {
+	for _, node := range h.nodes {
+		node.Cluster.Stop()
+		node.server.Stop()
+	}
+}
+
+// Clock returns the clock of the nodes.
+func (h *Harness) Clock() env.Clock {
+	return h.env.Clock
+}
+
+// Sleep waits until the duration elapses on the clock of the nodes. A simulated
+// clock is advanced by the duration instead, running the tasks of the nodes
+// due in the meantime.
+func (h *Harness) Sleep(d time.Duration) {
+	if clock, ok := h.env.Clock.(advancer); ok {
+		clock.Advance(d)
+		return
+	}
+
+	elapsed := make(chan struct{})
+	h.env.Clock.AfterFunc(d, func() { close(elapsed) })
+
+	<-elapsed
+}
+
+// WithTimeout returns a copy of the context canceled once the timeout elapses
+// on the clock of the nodes.
+func (h *Harness) WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	timer := h.env.Clock.AfterFunc(timeout, cancel)
+
+	return ctx, func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+// Network returns the network connecting the nodes.
+func (h *Harness) Network() *Network {
+	return h.network
+}
+
+// Node returns the node with the ID. It fails the test if there is no such node.
+func (h *Harness) Node(id membership.NodeID) *Node {
+	node, ok := h.nodes[id]
+	if !ok {
+		h.t.Fatalf("node %d does not exist", id)
+	}
+
+	return node
+}
+
+// Nodes returns all nodes ordered by ID.
+func (h *Harness) Nodes() []*Node {
+	nodes := make([]*Node, 0, len(h.nodes))
+	for _, node := range h.nodes {
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return nodes
+}
+
+// IDs returns the IDs of all nodes.
+func (h *Harness) IDs() []membership.NodeID {
+	ids := make([]membership.NodeID, 0, len(h.nodes))
+	for _, node := range h.Nodes() {
+		ids = append(ids, node.ID)
+	}
+
+	return ids
+}
+
+// Isolate cuts all links of the node, which is indistinguishable from a crash
+// for the other nodes.
+func (h *Harness) Isolate(id membership.NodeID) {
+	for other := range h.nodes {
+		if other != id {
+			h.network.Cut(id, other)
+		}
+	}
+}
+
+// Crash stops the node without notifying the other nodes.
+func (h *Harness) Crash(id membership.NodeID) {
+	node := h.Node(id)
+	node.Cluster.Stop()
+	node.server.Stop()
+}
+
+// Status returns the status of the target as seen by the observer.
+func (h *Harness) Status(observer, target membership.NodeID) (membership.Status, bool) {
+	node, ok := h.Node(observer).Cluster.Node(target)
+	return node.Status, ok
+}
+
+// Eventually polls the condition until it holds or the timeout expires on the
+// clock of the nodes.
+func (h *Harness) Eventually(timeout time.Duration, cond func() error) error {
+	deadline := h.env.Clock.Now().Add(timeout)
+
+	for {
+		err := cond()
+		if err == nil {
+			return nil
+		}
+
+		if h.env.Clock.Now().After(deadline) {
+			return fmt.Errorf("condition not met in %s: %w", timeout, err)
+		}
+
+		h.Sleep(pollInterval)
+	}
+}
+
+// WaitStatus waits until every observer sees the target in one of the statuses.
+func (h *Harness) WaitStatus(timeout time.Duration, observers []membership.NodeID, target membership.NodeID, statuses ...membership.Status) error {
+	return h.Eventually(timeout, func() error {
+		for _, observer := range observers {
+			status, ok := h.Status(observer, target)
+			if !ok {
+				return fmt.Errorf("node %d does not know node %d", observer, target)
+			}
+
+			if !containsStatus(statuses, status) {
+				return fmt.Errorf("node %d sees node %d as %s", observer, target, status)
+			}
+		}
+
+		return nil
+	})
+}
+
+// WaitConverged waits until every node sees all nodes as healthy.
+func (h *Harness) WaitConverged(timeout time.Duration) error {
+	ids := h.IDs()
+
+	return h.Eventually(timeout, func() error {
+		for _, target := range ids {
+			for _, observer := range ids {
+				status, ok := h.Status(observer, target)
+				if !ok || status != membership.StatusHealthy {
+					return fmt.Errorf("node %d sees node %d as %s", observer, target, status)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func containsStatus(statuses []membership.Status, status membership.Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
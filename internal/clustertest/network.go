@@ -0,0 +1,187 @@
+package clustertest
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+)
+
+// Fault describes how the messages sent over a link are disrupted.
+type Fault struct {
+	// Blocked makes every message fail, as if the link was cut.
+	Blocked bool
+	// DropRate is the probability of a message to be lost, from 0 to 1.
+	DropRate float64
+	// Delay is added to every message before it is sent.
+	Delay time.Duration
+}
+
+type link struct {
+	from, to membership.NodeID
+}
+
+// Network is an in-memory network between the nodes of the harness. The faults
+// are applied per directed link, so asymmetric failures can be simulated too.
+// Messages are failed with the Unavailable code, the same way gRPC reports an
+// unreachable peer.
+type Network struct {
+	mut    sync.Mutex
+	faults map[link]Fault
+	rand   *rand.Rand
+	clock  env.Clock
+}
+
+// NewNetwork creates a network without faults. The seed makes the random
+// message drops reproducible, and the delays elapse on the clock.
+func NewNetwork(seed int64, clock env.Clock) *Network {
+	return &Network{
+		faults: make(map[link]Fault),
+		rand:   rand.New(rand.NewSource(seed)), //nolint:gosec
+		clock:  clock,
+	}
+}
+
+// SetFault replaces the fault of the directed link between the nodes.
+func (n *Network) SetFault(from, to membership.NodeID, fault Fault) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	if fault == (Fault{}) {
+		delete(n.faults, link{from, to})
+		return
+	}
+
+	n.faults[link{from, to}] = fault
+}
+
+// update changes the faults of the links in both directions.
+func (n *Network) update(a, b membership.NodeID, f func(*Fault)) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	for _, l := range []link{{a, b}, {b, a}} {
+		fault := n.faults[l]
+		f(&fault)
+
+		if fault == (Fault{}) {
+			delete(n.faults, l)
+		} else {
+			n.faults[l] = fault
+		}
+	}
+}
+
+// Cut blocks the link between the nodes in both directions.
+func (n *Network) Cut(a, b membership.NodeID) {
+	n.update(a, b, func(f *Fault) { f.Blocked = true })
+}
+
+// Drop makes the link between the nodes lose messages with the given rate.
+func (n *Network) Drop(a, b membership.NodeID, rate float64) {
+	n.update(a, b, func(f *Fault) { f.DropRate = rate })
+}
+
+// Delay makes the link between the nodes deliver messages with the delay.
+func (n *Network) Delay(a, b membership.NodeID, d time.Duration) {
+	n.update(a, b, func(f *Fault) { f.Delay = d })
+}
+
+// Partition splits the nodes into groups that cannot reach each other. Links
+// within a group are left intact.
+func (n *Network) Partition(groups ...[]membership.NodeID) {
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, a := range group {
+				for _, b := range other {
+					n.Cut(a, b)
+				}
+			}
+		}
+	}
+}
+
+// Heal removes all the faults.
+func (n *Network) Heal() {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	n.faults = make(map[link]Fault)
+}
+
+// deliver applies the fault of the link to a single message.
+func (n *Network) deliver(ctx context.Context, from, to membership.NodeID) error {
+	n.mut.Lock()
+	fault, ok := n.faults[link{from, to}]
+	dropped := ok && fault.DropRate > 0 && n.rand.Float64() < fault.DropRate
+	n.mut.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	if fault.Blocked || dropped {
+		return status.Errorf(codes.Unavailable, "link %d->%d is down", from, to)
+	}
+
+	if fault.Delay > 0 {
+		delivered := make(chan struct{})
+		timer := n.clock.AfterFunc(fault.Delay, func() { close(delivered) })
+
+		defer timer.Stop()
+
+		select {
+		case <-delivered:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	return nil
+}
+
+// interceptors return the client interceptors applying the faults to the calls
+// made by one node to another.
+func (n *Network) interceptors(from, to membership.NodeID) []grpc.DialOption {
+	unary := func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if err := n.deliver(ctx, from, to); err != nil {
+			return err
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	stream := func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if err := n.deliver(ctx, from, to); err != nil {
+			return nil, err
+		}
+
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unary),
+		grpc.WithChainStreamInterceptor(stream),
+	}
+}
@@ -0,0 +1,212 @@
+package clustertest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// DefaultTimeout is how long the expectations of a scenario are waited for.
+const DefaultTimeout = 5 * time.Second
+
+// Step is a single action or expectation of a scenario.
+type Step struct {
+	Name string
+	Do   func(h *Harness) error
+}
+
+// Run executes the steps in order, and fails the test on the first step that
+// returns an error.
+func (h *Harness) Run(steps ...Step) {
+	h.t.Helper()
+
+	for i, step := range steps {
+		if err := step.Do(h); err != nil {
+			h.t.Fatalf("step %d (%s) failed: %v", i+1, step.Name, err)
+		}
+	}
+}
+
+// Partition splits the cluster into the groups that cannot reach each other.
+func Partition(groups ...[]membership.NodeID) Step {
+	return Step{
+		Name: fmt.Sprintf("partition %v", groups),
+		Do: func(h *Harness) error {
+			h.Network().Partition(groups...)
+			return nil
+		},
+	}
+}
+
+// Isolate cuts all links of the node.
+func Isolate(id membership.NodeID) Step {
+	return Step{
+		Name: fmt.Sprintf("isolate %d", id),
+		Do: func(h *Harness) error {
+			h.Isolate(id)
+			return nil
+		},
+	}
+}
+
+// Crash stops the node without notifying the others.
+func Crash(id membership.NodeID) Step {
+	return Step{
+		Name: fmt.Sprintf("crash %d", id),
+		Do: func(h *Harness) error {
+			h.Crash(id)
+			return nil
+		},
+	}
+}
+
+// Drop makes the link between the nodes lose messages with the given rate.
+func Drop(a, b membership.NodeID, rate float64) Step {
+	return Step{
+		Name: fmt.Sprintf("drop %d<->%d at %.2f", a, b, rate),
+		Do: func(h *Harness) error {
+			h.Network().Drop(a, b, rate)
+			return nil
+		},
+	}
+}
+
+// Delay makes the link between the nodes slow.
+func Delay(a, b membership.NodeID, d time.Duration) Step {
+	return Step{
+		Name: fmt.Sprintf("delay %d<->%d by %s", a, b, d),
+		Do: func(h *Harness) error {
+			h.Network().Delay(a, b, d)
+			return nil
+		},
+	}
+}
+
+// Heal removes all network faults.
+func Heal() Step {
+	return Step{
+		Name: "heal",
+		Do: func(h *Harness) error {
+			h.Network().Heal()
+			return nil
+		},
+	}
+}
+
+// Sleep pauses the scenario until the duration elapses on the clock of the
+// nodes.
+func Sleep(d time.Duration) Step {
+	return Step{
+		Name: fmt.Sprintf("sleep %s", d),
+		Do: func(h *Harness) error {
+			h.Sleep(d)
+			return nil
+		},
+	}
+}
+
+// ExpectStatus waits until every observer sees the target in one of the statuses.
+func ExpectStatus(observers []membership.NodeID, target membership.NodeID, statuses ...membership.Status) Step {
+	return Step{
+		Name: fmt.Sprintf("expect %v to see %d as %v", observers, target, statuses),
+		Do: func(h *Harness) error {
+			return h.WaitStatus(DefaultTimeout, observers, target, statuses...)
+		},
+	}
+}
+
+// ExpectRemoved waits until none of the observers knows the target.
+func ExpectRemoved(observers []membership.NodeID, target membership.NodeID) Step {
+	return Step{
+		Name: fmt.Sprintf("expect %v to remove %d", observers, target),
+		Do: func(h *Harness) error {
+			return h.Eventually(DefaultTimeout, func() error {
+				for _, observer := range observers {
+					if status, ok := h.Status(observer, target); ok {
+						return fmt.Errorf("node %d sees node %d as %s", observer, target, status)
+					}
+				}
+
+				return nil
+			})
+		},
+	}
+}
+
+// ExpectConverged waits until every node sees all nodes as healthy.
+func ExpectConverged() Step {
+	return Step{
+		Name: "expect converged",
+		Do: func(h *Harness) error {
+			return h.WaitConverged(DefaultTimeout)
+		},
+	}
+}
+
+// Put writes the value via the coordinator node and expects it to succeed.
+func Put(via membership.NodeID, key, value string, opts nodeapi.KeyOpts) Step {
+	return Step{
+		Name: fmt.Sprintf("put %s via %d", key, via),
+		Do: func(h *Harness) error {
+			ctx, cancel := h.WithTimeout(context.Background(), DefaultTimeout)
+			defer cancel()
+
+			_, err := h.Node(via).Client().PutKey(ctx, key, []byte(value), "", opts)
+
+			return err
+		},
+	}
+}
+
+// ExpectPutError writes the value via the coordinator node and expects it to
+// fail with the code.
+func ExpectPutError(via membership.NodeID, key, value string, opts nodeapi.KeyOpts, code codes.Code) Step {
+	return Step{
+		Name: fmt.Sprintf("put %s via %d fails with %s", key, via, code),
+		Do: func(h *Harness) error {
+			ctx, cancel := h.WithTimeout(context.Background(), DefaultTimeout)
+			defer cancel()
+
+			_, err := h.Node(via).Client().PutKey(ctx, key, []byte(value), "", opts)
+			if err == nil {
+				return fmt.Errorf("put succeeded")
+			}
+
+			if actual := grpcutil.ErrorCode(err); actual != code {
+				return fmt.Errorf("unexpected error: %w", err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// ExpectValue reads the key via the coordinator node and expects the value.
+func ExpectValue(via membership.NodeID, key, value string, opts nodeapi.KeyOpts) Step {
+	return Step{
+		Name: fmt.Sprintf("get %s via %d", key, via),
+		Do: func(h *Harness) error {
+			ctx, cancel := h.WithTimeout(context.Background(), DefaultTimeout)
+			defer cancel()
+
+			res, err := h.Node(via).Client().GetKey(ctx, key, opts)
+			if err != nil {
+				return err
+			}
+
+			for _, v := range res.Values {
+				if string(v) == value {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("key %s has values %q, want %q", key, res.Values, value)
+		},
+	}
+}
@@ -0,0 +1,244 @@
+package env
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time. Timers are callback based, since the simulated
+// clock runs them on the goroutine advancing the time rather than waking up
+// other goroutines.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// AfterFunc calls the function after the duration elapses.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled by AfterFunc.
+type Timer interface {
+	// Stop cancels the call, and returns false if it has already been made.
+	Stop() bool
+}
+
+// RealClock is the system clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SimClock is a virtual clock that only moves when it is advanced. The timers
+// due are fired in the order of their deadlines, and of their creation if the
+// deadlines are equal, on the goroutine advancing the clock.
+type SimClock struct {
+	mut    sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers timerHeap
+}
+
+// NewSimClock returns a clock starting at the given time.
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return c.now
+}
+
+func (c *SimClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// AfterFunc schedules the call. It is never made immediately, even if the
+// duration is not positive, but on the next step of the clock.
+func (c *SimClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.seq++
+
+	t := &simTimer{
+		clock: c,
+		at:    c.now.Add(max(d, 0)),
+		seq:   c.seq,
+		f:     f,
+	}
+
+	heap.Push(&c.timers, t)
+
+	return t
+}
+
+// Pending returns the number of the scheduled calls.
+func (c *SimClock) Pending() int {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	return len(c.timers)
+}
+
+// Step moves the clock to the earliest deadline and fires the timer. It returns
+// false if there are no timers.
+func (c *SimClock) Step() bool {
+	return c.step(time.Time{})
+}
+
+// Advance fires the timers due within the duration, including the ones
+// scheduled by the fired timers, and moves the clock forward by the duration.
+func (c *SimClock) Advance(d time.Duration) {
+	c.mut.Lock()
+	until := c.now.Add(d)
+	c.mut.Unlock()
+
+	for c.step(until) {
+	}
+
+	c.mut.Lock()
+	if c.now.Before(until) {
+		c.now = until
+	}
+	c.mut.Unlock()
+}
+
+// step fires the earliest timer if it is due by the given time, or if the time
+// is zero.
+func (c *SimClock) step(until time.Time) bool {
+	c.mut.Lock()
+
+	if len(c.timers) == 0 || (!until.IsZero() && c.timers[0].at.After(until)) {
+		c.mut.Unlock()
+		return false
+	}
+
+	t := heap.Pop(&c.timers).(*simTimer) //nolint:forcetypeassert
+	if t.at.After(c.now) {
+		c.now = t.at
+	}
+
+	c.mut.Unlock()
+
+	t.f()
+
+	return true
+}
+
+type simTimer struct {
+	clock *SimClock
+	at    time.Time
+	seq   uint64
+	f     func()
+	index int
+}
+
+func (t *simTimer) Stop() bool {
+	c := t.clock
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+
+	heap.Remove(&c.timers, t.index)
+
+	return true
+}
+
+type timerHeap []*simTimer
+
+func (h timerHeap) Len() int { return len(h) }
+
+func (h timerHeap) Less(i, j int) bool {
+	if !h[i].at.Equal(h[j].at) {
+		return h[i].at.Before(h[j].at)
+	}
+
+	return h[i].seq < h[j].seq
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*simTimer) //nolint:forcetypeassert
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+
+	return t
+}
+
+// Ticker calls a function repeatedly, waiting for the interval computed before
+// every call. Unlike time.Ticker it is built on AfterFunc, so the interval may
+// change between the calls and the calls never overlap.
+type Ticker struct {
+	mut      sync.Mutex
+	clock    Clock
+	interval func() time.Duration
+	f        func()
+	timer    Timer
+	stopped  bool
+}
+
+// NewTicker schedules the first call of the function.
+func NewTicker(clock Clock, interval func() time.Duration, f func()) *Ticker {
+	t := &Ticker{
+		clock:    clock,
+		interval: interval,
+		f:        f,
+	}
+
+	t.mut.Lock()
+	t.timer = clock.AfterFunc(interval(), t.tick)
+	t.mut.Unlock()
+
+	return t
+}
+
+func (t *Ticker) tick() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.stopped {
+		return
+	}
+
+	t.f()
+
+	t.timer = t.clock.AfterFunc(t.interval(), t.tick)
+}
+
+// Stop cancels the next call. If the function is running, it waits for it to
+// return, so it must not be called from the function itself.
+func (t *Ticker) Stop() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.stopped = true
+	t.timer.Stop()
+}
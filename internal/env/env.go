@@ -0,0 +1,108 @@
+// Package env abstracts the sources of nondeterminism the cluster depends on:
+// the time, the random numbers and the concurrency. Production code uses the
+// real environment, while the simulator substitutes a virtual clock, a seeded
+// random generator and inline execution, so that a run can be replayed exactly.
+package env
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Env is the environment a node runs in.
+type Env struct {
+	Clock Clock
+	Rand  *Rand
+	// Go runs the function concurrently with the caller. The simulator runs it
+	// inline, so the function must not wait for the caller.
+	Go func(f func())
+}
+
+// Real returns the environment backed by the system clock, a randomly seeded
+// generator and goroutines.
+func Real() Env {
+	return Env{
+		Clock: RealClock{},
+		Rand:  NewRand(time.Now().UnixNano()),
+		Go:    func(f func()) { go f() },
+	}
+}
+
+// WithDefaults returns the environment with the missing parts taken from the
+// real environment.
+func (e Env) WithDefaults() Env {
+	real := Real()
+
+	if e.Clock == nil {
+		e.Clock = real.Clock
+	}
+
+	if e.Rand == nil {
+		e.Rand = real.Rand
+	}
+
+	if e.Go == nil {
+		e.Go = real.Go
+	}
+
+	return e
+}
+
+// Rand is a random generator safe for concurrent use.
+type Rand struct {
+	mut sync.Mutex
+	r   *rand.Rand
+}
+
+// NewRand returns a generator with the given seed.
+func NewRand(seed int64) *Rand {
+	return &Rand{
+		r: rand.New(rand.NewSource(seed)), //nolint:gosec
+	}
+}
+
+func (r *Rand) Intn(n int) int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.r.Intn(n)
+}
+
+func (r *Rand) Int63() int64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.r.Int63()
+}
+
+func (r *Rand) Int63n(n int64) int64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.r.Int63n(n)
+}
+
+func (r *Rand) Float64() float64 {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.r.Float64()
+}
+
+func (r *Rand) Perm(n int) []int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return r.r.Perm(n)
+}
+
+// Shuffle is generic.Shuffle with the generator as the source.
+func Shuffle[T any](r *Rand, arr []T) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.r.Shuffle(len(arr), func(i, j int) {
+		arr[i], arr[j] = arr[j], arr[i]
+	})
+}
@@ -0,0 +1,214 @@
+// Package frontend implements what the front-ends speaking the protocols of
+// other databases have in common: accepting and tracking the connections of
+// their clients, and reading and writing the keys through the replication API
+// of the local node on behalf of the clients.
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// Server accepts the connections of the clients of a front-end, and hands each
+// of them to the front-end in its own goroutine.
+type Server struct {
+	cluster   membership.Cluster
+	errClosed error
+	local     nodeapi.Client
+
+	mut      sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a server sending the commands of the clients to the local
+// node of the cluster. Serve returns errClosed once the server is shut down.
+func NewServer(cluster membership.Cluster, errClosed error) *Server {
+	return &Server{
+		cluster:   cluster,
+		errClosed: errClosed,
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// SetLocalConn sets the connection the commands are sent over, in place of the
+// connection of the cluster to the local node.
+func (s *Server) SetLocalConn(conn nodeapi.Client) {
+	s.local = conn
+}
+
+// LocalConn returns the connection the commands are sent over.
+func (s *Server) LocalConn() nodeapi.Client {
+	if s.local != nil {
+		return s.local
+	}
+
+	return s.cluster.LocalConn()
+}
+
+// Serve accepts the connections on the listener until the server is shut down.
+// The context passed to the handler carries the address of the client, for the
+// admission control to limit each client on its own, and is canceled once the
+// handler returns.
+func (s *Server) Serve(l net.Listener, handle func(ctx context.Context, conn net.Conn)) error {
+	s.mut.Lock()
+	if s.closed {
+		s.mut.Unlock()
+		return s.errClosed
+	}
+	s.listener = l
+	s.mut.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mut.Lock()
+			closed := s.closed
+			s.mut.Unlock()
+
+			if closed {
+				return s.errClosed
+			}
+
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			return s.errClosed
+		}
+
+		go func() {
+			defer s.untrack(conn)
+
+			ctx, cancel := context.WithCancel(admission.ForwardClient(context.Background(), conn.RemoteAddr().String()))
+			defer cancel()
+
+			handle(ctx, conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and closes the open ones, waiting for
+// the commands in progress to complete or for the context to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mut.Lock()
+	s.closed = true
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mut.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mut.Lock()
+	delete(s.conns, conn)
+	s.mut.Unlock()
+
+	conn.Close()
+	s.wg.Done()
+}
+
+// ReadKey returns the values and the version of the key. The values stored in
+// chunks are streamed, as they cannot be read with a single request.
+func ReadKey(ctx context.Context, conn nodeapi.Client, key string, opts nodeapi.KeyOpts) ([][]byte, string, error) {
+	res, err := conn.GetKey(ctx, key, opts)
+	if err == nil {
+		return res.Values, res.Version, nil
+	}
+
+	if grpcutil.ErrorCode(err) != codes.FailedPrecondition {
+		return nil, "", err
+	}
+
+	var (
+		values  [][]byte
+		version string
+	)
+
+	err = conn.GetKeyStream(ctx, key, opts, func(part nodeapi.KeyPart) error {
+		if part.Count > 0 {
+			values = make([][]byte, part.Count)
+			version = part.Version
+		}
+
+		if part.Index < len(values) {
+			values[part.Index] = append(values[part.Index], part.Data...)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return values, version, nil
+}
+
+// WriteKey writes the value of the key over the given version, and returns the
+// new version of the key. The values too large for a single request are
+// streamed.
+func WriteKey(ctx context.Context, conn nodeapi.Client, key string, value []byte, version string, opts nodeapi.KeyOpts) (string, error) {
+	var (
+		res *nodeapi.PutKeyResult
+		err error
+	)
+
+	if len(value) > chunk.Size {
+		res, err = conn.PutKeyStream(ctx, key, bytes.NewReader(value), version, opts)
+	} else {
+		res, err = conn.PutKey(ctx, key, value, version, opts)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return res.Version, nil
+}
@@ -0,0 +1,89 @@
+// Package frontendtest provides the fixtures of the tests of the front-ends:
+// running a server for the duration of a test, and connecting to it.
+package frontendtest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/sadath-12/keywave/auth"
+)
+
+// StaticAuth authenticates the tokens of the map as their identities.
+type StaticAuth map[string]*auth.Identity
+
+// Authenticate implements auth.Authenticator.
+func (a StaticAuth) Authenticate(token string) (*auth.Identity, error) {
+	if id, ok := a[token]; ok {
+		return id, nil
+	}
+
+	return nil, auth.ErrInvalidToken
+}
+
+// Server is the server of a front-end.
+type Server interface {
+	Serve(l net.Listener) error
+	Shutdown(ctx context.Context) error
+}
+
+// Serve runs the server until the test ends, and returns its address. Once shut
+// down, the server is expected to stop serving with errClosed.
+func Serve(t *testing.T, s Server, errClosed error) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	t.Cleanup(func() {
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+
+		if err := <-done; !errors.Is(err, errClosed) {
+			t.Errorf("serve: %v", err)
+		}
+	})
+
+	return l.Addr().String()
+}
+
+// Conn is a connection to a server, closed when the test ends.
+type Conn struct {
+	net.Conn
+
+	T *testing.T
+	R *bufio.Reader
+}
+
+// Dial connects to the server.
+func Dial(t *testing.T, addr string) *Conn {
+	t.Helper()
+
+	return DialFrom(t, addr, nil)
+}
+
+// DialFrom connects to the server from the local address, e.g. another loopback
+// address, for the server to tell the clients apart.
+func DialFrom(t *testing.T, addr string, local net.Addr) *Conn {
+	t.Helper()
+
+	dialer := net.Dialer{LocalAddr: local}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return &Conn{Conn: conn, T: t, R: bufio.NewReader(conn)}
+}
@@ -2,32 +2,56 @@ package lockmap
 
 import "sync"
 
+type entry struct {
+	mut  sync.Mutex
+	refs int
+}
+
+// Map is a set of mutexes identified by keys. The mutex of a key is kept only
+// while it is held or waited for.
 type Map[K comparable] struct {
 	mut   sync.Mutex
-	locks map[K]*sync.Mutex
+	locks map[K]*entry
 }
 
 func New[K comparable]() *Map[K] {
 	return &Map[K]{
-		locks: make(map[K]*sync.Mutex),
+		locks: make(map[K]*entry),
 	}
 }
 
+// Lock locks the key, waiting until it is unlocked if it is held.
 func (lm *Map[K]) Lock(key K) {
 	lm.mut.Lock()
-	defer lm.mut.Unlock()
 
-	if _, ok := lm.locks[key]; !ok {
-		lm.locks[key] = &sync.Mutex{}
+	e, ok := lm.locks[key]
+	if !ok {
+		e = &entry{}
+		lm.locks[key] = e
 	}
 
-	lm.locks[key].Lock()
+	e.refs++
+
+	// The map must not be locked while waiting for the key, otherwise the
+	// holder of the key would not be able to release it.
+	lm.mut.Unlock()
+
+	e.mut.Lock()
 }
 
+// Unlock unlocks the key. The mutex of the key is dropped only once no one
+// waits for it, as a waiter that took it before would not exclude the lockers
+// coming after the drop.
 func (lm *Map[K]) Unlock(key K) {
 	lm.mut.Lock()
-	defer lm.mut.Unlock()
 
-	lm.locks[key].Unlock()
-	delete(lm.locks, key)
+	e := lm.locks[key]
+
+	if e.refs--; e.refs == 0 {
+		delete(lm.locks, key)
+	}
+
+	lm.mut.Unlock()
+
+	e.mut.Unlock()
 }
@@ -0,0 +1,103 @@
+package lockmap
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waiters returns the number of goroutines holding or waiting for the key.
+func (lm *Map[K]) waiters(key K) int {
+	lm.mut.Lock()
+	defer lm.mut.Unlock()
+
+	if e, ok := lm.locks[key]; ok {
+		return e.refs
+	}
+
+	return 0
+}
+
+func TestUnlockWhileWaited(t *testing.T) {
+	lm := New[string]()
+	lm.Lock("key")
+
+	locked := make(chan struct{})
+
+	go func() {
+		lm.Lock("key")
+		close(locked)
+	}()
+
+	for lm.waiters("key") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The holder releases the key while another goroutine waits for it.
+	unlocked := make(chan struct{})
+
+	go func() {
+		lm.Unlock("key")
+		close(unlocked)
+	}()
+
+	for _, ch := range []chan struct{}{unlocked, locked} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlock")
+		}
+	}
+
+	// The waiter holds the key now, so it is not dropped.
+	if n := lm.waiters("key"); n != 1 {
+		t.Fatalf("expected the key to be held by the waiter, got %d waiters", n)
+	}
+
+	lm.Unlock("key")
+
+	if n := lm.waiters("key"); n != 0 {
+		t.Errorf("expected the key to be dropped, %d waiters left", n)
+	}
+}
+
+func TestMutualExclusion(t *testing.T) {
+	var (
+		lm     = New[string]()
+		inside [2]atomic.Int32
+		wg     sync.WaitGroup
+	)
+
+	for i := 0; i < 16; i++ {
+		key, n := "a", &inside[0]
+		if i%2 == 1 {
+			key, n = "b", &inside[1]
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 200; j++ {
+				lm.Lock(key)
+
+				if holders := n.Add(1); holders != 1 {
+					t.Errorf("%d goroutines hold %s", holders, key)
+				}
+
+				runtime.Gosched()
+				n.Add(-1)
+				lm.Unlock(key)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if len(lm.locks) != 0 {
+		t.Errorf("expected every key to be dropped, %d left", len(lm.locks))
+	}
+}
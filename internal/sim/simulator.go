@@ -0,0 +1,332 @@
+// Package sim runs a cluster deterministically under a seeded simulator. All
+// nodes share a virtual clock, take their random numbers from generators derived
+// from the seed, and call each other synchronously over an in-process
+// transport, so the whole cluster runs on the goroutine advancing the clock. A
+// failing run is replayed exactly by running it again with the same seed.
+//
+// The streaming calls and leaving the cluster gracefully are not simulated, as
+// both require the nodes to run concurrently.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+	membershippb "github.com/sadath-12/keywave/membership/proto"
+	membershipsvc "github.com/sadath-12/keywave/membership/service"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+	replicationpb "github.com/sadath-12/keywave/replication/proto"
+	replicationsvc "github.com/sadath-12/keywave/replication/service"
+	"github.com/sadath-12/keywave/storage"
+	"github.com/sadath-12/keywave/storage/inmemory"
+	storagepb "github.com/sadath-12/keywave/storage/proto"
+	storagesvc "github.com/sadath-12/keywave/storage/service"
+	"github.com/sadath-12/keywave/storage/stack"
+)
+
+// Epoch is the virtual time the simulation starts at.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// tick is the granularity of RunUntil.
+const tick = 10 * time.Millisecond
+
+// Options configure the simulator.
+type Options struct {
+	// Nodes is the number of nodes, with IDs from 1 to Nodes.
+	Nodes int
+	// Seed determines the whole run, including the message drops.
+	Seed int64
+	// Namespaces are the namespaces of the cluster. The default namespace of the
+	// replication service is used if not set.
+	Namespaces *namespace.Registry
+	// Configure adjusts the membership configuration of every node.
+	Configure func(conf *membership.Config)
+	// Logger receives the logs of all nodes, with the virtual time elapsed since
+	// the start of the run as the "t" field. They are discarded if not set.
+	Logger kitlog.Logger
+}
+
+// DefaultConfig returns the membership configuration used by the simulator. The
+// timeouts are the production ones, since the virtual time is free.
+func DefaultConfig() membership.Config {
+	return membership.DefaultConfig()
+}
+
+// Node is a single simulated node.
+type Node struct {
+	ID      membership.NodeID
+	Addr    string
+	Cluster *membership.SWIMCluster
+	Engine  storage.Engine
+}
+
+// Simulator is a simulated cluster. It is not safe for concurrent use: the
+// cluster only makes progress when the simulator is run.
+type Simulator struct {
+	opts    Options
+	clock   *env.SimClock
+	rand    *env.Rand
+	network *network
+	nodes   map[membership.NodeID]*Node
+}
+
+// New starts the nodes and joins them into a single cluster. The cluster still
+// needs to be run for the nodes to learn about each other.
+func New(opts Options) (*Simulator, error) {
+	if opts.Logger == nil {
+		opts.Logger = kitlog.NewNopLogger()
+	}
+
+	if opts.Namespaces == nil {
+		opts.Namespaces = namespace.NewRegistry(replicationsvc.DefaultNamespace())
+	}
+
+	rand := env.NewRand(opts.Seed)
+
+	s := &Simulator{
+		opts:    opts,
+		clock:   env.NewSimClock(Epoch),
+		rand:    rand,
+		network: newNetwork(env.NewRand(rand.Int63())),
+		nodes:   make(map[membership.NodeID]*Node, opts.Nodes),
+	}
+
+	s.opts.Logger = kitlog.With(opts.Logger, "t", kitlog.Valuer(func() interface{} {
+		return s.Elapsed()
+	}))
+
+	for i := 1; i <= opts.Nodes; i++ {
+		s.start(membership.NodeID(i))
+	}
+
+	for _, node := range s.Nodes()[1:] {
+		if err := node.Cluster.Join(context.Background(), s.nodes[1].Addr); err != nil {
+			return nil, fmt.Errorf("node %d failed to join: %w", node.ID, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Simulator) start(id membership.NodeID) {
+	var (
+		addr   = fmt.Sprintf("node-%d", id)
+		logger = kitlog.With(s.opts.Logger, "node_id", id)
+	)
+
+	conf := DefaultConfig()
+	conf.NodeID = id
+	conf.NodeName = addr
+	conf.PublicAddr = addr
+	conf.LocalAddr = addr
+	conf.Logger = logger
+	conf.Dialer = s.dialer(id)
+
+	if s.opts.Configure != nil {
+		s.opts.Configure(&conf)
+	}
+
+	// Every node gets its own generator, so that the random choices of a node do
+	// not depend on how many numbers the other nodes have drawn.
+	conf.Env = env.Env{
+		Clock: s.clock,
+		Rand:  env.NewRand(s.rand.Int63()),
+		Go:    func(f func()) { f() },
+	}
+
+	cluster := membership.NewSWIM(conf)
+	engine := stack.New(inmemory.New(), s.opts.Namespaces)
+
+	srv := newServer()
+	storagepb.RegisterStorageServiceServer(srv, storagesvc.New(engine, uint32(id)))
+	membershippb.RegisterMembershipServer(srv, membershipsvc.NewMembershipService(cluster))
+	replicationpb.RegisterReplicationServer(srv, replicationsvc.New(cluster, s.opts.Namespaces, logger))
+
+	s.network.setServer(id, srv)
+
+	cluster.Start()
+
+	s.nodes[id] = &Node{
+		ID:      id,
+		Addr:    addr,
+		Cluster: cluster,
+		Engine:  engine,
+	}
+}
+
+// dialer returns the dialer of the node, which connects to the other nodes over
+// the simulated network.
+func (s *Simulator) dialer(from membership.NodeID) nodeapi.Dialer {
+	return func(ctx context.Context, addr string) (nodeapi.Client, error) {
+		var to membership.NodeID
+		if _, err := fmt.Sscanf(addr, "node-%d", &to); err != nil {
+			return nil, fmt.Errorf("unknown address %s", addr)
+		}
+
+		if err := s.network.reachable(from, to); err != nil {
+			return nil, err
+		}
+
+		return nodeapigrpc.NewClient(&conn{network: s.network, from: from, to: to}), nil
+	}
+}
+
+// Elapsed returns the virtual time since the start of the run.
+func (s *Simulator) Elapsed() time.Duration {
+	return s.clock.Since(Epoch)
+}
+
+// Run advances the virtual time by the duration, running everything the nodes
+// have scheduled in the meantime.
+func (s *Simulator) Run(d time.Duration) {
+	s.clock.Advance(d)
+}
+
+// RunUntil runs the cluster until the condition holds, or returns the last error
+// of the condition once the timeout of virtual time expires.
+func (s *Simulator) RunUntil(timeout time.Duration, cond func() error) error {
+	deadline := s.Elapsed() + timeout
+
+	for {
+		err := cond()
+		if err == nil {
+			return nil
+		}
+
+		if s.Elapsed() >= deadline {
+			return fmt.Errorf("condition not met in %s: %w", timeout, err)
+		}
+
+		s.Run(tick)
+	}
+}
+
+// Node returns the node with the ID.
+func (s *Simulator) Node(id membership.NodeID) (*Node, bool) {
+	node, ok := s.nodes[id]
+	return node, ok
+}
+
+// Nodes returns all nodes ordered by ID, including the crashed ones.
+func (s *Simulator) Nodes() []*Node {
+	nodes := make([]*Node, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return nodes
+}
+
+// Client returns the connection of the node to itself, which is not affected by
+// the network faults, to make the requests to the cluster.
+func (s *Simulator) Client(id membership.NodeID) (nodeapi.Client, error) {
+	node, ok := s.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("node %d does not exist", id)
+	}
+
+	return node.Cluster.Conn(id)
+}
+
+// Crash stops the node without notifying the other nodes. Its calls fail as if
+// it was unreachable.
+func (s *Simulator) Crash(id membership.NodeID) {
+	node, ok := s.nodes[id]
+	if !ok {
+		return
+	}
+
+	s.network.setServer(id, nil)
+	node.Cluster.Stop()
+}
+
+// Restart starts the crashed node again with empty storage and a new run ID, and
+// joins it to the cluster via the given node.
+func (s *Simulator) Restart(id, via membership.NodeID) error {
+	s.Crash(id)
+	s.start(id)
+
+	if err := s.nodes[id].Cluster.Join(context.Background(), s.nodes[via].Addr); err != nil {
+		return fmt.Errorf("node %d failed to rejoin: %w", id, err)
+	}
+
+	return nil
+}
+
+// Partition splits the nodes into groups that cannot reach each other.
+func (s *Simulator) Partition(groups ...[]membership.NodeID) {
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, a := range group {
+				for _, b := range other {
+					s.network.cut(a, b)
+				}
+			}
+		}
+	}
+}
+
+// Isolate cuts all links of the node.
+func (s *Simulator) Isolate(id membership.NodeID) {
+	for other := range s.nodes {
+		if other != id {
+			s.network.cut(id, other)
+		}
+	}
+}
+
+// Drop makes the link between the nodes lose messages with the given rate. The
+// losses are drawn from the seed as well.
+func (s *Simulator) Drop(a, b membership.NodeID, rate float64) {
+	s.network.drop(a, b, rate)
+}
+
+// Heal removes all the network faults.
+func (s *Simulator) Heal() {
+	s.network.heal()
+}
+
+// Status returns the status of the target as seen by the observer.
+func (s *Simulator) Status(observer, target membership.NodeID) (membership.Status, bool) {
+	node, ok := s.nodes[observer]
+	if !ok {
+		return 0, false
+	}
+
+	n, ok := node.Cluster.Node(target)
+
+	return n.Status, ok
+}
+
+// Converged returns an error unless every node sees all nodes as healthy.
+func (s *Simulator) Converged() error {
+	for _, observer := range s.Nodes() {
+		for _, target := range s.Nodes() {
+			status, ok := s.Status(observer.ID, target.ID)
+			if !ok || status != membership.StatusHealthy {
+				return fmt.Errorf("node %d sees node %d as %s", observer.ID, target.ID, status)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stop stops all nodes.
+func (s *Simulator) Stop() {
+	for _, node := range s.Nodes() {
+		s.Crash(node.ID)
+	}
+}
@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// scenario runs the cluster through a crash, a partition and a lossy link while
+// writing to it, and returns the logs of the run.
+func scenario(t *testing.T, seed int64) string {
+	t.Helper()
+
+	var trace bytes.Buffer
+
+	s, err := New(Options{
+		Nodes:  5,
+		Seed:   seed,
+		Logger: kitlog.NewLogfmtLogger(&trace),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Stop()
+
+	if err := s.RunUntil(time.Minute, s.Converged); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := s.Client(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(key string) {
+		opts := nodeapi.KeyOpts{}
+		if _, err := client.PutKey(context.Background(), key, []byte(key), "", opts); err != nil {
+			fmt.Fprintf(&trace, "put %s: %v\n", key, err)
+		}
+	}
+
+	put("a")
+
+	s.Crash(5)
+	s.Drop(1, 2, 0.5)
+	s.Run(20 * time.Second)
+	put("b")
+
+	s.Partition([]membership.NodeID{1, 2}, []membership.NodeID{3, 4})
+	s.Run(20 * time.Second)
+	put("c")
+
+	s.Heal()
+
+	if err := s.Restart(5, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RunUntil(time.Minute, s.Converged); err != nil {
+		t.Fatal(err)
+	}
+
+	return trace.String()
+}
+
+func TestDeterministic(t *testing.T) {
+	first := scenario(t, 42)
+	second := scenario(t, 42)
+
+	if first != second {
+		t.Fatalf("runs with the same seed differ:\n%s\n---\n%s", first, second)
+	}
+
+	if first == scenario(t, 43) {
+		t.Error("runs with different seeds are identical")
+	}
+}
@@ -0,0 +1,205 @@
+package sim
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+)
+
+type service struct {
+	desc *grpc.ServiceDesc
+	impl any
+}
+
+// server holds the gRPC services of a node. It implements grpc.ServiceRegistrar,
+// so the services are registered with the generated functions, the same way
+// they are registered with a real server.
+type server struct {
+	services map[string]service
+}
+
+func newServer() *server {
+	return &server{services: make(map[string]service)}
+}
+
+func (s *server) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	s.services[desc.ServiceName] = service{desc: desc, impl: impl}
+}
+
+// invoke calls the unary method of the registered service. The request is
+// copied, as it would be by the serialization, so that the handler cannot
+// modify the caller's message.
+func (s *server) invoke(ctx context.Context, method string, req, reply any) error {
+	name, methodName, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "malformed method name %q", method)
+	}
+
+	svc, ok := s.services[name]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "unknown service %s", name)
+	}
+
+	for _, m := range svc.desc.Methods {
+		if m.MethodName != methodName {
+			continue
+		}
+
+		dec := func(v any) error {
+			return copyMessage(v, req)
+		}
+
+		res, err := m.Handler(svc.impl, ctx, dec, nil)
+		if err != nil {
+			return status.Convert(err).Err()
+		}
+
+		return copyMessage(reply, res)
+	}
+
+	return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+}
+
+func copyMessage(dst, src any) error {
+	data, err := proto.Marshal(src.(proto.Message)) //nolint:forcetypeassert
+	if err != nil {
+		return status.Errorf(codes.Internal, "marshal: %v", err)
+	}
+
+	if err := proto.Unmarshal(data, dst.(proto.Message)); err != nil { //nolint:forcetypeassert
+		return status.Errorf(codes.Internal, "unmarshal: %v", err)
+	}
+
+	return nil
+}
+
+type link struct {
+	from, to membership.NodeID
+}
+
+// network delivers the calls between the nodes synchronously, on the goroutine
+// of the caller, so the whole cluster runs on the goroutine advancing the clock.
+type network struct {
+	mut     sync.Mutex
+	rand    *env.Rand
+	servers map[membership.NodeID]*server
+	blocked map[link]bool
+	drops   map[link]float64
+}
+
+func newNetwork(rand *env.Rand) *network {
+	return &network{
+		rand:    rand,
+		servers: make(map[membership.NodeID]*server),
+		blocked: make(map[link]bool),
+		drops:   make(map[link]float64),
+	}
+}
+
+// deliver returns the server of the target node, or the Unavailable error if
+// the node is down or the message is lost.
+func (n *network) deliver(from, to membership.NodeID) (*server, error) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	srv, ok := n.servers[to]
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "node %d is down", to)
+	}
+
+	l := link{from, to}
+
+	if n.blocked[l] {
+		return nil, status.Errorf(codes.Unavailable, "link %d->%d is down", from, to)
+	}
+
+	if rate := n.drops[l]; rate > 0 && n.rand.Float64() < rate {
+		return nil, status.Errorf(codes.Unavailable, "message %d->%d is lost", from, to)
+	}
+
+	return srv, nil
+}
+
+// reachable returns the Unavailable error if the node is down or the link to it
+// is cut, the same way dialing an unreachable node fails.
+func (n *network) reachable(from, to membership.NodeID) error {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	if _, ok := n.servers[to]; !ok {
+		return status.Errorf(codes.Unavailable, "node %d is down", to)
+	}
+
+	if n.blocked[link{from, to}] {
+		return status.Errorf(codes.Unavailable, "link %d->%d is down", from, to)
+	}
+
+	return nil
+}
+
+func (n *network) setServer(id membership.NodeID, srv *server) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	if srv == nil {
+		delete(n.servers, id)
+		return
+	}
+
+	n.servers[id] = srv
+}
+
+func (n *network) cut(a, b membership.NodeID) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	n.blocked[link{a, b}] = true
+	n.blocked[link{b, a}] = true
+}
+
+func (n *network) drop(a, b membership.NodeID, rate float64) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	n.drops[link{a, b}] = rate
+	n.drops[link{b, a}] = rate
+}
+
+func (n *network) heal() {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	n.blocked = make(map[link]bool)
+	n.drops = make(map[link]float64)
+}
+
+// conn is the client side of the calls from one node to another. It implements
+// grpc.ClientConnInterface, so the regular gRPC client of the node API is used
+// on top of it.
+type conn struct {
+	network  *network
+	from, to membership.NodeID
+}
+
+func (c *conn) Invoke(ctx context.Context, method string, args, reply any, _ ...grpc.CallOption) error {
+	srv, err := c.network.deliver(c.from, c.to)
+	if err != nil {
+		return err
+	}
+
+	return srv.invoke(ctx, method, args, reply)
+}
+
+// NewStream is not supported, the streams would need to run concurrently with
+// the rest of the cluster.
+func (c *conn) NewStream(_ context.Context, _ *grpc.StreamDesc, method string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "streaming method %s is not simulated", method)
+}
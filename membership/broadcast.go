@@ -0,0 +1,104 @@
+package membership
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// broadcastQueue holds the recent membership updates that are piggybacked on
+// the ping messages. Each update is retransmitted a limited number of times,
+// which grows logarithmically with the cluster size, so that it reaches every
+// node with high probability without flooding the network.
+type broadcastQueue struct {
+	mut     sync.Mutex
+	items   map[NodeID]*broadcast
+	mult    int
+	maxSize int
+	seq     uint64
+}
+
+type broadcast struct {
+	node      Node
+	transmits int
+	seq       uint64
+}
+
+func newBroadcastQueue(mult, maxSize int) *broadcastQueue {
+	return &broadcastQueue{
+		items:   make(map[NodeID]*broadcast),
+		mult:    mult,
+		maxSize: maxSize,
+	}
+}
+
+// Enqueue schedules the state of the node for dissemination. A pending update
+// about the same node is replaced, since it is superseded by the new one.
+func (q *broadcastQueue) Enqueue(node Node) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	q.seq++
+
+	q.items[node.ID] = &broadcast{
+		node: node,
+		seq:  q.seq,
+	}
+}
+
+// Take returns up to maxSize updates to piggyback on a message. The updates that
+// have been transmitted the least number of times go first, and the newest ones
+// among them. Updates that exhausted the retransmit budget are dropped.
+func (q *broadcastQueue) Take(numNodes int) []Node {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	items := make([]*broadcast, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].transmits == items[j].transmits {
+			return items[i].seq > items[j].seq
+		}
+
+		return items[i].transmits < items[j].transmits
+	})
+
+	var (
+		limit = q.retransmitLimit(numNodes)
+		res   = make([]Node, 0, min(len(items), q.maxSize))
+	)
+
+	for _, item := range items {
+		if len(res) == q.maxSize {
+			break
+		}
+
+		res = append(res, item.node)
+
+		if item.transmits++; item.transmits >= limit {
+			delete(q.items, item.node.ID)
+		}
+	}
+
+	return res
+}
+
+// Len returns the number of pending updates.
+func (q *broadcastQueue) Len() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return len(q.items)
+}
+
+// retransmitLimit returns mult * ceil(log10(n + 1)).
+func (q *broadcastQueue) retransmitLimit(numNodes int) int {
+	return q.mult * int(math.Ceil(math.Log10(float64(numNodes+1))))
+}
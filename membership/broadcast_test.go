@@ -0,0 +1,56 @@
+package membership
+
+import (
+	"slices"
+	"testing"
+)
+
+func ids(nodes []Node) []NodeID {
+	res := make([]NodeID, len(nodes))
+	for i, node := range nodes {
+		res[i] = node.ID
+	}
+
+	return res
+}
+
+func TestBroadcastQueue(t *testing.T) {
+	// Each update is sent twice in a cluster of nine nodes, at most two at a
+	// time.
+	q := newBroadcastQueue(2, 2)
+
+	if limit := q.retransmitLimit(9); limit != 2 {
+		t.Fatalf("expected a retransmit limit of 2, got %d", limit)
+	}
+
+	for id := NodeID(1); id <= 3; id++ {
+		q.Enqueue(Node{ID: id})
+	}
+
+	// The updates sent the fewest times go first, and the newest among them.
+	for i, want := range [][]NodeID{{3, 2}, {1, 3}, {2, 1}, {}} {
+		if got := ids(q.Take(9)); !slices.Equal(got, want) {
+			t.Fatalf("take %d: expected %v, got %v", i, want, got)
+		}
+	}
+
+	if n := q.Len(); n != 0 {
+		t.Fatalf("expected the updates to be dropped after the limit, %d left", n)
+	}
+
+	// A newer update of the node replaces the pending one, and is sent as many
+	// times.
+	q.Enqueue(Node{ID: 1, Gen: 1})
+	q.Take(9)
+	q.Enqueue(Node{ID: 1, Gen: 2})
+
+	for i := 0; i < 2; i++ {
+		if got := q.Take(9); len(got) != 1 || got[0].Gen != 2 {
+			t.Fatalf("take %d: expected the newer update, got %v", i, got)
+		}
+	}
+
+	if got := q.Take(9); got != nil {
+		t.Errorf("expected no update left, got %v", got)
+	}
+}
@@ -2,19 +2,27 @@ package membership
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/sadath-12/keywave/internal/env"
 	"github.com/sadath-12/keywave/internal/generic"
 	"github.com/sadath-12/keywave/nodeapi"
 )
 
 var (
 	_ Cluster = (*SWIMCluster)(nil)
+
+	// ErrNodeNotFound is returned when the node is not a member of the cluster.
+	ErrNodeNotFound = errors.New("node not found")
+	// ErrRemoveSelf is returned on an attempt to remove the local node.
+	ErrRemoveSelf = errors.New("cannot remove the local node")
 )
 
 type Cluster interface {
@@ -28,13 +36,24 @@ type Cluster interface {
 	LocalConn() nodeapi.Client
 
 	ApplyState(nodes []Node, sourceID NodeID) []Node
-	StateHash() uint64
+	Gossip(updates []Node, sourceID NodeID) []Node
+	StateDigest() []byte
+
+	Join(ctx context.Context, addr string) error
+	Leave(ctx context.Context) error
+	Remove(id NodeID) error
+	MarkReady()
+
+	Subscribe() (<-chan Event, func())
+
+	// Env returns the environment the node runs in.
+	Env() env.Env
 }
 
 type SWIMCluster struct {
 	mut           sync.RWMutex
 	selfID        NodeID
-	stateHash     uint64
+	stateDigest   []byte
 	nodes         map[NodeID]Node
 	connections   map[NodeID]nodeapi.Client
 	waiting       *generic.SyncMap[NodeID, chan struct{}]
@@ -48,7 +67,27 @@ type SWIMCluster struct {
 	indirectNodes int
 	gcInterval    time.Duration
 	stop          chan struct{}
-	wg            sync.WaitGroup
+	env           env.Env
+	detector      *env.Ticker
+	gc            *env.Ticker
+
+	// since holds the time when each node was first seen in its current status,
+	// to confirm suspicions and to reap the nodes after the timeouts.
+	since            map[NodeID]statusSince
+	suspicionTimeout time.Duration
+	localHealth      atomic.Int32
+	maxLocalHealth   int32
+
+	broadcasts       *broadcastQueue
+	pushPullInterval time.Duration
+	// lastPushPull is only accessed by the failure detector goroutine.
+	lastPushPull time.Time
+
+	events       *broker
+	tombstones   map[NodeID]tombstone
+	tombstoneTTL time.Duration
+	leftTimeout  time.Duration
+	deadTimeout  time.Duration
 }
 
 func withLock(l sync.Locker, f func()) {
@@ -58,24 +97,30 @@ func withLock(l sync.Locker, f func()) {
 }
 
 func NewSWIM(conf Config) *SWIMCluster {
+	environ := conf.Env.WithDefaults()
+
 	localNode := Node{
 		ID:         conf.NodeID,
 		Name:       conf.NodeName,
 		PublicAddr: conf.PublicAddr,
 		LocalAddr:  conf.LocalAddr,
+		Tags:       conf.NodeTags,
 		Status:     StatusHealthy,
-		RunID:      time.Now().Unix(),
+		RunID:      environ.Clock.Now().Unix(),
 		Gen:        1,
 	}
 
+	if conf.Bootstrap {
+		localNode.Status = StatusJoining
+	}
+
 	logger := kitlog.With(conf.Logger, "package", "membership")
 	nodes := make(map[NodeID]Node, 1)
 	nodes[localNode.ID] = localNode
 
-	return &SWIMCluster{
+	cl := &SWIMCluster{
 		nodes:         nodes,
 		selfID:        localNode.ID,
-		stateHash:     localNode.Hash64(),
 		connections:   make(map[NodeID]nodeapi.Client),
 		waiting:       new(generic.SyncMap[NodeID, chan struct{}]),
 		lastSync:      make(map[NodeID]time.Time),
@@ -87,7 +132,25 @@ func NewSWIM(conf Config) *SWIMCluster {
 		gcInterval:    conf.GCInterval,
 		indirectNodes: conf.IndirectNodes,
 		stop:          make(chan struct{}),
+		env:           environ,
+
+		since:            make(map[NodeID]statusSince),
+		suspicionTimeout: conf.SuspicionTimeout,
+		maxLocalHealth:   int32(conf.MaxLocalHealth),
+
+		broadcasts:       newBroadcastQueue(conf.RetransmitMult, conf.MaxPiggyback),
+		pushPullInterval: conf.PushPullInterval,
+
+		events:       newBroker(),
+		tombstones:   make(map[NodeID]tombstone),
+		tombstoneTTL: conf.TombstoneTTL,
+		leftTimeout:  conf.LeftTimeout,
+		deadTimeout:  conf.DeadTimeout,
 	}
+
+	cl.stateChanged()
+
+	return cl
 }
 
 // Start schedules background tasks for managing the cluster state, such as
@@ -97,6 +160,22 @@ func (cl *SWIMCluster) Start() {
 	cl.startGC()
 }
 
+// stopTasks stops the background tasks, waiting for the running ones to finish.
+func (cl *SWIMCluster) stopTasks() {
+	if cl.detector != nil {
+		cl.detector.Stop()
+	}
+
+	if cl.gc != nil {
+		cl.gc.Stop()
+	}
+}
+
+// Env returns the environment the node runs in.
+func (cl *SWIMCluster) Env() env.Env {
+	return cl.env
+}
+
 // SelfID returns the ID of the current node.
 func (cl *SWIMCluster) SelfID() NodeID {
 	return cl.selfID
@@ -141,9 +220,11 @@ func (cl *SWIMCluster) Node(id NodeID) (Node, bool) {
 
 // Join adds the current node to the cluster with the given address.
 // All nodes from the remote cluster are added to the local cluster and vice versa.
+// Joining an address of an unreachable member performs the state exchange anyway,
+// which is how the node rejoins the cluster after a partition.
 func (cl *SWIMCluster) Join(ctx context.Context, addr string) error {
 	for _, node := range cl.Nodes() {
-		if node.PublicAddr == addr {
+		if node.PublicAddr == addr && node.IsReachable() {
 			return nil // already joined
 		}
 	}
@@ -168,8 +249,15 @@ func (cl *SWIMCluster) Join(ctx context.Context, addr string) error {
 }
 
 // Leave removes the current node from the cluster. The leave call blocks until
-// at least one other node acknowledges the leave request.
+// at least one other node acknowledges the leave request. Calling it after the
+// node has already left is a no-op.
 func (cl *SWIMCluster) Leave(ctx context.Context) error {
+	select {
+	case <-cl.stop:
+		return nil
+	default:
+	}
+
 	cl.setStatus(cl.selfID, StatusLeft, "")
 
 	if err := cl.waitForSync(ctx); err != nil {
@@ -177,7 +265,7 @@ func (cl *SWIMCluster) Leave(ctx context.Context) error {
 	}
 
 	close(cl.stop)
-	cl.wg.Wait()
+	cl.stopTasks()
 
 	withLock(&cl.mut, func() {
 		self := cl.nodes[cl.selfID]
@@ -185,8 +273,41 @@ func (cl *SWIMCluster) Leave(ctx context.Context) error {
 		nodes[cl.selfID] = self
 
 		cl.nodes = nodes
-		cl.stateHash = self.Hash64()
+		cl.stateChanged()
+
+		for id, conn := range cl.connections {
+			if err := conn.Close(); err != nil {
+				level.Warn(cl.logger).Log("msg", "failed to close connection", "node", id, "err", err)
+			}
 
+			delete(cl.connections, id)
+		}
+	})
+
+	return nil
+}
+
+// Subscribe returns a channel delivering the changes of the cluster topology,
+// in the order they are observed by the local node, and a function to cancel
+// the subscription. The channel is closed once the subscription is canceled.
+func (cl *SWIMCluster) Subscribe() (<-chan Event, func()) {
+	return cl.events.subscribe()
+}
+
+// Stop terminates the background tasks and closes the connections without
+// notifying the other nodes, as if the node has crashed. The cluster must not
+// be used after it is stopped.
+func (cl *SWIMCluster) Stop() {
+	select {
+	case <-cl.stop:
+		return
+	default:
+	}
+
+	close(cl.stop)
+	cl.stopTasks()
+
+	withLock(&cl.mut, func() {
 		for id, conn := range cl.connections {
 			if err := conn.Close(); err != nil {
 				level.Warn(cl.logger).Log("msg", "failed to close connection", "node", id, "err", err)
@@ -195,23 +316,52 @@ func (cl *SWIMCluster) Leave(ctx context.Context) error {
 			delete(cl.connections, id)
 		}
 	})
+}
+
+// Remove forcibly removes the node that is not going to return to the cluster.
+// The node is marked as left, so that the removal is disseminated to the other
+// nodes, and is reaped along with the nodes that left the cluster gracefully.
+func (cl *SWIMCluster) Remove(id NodeID) error {
+	if id == cl.selfID {
+		return ErrRemoveSelf
+	}
+
+	if _, ok := cl.Node(id); !ok {
+		return ErrNodeNotFound
+	}
+
+	cl.setStatus(id, StatusLeft, "removed from the cluster")
 
 	return nil
 }
 
+// MarkReady moves the current node from the joining to the healthy state, after
+// which it starts participating in quorums. It does nothing if the node is not
+// joining.
+func (cl *SWIMCluster) MarkReady() {
+	if cl.Self().Status != StatusJoining {
+		return
+	}
+
+	cl.setStatus(cl.selfID, StatusHealthy, "")
+}
 
 // waitForSync blocks until at least one other node acknowledges the state update.
 func (cl *SWIMCluster) waitForSync(ctx context.Context) error {
 	var (
-		start = time.Now()
+		start = cl.env.Clock.Now()
 		done  bool
 	)
 
 	for {
+		wait := make(chan struct{})
+		timer := cl.env.Clock.AfterFunc(500*time.Millisecond, func() { close(wait) })
+
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return ctx.Err()
-		case <-time.After(500 * time.Millisecond):
+		case <-wait:
 		}
 
 		withLock(cl.mut.RLocker(), func() {
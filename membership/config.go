@@ -5,12 +5,14 @@ import (
 
 	kitlog "github.com/go-kit/log"
 
+	"github.com/sadath-12/keywave/internal/env"
 	"github.com/sadath-12/keywave/nodeapi"
 )
 
 type Config struct {
 	NodeID        NodeID
 	NodeName      string
+	NodeTags      map[string]string
 	PublicAddr    string
 	LocalAddr     string
 	Dialer        nodeapi.Dialer
@@ -21,6 +23,36 @@ type Config struct {
 	ProbeJitter   float64
 	GCInterval    time.Duration
 	IndirectNodes int
+	// SuspicionTimeout is how long a node stays suspected before it is declared
+	// unhealthy, unless it refutes the suspicion in the meantime.
+	SuspicionTimeout time.Duration
+	// MaxLocalHealth is the upper bound of the local health multiplier. The probe
+	// interval, the probe timeout and the suspicion timeout are multiplied by the
+	// score plus one, so that a slow node does not falsely accuse healthy ones.
+	MaxLocalHealth int
+	// RetransmitMult scales the number of times each membership update is
+	// piggybacked on pings, which is RetransmitMult * ceil(log10(N + 1)).
+	RetransmitMult int
+	// MaxPiggyback is the maximum number of updates carried by a single message.
+	MaxPiggyback int
+	// PushPullInterval is the minimum interval between full state exchanges,
+	// which are only performed when the state digests of the nodes differ.
+	PushPullInterval time.Duration
+	// LeftTimeout is how long the nodes that left the cluster stay in the state,
+	// so that the news spreads, before they are removed.
+	LeftTimeout time.Duration
+	// DeadTimeout is how long a node can stay unhealthy before it is declared
+	// dead and removed from the state. Zero disables the removal.
+	DeadTimeout time.Duration
+	// TombstoneTTL is how long the removed nodes are remembered, so that they are
+	// not added back by the stale state gossiped by other nodes.
+	TombstoneTTL time.Duration
+	// Env provides the clock, the random numbers and the concurrency to the
+	// background tasks. The missing parts default to the real environment.
+	Env env.Env
+	// Bootstrap makes the node start in the joining state. It is expected to call
+	// MarkReady once it has received its share of data from the other nodes.
+	Bootstrap bool
 }
 
 func DefaultConfig() Config {
@@ -31,5 +63,14 @@ func DefaultConfig() Config {
 		ProbeInterval: 1 * time.Second,
 		GCInterval:    30 * time.Second,
 		IndirectNodes: 1,
+
+		SuspicionTimeout: 5 * time.Second,
+		MaxLocalHealth:   8,
+		RetransmitMult:   4,
+		MaxPiggyback:     16,
+		PushPullInterval: 30 * time.Second,
+		LeftTimeout:      time.Minute,
+		DeadTimeout:      30 * time.Minute,
+		TombstoneTTL:     time.Hour,
 	}
 }
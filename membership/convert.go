@@ -12,6 +12,10 @@ func fromAPINodeInfo(nodeinfo *nodeapi.NodeInfo) Node {
 		status = StatusUnhealthy
 	case nodeapi.NodeStatusLeft:
 		status = StatusLeft
+	case nodeapi.NodeStatusJoining:
+		status = StatusJoining
+	case nodeapi.NodeStatusSuspect:
+		status = StatusSuspect
 	}
 	return Node{
 		ID:         NodeID(nodeinfo.ID),
@@ -21,6 +25,9 @@ func fromAPINodeInfo(nodeinfo *nodeapi.NodeInfo) Node {
 		Status:     status,
 		Error:      nodeinfo.Error,
 		RunID:      nodeinfo.RunID,
+
+		Incarnation: nodeinfo.Incarnation,
+		Tags:        nodeinfo.Tags,
 	}
 }
 
@@ -43,6 +50,10 @@ func toAPINodeInfo(node *Node) nodeapi.NodeInfo {
 		status = nodeapi.NodeStatusUnhealthy
 	case StatusLeft:
 		status = nodeapi.NodeStatusLeft
+	case StatusJoining:
+		status = nodeapi.NodeStatusJoining
+	case StatusSuspect:
+		status = nodeapi.NodeStatusSuspect
 	}
 
 	return nodeapi.NodeInfo{
@@ -53,6 +64,9 @@ func toAPINodeInfo(node *Node) nodeapi.NodeInfo {
 		Status: status,
 		Error:  node.Error,
 		RunID:  node.RunID,
+
+		Incarnation: node.Incarnation,
+		Tags:        node.Tags,
 	}
 }
 
@@ -64,3 +78,9 @@ func toAPINodesInfo(nodes []Node) []nodeapi.NodeInfo {
 
 	return nodesInfo
 }
+
+// FromAPINodes converts the nodes received from a remote node, e.g. by a tool
+// running outside of the cluster.
+func FromAPINodes(nodesInfo []nodeapi.NodeInfo) []Node {
+	return fromAPINodesInfo(nodesInfo)
+}
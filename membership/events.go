@@ -0,0 +1,204 @@
+package membership
+
+import (
+	"maps"
+	"sync"
+)
+
+// EventType is the kind of change in the cluster topology.
+type EventType uint8
+
+const (
+	// EventJoined is emitted when a node is seen for the first time, or when it
+	// comes back after a restart.
+	EventJoined EventType = iota + 1
+	// EventSuspect is emitted when a node becomes suspected.
+	EventSuspect
+	// EventUnhealthy is emitted when a node is declared unhealthy.
+	EventUnhealthy
+	// EventRecovered is emitted when a suspected or unhealthy node becomes healthy.
+	EventRecovered
+	// EventLeft is emitted when a node leaves the cluster, is removed from it or
+	// is declared dead.
+	EventLeft
+	// EventUpdated is emitted on any other change of the node, e.g. when a joining
+	// node becomes ready.
+	EventUpdated
+	// EventResync replaces the events a subscriber has fallen too far behind to
+	// receive. It carries no node: the subscriber is expected to read the whole
+	// state again with Nodes.
+	EventResync
+)
+
+// subscriberQueueSize is the number of events queued for a subscriber before
+// they are replaced with EventResync.
+const subscriberQueueSize = 256
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoined:
+		return "joined"
+	case EventSuspect:
+		return "suspect"
+	case EventUnhealthy:
+		return "unhealthy"
+	case EventRecovered:
+		return "recovered"
+	case EventLeft:
+		return "left"
+	case EventUpdated:
+		return "updated"
+	case EventResync:
+		return "resync"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a change of a single node, as observed by the local node.
+type Event struct {
+	Type EventType
+	Node Node
+}
+
+// newEvent returns the event describing the transition of the node from the
+// previous state, which is nil for a node that was not known before.
+func newEvent(prev *Node, curr Node) (Event, bool) {
+	if prev == nil || prev.RunID != curr.RunID {
+		if curr.Status == StatusLeft {
+			return Event{}, false
+		}
+
+		return Event{Type: EventJoined, Node: curr}, true
+	}
+
+	if prev.Status != curr.Status {
+		switch curr.Status {
+		case StatusSuspect:
+			return Event{Type: EventSuspect, Node: curr}, true
+		case StatusUnhealthy:
+			return Event{Type: EventUnhealthy, Node: curr}, true
+		case StatusLeft:
+			return Event{Type: EventLeft, Node: curr}, true
+		case StatusHealthy:
+			if prev.Status == StatusSuspect || prev.Status == StatusUnhealthy {
+				return Event{Type: EventRecovered, Node: curr}, true
+			}
+		}
+
+		return Event{Type: EventUpdated, Node: curr}, true
+	}
+
+	// The generation and the incarnation change along with the status, or when
+	// a suspicion is refuted, neither of which is interesting on its own.
+	if prev.Name != curr.Name || prev.PublicAddr != curr.PublicAddr ||
+		prev.Error != curr.Error || !maps.Equal(prev.Tags, curr.Tags) {
+		return Event{Type: EventUpdated, Node: curr}, true
+	}
+
+	return Event{}, false
+}
+
+// broker delivers the events to the subscribers. Each subscriber has its own
+// queue, so that publishing never blocks the cluster state updates. The queue
+// of a subscriber that does not keep up is bounded: once it is full, the
+// events queued are dropped in favour of a single EventResync.
+type broker struct {
+	mut         sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	mut    sync.Mutex
+	queue  []Event
+	notify chan struct{}
+	out    chan Event
+	done   chan struct{}
+}
+
+func newBroker() *broker {
+	return &broker{
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *broker) subscribe() (<-chan Event, func()) {
+	s := &subscriber{
+		notify: make(chan struct{}, 1),
+		out:    make(chan Event),
+		done:   make(chan struct{}),
+	}
+
+	withLock(&b.mut, func() {
+		b.subscribers[s] = struct{}{}
+	})
+
+	go s.run()
+
+	var once sync.Once
+
+	cancel := func() {
+		once.Do(func() {
+			withLock(&b.mut, func() {
+				delete(b.subscribers, s)
+			})
+
+			close(s.done)
+		})
+	}
+
+	return s.out, cancel
+}
+
+func (b *broker) publish(events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	for s := range b.subscribers {
+		withLock(&s.mut, func() {
+			if len(s.queue)+len(events) > subscriberQueueSize {
+				s.queue = append(s.queue[:0], Event{Type: EventResync})
+				return
+			}
+
+			s.queue = append(s.queue, events...)
+		})
+
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run forwards the queued events to the output channel until the subscription
+// is canceled, after which the output channel is closed.
+func (s *subscriber) run() {
+	defer close(s.out)
+
+	for {
+		var queue []Event
+
+		withLock(&s.mut, func() {
+			queue, s.queue = s.queue, nil
+		})
+
+		for _, ev := range queue {
+			select {
+			case s.out <- ev:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+	}
+}
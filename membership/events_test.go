@@ -0,0 +1,84 @@
+package membership
+
+import (
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestBrokerDeliversInOrder(t *testing.T) {
+	b := newBroker()
+
+	events, cancel := b.subscribe()
+	defer cancel()
+
+	for i := 1; i <= subscriberQueueSize; i++ {
+		b.publish(Event{Type: EventUpdated, Node: Node{ID: NodeID(i)}})
+	}
+
+	for i := 1; i <= subscriberQueueSize; i++ {
+		if ev := receive(t, events); ev.Type != EventUpdated || ev.Node.ID != NodeID(i) {
+			t.Fatalf("expected the update of node %d, got %s of node %d", i, ev.Type, ev.Node.ID)
+		}
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := newBroker()
+
+	events, cancel := b.subscribe()
+	defer cancel()
+
+	// The subscriber does not read the events until far more of them than its
+	// queue holds have been published.
+	for i := 1; i <= 4*subscriberQueueSize; i++ {
+		b.publish(Event{Type: EventUpdated, Node: Node{ID: NodeID(i)}})
+	}
+
+	withLock(&b.mut, func() {
+		for s := range b.subscribers {
+			withLock(&s.mut, func() {
+				if n := len(s.queue); n > subscriberQueueSize {
+					t.Errorf("expected at most %d queued events, got %d", subscriberQueueSize, n)
+				}
+			})
+		}
+	})
+
+	// At most the event already taken from the queue is delivered before the
+	// resync, which replaces the events dropped.
+	ev := receive(t, events)
+	if ev.Type == EventUpdated {
+		ev = receive(t, events)
+	}
+
+	if ev.Type != EventResync {
+		t.Fatalf("expected a resync, got %s of node %d", ev.Type, ev.Node.ID)
+	}
+
+	// The resync is followed by the events published after the last overflow,
+	// and the subscriber receives the new events once it has caught up.
+	b.publish(Event{Type: EventLeft, Node: Node{ID: 1}})
+
+	for n := 0; ; n++ {
+		ev := receive(t, events)
+		if ev.Type == EventLeft {
+			break
+		}
+
+		if ev.Type != EventUpdated || n >= subscriberQueueSize {
+			t.Fatalf("unexpected %s of node %d after the resync", ev.Type, ev.Node.ID)
+		}
+	}
+}
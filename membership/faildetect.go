@@ -1,16 +1,16 @@
 package membership
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/go-kit/log/level"
-	"github.com/sadath-12/keywave/internal/generic"
+	"github.com/sadath-12/keywave/internal/env"
 	"github.com/sadath-12/keywave/nodeapi"
-	"golang.org/x/sync/errgroup"
 )
 
 type probeResult struct {
@@ -20,33 +20,25 @@ type probeResult struct {
 }
 
 func (cl *SWIMCluster) startDetector() {
-	cl.wg.Add(1)
+	// The interval is scaled by the local health, so it is computed again before
+	// every round.
+	cl.detector = env.NewTicker(cl.env.Clock, func() time.Duration {
+		interval := cl.scaled(cl.probeInterval)
 
-	go func() {
-		defer cl.wg.Done()
-
-		ticker := time.NewTicker(cl.probeInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				if cl.probeJitter > 0 {
-					jitter := rand.Int63n(int64(cl.probeJitter))
-					time.Sleep(time.Duration(jitter))
-				}
-
-				cl.detectFailures()
-			case <-cl.stop:
-				return
-			}
+		if cl.probeJitter > 0 {
+			interval += time.Duration(cl.env.Rand.Int63n(int64(cl.probeJitter)))
 		}
-	}()
+
+		return interval
+	}, func() {
+		cl.detectFailures()
+		cl.confirmSuspects()
+	})
 }
 
 func (cl *SWIMCluster) pickRandomNode() *Node {
 	nodes := cl.Nodes()
-	generic.Shuffle(nodes)
+	env.Shuffle(cl.env.Rand, nodes)
 
 	for _, node := range nodes {
 		if node.ID != cl.selfID && node.Status != StatusLeft {
@@ -59,7 +51,7 @@ func (cl *SWIMCluster) pickRandomNode() *Node {
 
 func (cl *SWIMCluster) pickIndirectNodes(node *Node) []*Node {
 	nodes := cl.Nodes()
-	generic.Shuffle(nodes)
+	env.Shuffle(cl.env.Rand, nodes)
 
 	res := make([]*Node, 0, cl.indirectNodes)
 
@@ -92,6 +84,8 @@ func (cl *SWIMCluster) setStatus(id NodeID, status Status, message string) {
 		"error", message,
 	)
 
+	prev := node
+
 	node.Status = status
 	node.Error = ""
 	node.Gen++
@@ -101,11 +95,73 @@ func (cl *SWIMCluster) setStatus(id NodeID, status Status, message string) {
 	}
 
 	cl.nodes[id] = node
+	cl.broadcasts.Enqueue(node)
+	cl.stateChanged()
+
+	if ev, ok := newEvent(&prev, node); ok {
+		cl.events.publish(ev)
+	}
+}
+
+// confirmSuspects declares unhealthy the nodes that have not refuted the
+// suspicion within the suspicion timeout.
+func (cl *SWIMCluster) confirmSuspects() {
+	timeout := cl.scaled(cl.suspicionTimeout)
+
+	cl.mut.RLock()
+
+	var expired []NodeID
+
+	for id, since := range cl.since {
+		if since.status == StatusSuspect && cl.env.Clock.Since(since.at) > timeout {
+			expired = append(expired, id)
+		}
+	}
+
+	cl.mut.RUnlock()
+
+	slices.Sort(expired)
+
+	for _, id := range expired {
+		cl.setStatusIf(id, StatusSuspect, StatusUnhealthy, "suspicion timeout")
+	}
+}
 
-	cl.stateHash = 0
-	for _, node := range cl.nodes {
-		cl.stateHash ^= node.Hash64()
+// setStatusIf updates the status of the node only if it has not changed since
+// it was last observed.
+func (cl *SWIMCluster) setStatusIf(id NodeID, expected, status Status, message string) {
+	if node, ok := cl.Node(id); !ok || node.Status != expected {
+		return
 	}
+
+	cl.setStatus(id, status, message)
+}
+
+// LocalHealth returns the local health score, as defined by Lifeguard. Zero
+// means the node is healthy, higher values mean the node has recently failed
+// to get answers to its probes or has been suspected by others.
+func (cl *SWIMCluster) LocalHealth() int {
+	return int(cl.localHealth.Load())
+}
+
+func (cl *SWIMCluster) adjustLocalHealth(delta int32) {
+	for {
+		curr := cl.localHealth.Load()
+		next := min(max(curr+delta, 0), cl.maxLocalHealth)
+
+		if next == curr || cl.localHealth.CompareAndSwap(curr, next) {
+			if next != curr {
+				level.Debug(cl.logger).Log("msg", "local health changed", "score", next)
+			}
+
+			return
+		}
+	}
+}
+
+// scaled multiplies the timeout by the local health score plus one.
+func (cl *SWIMCluster) scaled(d time.Duration) time.Duration {
+	return d * time.Duration(cl.localHealth.Load()+1)
 }
 
 func (cl *SWIMCluster) detectFailures() {
@@ -128,7 +184,34 @@ func (cl *SWIMCluster) detectFailures() {
 	if directRes, err = cl.directProbe(ctx, target); err != nil {
 		level.Error(cl.logger).Log("msg", "direct probe failed", "node_id", target.ID, "err", err)
 		return
-	} else if directRes.status == target.Status {
+	}
+
+	// A missed probe may as well be caused by the local node being overloaded,
+	// so the timeouts are extended until the probes succeed again.
+	if directRes.status == StatusHealthy {
+		cl.adjustLocalHealth(-1)
+	} else {
+		cl.adjustLocalHealth(1)
+	}
+
+	if directRes.status == target.Status {
+		return
+	}
+
+	// A failed probe only makes the node suspected. It is declared unhealthy once
+	// the suspicion times out, unless the node refutes it.
+	status := directRes.status
+	if status == StatusUnhealthy {
+		if target.Status == StatusSuspect || target.Status == StatusUnhealthy {
+			return
+		}
+
+		status = StatusSuspect
+	}
+
+	// A joining node responding to pings is alive, but only the node itself knows
+	// when it is ready to become healthy.
+	if target.Status == StatusJoining && directRes.status == StatusHealthy {
 		return
 	}
 
@@ -139,7 +222,7 @@ func (cl *SWIMCluster) detectFailures() {
 	nodes := cl.pickIndirectNodes(target)
 	if len(nodes) < cl.indirectNodes {
 		level.Warn(cl.logger).Log("msg", "not enough intermediary nodes")
-		cl.setStatus(target.ID, directRes.status, directRes.message)
+		cl.setStatus(target.ID, status, directRes.message)
 
 		return
 	}
@@ -150,8 +233,6 @@ func (cl *SWIMCluster) detectFailures() {
 	if indirectRes, err = cl.indirectProbe(ctx, target, nodes); err != nil {
 		level.Error(cl.logger).Log("msg", "indirect probe failed", "node_id", target.ID, "err", err)
 		return
-	} else if indirectRes.status == target.Status {
-		return
 	}
 
 	// Do nothing as long as the direct and indirect probe results differ.
@@ -166,39 +247,46 @@ func (cl *SWIMCluster) detectFailures() {
 		return
 	}
 
-	cl.setStatus(target.ID, directRes.status, directRes.message)
+	cl.setStatus(target.ID, status, directRes.message)
 }
 
 func (cl *SWIMCluster) directProbe(ctx context.Context, node *Node) (*probeResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, cl.probeTimeout)
+	ctx, cancel := context.WithTimeout(ctx, cl.scaled(cl.probeTimeout))
 	defer cancel()
 
-	start := time.Now()
+	start := cl.env.Clock.Now()
 
 	conn, err := cl.ConnContext(ctx, node.ID)
 	if err != nil {
 		return &probeResult{ //nolint:nilerr
-			duration: time.Since(start),
+			duration: cl.env.Clock.Since(start),
 			status:   StatusUnhealthy,
 			message:  err.Error(),
 		}, nil
 	}
 
-	// Lightweight ping message which also carries the state hash of the sender.
-	// It will be later used to determine if the full state exchange is required.
-	stateHash, err := conn.Ping(ctx)
+	// The ping carries the recent membership updates known to the local node, and
+	// the response carries the updates known to the target, so the changes spread
+	// through the cluster along with the failure detection.
+	updates := cl.broadcasts.Take(len(cl.Nodes()))
+
+	ack, err := conn.Ping(ctx, nodeapi.NodeID(cl.selfID), toAPINodesInfo(updates))
 	if err != nil {
 		return &probeResult{ //nolint:nilerr
-			duration: time.Since(start),
+			duration: cl.env.Clock.Since(start),
 			status:   StatusUnhealthy,
 			message:  err.Error(),
 		}, nil
 	}
 
-	// In case of a difference between the local and remote state hashes, the full
-	// state exchange is performed. This would merge the states of both nodes and
-	// make them consistent.
-	if stateHash != cl.StateHash() {
+	cl.Gossip(fromAPINodesInfo(ack.Updates), node.ID)
+
+	// Piggybacked updates may be lost when the retransmit budget is exhausted, e.g.
+	// during a partition. If the states still differ, fall back to the full state
+	// exchange, but not more often than the push-pull interval.
+	if !bytes.Equal(ack.Digest, cl.StateDigest()) && cl.env.Clock.Since(cl.lastPushPull) >= cl.pushPullInterval {
+		cl.lastPushPull = cl.env.Clock.Now()
+
 		level.Info(cl.logger).Log("msg", "performing state exchange", "node_id", node.ID)
 		nodesInfo, err := conn.PullPushState(ctx, toAPINodesInfo(cl.Nodes()))
 
@@ -214,46 +302,59 @@ func (cl *SWIMCluster) directProbe(ctx context.Context, node *Node) (*probeResul
 	}
 
 	return &probeResult{
-		duration: time.Since(start),
+		duration: cl.env.Clock.Since(start),
 		status:   StatusHealthy,
 	}, nil
 }
 
 func (cl *SWIMCluster) indirectProbe(ctx context.Context, target *Node, nodes []*Node) (*probeResult, error) {
-	ctx, cancel := context.WithTimeout(ctx, cl.probeTimeout*3)
+	timeout := cl.scaled(cl.probeTimeout)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout*3)
 	defer cancel()
 
-	errg := errgroup.Group{}
-	votesMut := sync.Mutex{}
+	var (
+		wg       sync.WaitGroup
+		votesMut sync.Mutex
+		firstErr error
+	)
 
 	votes := map[nodeapi.NodeStatus]int{
 		nodeapi.NodeStatusHealthy:   0,
 		nodeapi.NodeStatusUnhealthy: 0,
 	}
 
+	wg.Add(len(nodes))
+
 	for i := range nodes {
 		node := nodes[i]
 
-		errg.Go(func() error {
+		cl.env.Go(func() {
+			defer wg.Done()
+
 			conn, err := cl.ConnContext(ctx, node.ID)
-			if err != nil {
-				return err
-			}
-			res, err := conn.PingIndirect(ctx, nodeapi.NodeID(target.ID), cl.probeTimeout)
-			if err != nil {
-				return err
+			if err == nil {
+				var res nodeapi.PingResult
+				if res, err = conn.PingIndirect(ctx, nodeapi.NodeID(target.ID), timeout); err == nil {
+					withLock(&votesMut, func() { votes[res.Status]++ })
+					return
+				}
 			}
 
-			votesMut.Lock()
-			votes[res.Status]++
-			votesMut.Unlock()
-
-			return nil
+			withLock(&votesMut, func() {
+				if firstErr == nil {
+					firstErr = err
+				}
+			})
 		})
 	}
 
-	if err := errg.Wait(); err != nil {
-		return nil, err
+	// The probes are started with env.Go rather than an errgroup, so that the
+	// simulator can run them inline.
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
 	if votes[nodeapi.NodeStatusUnhealthy] == len(nodes) {
@@ -4,25 +4,17 @@ import (
 	"time"
 
 	"github.com/go-kit/log/level"
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/internal/generic"
 )
 
 func (cl *SWIMCluster) startGC() {
-	cl.wg.Add(1)
-
-	go func() {
-		defer cl.wg.Done()
-		ticker := time.NewTicker(cl.gcInterval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				cl.collectConnections()
-			case <-cl.stop:
-				return
-			}
-		}
-	}()
+	cl.gc = env.NewTicker(cl.env.Clock, func() time.Duration {
+		return cl.gcInterval
+	}, func() {
+		cl.reap()
+		cl.collectConnections()
+	})
 }
 
 func (cl *SWIMCluster) collectConnections() {
@@ -33,7 +25,7 @@ func (cl *SWIMCluster) collectConnections() {
 	for id, conn := range cl.connections {
 		node, ok := cl.nodes[id]
 
-		if !ok || node.Status != StatusLeft {
+		if !ok || node.Status == StatusLeft {
 			if err := conn.Close(); err != nil {
 				level.Warn(cl.logger).Log("msg", "failed to close connection", "node", id, "err", err)
 			}
@@ -45,3 +37,74 @@ func (cl *SWIMCluster) collectConnections() {
 		}
 	}
 }
+
+// tombstone is a record of a node removed from the cluster state. It prevents
+// the node from being added back by the nodes still gossiping its old state.
+type tombstone struct {
+	node      Node
+	removedAt time.Time
+}
+
+// supersededBy reports whether the state is newer than the removed node. A node
+// that has left can only come back after a restart, with a new run ID, while a
+// node declared dead can also come back by refuting with a higher incarnation.
+func (t tombstone) supersededBy(node Node) bool {
+	if node.RunID != t.node.RunID {
+		return node.RunID > t.node.RunID
+	}
+
+	return t.node.Status != StatusLeft && node.Incarnation > t.node.Incarnation
+}
+
+// reap removes the nodes that have left the cluster or have been unhealthy for
+// too long from the cluster state, and drops the expired tombstones.
+func (cl *SWIMCluster) reap() {
+	cl.mut.Lock()
+	defer cl.mut.Unlock()
+
+	var (
+		now     = cl.env.Clock.Now()
+		changed bool
+	)
+
+	// The nodes are visited in order, so that the removals are logged and
+	// published deterministically.
+	ids := generic.MapKeys(cl.nodes)
+	generic.SortSlice(ids, false)
+
+	for _, id := range ids {
+		node := cl.nodes[id]
+
+		if id == cl.selfID {
+			continue
+		}
+
+		since := now.Sub(cl.since[id].at)
+
+		switch {
+		case node.Status == StatusLeft && since >= cl.leftTimeout:
+			level.Info(cl.logger).Log("msg", "removing node that left the cluster", "node_id", id)
+		case node.Status == StatusUnhealthy && cl.deadTimeout > 0 && since >= cl.deadTimeout:
+			level.Warn(cl.logger).Log("msg", "removing dead node", "node_id", id, "unhealthy_for", since)
+			cl.events.publish(Event{Type: EventLeft, Node: node})
+		default:
+			continue
+		}
+
+		cl.tombstones[id] = tombstone{node: node, removedAt: now}
+		delete(cl.nodes, id)
+		delete(cl.lastSync, id)
+
+		changed = true
+	}
+
+	for id, tomb := range cl.tombstones {
+		if now.Sub(tomb.removedAt) >= cl.tombstoneTTL {
+			delete(cl.tombstones, id)
+		}
+	}
+
+	if changed {
+		cl.stateChanged()
+	}
+}
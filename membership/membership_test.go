@@ -0,0 +1,216 @@
+package membership_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+)
+
+func TestConverge(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 5})
+
+	h.Run(clustertest.ExpectConverged())
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 5, Seed: 1})
+
+	minority := []membership.NodeID{1, 2}
+	majority := []membership.NodeID{3, 4, 5}
+	failed := []membership.Status{membership.StatusSuspect, membership.StatusUnhealthy}
+
+	h.Run(
+		clustertest.Partition(minority, majority),
+		clustertest.ExpectStatus(majority, 1, failed...),
+		clustertest.ExpectStatus(majority, 2, failed...),
+		clustertest.ExpectStatus(minority, 3, failed...),
+		clustertest.Heal(),
+		clustertest.ExpectConverged(),
+	)
+}
+
+func TestCrash(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+
+	h.Run(
+		clustertest.Crash(3),
+		clustertest.ExpectStatus([]membership.NodeID{1, 2}, 3, membership.StatusUnhealthy),
+	)
+}
+
+func TestSimulatedClock(t *testing.T) {
+	clock := env.NewSimClock(time.Unix(1_700_000_000, 0))
+	h := clustertest.New(t, clustertest.Options{Nodes: 3, Env: env.Env{Clock: clock}})
+
+	// The failure is only detected as the harness advances the clock while it
+	// waits, so the suspicion timeout elapses on the simulated clock.
+	start := clock.Now()
+
+	h.Run(
+		clustertest.Crash(3),
+		clustertest.ExpectStatus([]membership.NodeID{1, 2}, 3, membership.StatusUnhealthy),
+	)
+
+	if elapsed := clock.Since(start); elapsed < clustertest.DefaultConfig().SuspicionTimeout {
+		t.Errorf("expected the detection to take the suspicion timeout, took %s", elapsed)
+	}
+}
+
+func TestLossyLink(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3, Seed: 42})
+
+	// Indirect probes via the third node keep the nodes healthy despite the
+	// direct link losing most of the messages.
+	h.Run(
+		clustertest.Drop(1, 2, 0.8),
+		clustertest.Sleep(clustertest.DefaultConfig().SuspicionTimeout*3),
+		clustertest.Heal(),
+		clustertest.ExpectConverged(),
+	)
+}
+
+// simulated returns the harness of the nodes sharing a simulated clock, so that
+// the timeouts of the removals elapse without waiting for them.
+func simulated(t *testing.T, configure func(conf *membership.Config)) *clustertest.Harness {
+	return clustertest.New(t, clustertest.Options{
+		Nodes:     3,
+		Env:       env.Env{Clock: env.NewSimClock(time.Unix(1_700_000_000, 0))},
+		Configure: configure,
+	})
+}
+
+func TestTombstones(t *testing.T) {
+	h := simulated(t, func(conf *membership.Config) {
+		conf.DeadTimeout = 500 * time.Millisecond
+		conf.TombstoneTTL = 2 * time.Second
+	})
+
+	stale, _ := h.Node(1).Cluster.Node(3)
+
+	h.Run(
+		clustertest.Crash(3),
+		clustertest.ExpectRemoved([]membership.NodeID{1, 2}, 3),
+	)
+
+	// The old state of the dead node still gossiped by another node does not
+	// add it back.
+	h.Node(1).Cluster.Gossip([]membership.Node{stale}, 2)
+
+	if _, ok := h.Node(1).Cluster.Node(3); ok {
+		t.Fatal("expected the dead node not to be added back by the old state")
+	}
+
+	// Once the tombstone expires, the node is no longer remembered, and is
+	// added back as any new node.
+	h.Sleep(2*time.Second + clustertest.DefaultConfig().GCInterval)
+	h.Node(1).Cluster.Gossip([]membership.Node{stale}, 2)
+
+	if _, ok := h.Node(1).Cluster.Node(3); !ok {
+		t.Error("expected the node to be added back once the tombstone expired")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	h := simulated(t, func(conf *membership.Config) {
+		conf.LeftTimeout = time.Second
+	})
+
+	if err := h.Node(1).Cluster.Remove(1); !errors.Is(err, membership.ErrRemoveSelf) {
+		t.Errorf("expected ErrRemoveSelf, got %v", err)
+	}
+
+	if err := h.Node(1).Cluster.Remove(4); !errors.Is(err, membership.ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+
+	// The node is removed long before it would be declared dead, and the
+	// removal is disseminated to the other nodes.
+	h.Crash(3)
+
+	if err := h.Node(1).Cluster.Remove(3); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Run(
+		clustertest.ExpectStatus([]membership.NodeID{1, 2}, 3, membership.StatusLeft),
+		clustertest.ExpectRemoved([]membership.NodeID{1, 2}, 3),
+	)
+}
+
+func TestRefuteSuspicion(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+
+	self := h.Node(2).Cluster.Self()
+
+	suspected := self
+	suspected.Status = membership.StatusSuspect
+	suspected.Incarnation = self.Incarnation + 3
+
+	// Node 1 suspects node 2 first, which learns of the suspicion from node 3
+	// and refutes it with a higher incarnation.
+	h.Node(1).Cluster.Gossip([]membership.Node{suspected}, 3)
+	h.Node(2).Cluster.Gossip([]membership.Node{suspected}, 3)
+
+	refuted := h.Node(2).Cluster.Self()
+	if refuted.Status != membership.StatusHealthy || refuted.Incarnation != suspected.Incarnation+1 {
+		t.Fatalf("expected node 2 to refute with incarnation %d, got %s with %d",
+			suspected.Incarnation+1, refuted.Status, refuted.Incarnation)
+	}
+
+	// A suspicion of an earlier incarnation is outdated, and is not refuted again.
+	h.Node(2).Cluster.Gossip([]membership.Node{suspected}, 3)
+
+	if self := h.Node(2).Cluster.Self(); self.Incarnation != refuted.Incarnation {
+		t.Errorf("expected the outdated suspicion to be ignored, got incarnation %d", self.Incarnation)
+	}
+
+	// The refutation overrides the suspicion on the other nodes.
+	err := h.Eventually(5*time.Second, func() error {
+		node, _ := h.Node(1).Cluster.Node(2)
+		if node.Status != membership.StatusHealthy || node.Incarnation != refuted.Incarnation {
+			return fmt.Errorf("node 1 sees node 2 as %s with incarnation %d", node.Status, node.Incarnation)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateDigest(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+
+	digest := h.Node(1).Cluster.StateDigest()
+
+	for _, node := range h.Nodes() {
+		if !bytes.Equal(node.Cluster.StateDigest(), digest) {
+			t.Fatalf("expected node %d to have the digest of node 1", node.ID)
+		}
+	}
+
+	// The digest depends on the state only, whatever the order of the updates.
+	left := []membership.Node{
+		{ID: 4, RunID: 1, Status: membership.StatusLeft},
+		{ID: 5, RunID: 1, Status: membership.StatusLeft},
+	}
+
+	h.Node(1).Cluster.Gossip([]membership.Node{left[0]}, 0)
+
+	if bytes.Equal(h.Node(1).Cluster.StateDigest(), digest) {
+		t.Fatal("expected the digest to change with the state")
+	}
+
+	h.Node(1).Cluster.Gossip([]membership.Node{left[1]}, 0)
+	h.Node(2).Cluster.Gossip([]membership.Node{left[1], left[0]}, 0)
+
+	if !bytes.Equal(h.Node(1).Cluster.StateDigest(), h.Node(2).Cluster.StateDigest()) {
+		t.Error("expected the same state to have the same digest")
+	}
+}
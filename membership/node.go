@@ -1,7 +1,15 @@
 package membership
 
+import "maps"
+
 type NodeID uint32
 
+// Well-known node tags used by the replica placement.
+const (
+	TagZone = "zone"
+	TagRack = "rack"
+)
+
 // Node represents a single cluster member.
 type Node struct {
 	ID         NodeID
@@ -12,12 +20,35 @@ type Node struct {
 	Error      string
 	Status     Status
 	Gen        uint32
+	// Incarnation is only incremented by the node itself, to refute the suspicion
+	// of other nodes. The state with a higher incarnation always wins.
+	Incarnation uint32
+	// Tags are arbitrary key/value attributes of the node, such as the zone or the
+	// rack it is running in. They are set at startup and never change during a run.
+	Tags map[string]string
 }
-func (n *Node) IsReachable() bool {
-	return n.Status == StatusHealthy
+
+// Equal reports whether both nodes have the same state.
+func (n *Node) Equal(other Node) bool {
+	return n.ID == other.ID &&
+		n.RunID == other.RunID &&
+		n.Name == other.Name &&
+		n.PublicAddr == other.PublicAddr &&
+		n.LocalAddr == other.LocalAddr &&
+		n.Error == other.Error &&
+		n.Status == other.Status &&
+		n.Gen == other.Gen &&
+		n.Incarnation == other.Incarnation &&
+		maps.Equal(n.Tags, other.Tags)
 }
 
-// Hash64 returns a 64-bit hash of the node.
-func (n *Node) Hash64() uint64 {
-	return uint64(n.ID) ^ uint64(n.RunID) ^ uint64(n.Gen)
-}
\ No newline at end of file
+// Tag returns the value of the tag, or an empty string if the tag is not set.
+func (n *Node) Tag(key string) string {
+	return n.Tags[key]
+}
+
+// IsReachable returns true if the node is expected to respond to requests.
+// Suspected nodes are still considered reachable until the suspicion is confirmed.
+func (n *Node) IsReachable() bool {
+	return n.Status == StatusHealthy || n.Status == StatusSuspect
+}
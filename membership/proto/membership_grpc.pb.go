@@ -26,6 +26,7 @@ type MembershipClient interface {
 	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
 	PullPushState(ctx context.Context, in *PullPushStateRequest, opts ...grpc.CallOption) (*PullPushStateResponse, error)
 	PingIndirect(ctx context.Context, in *PingIndirectRequest, opts ...grpc.CallOption) (*PingIndirectResponse, error)
+	WatchNodes(ctx context.Context, in *WatchNodesRequest, opts ...grpc.CallOption) (Membership_WatchNodesClient, error)
 }
 
 type membershipClient struct {
@@ -72,6 +73,38 @@ func (c *membershipClient) PingIndirect(ctx context.Context, in *PingIndirectReq
 	return out, nil
 }
 
+func (c *membershipClient) WatchNodes(ctx context.Context, in *WatchNodesRequest, opts ...grpc.CallOption) (Membership_WatchNodesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Membership_ServiceDesc.Streams[0], "/membership.Membership/WatchNodes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &membershipWatchNodesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Membership_WatchNodesClient interface {
+	Recv() (*NodeEvent, error)
+	grpc.ClientStream
+}
+
+type membershipWatchNodesClient struct {
+	grpc.ClientStream
+}
+
+func (x *membershipWatchNodesClient) Recv() (*NodeEvent, error) {
+	m := new(NodeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // MembershipServer is the server API for Membership service.
 // All implementations must embed UnimplementedMembershipServer
 // for forward compatibility
@@ -80,6 +113,7 @@ type MembershipServer interface {
 	Ping(context.Context, *PingRequest) (*PingResponse, error)
 	PullPushState(context.Context, *PullPushStateRequest) (*PullPushStateResponse, error)
 	PingIndirect(context.Context, *PingIndirectRequest) (*PingIndirectResponse, error)
+	WatchNodes(*WatchNodesRequest, Membership_WatchNodesServer) error
 	mustEmbedUnimplementedMembershipServer()
 }
 
@@ -99,6 +133,9 @@ func (UnimplementedMembershipServer) PullPushState(context.Context, *PullPushSta
 func (UnimplementedMembershipServer) PingIndirect(context.Context, *PingIndirectRequest) (*PingIndirectResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PingIndirect not implemented")
 }
+func (UnimplementedMembershipServer) WatchNodes(*WatchNodesRequest, Membership_WatchNodesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNodes not implemented")
+}
 func (UnimplementedMembershipServer) mustEmbedUnimplementedMembershipServer() {}
 
 // UnsafeMembershipServer may be embedded to opt out of forward compatibility for this service.
@@ -184,6 +221,27 @@ func _Membership_PingIndirect_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Membership_WatchNodes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchNodesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MembershipServer).WatchNodes(m, &membershipWatchNodesServer{stream})
+}
+
+type Membership_WatchNodesServer interface {
+	Send(*NodeEvent) error
+	grpc.ServerStream
+}
+
+type membershipWatchNodesServer struct {
+	grpc.ServerStream
+}
+
+func (x *membershipWatchNodesServer) Send(m *NodeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Membership_ServiceDesc is the grpc.ServiceDesc for Membership service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -208,6 +266,12 @@ var Membership_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Membership_PingIndirect_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchNodes",
+			Handler:       _Membership_WatchNodes_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "membership.proto",
 }
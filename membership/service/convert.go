@@ -17,15 +17,22 @@ func fromProtoNode(node *proto.Node) membership.Node {
 		status = membership.StatusUnhealthy
 	case proto.Status_LEFT:
 		status = membership.StatusLeft
+	case proto.Status_JOINING:
+		status = membership.StatusJoining
+	case proto.Status_SUSPECT:
+		status = membership.StatusSuspect
 	}
 
 	return membership.Node{
-		ID:         membership.NodeID(node.Id),
-		Gen:        node.Generation,
-		PublicAddr: node.Address,
-		Status:     status,
-		Error:      node.Error,
-		RunID:      node.RunId,
+		ID:          membership.NodeID(node.Id),
+		Name:        node.Name,
+		Gen:         node.Generation,
+		PublicAddr:  node.Address,
+		Status:      status,
+		Error:       node.Error,
+		RunID:       node.RunId,
+		Incarnation: node.Incarnation,
+		Tags:        node.Tags,
 	}
 }
 
@@ -46,19 +53,25 @@ func toProtoStatus(status membership.Status) proto.Status {
 		return proto.Status_UNHEALTHY
 	case membership.StatusLeft:
 		return proto.Status_LEFT
+	case membership.StatusJoining:
+		return proto.Status_JOINING
+	case membership.StatusSuspect:
+		return proto.Status_SUSPECT
 	default:
 		panic(fmt.Sprintf("unknown status %v", status))
 	}
 }
 func toProtoNode(node *membership.Node) *proto.Node {
 	return &proto.Node{
-		Id:         uint32(node.ID),
-		Name:       node.Name,
-		Address:    node.PublicAddr,
-		RunId:      node.RunID,
-		Generation: node.Gen,
-		Status:     toProtoStatus(node.Status),
-		Error:      node.Error,
+		Id:          uint32(node.ID),
+		Name:        node.Name,
+		Address:     node.PublicAddr,
+		RunId:       node.RunID,
+		Generation:  node.Gen,
+		Status:      toProtoStatus(node.Status),
+		Error:       node.Error,
+		Incarnation: node.Incarnation,
+		Tags:        node.Tags,
 	}
 }
 
@@ -70,3 +83,22 @@ func toProtoNodes(nodes []membership.Node) []*proto.Node {
 
 	return res
 }
+
+func toProtoEventType(t membership.EventType) proto.EventType {
+	switch t {
+	case membership.EventJoined:
+		return proto.EventType_NODE_JOINED
+	case membership.EventSuspect:
+		return proto.EventType_NODE_SUSPECT
+	case membership.EventUnhealthy:
+		return proto.EventType_NODE_UNHEALTHY
+	case membership.EventRecovered:
+		return proto.EventType_NODE_RECOVERED
+	case membership.EventLeft:
+		return proto.EventType_NODE_LEFT
+	case membership.EventUpdated:
+		return proto.EventType_NODE_UPDATED
+	default:
+		panic(fmt.Sprintf("unknown event type %v", t))
+	}
+}
@@ -8,10 +8,15 @@ import (
 
 	"github.com/sadath-12/keywave/membership"
 	"github.com/sadath-12/keywave/membership/proto"
+	"github.com/sadath-12/keywave/nodeapi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// errWatchBehind ends the watch of a client that does not keep up with the
+// events, which is to watch again with a snapshot.
+var errWatchBehind = status.Error(codes.Aborted, "watch fell behind the events, watch again with a snapshot")
+
 type MembershipServer struct {
 	proto.UnimplementedMembershipServer
 	cluster membership.Cluster
@@ -42,8 +47,11 @@ func (s *MembershipServer) PullPushState(ctx context.Context, req *proto.PullPus
 }
 
 func (s *MembershipServer) Ping(ctx context.Context, req *proto.PingRequest) (*proto.PingResponse, error) {
+	updates := s.cluster.Gossip(fromProtoNodes(req.Updates), membership.NodeID(req.NodeId))
+
 	return &proto.PingResponse{
-		StateHash: s.cluster.StateHash(),
+		StateDigest: s.cluster.StateDigest(),
+		Updates:     toProtoNodes(updates),
 	}, nil
 }
 
@@ -51,7 +59,8 @@ func (s *MembershipServer) PingIndirect(ctx context.Context, req *proto.PingIndi
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Millisecond)
 	defer cancel()
 
-	start := time.Now()
+	clock := s.cluster.Env().Clock
+	start := clock.Now()
 	targetID := membership.NodeID(req.NodeId)
 
 	if _, ok := s.cluster.Node(targetID); !ok {
@@ -68,7 +77,7 @@ func (s *MembershipServer) PingIndirect(ctx context.Context, req *proto.PingIndi
 		}, nil
 	}
 
-	_, err = conn.Ping(ctx)
+	_, err = conn.Ping(ctx, nodeapi.NodeID(s.cluster.SelfID()), nil)
 	if err != nil {
 		return &proto.PingIndirectResponse{
 			Status:  proto.Status_UNHEALTHY,
@@ -77,8 +86,53 @@ func (s *MembershipServer) PingIndirect(ctx context.Context, req *proto.PingIndi
 	}
 
 	return &proto.PingIndirectResponse{
-		Duration: time.Since(start).Milliseconds(),
+		Duration: clock.Since(start).Milliseconds(),
 		Status:   proto.Status_HEALTHY,
 	}, nil
 
 }
+
+func (s *MembershipServer) WatchNodes(req *proto.WatchNodesRequest, stream proto.Membership_WatchNodesServer) error {
+	// Subscribe before taking the snapshot, so that no change is missed in between.
+	events, cancel := s.cluster.Subscribe()
+	defer cancel()
+
+	if req.Snapshot {
+		for _, node := range s.cluster.Nodes() {
+			node := node
+
+			err := stream.Send(&proto.NodeEvent{
+				Type: proto.EventType_NODE_JOINED,
+				Node: toProtoNode(&node),
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			if ev.Type == membership.EventResync {
+				return errWatchBehind
+			}
+
+			err := stream.Send(&proto.NodeEvent{
+				Type: toProtoEventType(ev.Type),
+				Node: toProtoNode(&ev.Node),
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
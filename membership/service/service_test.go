@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/membership/proto"
+	"github.com/sadath-12/keywave/nodeapi"
+	nodeapigrpc "github.com/sadath-12/keywave/nodeapi/grpc"
+)
+
+// eventsCluster delivers the events sent by the test to its subscriber.
+type eventsCluster struct {
+	membership.Cluster
+	nodes  []membership.Node
+	events chan membership.Event
+}
+
+func (c *eventsCluster) Nodes() []membership.Node {
+	return c.nodes
+}
+
+func (c *eventsCluster) Subscribe() (<-chan membership.Event, func()) {
+	return c.events, func() {}
+}
+
+func dial(t *testing.T, cluster membership.Cluster) nodeapi.Client {
+	t.Helper()
+
+	var (
+		listener = bufconn.Listen(1 << 20)
+		server   = grpc.NewServer()
+	)
+
+	proto.RegisterMembershipServer(server, NewMembershipService(cluster))
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := nodeapigrpc.DialWithOptions(ctx, "bufconn", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestWatchNodes(t *testing.T) {
+	cluster := &eventsCluster{
+		nodes:  []membership.Node{{ID: 1, Status: membership.StatusHealthy}},
+		events: make(chan membership.Event, 3),
+	}
+
+	cluster.events <- membership.Event{Type: membership.EventJoined, Node: membership.Node{ID: 2, Status: membership.StatusHealthy}}
+	cluster.events <- membership.Event{Type: membership.EventLeft, Node: membership.Node{ID: 2, Status: membership.StatusLeft}}
+	cluster.events <- membership.Event{Type: membership.EventResync}
+
+	var received []nodeapi.NodeEvent
+
+	err := dial(t, cluster).WatchNodes(context.Background(), true, func(ev nodeapi.NodeEvent) error {
+		received = append(received, ev)
+		return nil
+	})
+
+	// The snapshot comes first, then the changes, until the watch falls behind
+	// and is to be made again.
+	if code := grpcutil.ErrorCode(err); code != codes.Aborted {
+		t.Errorf("expected the resync to abort the watch, got %v", err)
+	}
+
+	want := []struct {
+		typ nodeapi.NodeEventType
+		id  nodeapi.NodeID
+	}{
+		{nodeapi.NodeEventJoined, 1},
+		{nodeapi.NodeEventJoined, 2},
+		{nodeapi.NodeEventLeft, 2},
+	}
+
+	if len(received) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), received)
+	}
+
+	for i, ev := range received {
+		if ev.Type != want[i].typ || ev.Node.ID != want[i].id {
+			t.Errorf("event %d: expected %d of node %d, got %d of node %d", i, want[i].typ, want[i].id, ev.Type, ev.Node.ID)
+		}
+	}
+}
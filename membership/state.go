@@ -1,15 +1,23 @@
 package membership
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"sort"
 	"time"
+
+	"github.com/go-kit/log/level"
 )
 
-func (cl *SWIMCluster) StateHash() uint64 {
+// StateDigest returns the SHA-256 digest of the cluster state. Nodes with the
+// same digest have the same view of the cluster, so there is no need for a full
+// state exchange between them.
+func (cl *SWIMCluster) StateDigest() []byte {
 	cl.mut.RLock()
 	defer cl.mut.RUnlock()
 
-	return cl.stateHash
+	return cl.stateDigest
 }
 
 // ApplyState merges the given nodes with the current cluster state and returns
@@ -18,66 +26,231 @@ func (cl *SWIMCluster) ApplyState(nodes []Node, sourceID NodeID) []Node {
 	cl.mut.Lock()
 	defer cl.mut.Unlock()
 
+	cl.applyLocked(nodes, sourceID)
+
+	nodes = make([]Node, 0, len(cl.nodes))
+	for _, node := range cl.nodes {
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return nodes
+}
+
+// Gossip merges the updates piggybacked on a message from the source node with
+// the current cluster state, and returns the local updates to piggyback on the
+// response. The updates that changed the local state are disseminated further.
+func (cl *SWIMCluster) Gossip(updates []Node, sourceID NodeID) []Node {
+	var (
+		dead  Node
+		found bool
+	)
+
+	withLock(&cl.mut, func() {
+		cl.applyLocked(updates, sourceID)
+
+		if tomb, ok := cl.tombstones[sourceID]; ok && tomb.node.Status != StatusLeft {
+			dead, found = tomb.node, true
+		}
+	})
+
+	res := cl.broadcasts.Take(len(cl.Nodes()))
+
+	// The source has been declared dead, yet it is alive. Tell it so, and it will
+	// refute with a higher incarnation, which overrides the tombstone.
+	if found {
+		res = append(res, dead)
+	}
+
+	return res
+}
+
+// applyLocked merges the nodes with the current state, and schedules the nodes
+// whose state has changed for dissemination. Must be called with the lock held.
+func (cl *SWIMCluster) applyLocked(nodes []Node, sourceID NodeID) {
+	var events []Event
+
 	for _, next := range nodes {
+		prev, existed := cl.nodes[next.ID]
+
+		cl.merge(next, sourceID)
+
 		curr, ok := cl.nodes[next.ID]
-		if !ok {
-			cl.nodes[next.ID] = next
+		if !ok || (existed && curr.Equal(prev)) {
 			continue
 		}
 
-		// Preserve the local address.
-		if len(curr.LocalAddr) > 0 {
-			next.LocalAddr = curr.LocalAddr
+		cl.broadcasts.Enqueue(curr)
+
+		var before *Node
+		if existed {
+			before = &prev
+		}
+
+		if ev, ok := newEvent(before, curr); ok {
+			events = append(events, ev)
+		}
+	}
+
+	cl.events.publish(events...)
+
+	if sourceID != 0 {
+		cl.lastSync[sourceID] = cl.env.Clock.Now()
+	}
+
+	cl.stateChanged()
+}
+
+// merge resolves the conflict between the local and the received state of the
+// node. Must be called with the lock held.
+func (cl *SWIMCluster) merge(next Node, sourceID NodeID) {
+	if tomb, ok := cl.tombstones[next.ID]; ok {
+		if !tomb.supersededBy(next) {
+			return
 		}
 
+		delete(cl.tombstones, next.ID)
+	}
+
+	curr, ok := cl.nodes[next.ID]
+	if !ok {
+		cl.nodes[next.ID] = next
+		return
+	}
+
+	// Preserve the local address.
+	if len(curr.LocalAddr) > 0 {
+		next.LocalAddr = curr.LocalAddr
+	}
+
+	if next.ID == cl.selfID {
 		// There is a newer node with the same ID as ours. But if we are not dead yet,
 		// meaning something is very broken, better to panic.
-		if next.ID == cl.selfID && next.RunID > curr.RunID {
+		if next.RunID > curr.RunID {
 			panic(fmt.Sprintf("node ID collision: %d", next.ID))
 		}
 
-		// In case node is restarted, it’s generation number is reset, but the run ID
-		// will be higher. In this case, the node must be replaced with the new one.
-		if next.RunID > curr.RunID {
-			cl.nodes[next.ID] = next
-			continue
+		cl.refute(curr, next, sourceID)
+
+		return
+	}
+
+	// In case node is restarted, it’s generation number is reset, but the run ID
+	// will be higher. In this case, the node must be replaced with the new one.
+	if next.RunID > curr.RunID {
+		cl.nodes[next.ID] = next
+		return
+	}
+
+	// Once node has left the cluster, all nodes must see it as left despite the
+	// generation. This is to prevent the node from being marked as unhealthy by the
+	// nodes that haven't been notified about the node leaving.
+	if next.Status == StatusLeft {
+		if curr.Gen > next.Gen {
+			next.Gen = curr.Gen
 		}
+		cl.nodes[next.ID] = next
+		return
+	}
 
-		// Once node has left the cluster, all nodes must see it as left despite the
-		// generation. This is to prevent the node from being marked as unhealthy by the
-		// nodes that haven't been notified about the node leaving.
-		if next.Status == StatusLeft {
-			if curr.Gen > next.Gen {
-				next.Gen = curr.Gen
-			}
+	// The incarnation is only incremented by the node itself when it refutes
+	// a suspicion, so the state with the higher incarnation is always newer.
+	if next.Incarnation != curr.Incarnation {
+		if next.Incarnation > curr.Incarnation {
 			cl.nodes[next.ID] = next
-			continue
 		}
 
-		if curr.Gen < next.Gen {
-			// Node with the higher generation is preferred.
+		return
+	}
+
+	if curr.Gen < next.Gen {
+		// Node with the higher generation is preferred.
+		cl.nodes[next.ID] = next
+	} else if curr.Gen == next.Gen {
+		// In case of conflict, the worst status is preferred.
+		if next.Status.WorseThan(curr.Status) {
 			cl.nodes[next.ID] = next
-		} else if curr.Gen == next.Gen {
-			// In case of conflict, the worst status is preferred.
-			if next.Status.WorseThan(curr.Status) {
-				cl.nodes[next.ID] = next
-			}
 		}
 	}
+}
 
-	if sourceID != 0 {
-		cl.lastSync[sourceID] = time.Now()
+// refute overrides the state of the local node received from another node, if
+// that node suspects the local node or considers it unhealthy. The incarnation
+// is bumped above the received one, so that the refutation wins everywhere.
+// Must be called with the lock held.
+func (cl *SWIMCluster) refute(self, received Node, sourceID NodeID) {
+	if received.Status != StatusSuspect && received.Status != StatusUnhealthy {
+		return
 	}
 
-	cl.stateHash = 0
-	for _, node := range cl.nodes {
-		cl.stateHash ^= node.Hash64()
+	if received.Incarnation < self.Incarnation || self.Status == StatusLeft {
+		return
 	}
 
-	nodes = make([]Node, 0, len(cl.nodes))
+	self.Incarnation = received.Incarnation + 1
+	self.Gen = max(self.Gen, received.Gen) + 1
+	cl.nodes[self.ID] = self
+
+	// Being suspected by others is a sign that the local node may be too slow to
+	// answer the probes in time.
+	cl.adjustLocalHealth(1)
+
+	level.Warn(cl.logger).Log(
+		"msg", "refuting suspicion",
+		"status", received.Status,
+		"incarnation", self.Incarnation,
+		"source_id", sourceID,
+	)
+}
+
+// statusSince is the time when the node was first seen in the status.
+type statusSince struct {
+	status Status
+	at     time.Time
+}
+
+// stateChanged recalculates the state digest and tracks the status changes.
+// Must be called with the lock held after any update of the nodes.
+func (cl *SWIMCluster) stateChanged() {
+	ids := make([]NodeID, 0, len(cl.nodes))
+
 	for _, node := range cl.nodes {
-		nodes = append(nodes, node)
+		ids = append(ids, node.ID)
+
+		if since, ok := cl.since[node.ID]; !ok || since.status != node.Status {
+			cl.since[node.ID] = statusSince{status: node.Status, at: cl.env.Clock.Now()}
+		}
 	}
 
-	return nodes
+	for id := range cl.since {
+		if _, ok := cl.nodes[id]; !ok {
+			delete(cl.since, id)
+		}
+	}
+
+	// The digest covers the fields that are resolved by the merge, in the order
+	// of node IDs, so that the same state always yields the same digest.
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var (
+		h   = sha256.New()
+		buf [21]byte
+	)
+
+	for _, id := range ids {
+		node := cl.nodes[id]
+
+		binary.BigEndian.PutUint32(buf[0:], uint32(node.ID))
+		binary.BigEndian.PutUint64(buf[4:], uint64(node.RunID))
+		binary.BigEndian.PutUint32(buf[12:], node.Gen)
+		binary.BigEndian.PutUint32(buf[16:], node.Incarnation)
+		buf[20] = byte(node.Status)
+
+		h.Write(buf[:])
+	}
+
+	cl.stateDigest = h.Sum(nil)
 }
@@ -9,6 +9,12 @@ const (
 	StatusUnhealthy
 	// StatusLeft is the status of a node that has left the cluster.
 	StatusLeft
+	// StatusJoining is the status of a node that has joined the cluster but is
+	// still receiving its data. Such nodes are not counted in quorums.
+	StatusJoining
+	// StatusSuspect is the status of a node that has failed a health check, but
+	// has not been declared unhealthy yet, giving it a chance to refute the suspicion.
+	StatusSuspect
 )
 
 // String returns the string representation of the status.
@@ -20,12 +26,35 @@ func (s Status) String() string {
 		return "unhealthy"
 	case StatusLeft:
 		return "left"
+	case StatusJoining:
+		return "joining"
+	case StatusSuspect:
+		return "suspect"
 	default:
 		return ""
 	}
 }
 
+// severity orders the statuses from the best to the worst. It does not follow
+// the numeric values, since the statuses are appended as they are introduced.
+func (s Status) severity() int {
+	switch s {
+	case StatusHealthy:
+		return 1
+	case StatusJoining:
+		return 2
+	case StatusSuspect:
+		return 3
+	case StatusUnhealthy:
+		return 4
+	case StatusLeft:
+		return 5
+	default:
+		return 0
+	}
+}
+
 // WorseThan returns true if the status is worse than the other status.
 func (s Status) WorseThan(other Status) bool {
-	return s > other
+	return s.severity() > other.severity()
 }
@@ -0,0 +1,422 @@
+package memcache
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/internal/frontend"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// relativeExpiryLimit is the largest expiration time taken as a number of
+// seconds from now. The larger ones are unix timestamps, as in memcached.
+const relativeExpiryLimit = 30 * 24 * 60 * 60
+
+// serverVersion is the reply to the version command.
+const serverVersion = "keywave"
+
+// client is the state of a connection.
+type client struct {
+	server *Server
+	ctx    context.Context
+	r      *reader
+	w      *writer
+	quit   bool
+
+	// authCtx carries the token of the client once it has authenticated.
+	authCtx context.Context
+}
+
+// casToken returns the CAS unique standing for the version of a key.
+func casToken(version string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(version))
+
+	return h.Sum64()
+}
+
+// expiry converts the expiration time of a command into the lifetime of the
+// value. Zero means no expiration, and a time in the past expires the value
+// immediately.
+func expiry(exptime int64, now time.Time) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= relativeExpiryLimit:
+		return time.Duration(exptime) * time.Second, false
+	}
+
+	ttl = time.Unix(exptime, 0).Sub(now)
+
+	return ttl, ttl <= 0
+}
+
+// handle runs the command. An error means the connection cannot be used
+// anymore, as the data following the command could not be read.
+func (c *client) handle(fields []string) error {
+	name := fields[0]
+
+	if c.server.authn != nil && c.authCtx == nil {
+		switch name {
+		case "set":
+			return c.authenticate(fields)
+		case "quit":
+			c.quit = true
+		default:
+			c.w.Line("CLIENT_ERROR unauthenticated")
+		}
+
+		return nil
+	}
+
+	switch name {
+	case "get":
+		c.get(fields[1:], false)
+	case "gets":
+		c.get(fields[1:], true)
+	case "set":
+		return c.store(fields, false)
+	case "cas":
+		return c.store(fields, true)
+	case "delete":
+		c.delete(fields)
+	case "touch":
+		c.touch(fields)
+	case "version":
+		c.w.Line("VERSION " + serverVersion)
+	case "quit":
+		c.quit = true
+	default:
+		c.w.Line("ERROR")
+	}
+
+	return nil
+}
+
+// callContext returns the context of a call to the replication API, carrying
+// the token of the client.
+func (c *client) callContext() context.Context {
+	if c.authCtx == nil {
+		return c.ctx
+	}
+
+	return auth.OutgoingContext(c.authCtx)
+}
+
+func (c *client) opts() nodeapi.KeyOpts {
+	return nodeapi.KeyOpts{Namespace: c.server.namespace}
+}
+
+// writeError converts an error returned by the replication API into an error
+// reply. The errors caused by the request are client errors, and the others
+// server errors.
+func (c *client) writeError(err error) {
+	msg := status.Convert(err).Message()
+
+	if _, ok := admission.RetryAfter(err); ok {
+		c.w.Line("SERVER_ERROR " + oneLine(msg))
+		return
+	}
+
+	switch grpcutil.ErrorCode(err) {
+	case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+		c.w.Line("CLIENT_ERROR " + oneLine(msg))
+	case codes.ResourceExhausted:
+		c.w.Line("SERVER_ERROR out of memory storing object")
+	default:
+		c.w.Line("SERVER_ERROR " + oneLine(msg))
+	}
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// reply writes the reply to a command unless the client asked for none.
+func (c *client) reply(noreply bool, line string) {
+	if !noreply {
+		c.w.Line(line)
+	}
+}
+
+// readKey returns the values and the version of the key.
+func (c *client) readKey(key string) ([][]byte, string, error) {
+	return frontend.ReadKey(c.callContext(), c.server.conns.LocalConn(), key, c.opts())
+}
+
+// writeKey writes the value of the key over the given version.
+func (c *client) writeKey(key string, value []byte, version string, ttl time.Duration) error {
+	opts := c.opts()
+	opts.TTL = ttl
+
+	_, err := frontend.WriteKey(c.callContext(), c.server.conns.LocalConn(), key, value, version, opts)
+
+	return err
+}
+
+// authenticate handles the set sent by a client to authenticate, whose data is
+// the user name and the token.
+func (c *client) authenticate(fields []string) error {
+	if len(fields) < 5 {
+		c.w.Line("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	n, err := strconv.Atoi(fields[4])
+	if err != nil || n < 0 || n > maxLineLen {
+		c.w.Line("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	data, err := c.r.ReadData(n)
+	if err != nil {
+		return err
+	}
+
+	_, token, ok := strings.Cut(string(data), " ")
+	if !ok {
+		c.w.Line("CLIENT_ERROR authentication failure")
+		return nil
+	}
+
+	id, err := c.server.authn.Authenticate(strings.TrimSpace(token))
+	if err != nil {
+		c.w.Line("CLIENT_ERROR authentication failure")
+		return nil
+	}
+
+	c.authCtx = auth.NewContext(c.ctx, strings.TrimSpace(token), id)
+	c.w.Line("STORED")
+
+	return nil
+}
+
+// get replies with the values of the keys found. A key having several
+// concurrent values fails the command, as it cannot be returned.
+func (c *client) get(keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		c.w.Line("ERROR")
+		return
+	}
+
+	for _, key := range keys {
+		if !validKey(key) {
+			c.w.Line("CLIENT_ERROR bad command line format")
+			return
+		}
+
+		values, version, err := c.readKey(key)
+		if err != nil {
+			c.writeError(err)
+			return
+		}
+
+		switch len(values) {
+		case 0:
+			continue
+		case 1:
+		default:
+			c.w.Line("SERVER_ERROR key " + key + " has " + strconv.Itoa(len(values)) + " concurrent values")
+			return
+		}
+
+		line := "VALUE " + key + " 0 " + strconv.Itoa(len(values[0]))
+		if withCAS {
+			line += " " + strconv.FormatUint(casToken(version), 10)
+		}
+
+		c.w.Line(line)
+		c.w.Data(values[0])
+	}
+
+	c.w.Line("END")
+}
+
+// store handles set and cas, which are followed by the data block:
+//
+//	set <key> <flags> <exptime> <bytes> [noreply]
+//	cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (c *client) store(fields []string, cas bool) error {
+	argc := 5
+	if cas {
+		argc = 6
+	}
+
+	if len(fields) != argc && !(len(fields) == argc+1 && fields[argc] == "noreply") {
+		c.w.Line("ERROR")
+		return nil
+	}
+
+	n, err := strconv.Atoi(fields[4])
+	if err != nil || n < 0 || n > maxValueLen {
+		c.w.Line("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	var (
+		key           = fields[1]
+		noreply       = len(fields) > argc
+		flags, errF   = strconv.ParseUint(fields[2], 10, 32)
+		exptime, errE = strconv.ParseInt(fields[3], 10, 64)
+		token         uint64
+		errC          error
+	)
+
+	if cas {
+		token, errC = strconv.ParseUint(fields[5], 10, 64)
+	}
+
+	if !validKey(key) || errF != nil || errE != nil || errC != nil {
+		c.w.Line("CLIENT_ERROR bad command line format")
+		return c.r.Discard(n)
+	}
+
+	if flags != 0 {
+		c.w.Line("CLIENT_ERROR flags are not supported")
+		return c.r.Discard(n)
+	}
+
+	data, err := c.r.ReadData(n)
+	if err != nil {
+		if err == errBadChunk {
+			c.w.Line("CLIENT_ERROR bad data chunk")
+		}
+
+		return err
+	}
+
+	// The version is read first, as a write without it is rejected by the
+	// replicas that have seen an earlier write of the key.
+	values, current, err := c.readKey(key)
+	if err != nil {
+		c.writeError(err)
+		return nil
+	}
+
+	if cas {
+		if len(values) == 0 {
+			c.reply(noreply, "NOT_FOUND")
+			return nil
+		}
+
+		if casToken(current) != token {
+			c.reply(noreply, "EXISTS")
+			return nil
+		}
+	}
+
+	ttl, expired := expiry(exptime, c.server.cluster.Env().Clock.Now())
+
+	if expired {
+		if len(values) > 0 {
+			_, err = c.server.conns.LocalConn().DeleteKey(c.callContext(), key, current, c.opts())
+		}
+	} else {
+		err = c.writeKey(key, data, current, ttl)
+	}
+
+	switch {
+	case err == nil:
+		c.reply(noreply, "STORED")
+	case cas && grpcutil.ErrorCode(err) == codes.AlreadyExists:
+		// The key has been written since it was read.
+		c.reply(noreply, "EXISTS")
+	default:
+		c.writeError(err)
+	}
+
+	return nil
+}
+
+// delete handles "delete <key> [noreply]".
+func (c *client) delete(fields []string) {
+	if len(fields) != 2 && !(len(fields) == 3 && fields[2] == "noreply") {
+		c.w.Line("CLIENT_ERROR bad command line format. Usage: delete <key> [noreply]")
+		return
+	}
+
+	key, noreply := fields[1], len(fields) == 3
+	if !validKey(key) {
+		c.w.Line("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	values, current, err := c.readKey(key)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	if len(values) == 0 {
+		c.reply(noreply, "NOT_FOUND")
+		return
+	}
+
+	if _, err := c.server.conns.LocalConn().DeleteKey(c.callContext(), key, current, c.opts()); err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.reply(noreply, "DELETED")
+}
+
+// touch handles "touch <key> <exptime> [noreply]". The value is rewritten with
+// the new lifetime, as the lifetime is bound to the value.
+func (c *client) touch(fields []string) {
+	if len(fields) != 3 && !(len(fields) == 4 && fields[3] == "noreply") {
+		c.w.Line("ERROR")
+		return
+	}
+
+	key, noreply := fields[1], len(fields) == 4
+	if !validKey(key) {
+		c.w.Line("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	exptime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		c.w.Line("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+
+	values, current, err := c.readKey(key)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	if len(values) == 0 {
+		c.reply(noreply, "NOT_FOUND")
+		return
+	}
+
+	if len(values) > 1 {
+		c.w.Line("SERVER_ERROR key " + key + " has " + strconv.Itoa(len(values)) + " concurrent values")
+		return
+	}
+
+	if ttl, expired := expiry(exptime, c.server.cluster.Env().Clock.Now()); expired {
+		_, err = c.server.conns.LocalConn().DeleteKey(c.callContext(), key, current, c.opts())
+	} else {
+		err = c.writeKey(key, values[0], current, ttl)
+	}
+
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.reply(noreply, "TOUCHED")
+}
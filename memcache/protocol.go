@@ -0,0 +1,113 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	// maxLineLen limits the length of a command line.
+	maxLineLen = 4096
+	// maxKeyLen is the maximum length of a key, as in memcached.
+	maxKeyLen = 250
+	// maxValueLen limits the size of a value.
+	maxValueLen = 64 << 20
+)
+
+var (
+	errLineTooLong = errors.New("line too long")
+	errBadChunk    = errors.New("bad data chunk")
+)
+
+// reader reads the command lines and the data blocks following the storage
+// commands.
+type reader struct {
+	r *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReaderSize(r, maxLineLen)}
+}
+
+// Buffered reports whether the client has already sent more data, in which
+// case the replies are not flushed until the pipelined commands are handled.
+func (r *reader) Buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+// ReadLine returns the space-separated fields of the next command line.
+func (r *reader) ReadLine() ([]string, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, errLineTooLong
+		}
+
+		return nil, err
+	}
+
+	return strings.Fields(string(line)), nil
+}
+
+// ReadData reads a data block of n bytes terminated by "\r\n".
+func (r *reader) ReadData(n int) ([]byte, error) {
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasSuffix(buf, []byte("\r\n")) {
+		return nil, errBadChunk
+	}
+
+	return buf[:n], nil
+}
+
+// Discard skips a data block of n bytes that is not stored.
+func (r *reader) Discard(n int) error {
+	_, err := r.r.Discard(n + 2)
+	return err
+}
+
+// writer writes the replies.
+type writer struct {
+	w *bufio.Writer
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w)}
+}
+
+func (w *writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Line writes a reply line, which must not contain line breaks.
+func (w *writer) Line(s string) {
+	w.w.WriteString(s + "\r\n")
+}
+
+// Data writes a data block following a VALUE line.
+func (w *writer) Data(data []byte) {
+	w.w.Write(data)
+	w.w.WriteString("\r\n")
+}
+
+// validKey reports whether the key can be used with the text protocol, which
+// separates the fields of the commands with spaces.
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLen {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,126 @@
+// Package memcache implements a front-end speaking the memcached text protocol,
+// for the clients that cannot be moved to the other APIs. The commands are
+// mapped onto the replication API of the local node, in a single namespace
+// chosen for the listener, at the default consistency levels of the namespace.
+//
+// The CAS unique of a value returned by gets is a hash of the version of the
+// key, so a cas fails with EXISTS if the replicas have seen a write of the key
+// since it was read. It does not serialize the writes, though: a write made at
+// the same time through another node is not seen, and both values are kept as
+// concurrent values of the key, which cannot be read until a cas with the CAS
+// unique of the key overwrites them.
+// The client flags are not stored, so only the values set with flags 0 are
+// accepted. A key having several concurrent values cannot be read, since the
+// protocol has no way to return them, and is overwritten by a set or a cas with
+// the CAS unique of the key.
+//
+// When the authentication is enabled, a connection authenticates the same way
+// as with memcached, with a set of any key whose data is the user name and the
+// token, separated by a space. The user name is ignored.
+package memcache
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/internal/frontend"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// ErrServerClosed is returned by Serve after the server is shut down.
+var ErrServerClosed = errors.New("memcache: server closed")
+
+// Server accepts the connections of the memcached clients.
+type Server struct {
+	cluster   membership.Cluster
+	authn     auth.Authenticator
+	namespace string
+	logger    kitlog.Logger
+	conns     *frontend.Server
+}
+
+// NewServer creates a server forwarding the commands to the local node of the
+// cluster, for the keys of the namespace. An empty namespace is the default
+// one. The authenticator is nil if the authentication is disabled.
+func NewServer(cluster membership.Cluster, authn auth.Authenticator, ns string, logger kitlog.Logger) *Server {
+	return &Server{
+		cluster:   cluster,
+		authn:     authn,
+		namespace: ns,
+		logger:    logger,
+		conns:     frontend.NewServer(cluster, ErrServerClosed),
+	}
+}
+
+// SetLocalConn sets the connection the commands are sent over, in place of the
+// connection of the cluster to the local node. The commands are made on behalf
+// of the address of the client, which is only trusted by the admission control
+// on a connection with the admission.Controller.GatewayCredentials, so that the
+// clients are not all limited together.
+func (s *Server) SetLocalConn(conn nodeapi.Client) {
+	s.conns.SetLocalConn(conn)
+}
+
+// Serve accepts the connections on the listener until the server is shut down,
+// handling each of them in its own goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l, s.serveConn)
+}
+
+// Shutdown stops accepting connections and closes the open ones, waiting for
+// the commands in progress to complete or for the context to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.conns.Shutdown(ctx)
+}
+
+// serveConn reads the commands of the connection until it is closed. The
+// replies are buffered while the client has sent more commands, so that the
+// pipelined commands are answered with a single write.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	c := &client{
+		server: s,
+		ctx:    ctx,
+		r:      newReader(conn),
+		w:      newWriter(conn),
+	}
+
+	for {
+		fields, err := c.r.ReadLine()
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				c.w.Line("CLIENT_ERROR line too long")
+				c.w.Flush()
+			}
+
+			return
+		}
+
+		if len(fields) > 0 {
+			if err := c.handle(fields); err != nil {
+				level.Debug(s.logger).Log("msg", "connection closed", "remote", conn.RemoteAddr(), "err", err)
+				c.w.Flush()
+
+				return
+			}
+		} else {
+			c.w.Line("ERROR")
+		}
+
+		if c.quit {
+			return
+		}
+
+		if !c.r.Buffered() {
+			if err := c.w.Flush(); err != nil {
+				level.Debug(s.logger).Log("msg", "failed to write reply", "remote", conn.RemoteAddr(), "err", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package memcache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/internal/frontend/frontendtest"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication/service"
+)
+
+type testClient struct {
+	*frontendtest.Conn
+}
+
+func dialServer(t *testing.T, addr string) *testClient {
+	t.Helper()
+
+	return &testClient{frontendtest.Dial(t, addr)}
+}
+
+// Do sends the request and returns the reply lines up to the last one, which
+// is END for the retrievals.
+func (c *testClient) Do(request string, last ...string) []string {
+	c.T.Helper()
+
+	if _, err := c.Write([]byte(request)); err != nil {
+		c.T.Fatal(err)
+	}
+
+	var lines []string
+
+	for {
+		line, err := c.R.ReadString('\n')
+		if err != nil {
+			c.T.Fatal(err)
+		}
+
+		line = strings.TrimSuffix(line, "\r\n")
+		lines = append(lines, line)
+
+		if len(last) == 0 || line == last[0] || strings.HasPrefix(line, "SERVER_ERROR") || strings.HasPrefix(line, "CLIENT_ERROR") {
+			return lines
+		}
+	}
+}
+
+func (c *testClient) expect(request string, want ...string) {
+	c.T.Helper()
+
+	var got []string
+	if strings.Contains(request, "get") {
+		got = c.Do(request, "END")
+	} else {
+		got = c.Do(request)
+	}
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		c.T.Errorf("%q: expected %q, got %q", request, want, got)
+	}
+}
+
+// gets returns the CAS unique of the key.
+func (c *testClient) gets(key string) string {
+	c.T.Helper()
+
+	lines := c.Do("gets "+key+"\r\n", "END")
+	if len(lines) != 3 {
+		c.T.Fatalf("gets %s: %q", key, lines)
+	}
+
+	fields := strings.Fields(lines[0])
+
+	return fields[len(fields)-1]
+}
+
+func startServer(t *testing.T, h *clustertest.Harness, authn auth.Authenticator, ns string) string {
+	t.Helper()
+
+	return frontendtest.Serve(t, NewServer(h.Node(1).Cluster, authn, ns, kitlog.NewNopLogger()), ErrServerClosed)
+}
+
+func TestCommands(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+	c := dialServer(t, startServer(t, h, nil, ""))
+
+	c.expect("version\r\n", "VERSION keywave")
+	c.expect("unknown\r\n", "ERROR")
+
+	c.expect("get a b\r\n", "END")
+	c.expect("set a 0 0 3\r\none\r\n", "STORED")
+	c.expect("set b 0 0 3 noreply\r\ntwo\r\nget a b c\r\n", "VALUE a 0 3", "one", "VALUE b 0 3", "two", "END")
+	c.expect("set a 1 0 3\r\none\r\n", "CLIENT_ERROR flags are not supported")
+	c.expect("set a 0 0 3\r\nlonger\r\n", "CLIENT_ERROR bad data chunk")
+
+	// The connection is closed after a bad data chunk.
+	c = dialServer(t, startServer(t, h, nil, ""))
+
+	// The CAS unique changes with every write of the key.
+	token := c.gets("a")
+	c.expect("cas a 0 0 3 "+token+"\r\nnew\r\n", "STORED")
+	c.expect("cas a 0 0 3 "+token+"\r\nold\r\n", "EXISTS")
+	c.expect("cas x 0 0 3 "+token+"\r\nnew\r\n", "NOT_FOUND")
+	c.expect("get a\r\n", "VALUE a 0 3", "new", "END")
+
+	c.expect("delete a\r\n", "DELETED")
+	c.expect("delete a\r\n", "NOT_FOUND")
+	c.expect("get a\r\n", "END")
+	c.expect("set a 0 0 5\r\nagain\r\n", "STORED")
+	c.expect("get a\r\n", "VALUE a 0 5", "again", "END")
+
+	// The values written with an expiration time, or touched, expire.
+	c.expect("touch x 1\r\n", "NOT_FOUND")
+	c.expect("touch a 1\r\n", "TOUCHED")
+	c.expect("set e 0 1 1\r\ne\r\n", "STORED")
+	c.expect("set b 0 -1 1\r\nb\r\n", "STORED")
+	c.expect("get a e b\r\n", "VALUE a 0 5", "again", "VALUE e 0 1", "e", "END")
+
+	time.Sleep(1100 * time.Millisecond)
+
+	c.expect("get a e\r\n", "END")
+
+	// The values larger than a request are stored in chunks.
+	large := strings.Repeat("0123456789", chunk.Size/5)
+	c.expect("set large 0 0 "+strconv.Itoa(len(large))+"\r\n"+large+"\r\n", "STORED")
+	c.expect("get large\r\n", "VALUE large 0 "+strconv.Itoa(len(large)), large, "END")
+}
+
+func TestConcurrentValues(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		namespaces = namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{Name: "cache"})
+		h          = clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+		c          = dialServer(t, startServer(t, h, nil, "cache"))
+	)
+
+	for _, node := range h.Nodes() {
+		for _, id := range []membership.NodeID{1, 2} {
+			_, err := node.Client().StoragePut(ctx, namespace.Key("cache", "k"), nodeapi.VersionedValue{
+				Version: vclock.Encode(vclock.Version{uint32(id): 1}),
+				Data:    []byte{'a' + byte(id)},
+			}, false)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c.expect("get k\r\n", "SERVER_ERROR key k has 2 concurrent values")
+
+	// A cas with the CAS unique of the key overwrites all of the values.
+	res, err := h.Node(1).Client().GetKey(ctx, "k", nodeapi.KeyOpts{Namespace: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.expect("cas k 0 0 8 "+strconv.FormatUint(casToken(res.Version)+1, 10)+"\r\nresolved\r\n", "EXISTS")
+	c.expect("cas k 0 0 8 "+strconv.FormatUint(casToken(res.Version), 10)+"\r\nresolved\r\n", "STORED")
+	c.expect("get k\r\n", "VALUE k 0 8", "resolved", "END")
+}
+
+func TestAuthentication(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 1})
+	c := dialServer(t, startServer(t, h, frontendtest.StaticAuth{"secret": &auth.Identity{}}, ""))
+
+	c.expect("get a\r\n", "CLIENT_ERROR unauthenticated")
+	c.expect("set auth 0 0 10\r\nuser wrong\r\n", "CLIENT_ERROR authentication failure")
+	c.expect("set auth 0 0 11\r\nuser secret\r\n", "STORED")
+	c.expect("get a\r\n", "END")
+}
+
+func TestExpiryClock(t *testing.T) {
+	// The expiration times given as unix timestamps are relative to the clock of
+	// the cluster.
+	var (
+		now = time.Unix(1_700_000_000, 0)
+		h   = clustertest.New(t, clustertest.Options{Nodes: 1, Env: env.Env{Clock: env.NewSimClock(now)}})
+		c   = dialServer(t, startServer(t, h, nil, ""))
+		exp = strconv.FormatInt(now.Unix()+60, 10)
+	)
+
+	c.expect("set a 0 "+exp+" 1\r\na\r\n", "STORED")
+	c.expect("touch a "+exp+"\r\n", "TOUCHED")
+	c.expect("get a\r\n", "VALUE a 0 1", "a", "END")
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		exptime int64
+		ttl     time.Duration
+		expired bool
+	}{
+		{0, 0, false},
+		{-1, 0, true},
+		{60, time.Minute, false},
+		{relativeExpiryLimit, relativeExpiryLimit * time.Second, false},
+		{now.Unix() + 10, 10 * time.Second, false},
+		{now.Unix() - 10, -10 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		ttl, expired := expiry(tt.exptime, now)
+		if ttl != tt.ttl || expired != tt.expired {
+			t.Errorf("expiry(%d) = %v, %v", tt.exptime, ttl, expired)
+		}
+	}
+}
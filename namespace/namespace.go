@@ -0,0 +1,332 @@
+package namespace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sadath-12/keywave/replication/consistency"
+)
+
+// Default is the namespace used when a request does not specify one.
+const Default = "default"
+
+// Chunks is the internal namespace holding the chunks of the large values,
+// keyed by the hash of their content.
+const Chunks = "_chunks"
+
+// separator divides the namespace and the key in the storage key. It is not
+// allowed in namespace names, so the first occurrence always ends the namespace.
+const separator = "/"
+
+var (
+	// ErrNotFound is returned when the namespace is not declared.
+	ErrNotFound = errors.New("namespace not found")
+	// ErrInvalidName is returned when the namespace name contains forbidden characters.
+	ErrInvalidName = errors.New("invalid namespace name")
+)
+
+// Config describes the replication and retention policy of a namespace.
+type Config struct {
+	Name string
+	// ReadLevel is the consistency level of reads that do not specify one.
+	ReadLevel consistency.Level
+	// WriteLevel is the consistency level of writes that do not specify one.
+	WriteLevel consistency.Level
+	// ReplicationFactor is the number of nodes holding a copy of each key.
+	// Zero means that every node in the cluster holds a copy.
+	ReplicationFactor int
+	// TTL is the lifetime of the values written to the namespace. Zero means
+	// that values never expire.
+	TTL time.Duration
+	// MaxKeys limits the number of live keys in the namespace. Zero means no limit.
+	MaxKeys int64
+	// MaxBytes limits the total size of live values in the namespace. Zero means no limit.
+	MaxBytes int64
+	// Indexes are the secondary indexes over the fields of the JSON values.
+	Indexes []Index
+	// Compression selects the codec of the values stored in the namespace.
+	// Empty means CompressionAuto.
+	Compression Compression
+}
+
+// Compression selects how the values of a namespace are compressed by the
+// storage engine.
+type Compression string
+
+const (
+	// CompressionAuto compresses the small values with snappy and the large
+	// ones with zstd.
+	CompressionAuto Compression = "auto"
+	// CompressionNone stores the values as they are.
+	CompressionNone Compression = "none"
+	// CompressionSnappy compresses all values with snappy.
+	CompressionSnappy Compression = "snappy"
+	// CompressionZstd compresses all values with zstd.
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression returns the compression with the given name.
+func ParseCompression(name string) (Compression, bool) {
+	switch c := Compression(name); c {
+	case CompressionAuto, CompressionNone, CompressionSnappy, CompressionZstd:
+		return c, true
+	default:
+		return "", false
+	}
+}
+
+// Index declares a secondary index over a field of the JSON values stored in
+// the namespace. Each node indexes the values it stores, so the index is
+// queried on all nodes.
+type Index struct {
+	// Field is the indexed field, with the nested fields separated by dots,
+	// e.g. "address.city". It is unique within the namespace.
+	Field string
+	// Prefix limits the index to the keys starting with it. Empty means that
+	// all keys of the namespace are indexed.
+	Prefix string
+}
+
+// Index returns the index of the namespace over the field.
+func (c Config) Index(field string) (Index, bool) {
+	for _, idx := range c.Indexes {
+		if idx.Field == field {
+			return idx, true
+		}
+	}
+
+	return Index{}, false
+}
+
+// ValidName reports whether the name can be used as a namespace name. Names
+// starting with an underscore are reserved for internal use.
+func ValidName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Internal reports whether the namespace is reserved for internal use, and so
+// cannot be accessed by the clients directly.
+func Internal(name string) bool {
+	return strings.HasPrefix(name, "_")
+}
+
+// Key returns the key under which the given key of the namespace is stored in
+// the storage engine.
+func Key(ns, key string) string {
+	return ns + separator + key
+}
+
+// Prefix returns the storage key prefix shared by all keys of the namespace.
+func Prefix(ns string) string {
+	return ns + separator
+}
+
+// SplitKey splits the storage key into the namespace and the key. The last
+// return value is false if the storage key does not belong to any namespace.
+func SplitKey(storageKey string) (ns, key string, ok bool) {
+	idx := strings.Index(storageKey, separator)
+	if idx <= 0 {
+		return "", storageKey, false
+	}
+
+	return storageKey[:idx], storageKey[idx+1:], true
+}
+
+// Registry holds the configuration of all declared namespaces.
+type Registry struct {
+	namespaces map[string]Config
+}
+
+// NewRegistry creates a registry with the given namespaces. The default
+// namespace is always present and uses the defaults unless overridden. The
+// internal namespaces are added as well, with the replication factor and the
+// consistency levels of the default namespace.
+func NewRegistry(defaults Config, namespaces ...Config) *Registry {
+	r := &Registry{
+		namespaces: make(map[string]Config, len(namespaces)+1),
+	}
+
+	defaults.Name = Default
+	r.namespaces[Default] = defaults
+
+	for _, ns := range namespaces {
+		r.namespaces[ns.Name] = ns
+	}
+
+	// The chunks never expire nor count towards the quotas on their own, they
+	// are removed once no value refers to them.
+	def := r.namespaces[Default]
+	r.namespaces[Chunks] = Config{
+		Name:              Chunks,
+		ReadLevel:         def.ReadLevel,
+		WriteLevel:        def.WriteLevel,
+		ReplicationFactor: def.ReplicationFactor,
+		Compression:       def.Compression,
+	}
+
+	return r
+}
+
+// Get returns the configuration of the namespace. An empty name refers to the
+// default namespace.
+func (r *Registry) Get(name string) (Config, error) {
+	if name == "" {
+		name = Default
+	}
+
+	if !ValidName(name) {
+		return Config{}, ErrInvalidName
+	}
+
+	ns, ok := r.namespaces[name]
+	if !ok {
+		return Config{}, ErrNotFound
+	}
+
+	return ns, nil
+}
+
+// List returns the configuration of all namespaces.
+func (r *Registry) List() []Config {
+	res := make([]Config, 0, len(r.namespaces))
+
+	for _, ns := range r.namespaces {
+		res = append(res, ns)
+	}
+
+	return res
+}
+
+type fileJSON struct {
+	Namespaces []struct {
+		Name              string `json:"name"`
+		ReadLevel         string `json:"read_level"`
+		WriteLevel        string `json:"write_level"`
+		ReplicationFactor int    `json:"replication_factor"`
+		TTL               string `json:"ttl"`
+		MaxKeys           int64  `json:"max_keys"`
+		MaxBytes          int64  `json:"max_bytes"`
+		Compression       string `json:"compression"`
+		Indexes           []struct {
+			Field  string `json:"field"`
+			Prefix string `json:"prefix"`
+		} `json:"indexes"`
+	} `json:"namespaces"`
+}
+
+// LoadFile reads the namespace declarations from a JSON file of the following form.
+// Omitted fields are taken from the defaults:
+//
+//	{
+//	  "namespaces": [
+//	    {
+//	      "name": "sessions",
+//	      "read_level": "one",
+//	      "write_level": "quorum",
+//	      "replication_factor": 3,
+//	      "ttl": "24h",
+//	      "max_keys": 1000000,
+//	      "max_bytes": 1073741824,
+//	      "compression": "zstd",
+//	      "indexes": [
+//	        {"field": "user.email", "prefix": "session:"}
+//	      ]
+//	    }
+//	  ]
+//	}
+func LoadFile(path string, defaults Config) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read namespace file: %w", err)
+	}
+
+	var parsed fileJSON
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parse namespace file: %w", err)
+	}
+
+	namespaces := make([]Config, 0, len(parsed.Namespaces))
+
+	for _, n := range parsed.Namespaces {
+		if !ValidName(n.Name) || strings.HasPrefix(n.Name, "_") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidName, n.Name)
+		}
+
+		ns := defaults
+		ns.Name = n.Name
+		ns.ReplicationFactor = n.ReplicationFactor
+		ns.MaxKeys = n.MaxKeys
+		ns.MaxBytes = n.MaxBytes
+
+		if n.ReadLevel != "" {
+			level, ok := consistency.FromString(n.ReadLevel)
+			if !ok {
+				return nil, fmt.Errorf("namespace %s: unknown read level %q", n.Name, n.ReadLevel)
+			}
+
+			ns.ReadLevel = level
+		}
+
+		if n.WriteLevel != "" {
+			level, ok := consistency.FromString(n.WriteLevel)
+			if !ok {
+				return nil, fmt.Errorf("namespace %s: unknown write level %q", n.Name, n.WriteLevel)
+			}
+
+			ns.WriteLevel = level
+		}
+
+		if n.TTL != "" {
+			ttl, err := time.ParseDuration(n.TTL)
+			if err != nil {
+				return nil, fmt.Errorf("namespace %s: invalid ttl: %w", n.Name, err)
+			}
+
+			ns.TTL = ttl
+		}
+
+		if n.Compression != "" {
+			c, ok := ParseCompression(n.Compression)
+			if !ok {
+				return nil, fmt.Errorf("namespace %s: unknown compression %q", n.Name, n.Compression)
+			}
+
+			ns.Compression = c
+		}
+
+		ns.Indexes = nil
+
+		for _, idx := range n.Indexes {
+			if idx.Field == "" {
+				return nil, fmt.Errorf("namespace %s: index field is required", n.Name)
+			}
+
+			if _, ok := ns.Index(idx.Field); ok {
+				return nil, fmt.Errorf("namespace %s: duplicate index on %q", n.Name, idx.Field)
+			}
+
+			ns.Indexes = append(ns.Indexes, Index{Field: idx.Field, Prefix: idx.Prefix})
+		}
+
+		namespaces = append(namespaces, ns)
+	}
+
+	// Declaring the default namespace in the file overrides its defaults.
+	return NewRegistry(defaults, namespaces...), nil
+}
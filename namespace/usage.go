@@ -0,0 +1,136 @@
+package namespace
+
+import (
+	"sync"
+
+	"github.com/sadath-12/keywave/internal/lockmap"
+	"github.com/sadath-12/keywave/storage"
+)
+
+var (
+	_ storage.Engine    = (*Tracker)(nil)
+	_ storage.Scannable = (*Tracker)(nil)
+	_ Reporter          = (*Tracker)(nil)
+)
+
+// Usage is the amount of live data stored in a namespace.
+type Usage struct {
+	Keys  int64
+	Bytes int64
+}
+
+// Reporter is implemented by storage engines that keep track of the namespace usage.
+type Reporter interface {
+	Usage() map[string]Usage
+}
+
+// SizeFunc returns the size a value is accounted with.
+type SizeFunc func(storage.Value) int64
+
+// Tracker wraps a storage engine and accounts the number of live keys and the
+// total size of live values in each namespace stored in it. A key is live when
+// it has at least one version that is not a tombstone.
+type Tracker struct {
+	engine storage.Engine
+	size   SizeFunc
+	locks  *lockmap.Map[string]
+	mut    sync.Mutex
+	usage  map[string]Usage
+}
+
+// NewTracker wraps the engine with usage tracking. The engine is expected to be
+// empty, since the existing data is not accounted. The values are accounted
+// with the given size, or with the size of their data if size is nil.
+func NewTracker(engine storage.Engine, size SizeFunc) *Tracker {
+	if size == nil {
+		size = func(v storage.Value) int64 { return int64(len(v.Data)) }
+	}
+
+	return &Tracker{
+		engine: engine,
+		size:   size,
+		locks:  lockmap.New[string](),
+		usage:  make(map[string]Usage),
+	}
+}
+
+func (t *Tracker) Get(key string) ([]storage.Value, error) {
+	return t.engine.Get(key)
+}
+
+func (t *Tracker) Put(key string, value storage.Value) error {
+	// The key is locked to make sure that the values read before and after the
+	// write belong to the same update.
+	t.locks.Lock(key)
+	defer t.locks.Unlock(key)
+
+	before, _ := t.engine.Get(key)
+
+	if err := t.engine.Put(key, value); err != nil {
+		return err
+	}
+
+	after, _ := t.engine.Get(key)
+
+	ns, _, ok := SplitKey(key)
+	if !ok {
+		return nil
+	}
+
+	keysBefore, bytesBefore := t.liveSize(before)
+	keysAfter, bytesAfter := t.liveSize(after)
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	u := t.usage[ns]
+	u.Keys += keysAfter - keysBefore
+	u.Bytes += bytesAfter - bytesBefore
+	t.usage[ns] = u
+
+	return nil
+}
+
+// Scan returns an iterator over the underlying engine. If the engine does not
+// support range scans, the iterator is always empty.
+func (t *Tracker) Scan(key string) storage.ScanIterator {
+	if s, ok := t.engine.(storage.Scannable); ok {
+		return s.Scan(key)
+	}
+
+	return emptyIterator{}
+}
+
+// Usage returns a snapshot of the usage of all namespaces stored in the engine.
+func (t *Tracker) Usage() map[string]Usage {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	res := make(map[string]Usage, len(t.usage))
+	for ns, u := range t.usage {
+		res[ns] = u
+	}
+
+	return res
+}
+
+func (t *Tracker) liveSize(values []storage.Value) (keys, bytes int64) {
+	for _, v := range values {
+		if !v.Tombstone {
+			keys = 1
+			bytes += t.size(v)
+		}
+	}
+
+	return keys, bytes
+}
+
+type emptyIterator struct{}
+
+func (emptyIterator) Next() error {
+	return storage.ErrNoMoreItems
+}
+
+func (emptyIterator) Item() (string, []storage.Value) {
+	return "", nil
+}
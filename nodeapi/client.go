@@ -8,6 +8,7 @@ import "context"
 type Client interface {
 	storageClient
 	membershipClient
+	replicationClient
 	IsClosed() bool
 	Close() error
 }
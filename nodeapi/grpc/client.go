@@ -2,6 +2,8 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"io"
 	"sync/atomic"
 	"time"
 
@@ -60,6 +62,8 @@ func (c *Client) StorageGet(ctx context.Context, key string) (*nodeapi.StorageGe
 			Tombstone: v.Tombstone,
 			Version:   v.Version,
 			Data:      v.Data,
+			ExpiresAt: v.ExpiresAt,
+			Chunked:   v.Chunked,
 		}
 	}
 
@@ -76,6 +80,8 @@ func (c *Client) StoragePut(ctx context.Context, key string, value nodeapi.Versi
 			Data:      value.Data,
 			Version:   value.Version,
 			Tombstone: value.Tombstone,
+			ExpiresAt: value.ExpiresAt,
+			Chunked:   value.Chunked,
 		},
 	})
 
@@ -88,91 +94,510 @@ func (c *Client) StoragePut(ctx context.Context, key string, value nodeapi.Versi
 	}, nil
 }
 
-func (c *Client) Ping(ctx context.Context) (uint64, error) {
-	resp, err := c.membershipClient.Ping(ctx, &proto.PingRequest{})
+func (c *Client) StorageUsage(ctx context.Context) (*nodeapi.StorageUsageResult, error) {
+	resp, err := c.storageClient.Usage(ctx, &storagepb.UsageRequest{})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	usage := make(map[string]nodeapi.NamespaceUsage, len(resp.Namespaces))
+	for ns, u := range resp.Namespaces {
+		usage[ns] = nodeapi.NamespaceUsage{
+			Keys:  u.Keys,
+			Bytes: u.Bytes,
+		}
 	}
 
-	return resp.StateHash, nil
+	return &nodeapi.StorageUsageResult{
+		Namespaces: usage,
+	}, nil
 }
 
-func (c *Client) PingIndirect(ctx context.Context, nodeID nodeapi.NodeID, timeout time.Duration) (nodeapi.PingResult, error) {
-	resp, err := c.membershipClient.PingIndirect(ctx, &proto.PingIndirectRequest{
-		NodeId:  uint32(nodeID),
-		Timeout: timeout.Milliseconds(),
+func (c *Client) StorageScan(ctx context.Context, startKey string, fn nodeapi.StorageScanFunc) error {
+	return c.storageScan(ctx, &storagepb.ScanRequest{StartKey: startKey}, fn)
+}
+
+func (c *Client) StorageScanReplica(ctx context.Context, nodeID uint32, placement nodeapi.Placement, fn nodeapi.StorageScanFunc) error {
+	req := &storagepb.ScanRequest{
+		ReplicaOf: nodeID,
+		Placement: &storagepb.Placement{
+			Nodes:              make([]*storagepb.PlacementNode, len(placement.Nodes)),
+			ReplicationFactors: make(map[string]int32, len(placement.ReplicationFactors)),
+		},
+	}
+
+	for i, node := range placement.Nodes {
+		req.Placement.Nodes[i] = &storagepb.PlacementNode{Id: node.ID, Tags: node.Tags}
+	}
+
+	for ns, n := range placement.ReplicationFactors {
+		req.Placement.ReplicationFactors[ns] = int32(n)
+	}
+
+	return c.storageScan(ctx, req, fn)
+}
+
+func (c *Client) storageScan(ctx context.Context, req *storagepb.ScanRequest, fn nodeapi.StorageScanFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.storageClient.Scan(ctx, req)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		versions := make([]nodeapi.VersionedValue, len(resp.Value))
+
+		for idx, v := range resp.Value {
+			versions[idx] = nodeapi.VersionedValue{
+				Tombstone: v.Tombstone,
+				Version:   v.Version,
+				Data:      v.Data,
+				ExpiresAt: v.ExpiresAt,
+				Chunked:   v.Chunked,
+			}
+		}
+
+		if err := fn(nodeapi.StorageScanItem{Key: resp.Key, Versions: versions}); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) StorageQueryIndex(ctx context.Context, query nodeapi.IndexQuery) (*nodeapi.StorageQueryIndexResult, error) {
+	resp, err := c.storageClient.QueryIndex(ctx, &storagepb.QueryIndexRequest{
+		Namespace: query.Namespace,
+		Field:     query.Field,
+		Value:     query.Value,
+		Min:       query.Min,
+		Max:       query.Max,
+		Limit:     int32(query.Limit),
 	})
 
 	if err != nil {
-		return nodeapi.PingResult{}, err
+		return nil, err
+	}
+
+	items := make([]nodeapi.StorageScanItem, len(resp.Items))
+
+	for idx, item := range resp.Items {
+		versions := make([]nodeapi.VersionedValue, len(item.Value))
+
+		for i, v := range item.Value {
+			versions[i] = nodeapi.VersionedValue{
+				Tombstone: v.Tombstone,
+				Version:   v.Version,
+				Data:      v.Data,
+				ExpiresAt: v.ExpiresAt,
+				Chunked:   v.Chunked,
+			}
+		}
+
+		items[idx] = nodeapi.StorageScanItem{Key: item.Key, Versions: versions}
+	}
+
+	return &nodeapi.StorageQueryIndexResult{
+		Items: items,
+	}, nil
+}
+
+func (c *Client) StorageChunkRefs(ctx context.Context) (*nodeapi.StorageChunkRefsResult, error) {
+	resp, err := c.storageClient.ChunkRefs(ctx, &storagepb.ChunkRefsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeapi.StorageChunkRefsResult{
+		Hashes: resp.Hashes,
+	}, nil
+}
+
+func toProtoConsistency(level string) replicationpb.Consistency {
+	switch level {
+	case "one":
+		return replicationpb.Consistency_ONE
+	case "two":
+		return replicationpb.Consistency_TWO
+	case "quorum":
+		return replicationpb.Consistency_QUORUM
+	case "all":
+		return replicationpb.Consistency_ALL
+	default:
+		return replicationpb.Consistency_DEFAULT
+	}
+}
+
+func (c *Client) GetKey(ctx context.Context, key string, opts nodeapi.KeyOpts) (*nodeapi.GetKeyResult, error) {
+	resp, err := c.replicationClient.Get(ctx, &replicationpb.GetRequest{
+		Key:          key,
+		Namespace:    opts.Namespace,
+		Consistency:  toProtoConsistency(opts.Level),
+		SessionToken: opts.SessionToken,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(resp.Values))
+	for idx, v := range resp.Values {
+		values[idx] = v.Data
+	}
+
+	return &nodeapi.GetKeyResult{
+		Values:  values,
+		Version: resp.Version,
+	}, nil
+}
+
+func (c *Client) PutKey(ctx context.Context, key string, value []byte, version string, opts nodeapi.KeyOpts) (*nodeapi.PutKeyResult, error) {
+	resp, err := c.replicationClient.Put(ctx, &replicationpb.PutRequest{
+		Key:          key,
+		Version:      version,
+		Namespace:    opts.Namespace,
+		Consistency:  toProtoConsistency(opts.Level),
+		Ttl:          opts.TTL.Milliseconds(),
+		SessionToken: opts.SessionToken,
+		Value: &replicationpb.Value{
+			Data: value,
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeapi.PutKeyResult{
+		Version:      resp.Version,
+		Acknowledged: int(resp.Acknowledged),
+		SessionToken: resp.SessionToken,
+	}, nil
+}
+
+func (c *Client) DeleteKey(ctx context.Context, key string, version string, opts nodeapi.KeyOpts) (*nodeapi.DeleteKeyResult, error) {
+	resp, err := c.replicationClient.Delete(ctx, &replicationpb.DeleteRequest{
+		Key:          key,
+		Version:      version,
+		Namespace:    opts.Namespace,
+		Consistency:  toProtoConsistency(opts.Level),
+		SessionToken: opts.SessionToken,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeapi.DeleteKeyResult{
+		Version:      resp.Version,
+		Acknowledged: int(resp.Acknowledged),
+		SessionToken: resp.SessionToken,
+	}, nil
+}
+
+func (c *Client) QueryIndex(ctx context.Context, query nodeapi.IndexQuery) (*nodeapi.QueryIndexResult, error) {
+	resp, err := c.replicationClient.QueryIndex(ctx, &replicationpb.QueryIndexRequest{
+		Namespace: query.Namespace,
+		Field:     query.Field,
+		Value:     query.Value,
+		Min:       query.Min,
+		Max:       query.Max,
+		Limit:     int32(query.Limit),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]nodeapi.IndexItem, len(resp.Items))
+
+	for idx, item := range resp.Items {
+		values := make([][]byte, len(item.Values))
+		for i, v := range item.Values {
+			values[i] = v.Data
+		}
+
+		items[idx] = nodeapi.IndexItem{
+			Key:     item.Key,
+			Values:  values,
+			Version: item.Version,
+		}
+	}
+
+	return &nodeapi.QueryIndexResult{
+		Items: items,
+	}, nil
+}
+
+// streamPartSize is the size of the parts the values are sent in by PutKeyStream.
+const streamPartSize = 64 << 10
+
+func (c *Client) PutKeyStream(ctx context.Context, key string, value io.Reader, version string, opts nodeapi.KeyOpts) (*nodeapi.PutKeyResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.replicationClient.PutStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &replicationpb.PutStreamRequest{
+		Key:          key,
+		Version:      version,
+		Namespace:    opts.Namespace,
+		Consistency:  toProtoConsistency(opts.Level),
+		Ttl:          opts.TTL.Milliseconds(),
+		SessionToken: opts.SessionToken,
+	}
+
+	for {
+		// The messages must not be modified once sent, so every part is read
+		// into a new buffer.
+		buf := make([]byte, streamPartSize)
+
+		n, err := io.ReadFull(value, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+
+		// The first message is sent even if the value is empty, as it carries
+		// the key.
+		if n > 0 || req.Key != "" {
+			req.Data = buf[:n]
+
+			if err := stream.Send(req); err != nil {
+				return nil, err
+			}
+
+			req = &replicationpb.PutStreamRequest{}
+		}
+
+		if err != nil {
+			break
+		}
 	}
 
-	var status nodeapi.NodeStatus
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeapi.PutKeyResult{
+		Version:      resp.Version,
+		Acknowledged: int(resp.Acknowledged),
+		SessionToken: resp.SessionToken,
+	}, nil
+}
+
+func (c *Client) GetKeyStream(ctx context.Context, key string, opts nodeapi.KeyOpts, fn nodeapi.KeyPartFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.replicationClient.GetStream(ctx, &replicationpb.GetRequest{
+		Key:          key,
+		Namespace:    opts.Namespace,
+		Consistency:  toProtoConsistency(opts.Level),
+		SessionToken: opts.SessionToken,
+	})
+
+	if err != nil {
+		return err
+	}
 
-	switch resp.Status {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		err = fn(nodeapi.KeyPart{
+			Index:   int(resp.Index),
+			Data:    resp.Data,
+			Version: resp.Version,
+			Count:   int(resp.Count),
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func fromProtoStatus(status proto.Status) nodeapi.NodeStatus {
+	switch status {
 	case proto.Status_HEALTHY:
-		status = nodeapi.NodeStatusHealthy
+		return nodeapi.NodeStatusHealthy
 	case proto.Status_UNHEALTHY:
-		status = nodeapi.NodeStatusUnhealthy
+		return nodeapi.NodeStatusUnhealthy
 	case proto.Status_LEFT:
-		status = nodeapi.NodeStatusLeft
+		return nodeapi.NodeStatusLeft
+	case proto.Status_JOINING:
+		return nodeapi.NodeStatusJoining
+	case proto.Status_SUSPECT:
+		return nodeapi.NodeStatusSuspect
+	default:
+		return 0
+	}
+}
+
+func toProtoStatus(status nodeapi.NodeStatus) proto.Status {
+	switch status {
+	case nodeapi.NodeStatusUnhealthy:
+		return proto.Status_UNHEALTHY
+	case nodeapi.NodeStatusLeft:
+		return proto.Status_LEFT
+	case nodeapi.NodeStatusJoining:
+		return proto.Status_JOINING
+	case nodeapi.NodeStatusSuspect:
+		return proto.Status_SUSPECT
+	default:
+		return proto.Status_HEALTHY
+	}
+}
+
+func (c *Client) Ping(ctx context.Context, sourceID nodeapi.NodeID, updates []nodeapi.NodeInfo) (nodeapi.PingAck, error) {
+	resp, err := c.membershipClient.Ping(ctx, &proto.PingRequest{
+		NodeId:  uint32(sourceID),
+		Updates: toProtoNodes(updates),
+	})
+	if err != nil {
+		return nodeapi.PingAck{}, err
+	}
+
+	return nodeapi.PingAck{
+		Digest:  resp.StateDigest,
+		Updates: fromProtoNodes(resp.Updates),
+	}, nil
+}
+
+func (c *Client) PingIndirect(ctx context.Context, nodeID nodeapi.NodeID, timeout time.Duration) (nodeapi.PingResult, error) {
+	resp, err := c.membershipClient.PingIndirect(ctx, &proto.PingIndirectRequest{
+		NodeId:  uint32(nodeID),
+		Timeout: timeout.Milliseconds(),
+	})
+
+	if err != nil {
+		return nodeapi.PingResult{}, err
 	}
 
 	return nodeapi.PingResult{
 		Took:    time.Duration(resp.Duration) * time.Millisecond,
 		Message: resp.Message,
-		Status:  status,
+		Status:  fromProtoStatus(resp.Status),
 	}, nil
 }
 
 func (c *Client) PullPushState(ctx context.Context, nodes []nodeapi.NodeInfo) ([]nodeapi.NodeInfo, error) {
 	req := &proto.PullPushStateRequest{
-		Nodes: make([]*proto.Node, len(nodes)),
+		Nodes: toProtoNodes(nodes),
 	}
-	for idx, n := range nodes {
-		req.Nodes[idx] = &proto.Node{
-			Id:         uint32(n.ID),
-			Name:       n.Name,
-			Address:    n.Addr,
-			Generation: n.Gen,
-			Error:      n.Error,
-			RunId:      n.RunID,
+
+	resp, err := c.membershipClient.PullPushState(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromProtoNodes(resp.Nodes), nil
+}
+
+func (c *Client) WatchNodes(ctx context.Context, snapshot bool, fn nodeapi.NodeEventFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.membershipClient.WatchNodes(ctx, &proto.WatchNodesRequest{
+		Snapshot: snapshot,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
 		}
 
-		switch n.Status {
-		case nodeapi.NodeStatusHealthy:
-			req.Nodes[idx].Status = proto.Status_HEALTHY
-		case nodeapi.NodeStatusUnhealthy:
-			req.Nodes[idx].Status = proto.Status_UNHEALTHY
-		case nodeapi.NodeStatusLeft:
-			req.Nodes[idx].Status = proto.Status_LEFT
+		nodes := fromProtoNodes([]*proto.Node{resp.Node})
+
+		if err := fn(nodeapi.NodeEvent{Type: fromProtoEventType(resp.Type), Node: nodes[0]}); err != nil {
+			return err
 		}
 	}
+}
 
-	resp, err := c.membershipClient.PullPushState(ctx, req)
-	if err != nil {
-		return nil, err
+func fromProtoEventType(t proto.EventType) nodeapi.NodeEventType {
+	switch t {
+	case proto.EventType_NODE_SUSPECT:
+		return nodeapi.NodeEventSuspect
+	case proto.EventType_NODE_UNHEALTHY:
+		return nodeapi.NodeEventUnhealthy
+	case proto.EventType_NODE_RECOVERED:
+		return nodeapi.NodeEventRecovered
+	case proto.EventType_NODE_LEFT:
+		return nodeapi.NodeEventLeft
+	case proto.EventType_NODE_UPDATED:
+		return nodeapi.NodeEventUpdated
+	default:
+		return nodeapi.NodeEventJoined
 	}
-	nodes = make([]nodeapi.NodeInfo, len(resp.Nodes))
-	for idx, n := range resp.Nodes {
-		nodes[idx] = nodeapi.NodeInfo{
-			ID:    nodeapi.NodeID(n.Id),
-			Name:  n.Name,
-			Gen:   n.Generation,
-			Addr:  n.Address,
-			RunID: n.RunId,
-			Error: n.Error,
+}
+
+func toProtoNodes(nodes []nodeapi.NodeInfo) []*proto.Node {
+	res := make([]*proto.Node, len(nodes))
+
+	for idx, n := range nodes {
+		res[idx] = &proto.Node{
+			Id:          uint32(n.ID),
+			Name:        n.Name,
+			Address:     n.Addr,
+			Generation:  n.Gen,
+			Error:       n.Error,
+			RunId:       n.RunID,
+			Status:      toProtoStatus(n.Status),
+			Incarnation: n.Incarnation,
+			Tags:        n.Tags,
 		}
+	}
+
+	return res
+}
+
+func fromProtoNodes(nodes []*proto.Node) []nodeapi.NodeInfo {
+	res := make([]nodeapi.NodeInfo, len(nodes))
+
+	for idx, n := range nodes {
+		res[idx] = nodeapi.NodeInfo{
+			ID:     nodeapi.NodeID(n.Id),
+			Name:   n.Name,
+			Gen:    n.Generation,
+			Addr:   n.Address,
+			RunID:  n.RunId,
+			Error:  n.Error,
+			Status: fromProtoStatus(n.Status),
 
-		switch n.Status {
-		case proto.Status_HEALTHY:
-			nodes[idx].Status = nodeapi.NodeStatusHealthy
-		case proto.Status_UNHEALTHY:
-			nodes[idx].Status = nodeapi.NodeStatusUnhealthy
-		case proto.Status_LEFT:
-			nodes[idx].Status = nodeapi.NodeStatusLeft
+			Incarnation: n.Incarnation,
+			Tags:        n.Tags,
 		}
 	}
 
-	return nodes, nil
+	return res
 }
@@ -14,30 +14,46 @@ import (
 )
 
 func Dial(ctx context.Context, addr string) (nodeapi.Client, error) {
+	return DialWithOptions(ctx, addr)
+}
+
+// NewDialer returns a dialer that establishes connections with the extra
+// options, e.g. a custom transport or interceptors.
+func NewDialer(extra ...grpc.DialOption) nodeapi.Dialer {
+	return func(ctx context.Context, addr string) (nodeapi.Client, error) {
+		return DialWithOptions(ctx, addr, extra...)
+	}
+}
+
+// DialWithOptions connects to the node at the address, applying the extra
+// options on top of the default ones.
+func DialWithOptions(ctx context.Context, addr string, extra ...grpc.DialOption) (nodeapi.Client, error) {
 	creds := insecure.NewCredentials()
 
-	conn, err := grpc.DialContext(
-		ctx,
-		addr,
+	opts := append([]grpc.DialOption{
 		grpc.WithBlock(),
 		grpc.WithTransportCredentials(creds),
 		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
-	)
+	}, extra...)
+
+	conn, err := grpc.DialContext(ctx, addr, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("grpc dial failed: %w", err)
 	}
 
-	storageClient := storagepb.NewStorageServiceClient(conn)
-	replicationClient := replicationpb.NewReplicationClient(conn)
-	membershipClient := membershippb.NewMembershipClient(conn)
-
-	c := &Client{
-		storageClient:     storageClient,
-		replicationClient: replicationClient,
-		membershipClient:  membershipClient,
-	}
-
+	c := NewClient(conn)
 	c.addOnCloseHook(conn.Close)
 
 	return c, nil
 }
+
+// NewClient returns a client making the calls over the connection, which may
+// also be an in-process transport. Closing the client does not close the
+// connection.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{
+		storageClient:     storagepb.NewStorageServiceClient(conn),
+		replicationClient: replicationpb.NewReplicationClient(conn),
+		membershipClient:  membershippb.NewMembershipClient(conn),
+	}
+}
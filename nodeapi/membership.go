@@ -13,6 +13,8 @@ const (
 	NodeStatusHealthy NodeStatus = iota + 1
 	NodeStatusUnhealthy
 	NodeStatusLeft
+	NodeStatusJoining
+	NodeStatusSuspect
 )
 
 type NodeInfo struct {
@@ -23,19 +25,59 @@ type NodeInfo struct {
 	Gen    uint32
 	Error  string
 	RunID  int64
+
+	Incarnation uint32
+	Tags        map[string]string
+}
+
+type NodeEventType uint8
+
+const (
+	NodeEventJoined NodeEventType = iota + 1
+	NodeEventSuspect
+	NodeEventUnhealthy
+	NodeEventRecovered
+	NodeEventLeft
+	NodeEventUpdated
+)
+
+// NodeEvent is a change of the cluster topology observed by the remote node.
+type NodeEvent struct {
+	Type NodeEventType
+	Node NodeInfo
 }
 
+// NodeEventFunc is called for every event received by WatchNodes. Returning an
+// error stops the watch.
+type NodeEventFunc func(event NodeEvent) error
+
 type PingResult struct {
 	Took    time.Duration
 	Status  NodeStatus
 	Message string
 }
 
+// PingAck is the response to a ping.
+type PingAck struct {
+	// Digest is the digest of the cluster state of the remote node.
+	Digest []byte
+	// Updates are the recent membership updates piggybacked on the response.
+	Updates []NodeInfo
+}
+
 type membershipClient interface {
-	// Ping returns the hash of the cluster state.
-	Ping(ctx context.Context) (uint64, error)
+	// Ping checks that the remote node is alive. The recent membership updates
+	// known to the source node are piggybacked on the ping, and the remote node
+	// responds with its own updates and the digest of its state.
+	Ping(ctx context.Context, sourceID NodeID, updates []NodeInfo) (PingAck, error)
 	// PullPushState exchanges the state of the cluster with the remote node.
 	PullPushState(ctx context.Context, nodes []NodeInfo) ([]NodeInfo, error)
 	// PingIndirect pings the target node indirectly via the current node.
 	PingIndirect(ctx context.Context, target NodeID, timeout time.Duration) (PingResult, error)
+	// WatchNodes streams the topology changes observed by the remote node until
+	// the context is canceled or the callback returns an error. If snapshot is
+	// set, the current nodes are delivered first as joined events. A watch that
+	// does not keep up with the events fails with the Aborted code, and is to be
+	// made again with a snapshot.
+	WatchNodes(ctx context.Context, snapshot bool, fn NodeEventFunc) error
 }
\ No newline at end of file
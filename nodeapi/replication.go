@@ -3,12 +3,31 @@ package nodeapi
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 )
 
 var (
 	ErrVersionConflict = errors.New("version conflict")
 )
 
+// KeyOpts are the optional parameters of the replication requests.
+type KeyOpts struct {
+	// Namespace is the namespace of the key. Empty means the default namespace.
+	Namespace string
+	// Level is the name of the consistency level, such as "quorum". Empty means
+	// the default level of the namespace.
+	Level string
+	// SessionToken is the token returned by the last write of the session. A
+	// read with the token only returns the values that have seen the last write
+	// of the key made in the session, if any, and a write with the token returns
+	// the token covering the earlier writes of the session as well.
+	SessionToken string
+	// TTL is the lifetime of the value written, overriding the TTL of the
+	// namespace. Zero means the TTL of the namespace.
+	TTL time.Duration
+}
+
 type GetKeyResult struct {
 	Values       [][]byte
 	Version      string
@@ -18,18 +37,73 @@ type GetKeyResult struct {
 type PutKeyResult struct {
 	Version      string
 	Acknowledged int
+	// SessionToken is to be passed to the next reads and writes of the session,
+	// so that the reads see this write and the earlier ones of the session.
+	SessionToken string
 }
 
 type DeleteKeyResult struct {
 	Version      string
 	Acknowledged int
+	// SessionToken is to be passed to the next reads and writes of the session,
+	// so that the reads see this deletion and the earlier writes of the session.
+	SessionToken string
+}
+
+// IndexQuery selects the keys of a namespace by the value of an indexed field.
+type IndexQuery struct {
+	// Namespace is the namespace of the keys. Empty means the default namespace.
+	Namespace string
+	// Field is the indexed field, with the nested fields separated by dots.
+	Field string
+	// Value is the JSON value the field is equal to. If not set, the field is
+	// matched against the inclusive range between the JSON values Min and Max,
+	// either of which may be omitted.
+	Value []byte
+	Min   []byte
+	Max   []byte
+	// Limit is the maximum number of keys returned. Zero means the default limit.
+	Limit int
+}
+
+type IndexItem struct {
+	Key     string
+	Values  [][]byte
+	Version string
 }
 
+type QueryIndexResult struct {
+	Items []IndexItem
+}
+
+// KeyPart is a part of a value streamed by GetKeyStream.
+type KeyPart struct {
+	// Index is the number of the concurrent value the data belongs to.
+	Index int
+	Data  []byte
+	// Version and Count, the number of the values, are only set in the first
+	// part. A key without values is streamed as a single empty part.
+	Version string
+	Count   int
+}
+
+// KeyPartFunc is called for every part of the value received by GetKeyStream.
+// Returning an error stops the stream.
+type KeyPartFunc func(part KeyPart) error
+
 type replicationClient interface {
 	// GetKey returns the value of the key and the version of the key.
-	GetKey(ctx context.Context, key string) (*GetKeyResult, error)
+	GetKey(ctx context.Context, key string, opts KeyOpts) (*GetKeyResult, error)
 	// PutKey puts the value of the key and returns the new version of the key.
-	PutKey(ctx context.Context, key string, value []byte, version string) (*PutKeyResult, error)
+	PutKey(ctx context.Context, key string, value []byte, version string, opts KeyOpts) (*PutKeyResult, error)
 	// DeleteKey deletes the value associated with the key and returns the new version of the key.
-	DeleteKey(ctx context.Context, key string, version string) (*DeleteKeyResult, error)
+	DeleteKey(ctx context.Context, key string, version string, opts KeyOpts) (*DeleteKeyResult, error)
+	// QueryIndex returns the keys whose indexed field matches the query, ordered
+	// by the value of the field.
+	QueryIndex(ctx context.Context, query IndexQuery) (*QueryIndexResult, error)
+	// PutKeyStream puts the value read from the reader, which may exceed the
+	// message size limit, as the large values are stored in chunks.
+	PutKeyStream(ctx context.Context, key string, value io.Reader, version string, opts KeyOpts) (*PutKeyResult, error)
+	// GetKeyStream streams the values of the key in parts.
+	GetKeyStream(ctx context.Context, key string, opts KeyOpts, fn KeyPartFunc) error
 }
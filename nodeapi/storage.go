@@ -6,6 +6,9 @@ type VersionedValue struct {
 	Version   string
 	Data      []byte
 	Tombstone bool
+	ExpiresAt int64
+	// Chunked is set if the data is the manifest of a value stored in chunks.
+	Chunked bool
 }
 
 type StorageGetResult struct {
@@ -16,7 +19,61 @@ type StoragePutResult struct {
 	Version string
 }
 
+type NamespaceUsage struct {
+	Keys  int64
+	Bytes int64
+}
+
+type StorageUsageResult struct {
+	Namespaces map[string]NamespaceUsage
+}
+
+type StorageScanItem struct {
+	Key      string
+	Versions []VersionedValue
+}
+
+type StorageQueryIndexResult struct {
+	Items []StorageScanItem
+}
+
+type StorageChunkRefsResult struct {
+	Hashes []string
+}
+
+// Placement describes the replica sets of the keys in a cluster made of the
+// nodes, all of them healthy.
+type Placement struct {
+	Nodes []PlacementNode
+	// ReplicationFactors are the replication factors of the namespaces. The
+	// keys of the other namespaces are placed as those of the default one.
+	ReplicationFactors map[string]int
+}
+
+type PlacementNode struct {
+	ID   uint32
+	Tags map[string]string
+}
+
+// StorageScanFunc is called for each key received during a scan. Returning an
+// error stops the scan, and the error is returned to the caller of StorageScan.
+type StorageScanFunc func(item StorageScanItem) error
+
 type storageClient interface {
 	StorageGet(ctx context.Context, key string) (*StorageGetResult, error)
 	StoragePut(ctx context.Context, key string, value VersionedValue, primary bool) (*StoragePutResult, error)
+	StorageUsage(ctx context.Context) (*StorageUsageResult, error)
+	// StorageScan streams the keys stored on the node in lexicographical order,
+	// starting from the given key.
+	StorageScan(ctx context.Context, startKey string, fn StorageScanFunc) error
+	// StorageScanReplica streams the keys stored on the node of which the node
+	// with the given ID would be a replica within the placement, so that only
+	// they are sent over the network.
+	StorageScanReplica(ctx context.Context, nodeID uint32, placement Placement, fn StorageScanFunc) error
+	// StorageQueryIndex returns the storage keys of the node matching the query,
+	// with all versions of the keys.
+	StorageQueryIndex(ctx context.Context, query IndexQuery) (*StorageQueryIndexResult, error)
+	// StorageChunkRefs returns the hashes of the chunks referred to by the values
+	// stored on the node.
+	StorageChunkRefs(ctx context.Context) (*StorageChunkRefsResult, error)
 }
@@ -0,0 +1,210 @@
+package rebalance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/storage"
+)
+
+const (
+	bootstrapMinBackoff = time.Second
+	bootstrapMaxBackoff = 30 * time.Second
+)
+
+// Bootstrapper fills the storage of a node that has just joined the cluster. The
+// node stays in the joining state, and is therefore excluded from replica sets,
+// until it has pulled all keys it is responsible for from the existing replicas.
+type Bootstrapper struct {
+	cluster    membership.Cluster
+	engine     storage.Engine
+	namespaces *namespace.Registry
+	logger     kitlog.Logger
+}
+
+func NewBootstrapper(cluster membership.Cluster, engine storage.Engine, namespaces *namespace.Registry, logger kitlog.Logger) *Bootstrapper {
+	return &Bootstrapper{
+		cluster:    cluster,
+		engine:     engine,
+		namespaces: namespaces,
+		logger:     kitlog.With(logger, "component", "bootstrap"),
+	}
+}
+
+// Run waits until the node discovers at least one other healthy node, pulls the
+// data from the cluster and marks the node ready. Failed attempts are retried
+// with exponential backoff until the context is canceled.
+//
+// While the node is joining, the coordinators send it the writes of the keys it
+// will be a replica of, but the ones made before they learned of the node are
+// missed. Once the data is pulled, a second pass catches up with the writes
+// made during the first one.
+func (b *Bootstrapper) Run(ctx context.Context) error {
+	if b.cluster.Self().Status != membership.StatusJoining {
+		return nil
+	}
+
+	events, cancel := b.cluster.Subscribe()
+	defer cancel()
+
+	backoff := bootstrapMinBackoff
+
+	for {
+		if !b.hasPeers() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-events:
+				continue
+			}
+		}
+
+		start := time.Now()
+		keys, err := b.pull(ctx)
+
+		var caughtUp int
+		if err == nil {
+			caughtUp, err = b.pull(ctx)
+		}
+
+		if err == nil {
+			level.Info(b.logger).Log("msg", "bootstrap complete", "keys", keys, "caught_up", caughtUp, "took", time.Since(start))
+			b.cluster.MarkReady()
+
+			return nil
+		}
+
+		level.Warn(b.logger).Log("msg", "bootstrap failed, retrying", "err", err, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > bootstrapMaxBackoff {
+			backoff = bootstrapMaxBackoff
+		}
+	}
+}
+
+func (b *Bootstrapper) hasPeers() bool {
+	for _, node := range b.cluster.Nodes() {
+		if node.ID != b.cluster.SelfID() && node.IsReachable() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pull streams from every healthy node the keys that the local node will be a
+// replica of once it becomes healthy, and stores them. Each node is scanned,
+// since with a partial replication factor no single node has all the keys, but
+// the nodes only send the keys of the local node. It returns the number of keys
+// of which a new version was stored.
+func (b *Bootstrapper) pull(ctx context.Context) (int, error) {
+	var (
+		selfID    = b.cluster.SelfID()
+		members   = b.cluster.Nodes()
+		placement = b.placement(members)
+		stored    int
+	)
+
+	// The scans must not starve the clients of the nodes being scanned.
+	ctx = admission.WithPriority(ctx, admission.PriorityBackground)
+
+	for _, node := range members {
+		if node.ID == selfID || !node.IsReachable() {
+			continue
+		}
+
+		level.Info(b.logger).Log("msg", "pulling data", "node_id", node.ID)
+
+		conn, err := b.cluster.ConnContext(ctx, node.ID)
+		if err != nil {
+			return stored, fmt.Errorf("connect to node %d: %w", node.ID, err)
+		}
+
+		err = conn.StorageScanReplica(ctx, uint32(selfID), placement, func(item nodeapi.StorageScanItem) error {
+			added, err := b.store(item)
+			if err != nil {
+				return err
+			}
+
+			if added {
+				stored++
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return stored, fmt.Errorf("scan node %d: %w", node.ID, err)
+		}
+	}
+
+	return stored, nil
+}
+
+// placement returns the placement of the keys once the local node is healthy,
+// as it would otherwise never be selected as a replica. The other joining nodes
+// and the nodes that have left are not replicas.
+func (b *Bootstrapper) placement(members []membership.Node) nodeapi.Placement {
+	placement := nodeapi.Placement{ReplicationFactors: make(map[string]int)}
+
+	for _, node := range members {
+		if node.ID != b.cluster.SelfID() && (node.Status == membership.StatusLeft || node.Status == membership.StatusJoining) {
+			continue
+		}
+
+		placement.Nodes = append(placement.Nodes, nodeapi.PlacementNode{ID: uint32(node.ID), Tags: node.Tags})
+	}
+
+	for _, ns := range b.namespaces.List() {
+		placement.ReplicationFactors[ns.Name] = ns.ReplicationFactor
+	}
+
+	return placement
+}
+
+// store writes the received versions to the local storage, and reports whether
+// any of them was new. Versions that are already known or superseded are
+// skipped, since the same key is received from several replicas.
+func (b *Bootstrapper) store(item nodeapi.StorageScanItem) (bool, error) {
+	added := false
+
+	for _, v := range item.Versions {
+		version, err := vclock.Decode(v.Version)
+		if err != nil {
+			return added, fmt.Errorf("invalid version of key %s: %w", item.Key, err)
+		}
+
+		err = b.engine.Put(item.Key, storage.Value{
+			Version:   version,
+			Data:      v.Data,
+			Tombstone: v.Tombstone,
+			ExpiresAt: v.ExpiresAt,
+			Chunked:   v.Chunked,
+		})
+
+		switch {
+		case err == nil:
+			added = true
+		case !errors.Is(err, storage.ErrObsolete):
+			return added, fmt.Errorf("store key %s: %w", item.Key, err)
+		}
+	}
+
+	return added, nil
+}
@@ -0,0 +1,379 @@
+package rebalance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication"
+	"github.com/sadath-12/keywave/storage"
+)
+
+const (
+	handoffParallelism = 8
+	progressLogEvery   = 10000
+)
+
+var (
+	// ErrAlreadyRunning is returned when a decommission is already in progress.
+	ErrAlreadyRunning = errors.New("decommission already in progress")
+	// ErrHandoffFailed is returned when some keys could not be transferred.
+	ErrHandoffFailed = errors.New("some keys were not handed off")
+)
+
+// State is the state of the decommission workflow.
+type State string
+
+const (
+	StateIdle       State = "idle"
+	StateStreaming  State = "streaming"
+	StateCatchingUp State = "catching_up"
+	StateLeaving    State = "leaving"
+	StateDone       State = "done"
+	StateFailed     State = "failed"
+)
+
+// Progress is a snapshot of the decommission progress. The keys are scanned
+// once per pass, and are only counted as sent when a target has stored a new
+// version of them.
+type Progress struct {
+	State       State
+	KeysScanned int64
+	KeysSent    int64
+	Failed      int64
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	Error       string
+}
+
+// Decommissioner transfers the data of the local node to the nodes that take
+// over its replicas, and makes the node leave the cluster once the transfer is
+// complete. The node keeps accepting writes while the data is streamed, so the
+// storage is then made read-only, if it supports it, and a second pass hands
+// off the writes made during the first one. The writes sent to the node after
+// that fail, instead of being lost once it leaves.
+type Decommissioner struct {
+	cluster    membership.Cluster
+	engine     storage.Scannable
+	namespaces *namespace.Registry
+	logger     kitlog.Logger
+
+	mut      sync.Mutex
+	progress Progress
+	scanned  atomic.Int64
+	sent     atomic.Int64
+	failed   atomic.Int64
+}
+
+func NewDecommissioner(cluster membership.Cluster, engine storage.Scannable, namespaces *namespace.Registry, logger kitlog.Logger) *Decommissioner {
+	return &Decommissioner{
+		cluster:    cluster,
+		engine:     engine,
+		namespaces: namespaces,
+		logger:     kitlog.With(logger, "component", "decommission"),
+		progress:   Progress{State: StateIdle},
+	}
+}
+
+// Progress returns the current progress of the decommission.
+func (d *Decommissioner) Progress() Progress {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	p := d.progress
+	p.KeysScanned = d.scanned.Load()
+	p.KeysSent = d.sent.Load()
+	p.Failed = d.failed.Load()
+
+	return p
+}
+
+func (d *Decommissioner) setState(state State, err error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.progress.State = state
+
+	if err != nil {
+		d.progress.Error = err.Error()
+	}
+
+	if state == StateDone || state == StateFailed {
+		d.progress.FinishedAt = d.cluster.Env().Clock.Now()
+	}
+}
+
+// begin resets the progress and moves the workflow into the streaming state.
+func (d *Decommissioner) begin() error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	switch d.progress.State {
+	case StateStreaming, StateCatchingUp, StateLeaving, StateDone:
+		return ErrAlreadyRunning
+	}
+
+	d.scanned.Store(0)
+	d.sent.Store(0)
+	d.failed.Store(0)
+	d.progress = Progress{
+		State:     StateStreaming,
+		StartedAt: d.cluster.Env().Clock.Now(),
+	}
+
+	return nil
+}
+
+// Start launches the decommission in background. The context controls the whole
+// workflow, not just the call. It fails if another decommission is running or
+// has already completed. A failed decommission can be started again.
+func (d *Decommissioner) Start(ctx context.Context) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := d.run(ctx); err != nil {
+			level.Error(d.logger).Log("msg", "decommission failed", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// Run performs the decommission synchronously.
+func (d *Decommissioner) Run(ctx context.Context) error {
+	if err := d.begin(); err != nil {
+		return err
+	}
+
+	return d.run(ctx)
+}
+
+func (d *Decommissioner) run(ctx context.Context) error {
+	level.Info(d.logger).Log("msg", "streaming data to the new replicas")
+
+	if err := d.handoff(ctx); err != nil {
+		d.setState(StateFailed, err)
+		return err
+	}
+
+	sent := d.sent.Load()
+
+	if st, ok := d.engine.(storage.Freezable); ok {
+		d.setState(StateCatchingUp, nil)
+		st.SetReadOnly(true)
+
+		if err := d.handoff(ctx); err != nil {
+			// The node keeps serving the writes until it is decommissioned again.
+			st.SetReadOnly(false)
+			d.setState(StateFailed, err)
+
+			return err
+		}
+	}
+
+	level.Info(d.logger).Log("msg", "handoff complete, leaving the cluster",
+		"keys_scanned", d.scanned.Load(), "keys_sent", sent, "caught_up", d.sent.Load()-sent)
+
+	d.setState(StateLeaving, nil)
+
+	if err := d.cluster.Leave(ctx); err != nil {
+		err = fmt.Errorf("leave: %w", err)
+		d.setState(StateFailed, err)
+
+		return err
+	}
+
+	d.setState(StateDone, nil)
+
+	return nil
+}
+
+// handoff streams every local key to the nodes that will become its replicas
+// once the local node leaves the cluster. The keys already handed off are sent
+// again, but are not stored twice.
+func (d *Decommissioner) handoff(ctx context.Context) error {
+	var (
+		selfID  = d.cluster.SelfID()
+		members = d.cluster.Nodes()
+		others  = make([]membership.Node, 0, len(members))
+	)
+
+	for _, node := range members {
+		if node.ID != selfID {
+			others = append(others, node)
+		}
+	}
+
+	// The handoff must not starve the clients of the nodes taking the keys. The
+	// failed keys are counted rather than stopping the group, whose context
+	// would be canceled once it is waited for.
+	var errg errgroup.Group
+	errg.SetLimit(handoffParallelism)
+
+	ctx = admission.WithPriority(ctx, admission.PriorityBackground)
+
+	it := d.engine.Scan("")
+
+	for {
+		if err := it.Next(); err != nil {
+			if errors.Is(err, storage.ErrNoMoreItems) {
+				break
+			}
+
+			return fmt.Errorf("scan: %w", err)
+		}
+
+		key, values := it.Item()
+
+		if n := d.scanned.Add(1); n%progressLogEvery == 0 {
+			level.Info(d.logger).Log("msg", "handoff in progress", "keys_scanned", n, "keys_sent", d.sent.Load())
+		}
+
+		rf := d.replicationFactor(key)
+		targets := newOwners(members, others, key, rf, selfID)
+
+		if len(targets) == 0 {
+			continue
+		}
+
+		errg.Go(func() error {
+			stored, err := d.transfer(ctx, key, values, targets)
+			if err != nil {
+				d.failed.Add(1)
+
+				level.Warn(d.logger).Log("msg", "failed to hand off key", "key", key, "err", err)
+
+				return nil
+			}
+
+			if stored {
+				d.sent.Add(1)
+			}
+
+			return nil
+		})
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if d.failed.Load() > 0 {
+		return ErrHandoffFailed
+	}
+
+	return nil
+}
+
+func (d *Decommissioner) replicationFactor(key string) int {
+	name, _, _ := namespace.SplitKey(key)
+
+	ns, err := d.namespaces.Get(name)
+	if err != nil {
+		ns, _ = d.namespaces.Get(namespace.Default)
+	}
+
+	return ns.ReplicationFactor
+}
+
+// newOwners returns the nodes that become replicas of the key once the local
+// node is gone, and that are not replicas of the key already.
+func newOwners(members, others []membership.Node, key string, rf int, selfID membership.NodeID) []membership.Node {
+	var (
+		owned   bool
+		current = make(map[membership.NodeID]struct{})
+		res     []membership.Node
+	)
+
+	for _, node := range replication.ReplicaSet(members, key, rf) {
+		current[node.ID] = struct{}{}
+
+		if node.ID == selfID {
+			owned = true
+		}
+	}
+
+	if !owned {
+		return nil
+	}
+
+	for _, node := range replication.ReplicaSet(others, key, rf) {
+		if _, ok := current[node.ID]; !ok {
+			res = append(res, node)
+		}
+	}
+
+	return res
+}
+
+// transfer writes all versions of the key to the target nodes, and reports
+// whether any of them has stored a new version.
+func (d *Decommissioner) transfer(ctx context.Context, key string, values []storage.Value, targets []membership.Node) (bool, error) {
+	var stored bool
+
+	for _, target := range targets {
+		conn, err := d.cluster.ConnContext(ctx, target.ID)
+		if err != nil {
+			return stored, fmt.Errorf("connect to node %d: %w", target.ID, err)
+		}
+
+		added, err := putVersions(ctx, conn, key, values)
+		if err != nil {
+			return stored, fmt.Errorf("node %d: %w", target.ID, err)
+		}
+
+		stored = stored || added
+	}
+
+	return stored, nil
+}
+
+// putVersions writes the versions of the key as-is, so that the vector clocks are
+// preserved. Versions already superseded on the remote node are skipped. It
+// reports whether any version was stored.
+func putVersions(ctx context.Context, conn nodeapi.Client, key string, values []storage.Value) (bool, error) {
+	var stored bool
+
+	for _, v := range values {
+		_, err := conn.StoragePut(ctx, key, nodeapi.VersionedValue{
+			Version:   vclock.Encode(v.Version),
+			Data:      v.Data,
+			Tombstone: v.Tombstone,
+			ExpiresAt: v.ExpiresAt,
+			Chunked:   v.Chunked,
+		}, false)
+
+		switch {
+		case err == nil:
+			stored = true
+		case grpcutil.ErrorCode(err) != codes.AlreadyExists:
+			return stored, err
+		}
+	}
+
+	return stored, nil
+}
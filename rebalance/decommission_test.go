@@ -0,0 +1,114 @@
+package rebalance_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/rebalance"
+	"github.com/sadath-12/keywave/replication/consistency"
+	"github.com/sadath-12/keywave/replication/service"
+	"github.com/sadath-12/keywave/storage"
+)
+
+func TestDecommission(t *testing.T) {
+	// With a single replica, a key written to the leaving node only survives if
+	// it is handed off.
+	namespaces := namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{
+		Name:              "data",
+		ReadLevel:         consistency.One,
+		WriteLevel:        consistency.One,
+		ReplicationFactor: 1,
+	})
+
+	h := clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+	ctx := context.Background()
+	opts := nodeapi.KeyOpts{Namespace: "data"}
+
+	var written []string
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if _, err := h.Node(1).Client().PutKey(ctx, key, []byte(key), "", opts); err != nil {
+			t.Fatal(err)
+		}
+
+		written = append(written, key)
+	}
+
+	// The writes accepted while the node is decommissioned are handed off as
+	// well. The ones made once it no longer accepts them fail.
+	var (
+		stop     = make(chan struct{})
+		accepted = make(chan []string)
+	)
+
+	go func() {
+		var keys []string
+
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				accepted <- keys
+				return
+			default:
+			}
+
+			key := fmt.Sprintf("late-%d", i)
+			if _, err := h.Node(1).Client().PutKey(ctx, key, []byte(key), "", opts); err == nil {
+				keys = append(keys, key)
+			}
+		}
+	}()
+
+	node := h.Node(3)
+	decommissioner := rebalance.NewDecommissioner(node.Cluster, node.Engine.(storage.Scannable), namespaces, kitlog.NewNopLogger())
+
+	err := decommissioner.Run(ctx)
+
+	close(stop)
+	written = append(written, <-accepted...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := decommissioner.Progress(); p.State != rebalance.StateDone || p.KeysSent == 0 || p.FinishedAt.IsZero() {
+		t.Errorf("unexpected progress %+v", p)
+	}
+
+	if err := decommissioner.Run(ctx); err != rebalance.ErrAlreadyRunning {
+		t.Errorf("expected ErrAlreadyRunning, got %v", err)
+	}
+
+	err = h.Eventually(5*time.Second, func() error {
+		for _, observer := range []membership.NodeID{1, 2} {
+			if status, ok := h.Status(observer, 3); ok && status != membership.StatusLeft {
+				return fmt.Errorf("node %d sees node 3 as %s", observer, status)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range written {
+		res, err := h.Node(2).Client().GetKey(ctx, key, opts)
+		if err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+
+		if len(res.Values) != 1 || string(res.Values[0]) != key {
+			t.Errorf("key %s was lost", key)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package replication
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sadath-12/keywave/membership"
+)
+
+const (
+	// latencyWindow is the number of the recent replies the percentiles are
+	// computed over.
+	latencyWindow = 256
+	// minLatencySamples is the number of the replies needed before the
+	// percentiles of the node are trusted.
+	minLatencySamples = 20
+)
+
+// SpeculativeRetry is the policy of sending the request to a spare node when a
+// node is slow to reply. The zero value disables the speculation, in which
+// case the request is sent to all nodes at once.
+type SpeculativeRetry struct {
+	// Percentile of the recent latencies of the node after which the reply is
+	// considered late, e.g. 99.
+	Percentile float64
+	// Delay after which the reply is considered late, if the percentile is not
+	// set or not enough latencies of the node are known yet.
+	Delay time.Duration
+}
+
+// ParseSpeculativeRetry parses the policy, which is either "none", a percentile
+// such as "99p" or a fixed delay such as "50ms".
+func ParseSpeculativeRetry(s string) (SpeculativeRetry, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "none" {
+		return SpeculativeRetry{}, nil
+	}
+
+	if p, ok := strings.CutSuffix(s, "p"); ok {
+		percentile, err := strconv.ParseFloat(p, 64)
+		if err != nil || percentile <= 0 || percentile > 100 {
+			return SpeculativeRetry{}, fmt.Errorf("invalid percentile %q", s)
+		}
+
+		return SpeculativeRetry{Percentile: percentile}, nil
+	}
+
+	delay, err := time.ParseDuration(s)
+	if err != nil || delay <= 0 {
+		return SpeculativeRetry{}, fmt.Errorf("invalid speculative retry policy %q", s)
+	}
+
+	return SpeculativeRetry{Delay: delay}, nil
+}
+
+// Enabled returns true if the policy allows the speculative requests.
+func (r SpeculativeRetry) Enabled() bool {
+	return r.Percentile > 0 || r.Delay > 0
+}
+
+func (r SpeculativeRetry) String() string {
+	switch {
+	case r.Percentile > 0:
+		return strconv.FormatFloat(r.Percentile, 'f', -1, 64) + "p"
+	case r.Delay > 0:
+		return r.Delay.String()
+	default:
+		return "none"
+	}
+}
+
+// Latencies keeps the recent latencies of the replies of every node.
+type Latencies struct {
+	mut   sync.Mutex
+	nodes map[membership.NodeID]*latencies
+}
+
+type latencies struct {
+	samples [latencyWindow]time.Duration
+	count   int
+}
+
+func NewLatencies() *Latencies {
+	return &Latencies{
+		nodes: make(map[membership.NodeID]*latencies),
+	}
+}
+
+// Record adds the latency of a reply of the node.
+func (l *Latencies) Record(id membership.NodeID, d time.Duration) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	node, ok := l.nodes[id]
+	if !ok {
+		node = &latencies{}
+		l.nodes[id] = node
+	}
+
+	node.samples[node.count%latencyWindow] = d
+	node.count++
+}
+
+// Percentile returns the percentile of the recent latencies of the node. It
+// returns false if too few replies of the node have been recorded.
+func (l *Latencies) Percentile(id membership.NodeID, p float64) (time.Duration, bool) {
+	l.mut.Lock()
+
+	node, ok := l.nodes[id]
+	if !ok || node.count < minLatencySamples {
+		l.mut.Unlock()
+		return 0, false
+	}
+
+	samples := slices.Clone(node.samples[:min(node.count, latencyWindow)])
+
+	l.mut.Unlock()
+
+	slices.Sort(samples)
+
+	idx := int(math.Ceil(p/100*float64(len(samples)))) - 1
+
+	return samples[min(max(idx, 0), len(samples)-1)], true
+}
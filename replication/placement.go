@@ -0,0 +1,169 @@
+package replication
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+
+	"github.com/sadath-12/keywave/membership"
+)
+
+// ReplicaSet returns the nodes responsible for storing the key, ordered by
+// preference. The placement uses rendezvous hashing, so that adding or removing
+// a node only moves the keys owned by that node. Nodes that have left the cluster
+// or are still joining it are never selected, while unreachable nodes keep their
+// place so that the replica set does not change during a temporary failure. If n
+// is zero or exceeds the number of nodes, all nodes are returned.
+//
+// The replicas are spread across distinct zones, and then across distinct racks
+// within a zone, as long as there are enough of them. Without the zone and rack
+// tags, the nodes are simply taken in the order of their scores.
+func ReplicaSet(nodes []membership.Node, key string, n int) []membership.Node {
+	type scored struct {
+		node  membership.Node
+		score uint64
+	}
+
+	var (
+		candidates = make([]scored, 0, len(nodes))
+		tagged     bool
+	)
+
+	for _, node := range nodes {
+		if node.Status == membership.StatusLeft || node.Status == membership.StatusJoining {
+			continue
+		}
+
+		if node.Tag(membership.TagZone) != "" || node.Tag(membership.TagRack) != "" {
+			tagged = true
+		}
+
+		candidates = append(candidates, scored{
+			node:  node,
+			score: placementScore(node.ID, key),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score == candidates[j].score {
+			return candidates[i].node.ID < candidates[j].node.ID
+		}
+
+		return candidates[i].score > candidates[j].score
+	})
+
+	if n <= 0 || n > len(candidates) {
+		n = len(candidates)
+	}
+
+	res := make([]membership.Node, 0, n)
+
+	if !tagged {
+		for i := 0; i < n; i++ {
+			res = append(res, candidates[i].node)
+		}
+
+		return res
+	}
+
+	var (
+		zones  = make(map[string]int)
+		racks  = make(map[[2]string]int)
+		picked = make([]bool, len(candidates))
+	)
+
+	// Each slot is taken by the highest scored node among those in the least used
+	// zone, and then in the least used rack of that zone.
+	for len(res) < n {
+		best := -1
+
+		for i, c := range candidates {
+			if picked[i] {
+				continue
+			}
+
+			if best == -1 || lessUsed(c.node, candidates[best].node, zones, racks) {
+				best = i
+			}
+		}
+
+		node := candidates[best].node
+		zone := node.Tag(membership.TagZone)
+
+		picked[best] = true
+		zones[zone]++
+		racks[[2]string{zone, node.Tag(membership.TagRack)}]++
+		res = append(res, node)
+	}
+
+	return res
+}
+
+// PendingReplicas returns the joining nodes that will be replicas of the key
+// once they become healthy. The writes are sent to them as well, so that they
+// do not miss the writes made while they pull the data of the cluster.
+func PendingReplicas(nodes []membership.Node, key string, n int) []membership.Node {
+	var (
+		future  []membership.Node
+		joining = make(map[membership.NodeID]struct{})
+	)
+
+	for _, node := range nodes {
+		if node.Status == membership.StatusJoining {
+			joining[node.ID] = struct{}{}
+			node.Status = membership.StatusHealthy
+		}
+
+		future = append(future, node)
+	}
+
+	if len(joining) == 0 {
+		return nil
+	}
+
+	var res []membership.Node
+
+	for _, node := range ReplicaSet(future, key, n) {
+		if _, ok := joining[node.ID]; ok {
+			res = append(res, node)
+		}
+	}
+
+	return res
+}
+
+// lessUsed reports whether the zone and the rack of node a hold fewer replicas
+// than those of node b.
+func lessUsed(a, b membership.Node, zones map[string]int, racks map[[2]string]int) bool {
+	za, zb := a.Tag(membership.TagZone), b.Tag(membership.TagZone)
+	if zones[za] != zones[zb] {
+		return zones[za] < zones[zb]
+	}
+
+	ra := racks[[2]string{za, a.Tag(membership.TagRack)}]
+	rb := racks[[2]string{zb, b.Tag(membership.TagRack)}]
+
+	return ra < rb
+}
+
+// placementScore returns the weight of the node for the key.
+func placementScore(id membership.NodeID, key string) uint64 {
+	var buf [4]byte
+
+	binary.BigEndian.PutUint32(buf[:], uint32(id))
+
+	h := fnv.New64a()
+	h.Write(buf[:])
+	h.Write([]byte(key))
+
+	// FNV alone distributes similar inputs poorly, so the result is additionally
+	// mixed with the splitmix64 finalizer.
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
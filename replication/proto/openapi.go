@@ -0,0 +1,9 @@
+package proto
+
+import _ "embed"
+
+// OpenAPI is the description of the HTTP/JSON gateway of the replication API,
+// generated from the annotations of replication.proto.
+//
+//go:embed replication.swagger.json
+var OpenAPI []byte
@@ -25,6 +25,9 @@ type ReplicationClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	QueryIndex(ctx context.Context, in *QueryIndexRequest, opts ...grpc.CallOption) (*QueryIndexResponse, error)
+	PutStream(ctx context.Context, opts ...grpc.CallOption) (Replication_PutStreamClient, error)
+	GetStream(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (Replication_GetStreamClient, error)
 }
 
 type replicationClient struct {
@@ -62,6 +65,81 @@ func (c *replicationClient) Delete(ctx context.Context, in *DeleteRequest, opts
 	return out, nil
 }
 
+func (c *replicationClient) QueryIndex(ctx context.Context, in *QueryIndexRequest, opts ...grpc.CallOption) (*QueryIndexResponse, error) {
+	out := new(QueryIndexResponse)
+	err := c.cc.Invoke(ctx, "/replication.Replication/QueryIndex", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *replicationClient) PutStream(ctx context.Context, opts ...grpc.CallOption) (Replication_PutStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[0], "/replication.Replication/PutStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationPutStreamClient{stream}
+	return x, nil
+}
+
+type Replication_PutStreamClient interface {
+	Send(*PutStreamRequest) error
+	CloseAndRecv() (*PutResponse, error)
+	grpc.ClientStream
+}
+
+type replicationPutStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationPutStreamClient) Send(m *PutStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *replicationPutStreamClient) CloseAndRecv() (*PutResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *replicationClient) GetStream(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (Replication_GetStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Replication_ServiceDesc.Streams[1], "/replication.Replication/GetStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationGetStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_GetStreamClient interface {
+	Recv() (*GetStreamResponse, error)
+	grpc.ClientStream
+}
+
+type replicationGetStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationGetStreamClient) Recv() (*GetStreamResponse, error) {
+	m := new(GetStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ReplicationServer is the server API for Replication service.
 // All implementations must embed UnimplementedReplicationServer
 // for forward compatibility
@@ -69,6 +147,9 @@ type ReplicationServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Put(context.Context, *PutRequest) (*PutResponse, error)
 	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	QueryIndex(context.Context, *QueryIndexRequest) (*QueryIndexResponse, error)
+	PutStream(Replication_PutStreamServer) error
+	GetStream(*GetRequest, Replication_GetStreamServer) error
 	mustEmbedUnimplementedReplicationServer()
 }
 
@@ -85,6 +166,15 @@ func (UnimplementedReplicationServer) Put(context.Context, *PutRequest) (*PutRes
 func (UnimplementedReplicationServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (UnimplementedReplicationServer) QueryIndex(context.Context, *QueryIndexRequest) (*QueryIndexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryIndex not implemented")
+}
+func (UnimplementedReplicationServer) PutStream(Replication_PutStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutStream not implemented")
+}
+func (UnimplementedReplicationServer) GetStream(*GetRequest, Replication_GetStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetStream not implemented")
+}
 func (UnimplementedReplicationServer) mustEmbedUnimplementedReplicationServer() {}
 
 // UnsafeReplicationServer may be embedded to opt out of forward compatibility for this service.
@@ -152,6 +242,71 @@ func _Replication_Delete_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Replication_QueryIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryIndexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).QueryIndex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/replication.Replication/QueryIndex",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).QueryIndex(ctx, req.(*QueryIndexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Replication_PutStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ReplicationServer).PutStream(&replicationPutStreamServer{stream})
+}
+
+type Replication_PutStreamServer interface {
+	SendAndClose(*PutResponse) error
+	Recv() (*PutStreamRequest, error)
+	grpc.ServerStream
+}
+
+type replicationPutStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationPutStreamServer) SendAndClose(m *PutResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *replicationPutStreamServer) Recv() (*PutStreamRequest, error) {
+	m := new(PutStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Replication_GetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).GetStream(m, &replicationGetStreamServer{stream})
+}
+
+type Replication_GetStreamServer interface {
+	Send(*GetStreamResponse) error
+	grpc.ServerStream
+}
+
+type replicationGetStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationGetStreamServer) Send(m *GetStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Replication_ServiceDesc is the grpc.ServiceDesc for Replication service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -171,7 +326,22 @@ var Replication_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _Replication_Delete_Handler,
 		},
+		{
+			MethodName: "QueryIndex",
+			Handler:    _Replication_QueryIndex_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PutStream",
+			Handler:       _Replication_PutStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetStream",
+			Handler:       _Replication_GetStream_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "replication.proto",
 }
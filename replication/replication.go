@@ -3,12 +3,13 @@ package replication
 import (
 	"context"
 	"errors"
-	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
 	kitlog "github.com/go-kit/log"
 	loglevel "github.com/go-kit/log/level"
+	"github.com/sadath-12/keywave/internal/env"
 	"github.com/sadath-12/keywave/internal/grpcutil"
 	"github.com/sadath-12/keywave/membership"
 	"github.com/sadath-12/keywave/nodeapi"
@@ -22,6 +23,9 @@ type nodeReply[T any] struct {
 	nodeID membership.NodeID
 	err    error
 	reply  T
+	// noConn is set if the request has not been sent for the lack of the
+	// connection to the node.
+	noConn bool
 }
 
 type Opts[T any] struct {
@@ -46,6 +50,15 @@ type Opts[T any] struct {
 	// background for the remaining nodes after the minimum number of acknowledgments
 	// has been received.
 	Background bool
+	// Speculative is the policy of sending the request to a spare node when a
+	// node is slow to reply. If disabled, the request is sent to all nodes.
+	Speculative SpeculativeRetry
+	// Latencies records the latencies of the replies, and provides the
+	// percentiles to the speculative retries.
+	Latencies *Latencies
+	// Env provides the random order of the nodes and runs the requests. The
+	// missing parts default to the real environment.
+	Env env.Env
 }
 
 // MapFn is called for each node in the replica set. The function should send a
@@ -63,8 +76,9 @@ type ReduceFn[T any] func(abort func(), nodeID membership.NodeID, ret T, err err
 // for each node in the replica set, and the reduceFn is called for each reply.
 // The function blocks until the minimum number of acknowledgments has been
 // received or the operation is aborted either by canceling the context or
-// calling the abort function in the reduceFn.
-
+// calling the abort function in the reduceFn. If the speculative retries are
+// enabled, the request is only sent to as many nodes as needed, and the rest
+// of the nodes are used as spares.
 func (o Opts[T]) Distribute(ctx context.Context, mapFn MapFn[T], reduceFn ReduceFn[T]) error {
 	if o.AckedNodes == nil {
 		o.AckedNodes = make(map[membership.NodeID]struct{})
@@ -74,15 +88,22 @@ func (o Opts[T]) Distribute(ctx context.Context, mapFn MapFn[T], reduceFn Reduce
 		panic("timeout is not set")
 	}
 
-	mapCtx, cancelMap := context.WithTimeout(context.Background(), o.Timeout)
+	environ := o.Env.WithDefaults()
+
+	if o.Speculative.Enabled() {
+		return o.distributeSpeculative(ctx, environ, mapFn, reduceFn)
+	}
+
+	// The requests outlive the context, but keep its values, such as the
+	// metadata marking the requests already admitted.
+	mapCtx, cancelMap := context.WithTimeout(context.WithoutCancel(ctx), o.Timeout)
 	replies := make(chan nodeReply[T], len(o.Nodes))
 
 	wg := sync.WaitGroup{}
 	wg.Add(len(o.Nodes))
 
 	// Randomize the order of nodes to avoid sending requests to the same node first.
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	indices := rnd.Perm(len(o.Nodes))
+	indices := environ.Rand.Perm(len(o.Nodes))
 
 	for _, i := range indices {
 		member := &o.Nodes[i]
@@ -96,42 +117,22 @@ func (o Opts[T]) Distribute(ctx context.Context, mapFn MapFn[T], reduceFn Reduce
 			continue
 		}
 
-		go func(nodeID membership.NodeID) {
-			defer wg.Done()
-
-			conn, err := o.Cluster.Conn(nodeID)
-
-			if err != nil {
-				loglevel.Warn(
-					kitlog.With(o.Logger, "node_id", nodeID),
-				).Log("msg", "failed to get connection", "err", err)
-
-				return
-			}
-
-			ret, err := mapFn(mapCtx, nodeID, conn)
+		nodeID := member.ID
 
-			if err != nil {
-				if !errors.Is(err, context.Canceled) && !grpcutil.IsCanceled(err) {
-					loglevel.Warn(
-						kitlog.With(o.Logger, "node_id", nodeID),
-					).Log("msg", "failed to replicate", "err", err)
-				}
-			}
+		environ.Go(func() {
+			defer wg.Done()
 
-			replies <- nodeReply[T]{
-				nodeID: nodeID,
-				reply:  ret,
-				err:    err,
+			if reply, ok := o.call(mapCtx, environ, nodeID, mapFn); ok {
+				replies <- reply
 			}
-		}(member.ID)
+		})
 	}
 
-	go func() {
+	environ.Go(func() {
 		wg.Wait()
 		cancelMap()
 		close(replies)
-	}()
+	})
 
 	// If we already have enough replies, no need to wait for more.
 	if len(o.AckedNodes) >= o.MinAcks {
@@ -181,3 +182,234 @@ func (o Opts[T]) Distribute(ctx context.Context, mapFn MapFn[T], reduceFn Reduce
 		}
 	}
 }
+
+// call sends the request to the node and records the latency of the reply. It
+// returns false if there is no connection to the node.
+func (o Opts[T]) call(ctx context.Context, environ env.Env, nodeID membership.NodeID, mapFn MapFn[T]) (nodeReply[T], bool) {
+	conn, err := o.Cluster.Conn(nodeID)
+
+	if err != nil {
+		loglevel.Warn(
+			kitlog.With(o.Logger, "node_id", nodeID),
+		).Log("msg", "failed to get connection", "err", err)
+
+		return nodeReply[T]{nodeID: nodeID, err: err}, false
+	}
+
+	start := environ.Clock.Now()
+	ret, err := mapFn(ctx, nodeID, conn)
+
+	if err != nil {
+		if !errors.Is(err, context.Canceled) && !grpcutil.IsCanceled(err) {
+			loglevel.Warn(
+				kitlog.With(o.Logger, "node_id", nodeID),
+			).Log("msg", "failed to replicate", "err", err)
+		}
+	} else if o.Latencies != nil {
+		o.Latencies.Record(nodeID, environ.Clock.Since(start))
+	}
+
+	return nodeReply[T]{
+		nodeID: nodeID,
+		reply:  ret,
+		err:    err,
+	}, true
+}
+
+// threshold returns the time after which the reply of the node is late.
+func (o Opts[T]) threshold(nodeID membership.NodeID) (time.Duration, bool) {
+	if o.Speculative.Percentile > 0 && o.Latencies != nil {
+		if d, ok := o.Latencies.Percentile(nodeID, o.Speculative.Percentile); ok {
+			return d, true
+		}
+	}
+
+	return o.Speculative.Delay, o.Speculative.Delay > 0
+}
+
+// inflight is a request waiting for the reply.
+type inflight struct {
+	nodeID membership.NodeID
+	start  time.Time
+	// speculated is set once a spare node has been asked in place of this one.
+	speculated bool
+}
+
+// distributeSpeculative sends the request to as many nodes as needed to collect
+// the acknowledgments. Another node is asked each time a node fails, or when its
+// reply is late according to the speculative retry policy. The first replies
+// win, the remaining requests are canceled unless running in background.
+func (o Opts[T]) distributeSpeculative(ctx context.Context, environ env.Env, mapFn MapFn[T], reduceFn ReduceFn[T]) error {
+	if len(o.AckedNodes) >= o.MinAcks {
+		return nil
+	}
+
+	// The requests outlive the context, but keep its values, such as the
+	// metadata marking the requests already admitted.
+	mapCtx, cancelMap := context.WithTimeout(context.WithoutCancel(ctx), o.Timeout)
+	replies := make(chan nodeReply[T], len(o.Nodes))
+	wg := sync.WaitGroup{}
+
+	defer func() {
+		if !o.Background {
+			cancelMap()
+			return
+		}
+
+		environ.Go(func() {
+			wg.Wait()
+			cancelMap()
+		})
+	}()
+
+	// Randomize the order of nodes to avoid sending requests to the same node first.
+	var spares []membership.NodeID
+
+	for _, i := range environ.Rand.Perm(len(o.Nodes)) {
+		member := &o.Nodes[i]
+
+		if _, ok := o.AckedNodes[member.ID]; ok || !member.IsReachable() {
+			continue
+		}
+
+		spares = append(spares, member.ID)
+	}
+
+	var pending []*inflight
+
+	send := func() (membership.NodeID, bool) {
+		if len(spares) == 0 {
+			return 0, false
+		}
+
+		nodeID := spares[0]
+		spares = spares[1:]
+
+		pending = append(pending, &inflight{nodeID: nodeID, start: environ.Clock.Now()})
+
+		wg.Add(1)
+		environ.Go(func() {
+			defer wg.Done()
+
+			reply, ok := o.call(mapCtx, environ, nodeID, mapFn)
+			reply.noConn = !ok
+			replies <- reply
+		})
+
+		return nodeID, true
+	}
+
+	for i := len(o.AckedNodes); i < o.MinAcks; i++ {
+		if _, ok := send(); !ok {
+			break
+		}
+	}
+
+	var (
+		late    = make(chan struct{}, 1)
+		timer   env.Timer
+		aborted bool
+	)
+
+	abort := func() {
+		aborted = true
+		cancelMap() // nolint:wsl
+	}
+
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if len(pending) == 0 {
+			return ErrNotEnoughAcks
+		}
+
+		// Wake up when the earliest of the pending replies becomes late.
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+
+		if len(spares) > 0 {
+			var (
+				next  time.Time
+				found bool
+			)
+
+			for _, req := range pending {
+				if d, ok := o.threshold(req.nodeID); ok && !req.speculated {
+					if at := req.start.Add(d); !found || at.Before(next) {
+						next, found = at, true
+					}
+				}
+			}
+
+			if found {
+				timer = environ.Clock.AfterFunc(next.Sub(environ.Clock.Now()), func() {
+					select {
+					case late <- struct{}{}:
+					default:
+					}
+				})
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-late:
+			now := environ.Clock.Now()
+
+			for _, req := range pending {
+				d, ok := o.threshold(req.nodeID)
+				if !ok || req.speculated || now.Sub(req.start) < d {
+					continue
+				}
+
+				req.speculated = true
+
+				if nodeID, ok := send(); ok {
+					loglevel.Debug(o.Logger).Log(
+						"msg", "sending speculative request",
+						"node_id", nodeID,
+						"late_node_id", req.nodeID,
+						"threshold", d,
+					)
+				}
+			}
+		case reply := <-replies:
+			pending = slices.DeleteFunc(pending, func(req *inflight) bool {
+				return req.nodeID == reply.nodeID
+			})
+
+			// The node could not be dialed, so there is no reply to reduce.
+			if reply.noConn {
+				send()
+				continue
+			}
+
+			err := reduceFn(abort, reply.nodeID, reply.reply, reply.err)
+			if aborted {
+				return err
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if reply.err != nil {
+				send()
+				continue
+			}
+
+			o.AckedNodes[reply.nodeID] = struct{}{}
+
+			if len(o.AckedNodes) >= o.MinAcks {
+				return nil
+			}
+		}
+	}
+}
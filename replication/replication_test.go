@@ -0,0 +1,302 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// stubCluster only provides the connections, which are not used by the map
+// functions of the tests.
+type stubCluster struct {
+	membership.Cluster
+}
+
+func (stubCluster) Conn(membership.NodeID) (nodeapi.Client, error) {
+	return nil, nil
+}
+
+func nodes(n int) []membership.Node {
+	res := make([]membership.Node, n)
+	for i := range res {
+		res[i] = membership.Node{ID: membership.NodeID(i + 1), Status: membership.StatusHealthy}
+	}
+
+	return res
+}
+
+func TestSpeculativeRetry(t *testing.T) {
+	opts := Opts[int]{
+		Cluster:     stubCluster{},
+		AckedNodes:  make(map[membership.NodeID]struct{}),
+		Logger:      kitlog.NewNopLogger(),
+		Nodes:       nodes(3),
+		Timeout:     5 * time.Second,
+		MinAcks:     2,
+		Speculative: SpeculativeRetry{Delay: 20 * time.Millisecond},
+	}
+
+	start := time.Now()
+
+	err := opts.Distribute(
+		context.Background(),
+		func(ctx context.Context, id membership.NodeID, _ nodeapi.Client) (int, error) {
+			// Node 1 is slow, so it is replaced by the spare node.
+			if id == 1 {
+				<-ctx.Done()
+				return 0, ctx.Err()
+			}
+
+			return 0, nil
+		},
+		func(func(), membership.NodeID, int, error) error {
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the slow node was waited for %s", elapsed)
+	}
+
+	if _, ok := opts.AckedNodes[1]; ok || len(opts.AckedNodes) != 2 {
+		t.Errorf("unexpected acknowledgments %v", opts.AckedNodes)
+	}
+}
+
+func TestSpeculativeRetryFailover(t *testing.T) {
+	opts := Opts[int]{
+		Cluster:     stubCluster{},
+		AckedNodes:  make(map[membership.NodeID]struct{}),
+		Logger:      kitlog.NewNopLogger(),
+		Nodes:       nodes(3),
+		Timeout:     5 * time.Second,
+		MinAcks:     2,
+		Speculative: SpeculativeRetry{Percentile: 99},
+	}
+
+	err := opts.Distribute(
+		context.Background(),
+		func(_ context.Context, id membership.NodeID, _ nodeapi.Client) (int, error) {
+			if id == 1 {
+				return 0, errors.New("failed")
+			}
+
+			return 0, nil
+		},
+		func(func(), membership.NodeID, int, error) error {
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opts.AckedNodes) != 2 {
+		t.Errorf("unexpected acknowledgments %v", opts.AckedNodes)
+	}
+
+	opts.AckedNodes = make(map[membership.NodeID]struct{})
+	opts.MinAcks = 3
+
+	if err := opts.Distribute(
+		context.Background(),
+		func(_ context.Context, id membership.NodeID, _ nodeapi.Client) (int, error) {
+			if id == 1 {
+				return 0, errors.New("failed")
+			}
+
+			return 0, nil
+		},
+		func(func(), membership.NodeID, int, error) error {
+			return nil
+		},
+	); !errors.Is(err, ErrNotEnoughAcks) {
+		t.Errorf("expected ErrNotEnoughAcks, got %v", err)
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	l := NewLatencies()
+
+	for i := 1; i < minLatencySamples; i++ {
+		l.Record(1, time.Duration(i)*time.Millisecond)
+	}
+
+	if _, ok := l.Percentile(1, 99); ok {
+		t.Fatal("percentile with too few samples")
+	}
+
+	l.Record(1, 100*time.Millisecond)
+
+	if d, ok := l.Percentile(1, 50); !ok || d != 10*time.Millisecond {
+		t.Errorf("p50 = %s", d)
+	}
+
+	if d, _ := l.Percentile(1, 99); d != 100*time.Millisecond {
+		t.Errorf("p99 = %s", d)
+	}
+}
+
+func TestPendingReplicas(t *testing.T) {
+	members := nodes(5)
+
+	if pending := PendingReplicas(members, "key", 3); pending != nil {
+		t.Fatalf("expected no pending replica without a joining node, got %v", pending)
+	}
+
+	// Each joining node receives the writes of the keys it will be a replica of
+	// once healthy, and only of those.
+	members[4].Status = membership.StatusJoining
+
+	healthy := nodes(5)
+
+	for i := 0; i < 100; i++ {
+		key := string(rune('a' + i))
+
+		var future bool
+
+		for _, node := range ReplicaSet(healthy, key, 3) {
+			future = future || node.ID == 5
+		}
+
+		pending := PendingReplicas(members, key, 3)
+		if len(pending) > 1 || future != (len(pending) == 1 && pending[0].ID == 5) {
+			t.Fatalf("unexpected pending replicas of %q: %v", key, pending)
+		}
+	}
+}
+
+// topology returns the healthy nodes spread over the given zones, racks per zone
+// and nodes per rack. Without zones, the nodes are left untagged.
+func topology(zones, racks, perRack int) []membership.Node {
+	if zones == 0 {
+		return nodes(racks * perRack)
+	}
+
+	var res []membership.Node
+
+	for z := 0; z < zones; z++ {
+		for r := 0; r < racks; r++ {
+			for i := 0; i < perRack; i++ {
+				res = append(res, membership.Node{
+					ID:     membership.NodeID(len(res) + 1),
+					Status: membership.StatusHealthy,
+					Tags: map[string]string{
+						membership.TagZone: fmt.Sprintf("zone-%d", z),
+						membership.TagRack: fmt.Sprintf("rack-%d", r),
+					},
+				})
+			}
+		}
+	}
+
+	return res
+}
+
+func TestReplicaSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []membership.Node
+		n     int
+		zones int // the expected number of distinct zones
+		racks int // the expected number of distinct racks
+	}{
+		{name: "untagged", nodes: topology(0, 1, 5), n: 3, zones: 1, racks: 1},
+		{name: "distinct zones", nodes: topology(3, 2, 2), n: 3, zones: 3, racks: 3},
+		{name: "distinct racks", nodes: topology(3, 2, 2), n: 5, zones: 3, racks: 5},
+		{name: "fewer zones", nodes: topology(2, 2, 2), n: 3, zones: 2, racks: 3},
+		{name: "single rack", nodes: topology(1, 1, 4), n: 3, zones: 1, racks: 1},
+		{name: "all nodes", nodes: topology(2, 1, 1), n: 0, zones: 2, racks: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.n
+			if want == 0 {
+				want = len(tt.nodes)
+			}
+
+			for i := 0; i < 100; i++ {
+				key := fmt.Sprintf("key-%d", i)
+				replicas := ReplicaSet(tt.nodes, key, tt.n)
+
+				var (
+					ids   = make(map[membership.NodeID]struct{})
+					zones = make(map[string]struct{})
+					racks = make(map[[2]string]struct{})
+				)
+
+				for _, node := range replicas {
+					zone := node.Tag(membership.TagZone)
+
+					ids[node.ID] = struct{}{}
+					zones[zone] = struct{}{}
+					racks[[2]string{zone, node.Tag(membership.TagRack)}] = struct{}{}
+				}
+
+				if len(replicas) != want || len(ids) != want {
+					t.Fatalf("expected %d distinct replicas of %q, got %v", want, key, replicas)
+				}
+
+				// Without tags, the nodes are taken in the order of their scores.
+				for j := 1; j < len(replicas) && tt.name == "untagged"; j++ {
+					if placementScore(replicas[j-1].ID, key) < placementScore(replicas[j].ID, key) {
+						t.Fatalf("the replicas of %q are not ordered by score: %v", key, replicas)
+					}
+				}
+
+				if len(zones) != tt.zones || len(racks) != tt.racks {
+					t.Fatalf("expected the replicas of %q over %d zones and %d racks, got %d and %d",
+						key, tt.zones, tt.racks, len(zones), len(racks))
+				}
+
+				// The keys that are not placed on a new node keep their replicas.
+				added := membership.Node{
+					ID:     membership.NodeID(len(tt.nodes) + 1),
+					Status: membership.StatusHealthy,
+					Tags:   tt.nodes[0].Tags,
+				}
+
+				moved := ReplicaSet(append(tt.nodes[:len(tt.nodes):len(tt.nodes)], added), key, tt.n)
+
+				if tt.n != 0 && !containsNode(moved, added.ID) && !sameNodes(moved, replicas) {
+					t.Fatalf("the replicas of %q moved from %v to %v", key, replicas, moved)
+				}
+			}
+		})
+	}
+}
+
+func containsNode(nodes []membership.Node, id membership.NodeID) bool {
+	for _, node := range nodes {
+		if node.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sameNodes(a, b []membership.Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+
+	return true
+}
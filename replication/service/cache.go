@@ -0,0 +1,171 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sadath-12/keywave/internal/vclock"
+)
+
+// CacheStats is a snapshot of the state of the read cache.
+type CacheStats struct {
+	Entries int   `json:"entries"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+type cacheEntry struct {
+	// result is only valid if cached is set. Otherwise, the entry only holds
+	// the floor, the version of the last write of the key.
+	result    readResult
+	cached    bool
+	floor     vclock.Version
+	expiresAt time.Time
+}
+
+// readCache keeps the results of the reads of the hot keys at level one, so
+// that the coordinator serves them without reading the replicas. The entries
+// expire after the ttl, which bounds how stale the reads of the keys written
+// through the other nodes are, and are invalidated by the writes made through
+// the local node.
+type readCache struct {
+	ttl  time.Duration
+	size int
+
+	mut     sync.Mutex
+	entries map[string]*cacheEntry
+
+	hits, misses atomic.Int64
+}
+
+func newReadCache(ttl time.Duration, size int) *readCache {
+	return &readCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the result of the last read of the key, without the values that
+// have expired since.
+func (c *readCache) get(key string, now time.Time) (readResult, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !e.cached || !now.Before(e.expiresAt) {
+		c.misses.Add(1)
+		return readResult{}, false
+	}
+
+	c.hits.Add(1)
+
+	res := readResult{version: e.result.version}
+	nowMillis := now.UnixMilli()
+
+	for _, val := range e.result.values {
+		if !isExpired(val, nowMillis) {
+			res.values = append(res.values, val)
+		}
+	}
+
+	return res, true
+}
+
+// put caches the result of a read of the key, unless it has not seen the last
+// write of the key, which may have been made while the key was being read.
+func (c *readCache) put(key string, res readResult, now time.Time) {
+	version, err := vclock.Decode(res.version)
+	if err != nil {
+		return
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && now.Before(e.expiresAt) && (e.floor == nil || !descends(version, e.floor)) {
+		return
+	}
+
+	if !ok && len(c.entries) >= c.size {
+		c.removeExpired(now)
+
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+
+	c.entries[key] = &cacheEntry{
+		result:    readResult{version: res.version, values: res.values},
+		cached:    true,
+		floor:     version,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+// invalidate drops the cached result of the key unless it has seen the version
+// written. An empty version, of a write whose outcome is unknown, drops it in
+// any case. The writes of the keys neither cached nor hot are not remembered,
+// as their reads are not cached.
+func (c *readCache) invalidate(key, version string, hot bool, now time.Time) {
+	written, err := vclock.Decode(version)
+	if version == "" || err != nil {
+		written = nil
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	e, ok := c.entries[key]
+	if ok && e.cached && written != nil && descends(e.floor, written) {
+		return
+	}
+
+	if !ok && !hot {
+		return
+	}
+
+	if !ok && len(c.entries) >= c.size {
+		c.removeExpired(now)
+
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+
+	// The entry is kept without the result, so that a read that started before
+	// the write does not cache its stale result. Without the version written,
+	// no result is cached until the entry expires.
+	c.entries[key] = &cacheEntry{floor: written, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *readCache) removeExpired(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *readCache) stats() CacheStats {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	entries := 0
+
+	for _, e := range c.entries {
+		if e.cached {
+			entries++
+		}
+	}
+
+	return CacheStats{Entries: entries, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// descends reports whether the version has seen the other one.
+func descends(version, other vclock.Version) bool {
+	c := vclock.Compare(version, other)
+	return c == vclock.After || c == vclock.Equal
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/replication/proto"
+)
+
+var (
+	errChunkedValue = status.Error(codes.FailedPrecondition, "value is stored in chunks, use GetStream to read it")
+	errMissingChunk = status.Error(codes.DataLoss, "chunk of the value is missing or corrupted")
+)
+
+// PutStream writes the value received in parts. A value larger than a chunk is
+// split into chunks, which are written before the manifest replacing the value,
+// so a reader never sees a manifest referring to the missing chunks. The value
+// is charged to the quotas of its namespace as it is received, and the stream
+// is rejected before writing the chunk that would exceed them.
+func (s *ReplicationService) PutStream(stream proto.Replication_PutStreamServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errMissingKey
+		}
+
+		return err
+	}
+
+	if first.Key == "" {
+		return errMissingKey
+	}
+
+	if _, err := decodeSession(first.SessionToken); err != nil {
+		return err
+	}
+
+	ns, err := s.namespace(first.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// The context is taken once the first message is received, as it carries
+	// the identity of the client checked against the key.
+	ctx := stream.Context()
+
+	var (
+		buf      = make([]byte, 0, chunk.Size)
+		data     = first.Data
+		manifest chunk.Manifest
+	)
+
+	for {
+		// The buffer is only flushed once it overflows, so a value of up to a
+		// chunk is not split at all.
+		for len(buf)+len(data) > chunk.Size {
+			n := chunk.Size - len(buf)
+			buf = append(buf, data[:n]...)
+			data = data[n:]
+
+			if err := s.quotas.check(ctx, ns, first.Version, manifest.Size+int64(len(buf))); err != nil {
+				return err
+			}
+
+			hash, err := s.putChunk(ctx, first.Consistency, buf)
+			if err != nil {
+				return err
+			}
+
+			manifest.Chunks = append(manifest.Chunks, hash)
+			manifest.Size += int64(len(buf))
+
+			// The chunk may still be written to the remaining replicas in the
+			// background, so the buffer cannot be reused.
+			buf = make([]byte, 0, chunk.Size)
+		}
+
+		buf = append(buf, data...)
+
+		msg, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return err
+		}
+
+		data = msg.Data
+	}
+
+	req := &proto.PutRequest{
+		Key:          first.Key,
+		Namespace:    first.Namespace,
+		Version:      first.Version,
+		Consistency:  first.Consistency,
+		Ttl:          first.Ttl,
+		SessionToken: first.SessionToken,
+		Value:        &proto.Value{Data: buf},
+	}
+
+	if len(manifest.Chunks) == 0 {
+		resp, err := s.put(ctx, ns, req, false)
+		if err != nil {
+			return err
+		}
+
+		return stream.SendAndClose(resp)
+	}
+
+	if len(buf) > 0 {
+		if err := s.quotas.check(ctx, ns, first.Version, manifest.Size+int64(len(buf))); err != nil {
+			return err
+		}
+
+		hash, err := s.putChunk(ctx, first.Consistency, buf)
+		if err != nil {
+			return err
+		}
+
+		manifest.Chunks = append(manifest.Chunks, hash)
+		manifest.Size += int64(len(buf))
+	}
+
+	req.Value.Data = manifest.Encode()
+
+	resp, err := s.put(ctx, ns, req, true)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(resp)
+}
+
+// GetStream reads the value and streams it in parts, assembling the values
+// stored in chunks.
+func (s *ReplicationService) GetStream(req *proto.GetRequest, stream proto.Replication_GetStreamServer) error {
+	if err := validateGetRequest(req); err != nil {
+		return err
+	}
+
+	ns, err := s.namespace(req.Namespace)
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+
+	res, err := s.get(ctx, ns, req)
+	if err != nil {
+		return err
+	}
+
+	first := true
+	send := func(index int, data []byte) error {
+		msg := &proto.GetStreamResponse{
+			Index: int32(index),
+			Data:  data,
+		}
+
+		if first {
+			msg.Version = res.version
+			msg.Count = int32(len(res.values))
+			first = false
+		}
+
+		return stream.Send(msg)
+	}
+
+	if len(res.values) == 0 {
+		return send(0, nil)
+	}
+
+	for i, val := range res.values {
+		if !val.Chunked {
+			if err := send(i, val.Data); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		manifest, err := chunk.DecodeManifest(val.Data)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		for _, hash := range manifest.Chunks {
+			data, err := s.getChunk(ctx, req.Consistency, hash)
+			if err != nil {
+				return err
+			}
+
+			if err := send(i, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *ReplicationService) chunkNamespace() (namespace.Config, error) {
+	ns, err := s.namespaces.Get(namespace.Chunks)
+	if err != nil {
+		return ns, status.Error(codes.Internal, "chunk namespace is not configured")
+	}
+
+	return ns, nil
+}
+
+// putChunk writes the chunk, and returns its hash. A chunk already stored is
+// written again with the version superseding the one read, so that a collector
+// that found it orphaned either restarts its grace period or removes it with a
+// tombstone concurrent to the new version, which keeps the chunk. A chunk
+// removed as orphaned is written again with the version superseding the
+// tombstone.
+func (s *ReplicationService) putChunk(ctx context.Context, level proto.Consistency, data []byte) (string, error) {
+	ns, err := s.chunkNamespace()
+	if err != nil {
+		return "", err
+	}
+
+	hash := chunk.Hash(data)
+
+	existing, err := s.get(ctx, ns, &proto.GetRequest{Key: hash, Consistency: level})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.put(ctx, ns, &proto.PutRequest{
+		Key:         hash,
+		Version:     existing.version,
+		Consistency: level,
+		Value:       &proto.Value{Data: data},
+	}, false)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// getChunk reads the chunk and verifies its content.
+func (s *ReplicationService) getChunk(ctx context.Context, level proto.Consistency, hash string) ([]byte, error) {
+	ns, err := s.chunkNamespace()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.get(ctx, ns, &proto.GetRequest{Key: hash, Consistency: level})
+	if err != nil {
+		return nil, err
+	}
+
+	// The concurrent versions of a chunk have the same content, unless one of
+	// them is corrupted.
+	for _, val := range res.values {
+		if chunk.Verify(hash, val.Data) == nil {
+			return val.Data, nil
+		}
+	}
+
+	return nil, errMissingChunk
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/index"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication"
+	"github.com/sadath-12/keywave/replication/proto"
+)
+
+const (
+	defaultIndexLimit = 100
+	maxIndexLimit     = 1000
+)
+
+var (
+	errMissingField = status.Error(codes.InvalidArgument, "field is required")
+	errNoIndex      = status.Error(codes.NotFound, "field is not indexed")
+)
+
+type indexItem struct {
+	term    string
+	key     string
+	version string
+	values  []*proto.Value
+}
+
+// QueryIndex returns the keys whose indexed field matches the query. Each node
+// only indexes the keys it stores, so the query is sent to all nodes, and the
+// versions of each key returned by the nodes are merged the same way as in a
+// read. The result is complete once every key has been returned by at least one
+// of its replicas, but it is not repaired, so a key may still be matched by the
+// outdated values of the replicas that have not received the latest write.
+// The access is checked for the namespace as a whole, so the keys the client
+// is not allowed to read are left out of the result.
+func (s *ReplicationService) QueryIndex(ctx context.Context, req *proto.QueryIndexRequest) (*proto.QueryIndexResponse, error) {
+	if req.Field == "" {
+		return nil, errMissingField
+	}
+
+	ns, err := s.namespace(req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ns.Index(req.Field); !ok {
+		return nil, errNoIndex
+	}
+
+	r, err := index.NewRange(req.Value, req.Min, req.Max)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = defaultIndexLimit
+	}
+
+	limit = min(limit, maxIndexLimit)
+
+	var (
+		nodes      = replication.ReplicaSet(s.cluster.Nodes(), "", 0)
+		ackedNodes = make(map[membership.NodeID]struct{})
+		keyValues  = make(map[string][]nodeValue)
+		query      = nodeapi.IndexQuery{
+			Namespace: ns.Name,
+			Field:     req.Field,
+			Value:     req.Value,
+			Min:       req.Min,
+			Max:       req.Max,
+			Limit:     limit,
+		}
+	)
+
+	// Any set of N-RF+1 nodes holds a replica of every key.
+	copies := ns.ReplicationFactor
+	if copies <= 0 || copies > len(nodes) {
+		copies = len(nodes)
+	}
+
+	needAcks := len(nodes) - copies + 1
+
+	err = replication.Opts[[]nodeapi.StorageScanItem]{
+		Cluster:    s.cluster,
+		Nodes:      nodes,
+		AckedNodes: ackedNodes,
+		MinAcks:    len(nodes),
+		Logger:     s.logger,
+		Timeout:    s.readTimeout,
+		Env:        s.cluster.Env(),
+	}.Distribute(
+		ctx,
+		func(ctx context.Context, nodeID membership.NodeID, conn nodeapi.Client) ([]nodeapi.StorageScanItem, error) {
+			res, err := conn.StorageQueryIndex(ctx, query)
+			if err != nil {
+				return nil, err
+			}
+
+			return res.Items, nil
+		},
+		func(abort func(), nodeID membership.NodeID, items []nodeapi.StorageScanItem, err error) error {
+			for _, item := range items {
+				_, key, ok := namespace.SplitKey(item.Key)
+				if !ok {
+					continue
+				}
+
+				for i := range item.Versions {
+					keyValues[key] = append(keyValues[key], nodeValue{nodeID, item.Versions[i]})
+				}
+			}
+
+			return nil
+		},
+	)
+
+	if err != nil {
+		if !errors.Is(err, replication.ErrNotEnoughAcks) {
+			return nil, err
+		}
+
+		if len(ackedNodes) < needAcks {
+			return nil, errLevelNotSatisfied
+		}
+	}
+
+	var (
+		now         = s.cluster.Env().Clock.Now().UnixMilli()
+		items       = make([]indexItem, 0, len(keyValues))
+		identity, _ = auth.FromContext(ctx)
+	)
+
+	for key, values := range keyValues {
+		if identity != nil && !identity.Allowed(auth.PermRead, ns.Name, key) {
+			continue
+		}
+
+		merged, err := mergeVersions(values)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to merge indexed values", "key", key, "err", err)
+			continue
+		}
+
+		item := indexItem{key: key, version: merged.version}
+		matched := false
+
+		for _, val := range merged.values {
+			if val.Tombstone || isExpired(val.VersionedValue, now) {
+				continue
+			}
+
+			// The key is ordered by its smallest matching value among the
+			// siblings, the same way the nodes order it.
+			if term, ok := r.Match(val.Data, req.Field); ok && (!matched || term < item.term) {
+				item.term, matched = term, true
+			}
+
+			item.values = append(item.values, &proto.Value{Data: val.Data})
+		}
+
+		if matched {
+			items = append(items, item)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].term != items[j].term {
+			return items[i].term < items[j].term
+		}
+
+		return items[i].key < items[j].key
+	})
+
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	resp := &proto.QueryIndexResponse{
+		Items: make([]*proto.IndexItem, 0, len(items)),
+	}
+
+	for _, item := range items {
+		resp.Items = append(resp.Items, &proto.IndexItem{
+			Key:     item.key,
+			Values:  item.values,
+			Version: item.version,
+		})
+	}
+
+	return resp, nil
+}
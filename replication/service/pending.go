@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication"
+)
+
+// writePending sends the write to the joining nodes that will be replicas of
+// the key, in the background. They do not count towards the acknowledgments,
+// but keep receiving the writes until they are ready, so that they do not miss
+// the ones made after they have pulled the key.
+func (s *ReplicationService) writePending(ctx context.Context, ns namespace.Config, key string, write func(context.Context, nodeapi.Client) error) {
+	pending := replication.PendingReplicas(s.cluster.Nodes(), key, ns.ReplicationFactor)
+	if len(pending) == 0 {
+		return
+	}
+
+	// The writes outlive the request, but keep the values of its context.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.writeTimeout)
+
+	var wg sync.WaitGroup
+
+	for _, node := range pending {
+		nodeID := node.ID
+
+		wg.Add(1)
+
+		s.cluster.Env().Go(func() {
+			defer wg.Done()
+
+			if err := s.writeTo(ctx, nodeID, write); err != nil {
+				level.Debug(s.logger).Log("msg", "failed to write to joining node", "node_id", nodeID, "key", key, "err", err)
+			}
+		})
+	}
+
+	s.cluster.Env().Go(func() {
+		wg.Wait()
+		cancel()
+	})
+}
+
+func (s *ReplicationService) writeTo(ctx context.Context, nodeID membership.NodeID, write func(context.Context, nodeapi.Client) error) error {
+	conn, err := s.cluster.ConnContext(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	return write(ctx, conn)
+}
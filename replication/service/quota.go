@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+const (
+	usageRefreshInterval = 5 * time.Second
+	usageRefreshTimeout  = time.Second
+)
+
+var (
+	errKeyQuotaExceeded  = status.Error(codes.ResourceExhausted, "namespace key quota exceeded")
+	errByteQuotaExceeded = status.Error(codes.ResourceExhausted, "namespace size quota exceeded")
+)
+
+// quotaChecker enforces the namespace quotas at the coordinator. Each node only
+// knows the usage of the keys it stores, so the cluster-wide usage is estimated
+// from the usage reported by all reachable nodes and is refreshed periodically.
+// The writes made while the usage is refreshed are checked against the previous
+// estimate, rather than waiting for the nodes to respond.
+type quotaChecker struct {
+	cluster membership.Cluster
+	logger  kitlog.Logger
+	mut     sync.Mutex
+	updated time.Time
+	usage   map[string]nodeapi.NamespaceUsage
+	nodes   int
+	alive   int
+	// refreshing is closed once the refresh in progress completes, and is nil
+	// if there is none.
+	refreshing chan struct{}
+}
+
+func newQuotaChecker(cluster membership.Cluster, logger kitlog.Logger) *quotaChecker {
+	return &quotaChecker{
+		cluster: cluster,
+		logger:  logger,
+	}
+}
+
+// check returns an error if the write of a value of the size would exceed the
+// quotas of the namespace. Writes carrying a version are updates of the keys the
+// client has read before, so they are only subject to the size quota.
+func (q *quotaChecker) check(ctx context.Context, ns namespace.Config, version string, size int64) error {
+	if ns.MaxKeys == 0 && ns.MaxBytes == 0 {
+		return nil
+	}
+
+	usage := q.estimate(ctx, ns)
+
+	if ns.MaxKeys > 0 && version == "" && usage.Keys >= ns.MaxKeys {
+		return errKeyQuotaExceeded
+	}
+
+	if ns.MaxBytes > 0 && usage.Bytes+size > ns.MaxBytes {
+		return errByteQuotaExceeded
+	}
+
+	return nil
+}
+
+// estimate returns the estimated cluster-wide usage of the namespace.
+func (q *quotaChecker) estimate(ctx context.Context, ns namespace.Config) namespace.Usage {
+	q.mut.Lock()
+
+	switch refreshing := q.refreshing; {
+	case refreshing == nil && q.cluster.Env().Clock.Since(q.updated) > usageRefreshInterval:
+		q.refreshing = make(chan struct{})
+		q.mut.Unlock()
+
+		q.refresh(ctx)
+
+		q.mut.Lock()
+	case refreshing != nil && q.updated.IsZero():
+		// There is no previous estimate to use yet.
+		q.mut.Unlock()
+
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+		}
+
+		q.mut.Lock()
+	}
+
+	defer q.mut.Unlock()
+
+	u := q.usage[ns.Name]
+	if q.nodes == 0 {
+		return namespace.Usage{}
+	}
+
+	// Each key is stored on several nodes, so the sum is divided by the number of
+	// copies. The result is also extrapolated to the nodes that did not respond.
+	copies := int64(ns.ReplicationFactor)
+	if copies <= 0 || copies > int64(q.alive) {
+		copies = int64(q.alive)
+	}
+
+	scale := func(v int64) int64 {
+		return v * int64(q.alive) / int64(q.nodes) / copies
+	}
+
+	return namespace.Usage{
+		Keys:  scale(u.Keys),
+		Bytes: scale(u.Bytes),
+	}
+}
+
+// refresh fetches the usage from the reachable nodes without holding the lock,
+// and replaces the estimate with it.
+func (q *quotaChecker) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, usageRefreshTimeout)
+	defer cancel()
+
+	var (
+		mut   sync.Mutex
+		wg    sync.WaitGroup
+		nodes int
+		alive int
+		total = make(map[string]nodeapi.NamespaceUsage)
+	)
+
+	for _, node := range q.cluster.Nodes() {
+		if !node.IsReachable() {
+			continue
+		}
+
+		alive++
+		wg.Add(1)
+
+		nodeID := node.ID
+
+		q.cluster.Env().Go(func() {
+			defer wg.Done()
+
+			conn, err := q.cluster.ConnContext(ctx, nodeID)
+			if err != nil {
+				return
+			}
+
+			res, err := conn.StorageUsage(ctx)
+			if err != nil {
+				level.Warn(q.logger).Log("msg", "failed to get namespace usage", "node_id", nodeID, "err", err)
+				return
+			}
+
+			mut.Lock()
+			defer mut.Unlock()
+
+			nodes++
+
+			for name, u := range res.Namespaces {
+				t := total[name]
+				t.Keys += u.Keys
+				t.Bytes += u.Bytes
+				total[name] = t
+			}
+		})
+	}
+
+	wg.Wait()
+
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	close(q.refreshing)
+	q.refreshing = nil
+
+	q.usage = total
+	q.nodes = nodes
+	q.alive = alive
+	q.updated = q.cluster.Env().Clock.Now()
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// usageCluster is a single node reporting the usage of the keys it stores, once
+// the test lets it respond.
+type usageCluster struct {
+	membership.Cluster
+	clock *env.SimClock
+	calls chan struct{}
+	reply chan int64
+}
+
+func (c *usageCluster) Nodes() []membership.Node {
+	return []membership.Node{{ID: 1, Status: membership.StatusHealthy}}
+}
+
+func (c *usageCluster) Env() env.Env {
+	return env.Env{Clock: c.clock}.WithDefaults()
+}
+
+func (c *usageCluster) ConnContext(context.Context, membership.NodeID) (nodeapi.Client, error) {
+	return usageConn{cluster: c}, nil
+}
+
+type usageConn struct {
+	nodeapi.Client
+	cluster *usageCluster
+}
+
+func (c usageConn) StorageUsage(ctx context.Context) (*nodeapi.StorageUsageResult, error) {
+	c.cluster.calls <- struct{}{}
+
+	select {
+	case keys := <-c.cluster.reply:
+		return &nodeapi.StorageUsageResult{Namespaces: map[string]nodeapi.NamespaceUsage{"ns": {Keys: keys}}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestQuotaRefreshDoesNotBlock(t *testing.T) {
+	cluster := &usageCluster{
+		clock: env.NewSimClock(time.Unix(1_700_000_000, 0)),
+		calls: make(chan struct{}, 1),
+		reply: make(chan int64),
+	}
+
+	var (
+		q   = newQuotaChecker(cluster, kitlog.NewNopLogger())
+		ns  = namespace.Config{Name: "ns", MaxKeys: 2, ReplicationFactor: 1}
+		ctx = context.Background()
+	)
+
+	// Without a previous estimate, the writes wait for the first one.
+	errs := make(chan error, 2)
+
+	go func() { errs <- q.check(ctx, ns, "", 1) }()
+	<-cluster.calls
+
+	go func() { errs <- q.check(ctx, ns, "", 1) }()
+
+	cluster.reply <- 1
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("expected the write to be accepted, got %v", err)
+		}
+	}
+
+	// Once the estimate is stale, the writes made while it is refreshed are
+	// checked against it rather than waiting for the nodes.
+	cluster.clock.Advance(usageRefreshInterval + time.Second)
+
+	go func() { errs <- q.check(ctx, ns, "", 1) }()
+	<-cluster.calls
+
+	if err := q.check(ctx, ns, "", 1); err != nil {
+		t.Errorf("expected the write to be checked against the previous estimate, got %v", err)
+	}
+
+	cluster.reply <- 2
+
+	if err := <-errs; err != errKeyQuotaExceeded {
+		t.Errorf("expected the refreshed estimate to exceed the quota, got %v", err)
+	}
+
+	if err := q.check(ctx, ns, "", 1); err != errKeyQuotaExceeded {
+		t.Errorf("expected the refreshed estimate to be kept, got %v", err)
+	}
+}
@@ -8,13 +8,18 @@ import (
 
 	kitlog "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/hotkeys"
 	"github.com/sadath-12/keywave/internal/generic"
 	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/internal/vclock"
 	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
 	"github.com/sadath-12/keywave/nodeapi"
 	"github.com/sadath-12/keywave/replication"
 	"github.com/sadath-12/keywave/replication/consistency"
 	"github.com/sadath-12/keywave/replication/proto"
+	"github.com/sadath-12/keywave/storage"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -30,6 +35,10 @@ var (
 	errNotEnoughReplicas = status.Error(codes.FailedPrecondition, "not enough replicas available to satisfy the consistency level")
 	errMissingVersion    = status.Error(codes.InvalidArgument, "version is required")
 	errMissingKey        = status.Error(codes.InvalidArgument, "key is required")
+	errMissingValue      = status.Error(codes.InvalidArgument, "value is required")
+	errInvalidNamespace  = status.Error(codes.InvalidArgument, "invalid namespace name")
+	errUnknownNamespace  = status.Error(codes.NotFound, "namespace not found")
+	errReservedNamespace = status.Error(codes.InvalidArgument, "namespace is reserved for internal use")
 )
 
 type nodeValue struct {
@@ -47,11 +56,8 @@ func countAlive(members []membership.Node) (alive int) {
 	return
 }
 
-func putValue(ctx context.Context, conn nodeapi.Client, key string, value []byte, version string, primary bool) (string, error) {
-	resp, err := conn.StoragePut(ctx, key, nodeapi.VersionedValue{
-		Version: version,
-		Data:    value,
-	}, primary)
+func putValue(ctx context.Context, conn nodeapi.Client, key string, value nodeapi.VersionedValue, primary bool) (string, error) {
+	resp, err := conn.StoragePut(ctx, key, value, primary)
 
 	if err != nil {
 		return "", err
@@ -73,26 +79,154 @@ func putTombstone(ctx context.Context, conn nodeapi.Client, key, version string,
 	return resp.Version, nil
 }
 
+// DefaultNamespace returns the configuration used for the default namespace
+// when it is not declared explicitly.
+func DefaultNamespace() namespace.Config {
+	return namespace.Config{
+		Name:       namespace.Default,
+		ReadLevel:  defaultConsistencyLevel,
+		WriteLevel: defaultConsistencyLevel,
+	}
+}
+
 type ReplicationService struct {
 	proto.UnimplementedReplicationServer
 
 	cluster      membership.Cluster
+	namespaces   *namespace.Registry
+	quotas       *quotaChecker
 	logger       kitlog.Logger
 	readTimeout  time.Duration
 	writeTimeout time.Duration
-	readLevel    consistency.Level
-	writeLevel   consistency.Level
+
+	// speculative holds the speculative retry policies of the reads per
+	// consistency level, and latencies the read latencies of the replicas.
+	speculative map[consistency.Level]replication.SpeculativeRetry
+	latencies   *replication.Latencies
+
+	// hotKeys tracks the rates of the reads of the keys, and cache holds the
+	// results of the reads of the hot ones. Both are optional.
+	hotKeys *hotkeys.Detector
+	cache   *readCache
 }
 
-func New(cluster membership.Cluster, logger kitlog.Logger) *ReplicationService {
+func New(cluster membership.Cluster, namespaces *namespace.Registry, logger kitlog.Logger) *ReplicationService {
 	return &ReplicationService{
 		logger:       logger,
 		cluster:      cluster,
+		namespaces:   namespaces,
+		quotas:       newQuotaChecker(cluster, logger),
 		readTimeout:  defaultReadTimeout,
 		writeTimeout: defaultWriteTimeout,
-		readLevel:    defaultConsistencyLevel,
-		writeLevel:   defaultConsistencyLevel,
+		speculative:  make(map[consistency.Level]replication.SpeculativeRetry),
+		latencies:    replication.NewLatencies(),
+	}
+}
+
+// SetSpeculativeRetry sets the speculative retry policies of the reads per
+// consistency level. The reads at the levels without a policy are sent to all
+// replicas at once. It must be called before the service starts serving.
+func (s *ReplicationService) SetSpeculativeRetry(policies map[consistency.Level]replication.SpeculativeRetry) {
+	s.speculative = policies
+}
+
+// SetHotKeys makes the service record the reads of the keys with the detector.
+// It must be called before the service starts serving.
+func (s *ReplicationService) SetHotKeys(detector *hotkeys.Detector) {
+	s.hotKeys = detector
+}
+
+// SetReadCache enables the cache of the reads at level one of the keys found
+// hot by the detector set with SetHotKeys. The results are cached for the ttl,
+// and up to size keys are cached. It must be called before the service starts
+// serving.
+func (s *ReplicationService) SetReadCache(ttl time.Duration, size int) {
+	s.cache = newReadCache(ttl, size)
+}
+
+// CacheStats returns a snapshot of the state of the read cache, which is empty
+// if the cache is disabled.
+func (s *ReplicationService) CacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+
+	return s.cache.stats()
+}
+
+// invalidateCache drops the cached result of the key after a write of the
+// version, or of a write that failed if the version is empty.
+func (s *ReplicationService) invalidateCache(key, version string) {
+	if s.cache != nil {
+		s.cache.invalidate(key, version, s.hotKeys != nil && s.hotKeys.IsHot(key), s.cluster.Env().Clock.Now())
+	}
+}
+
+// namespace returns the configuration of the namespace the request refers to.
+// The internal namespaces cannot be referred to by the requests.
+func (s *ReplicationService) namespace(name string) (namespace.Config, error) {
+	if namespace.Internal(name) {
+		return namespace.Config{}, errReservedNamespace
+	}
+
+	ns, err := s.namespaces.Get(name)
+	if err != nil {
+		if errors.Is(err, namespace.ErrInvalidName) {
+			return ns, errInvalidNamespace
+		}
+
+		return ns, errUnknownNamespace
+	}
+
+	return ns, nil
+}
+
+// primary picks the node that coordinates the write and generates the new
+// version. The local node is preferred as long as it is one of the replicas,
+// otherwise the first reachable replica is used.
+func (s *ReplicationService) primary(members []membership.Node) (membership.NodeID, nodeapi.Client, error) {
+	selfID := s.cluster.SelfID()
+
+	for _, member := range members {
+		if member.ID == selfID && member.IsReachable() {
+			return selfID, s.cluster.LocalConn(), nil
+		}
 	}
+
+	for _, member := range members {
+		if !member.IsReachable() {
+			continue
+		}
+
+		conn, err := s.cluster.Conn(member.ID)
+		if err != nil {
+			level.Warn(s.logger).Log("msg", "failed to connect to replica", "node_id", member.ID, "err", err)
+			continue
+		}
+
+		return member.ID, conn, nil
+	}
+
+	return 0, nil, errNotEnoughReplicas
+}
+
+func fromProtoConsistency(c proto.Consistency, fallback consistency.Level) consistency.Level {
+	switch c {
+	case proto.Consistency_ONE:
+		return consistency.One
+	case proto.Consistency_TWO:
+		return consistency.Two
+	case proto.Consistency_QUORUM:
+		return consistency.Quorum
+	case proto.Consistency_ALL:
+		return consistency.All
+	default:
+		return fallback
+	}
+}
+
+func isExpired(value nodeapi.VersionedValue, nowMillis int64) bool {
+	return value.ExpiresAt != 0 && value.ExpiresAt <= nowMillis
 }
 
 func validateGetRequest(req *proto.GetRequest) error {
@@ -108,56 +242,154 @@ func (s *ReplicationService) Get(ctx context.Context, req *proto.GetRequest) (*p
 		return nil, err
 	}
 
+	ns, err := s.namespace(req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.get(ctx, ns, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &proto.GetResponse{
+		Version: res.version,
+	}
+
+	for _, val := range res.values {
+		if val.Chunked {
+			return nil, errChunkedValue
+		}
+
+		resp.Values = append(resp.Values, &proto.Value{Data: val.Data})
+	}
+
+	return resp, nil
+}
+
+// readResult is the outcome of a read: the merged version of the key and its
+// live values, without the tombstones and the expired values.
+type readResult struct {
+	version string
+	values  []nodeapi.VersionedValue
+}
+
+// get reads the key of the namespace from its replicas and repairs the stale
+// ones.
+func (s *ReplicationService) get(ctx context.Context, ns namespace.Config, req *proto.GetRequest) (readResult, error) {
 	var (
-		members    = s.cluster.Nodes()
-		needAcks   = s.readLevel.N(len(members))
+		err        error
+		key        = namespace.Key(ns.Name, req.Key)
+		members    = replication.ReplicaSet(s.cluster.Nodes(), key, ns.ReplicationFactor)
+		readLevel  = fromProtoConsistency(req.Consistency, ns.ReadLevel)
+		needAcks   = readLevel.N(len(members))
 		staleNodes = map[membership.NodeID]struct{}{}
 		ackedNodes = map[membership.NodeID]struct{}{}
 		allValues  = make([]nodeValue, 0)
+		session    vclock.Version
 	)
-	err := replication.Opts[[]nodeapi.VersionedValue]{
-		Cluster:    s.cluster,
-		Nodes:      members,
-		AckedNodes: ackedNodes,
-		MinAcks:    needAcks,
-		Logger:     s.logger,
-		Timeout:    s.readTimeout,
-	}.Distribute(
-		ctx,
-		func(ctx context.Context, nodeID membership.NodeID, conn nodeapi.Client) ([]nodeapi.VersionedValue, error) {
-			l := kitlog.With(s.logger, "node_id", nodeID, "key", req.Key)
-			level.Debug(l).Log("msg", "getting value from node")
 
-			res, err := conn.StorageGet(ctx, req.Key)
+	if session, err = parseSessionToken(req.SessionToken, key); err != nil {
+		return readResult{}, err
+	}
 
-			if err != nil {
-				level.Error(l).Log("msg", "failed to get value from node", "err", err)
-				return nil, err
-			}
+	// The reads at level one of the hot keys are served from the cache, since
+	// they may be stale anyway, unless they must see the write of a session.
+	cacheable := s.cache != nil && s.hotKeys != nil && readLevel == consistency.One && session == nil && !namespace.Internal(ns.Name)
 
-			return res.Versions, nil
-		},
-		func(abort func(), nodeID membership.NodeID, values []nodeapi.VersionedValue, err error) error {
-			if len(values) == 0 {
-				staleNodes[nodeID] = struct{}{}
-				return nil
+	if s.hotKeys != nil && !namespace.Internal(ns.Name) {
+		s.hotKeys.Record(key)
+	}
+
+	if cacheable {
+		if res, ok := s.cache.get(key, s.cluster.Env().Clock.Now()); ok {
+			return res, nil
+		}
+	}
+
+	getValue := func(ctx context.Context, nodeID membership.NodeID, conn nodeapi.Client) ([]nodeapi.VersionedValue, error) {
+		l := kitlog.With(s.logger, "node_id", nodeID, "key", key)
+		level.Debug(l).Log("msg", "getting value from node")
+
+		res, err := conn.StorageGet(ctx, key)
+
+		if err != nil {
+			level.Error(l).Log("msg", "failed to get value from node", "err", err)
+			return nil, err
+		}
+
+		return res.Versions, nil
+	}
+
+	collectValues := func(abort func(), nodeID membership.NodeID, values []nodeapi.VersionedValue, err error) error {
+		// A node that has not replied is not known to be stale, and repairing it
+		// would most likely fail the read.
+		if err != nil {
+			return nil
+		}
+
+		if len(values) == 0 {
+			staleNodes[nodeID] = struct{}{}
+			return nil
+		}
+
+		for i := range values {
+			allValues = append(allValues, nodeValue{nodeID, values[i]})
+		}
+
+		return nil
+	}
+
+	err = replication.Opts[[]nodeapi.VersionedValue]{
+		Cluster:     s.cluster,
+		Nodes:       members,
+		AckedNodes:  ackedNodes,
+		MinAcks:     needAcks,
+		Logger:      s.logger,
+		Timeout:     s.readTimeout,
+		Speculative: s.speculative[readLevel],
+		Latencies:   s.latencies,
+		Env:         s.cluster.Env(),
+	}.Distribute(ctx, getValue, collectValues)
+
+	if err != nil {
+		return readResult{}, err
+	}
+
+	// The replicas read may not have received the write of the session yet, so
+	// the rest of the replicas are read until one of them has.
+	if session != nil && !seenSession(allValues, session) {
+		level.Debug(s.logger).Log("msg", "session write not seen, reading more replicas", "key", key)
+
+		_ = replication.Opts[[]nodeapi.VersionedValue]{
+			Cluster:    s.cluster,
+			Nodes:      members,
+			AckedNodes: ackedNodes,
+			MinAcks:    len(members),
+			Logger:     s.logger,
+			Timeout:    s.readTimeout,
+			Latencies:  s.latencies,
+			Env:        s.cluster.Env(),
+		}.Distribute(ctx, getValue, func(abort func(), nodeID membership.NodeID, values []nodeapi.VersionedValue, err error) error {
+			if err := collectValues(abort, nodeID, values, err); err != nil {
+				return err
 			}
 
-			for i := range values {
-				allValues = append(allValues, nodeValue{nodeID, values[i]})
+			if seenSession(allValues, session) {
+				abort()
 			}
 
 			return nil
-		},
-	)
+		})
 
-	if err != nil {
-		return nil, err
+		if !seenSession(allValues, session) {
+			return readResult{}, errSessionNotSatisfied
+		}
 	}
 
 	merged, err := mergeVersions(allValues)
 	if err != nil {
-		return nil, err
+		return readResult{}, err
 	}
 
 	for _, id := range merged.staleReplicas {
@@ -169,7 +401,7 @@ func (s *ReplicationService) Get(ctx context.Context, req *proto.GetRequest) (*p
 
 		level.Debug(s.logger).Log(
 			"msg", "repairing stale replicas",
-			"key", req.Key,
+			"key", key,
 			"stale_replicas", fmt.Sprintf("%v", merged.staleReplicas),
 			"repair_replicas", fmt.Sprintf("%v", generic.MapKeys(staleNodes)),
 		)
@@ -189,19 +421,23 @@ func (s *ReplicationService) Get(ctx context.Context, req *proto.GetRequest) (*p
 			Timeout:    s.writeTimeout,
 			Logger:     s.logger,
 			Background: true,
+			Env:        s.cluster.Env(),
 		}.Distribute(
 			ctx,
 			func(ctx context.Context, nodeID membership.NodeID, conn nodeapi.Client) (int, error) {
-				l := kitlog.With(s.logger, "key", req.Key, "node_id", nodeID, "tomb", value.Tombstone)
+				l := kitlog.With(s.logger, "key", key, "node_id", nodeID, "tomb", value.Tombstone)
 
 				if value.Tombstone {
-					if _, err := putTombstone(ctx, conn, req.Key, merged.version, false); err != nil {
+					if _, err := putTombstone(ctx, conn, key, merged.version, false); err != nil {
 						level.Error(l).Log("msg", "failed to repair", "err", err)
 						return 0, err
 					}
-				}
-
-				if _, err := putValue(ctx, conn, req.Key, value.Data, merged.version, false); err != nil {
+				} else if _, err := putValue(ctx, conn, key, nodeapi.VersionedValue{
+					Version:   merged.version,
+					Data:      value.Data,
+					ExpiresAt: value.ExpiresAt,
+					Chunked:   value.Chunked,
+				}, false); err != nil {
 					level.Error(l).Log("msg", "failed to repair", "err", err)
 					return 0, err
 				}
@@ -220,22 +456,26 @@ func (s *ReplicationService) Get(ctx context.Context, req *proto.GetRequest) (*p
 		)
 
 		if err != nil {
-			return nil, err
+			return readResult{}, err
 		}
 	}
 
-	return &proto.GetResponse{
-		Version: merged.version,
-		Values: func() (pv []*proto.Value) {
-			for _, val := range merged.values {
-				if !val.Tombstone {
-					pv = append(pv, &proto.Value{Data: val.Data})
-				}
-			}
+	var (
+		now = s.cluster.Env().Clock.Now().UnixMilli()
+		res = readResult{version: merged.version}
+	)
 
-			return
-		}(),
-	}, nil
+	for _, val := range merged.values {
+		if !val.Tombstone && !isExpired(val.VersionedValue, now) {
+			res.values = append(res.values, val.VersionedValue)
+		}
+	}
+
+	if cacheable && s.hotKeys.IsHot(key) {
+		s.cache.put(key, res, s.cluster.Env().Clock.Now())
+	}
+
+	return res, nil
 }
 
 
@@ -243,7 +483,15 @@ func validatePutRequest(req *proto.PutRequest) error {
 	if len(req.Key) == 0 {
 		return errMissingKey
 	}
- 
+
+	if req.Value == nil {
+		return errMissingValue
+	}
+
+	if _, err := decodeSession(req.SessionToken); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -253,11 +501,23 @@ func (s *ReplicationService) Put(ctx context.Context, req *proto.PutRequest) (*p
 		return nil, err
 	}
 
-	fmt.Println("put from rep service")
+	ns, err := s.namespace(req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.put(ctx, ns, req, false)
+}
+
+// put writes the value to the replicas of the key of the namespace. If chunked
+// is set, the value is the manifest of a value stored in chunks.
+func (s *ReplicationService) put(ctx context.Context, ns namespace.Config, req *proto.PutRequest, chunked bool) (*proto.PutResponse, error) {
 	var (
-		members   = s.cluster.Nodes()
-		localConn = s.cluster.LocalConn()
-		needAcks  = s.writeLevel.N(len(members))
+		key        = namespace.Key(ns.Name, req.Key)
+		members    = replication.ReplicaSet(s.cluster.Nodes(), key, ns.ReplicationFactor)
+		writeLevel = fromProtoConsistency(req.Consistency, ns.WriteLevel)
+		needAcks   = writeLevel.N(len(members))
+		expiresAt  int64
 	)
 
 	// Do not attempt to write if we know in advance that there is not enough alive nodes.
@@ -265,16 +525,55 @@ func (s *ReplicationService) Put(ctx context.Context, req *proto.PutRequest) (*p
 		return nil, errNotEnoughReplicas
 	}
 
-	// The first write goes to the coordinator, which is the local node. The coordinator
-	// is responsible for generating the version number, which is then send to the other nodes.
-	version, err := putValue(ctx, localConn, req.Key, req.Value.Data, req.Version, true)
+	// A chunked value is charged with its whole size rather than the size of
+	// the manifest.
+	size := chunk.ValueSize(storage.Value{Data: req.Value.Data, Chunked: chunked})
+
+	if err := s.quotas.check(ctx, ns, req.Version, size); err != nil {
+		return nil, err
+	}
+
+	if ttl := time.Duration(req.Ttl) * time.Millisecond; ttl > 0 {
+		expiresAt = s.cluster.Env().Clock.Now().Add(ttl).UnixMilli()
+	} else if ns.TTL > 0 {
+		expiresAt = s.cluster.Env().Clock.Now().Add(ns.TTL).UnixMilli()
+	}
+
+	primaryID, primaryConn, err := s.primary(members)
 	if err != nil {
 		return nil, err
 	}
 
+	// The first write goes to the primary replica, which is the local node unless it
+	// does not store the key. The primary is responsible for generating the version
+	// number, which is then send to the other nodes.
+	version, err := putValue(ctx, primaryConn, key, nodeapi.VersionedValue{
+		Version:   req.Version,
+		Data:      req.Value.Data,
+		ExpiresAt: expiresAt,
+		Chunked:   chunked,
+	}, true)
+
+	s.invalidateCache(key, version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.writePending(ctx, ns, key, func(ctx context.Context, conn nodeapi.Client) error {
+		_, err := putValue(ctx, conn, key, nodeapi.VersionedValue{
+			Version:   version,
+			Data:      req.Value.Data,
+			ExpiresAt: expiresAt,
+			Chunked:   chunked,
+		}, false)
+
+		return err
+	})
+
 	// We already received an ack from the primary node, so skip in the map-reduce operation.
 	ackedNodes := make(map[membership.NodeID]struct{})
-	ackedNodes[s.cluster.SelfID()] = struct{}{}
+	ackedNodes[primaryID] = struct{}{}
 
 	err = replication.Opts[string]{
 		MinAcks:    needAcks,
@@ -284,10 +583,16 @@ func (s *ReplicationService) Put(ctx context.Context, req *proto.PutRequest) (*p
 		Logger:     s.logger,
 		Timeout:    s.writeTimeout,
 		Background: true,
+		Env:        s.cluster.Env(),
 	}.Distribute(
 		ctx,
 		func(ctx context.Context, nodeID membership.NodeID, conn nodeapi.Client) (string, error) {
-			version, err := putValue(ctx, conn, req.Key, req.Value.Data, version, false)
+			version, err := putValue(ctx, conn, key, nodeapi.VersionedValue{
+				Version:   version,
+				Data:      req.Value.Data,
+				ExpiresAt: expiresAt,
+				Chunked:   chunked,
+			}, false)
 			if err != nil {
 				return "", err
 			}
@@ -316,9 +621,15 @@ func (s *ReplicationService) Put(ctx context.Context, req *proto.PutRequest) (*p
 		return nil, err
 	}
 
+	session, err := newSessionToken(req.SessionToken, key, version)
+	if err != nil {
+		return nil, err
+	}
+
 	return &proto.PutResponse{
 		Acknowledged: int32(len(ackedNodes)),
 		Version:      version,
+		SessionToken: session,
 	}, nil
 }
 
@@ -331,6 +642,10 @@ func validateDeleteRequest(req *proto.DeleteRequest) error {
 		return errMissingVersion
 	}
 
+	if _, err := decodeSession(req.SessionToken); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -339,21 +654,39 @@ func (s *ReplicationService) Delete(ctx context.Context, req *proto.DeleteReques
 		return nil, err
 	}
 
+	ns, err := s.namespace(req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
-		members  = s.cluster.Nodes()
-		needAcks = s.writeLevel.N(len(members))
+		key        = namespace.Key(ns.Name, req.Key)
+		members    = replication.ReplicaSet(s.cluster.Nodes(), key, ns.ReplicationFactor)
+		writeLevel = fromProtoConsistency(req.Consistency, ns.WriteLevel)
+		needAcks   = writeLevel.N(len(members))
 	)
 
+	primaryID, primaryConn, err := s.primary(members)
+	if err != nil {
+		return nil, err
+	}
+
 	ackedNodes := make(map[membership.NodeID]struct{})
-	ackedNodes[s.cluster.SelfID()] = struct{}{}
-	localConn := s.cluster.LocalConn()
+	ackedNodes[primaryID] = struct{}{}
+
+	version, err := putTombstone(ctx, primaryConn, key, req.Version, true)
+
+	s.invalidateCache(key, version)
 
-	version, err := putTombstone(
-		ctx, localConn, req.Key, req.Version, true)
 	if err != nil {
 		return nil, err
 	}
 
+	s.writePending(ctx, ns, key, func(ctx context.Context, conn nodeapi.Client) error {
+		_, err := putTombstone(ctx, conn, key, version, false)
+		return err
+	})
+
 	err = replication.Opts[string]{
 		Nodes:      members,
 		MinAcks:    needAcks,
@@ -362,10 +695,11 @@ func (s *ReplicationService) Delete(ctx context.Context, req *proto.DeleteReques
 		Logger:     s.logger,
 		Timeout:    s.writeTimeout,
 		Background: true,
+		Env:        s.cluster.Env(),
 	}.Distribute(
 		ctx,
 		func(ctx context.Context, nodeID membership.NodeID, conn nodeapi.Client) (string, error) {
-			version, err := putTombstone(ctx, conn, req.Key, version, false)
+			version, err := putTombstone(ctx, conn, key, version, false)
 			if err != nil {
 				return "", err
 			}
@@ -387,8 +721,14 @@ func (s *ReplicationService) Delete(ctx context.Context, req *proto.DeleteReques
 		return nil, err
 	}
 
+	session, err := newSessionToken(req.SessionToken, key, version)
+	if err != nil {
+		return nil, err
+	}
+
 	return &proto.DeleteResponse{
 		Acknowledged: int32(len(ackedNodes)),
 		Version:      version,
+		SessionToken: session,
 	}, nil
 }
@@ -0,0 +1,559 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"google.golang.org/grpc/codes"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/hotkeys"
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication/consistency"
+	"github.com/sadath-12/keywave/replication/proto"
+	"github.com/sadath-12/keywave/replication/service"
+)
+
+func TestQuorumWithFailures(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+	opts := nodeapi.KeyOpts{Level: "quorum"}
+
+	h.Run(
+		clustertest.Put(1, "key", "value", opts),
+		clustertest.ExpectValue(2, "key", "value", opts),
+
+		// A single failure still leaves the majority of the replicas.
+		clustertest.Isolate(3),
+		clustertest.ExpectStatus([]membership.NodeID{1, 2}, 3, membership.StatusUnhealthy),
+		clustertest.Put(1, "other", "value", opts),
+		clustertest.ExpectValue(2, "other", "value", opts),
+
+		// Without the majority the writes are rejected.
+		clustertest.Isolate(2),
+		clustertest.ExpectStatus([]membership.NodeID{1}, 2, membership.StatusUnhealthy),
+		clustertest.ExpectPutError(1, "lost", "value", opts, codes.FailedPrecondition),
+
+		clustertest.Heal(),
+		clustertest.ExpectConverged(),
+		clustertest.ExpectValue(3, "other", "value", opts),
+	)
+}
+
+func TestSessionReadYourWrites(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+	ctx := context.Background()
+
+	// Node 3 does not receive the write, so a read at level one served by node 3
+	// alone would not see it.
+	h.Network().Cut(1, 3)
+
+	res, err := h.Node(1).Client().PutKey(ctx, "key", []byte("value"), "", nodeapi.KeyOpts{Level: "quorum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Run(clustertest.ExpectValue(2, "key", "value", nodeapi.KeyOpts{Level: "all"}))
+
+	read := nodeapi.KeyOpts{Level: "one", SessionToken: res.SessionToken}
+
+	h.Run(clustertest.ExpectValue(3, "key", "value", read))
+
+	// The keys not written in the session are read as usual.
+	if _, err := h.Node(3).Client().GetKey(ctx, "other", read); err != nil {
+		t.Errorf("expected the key not written in the session to be read, got %v", err)
+	}
+
+	if _, err := h.Node(3).Client().GetKey(ctx, "key", nodeapi.KeyOpts{SessionToken: "invalid"}); grpcutil.ErrorCode(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid token, got %v", err)
+	}
+
+	// The read has repaired node 3, but the next write of the session misses it
+	// again, and once node 3 is isolated no reachable replica has seen the write.
+	res, err = h.Node(1).Client().PutKey(ctx, "next", []byte("value"), "", nodeapi.KeyOpts{Level: "quorum", SessionToken: res.SessionToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Isolate(3)
+
+	read.SessionToken = res.SessionToken
+
+	if _, err := h.Node(3).Client().GetKey(ctx, "next", read); grpcutil.ErrorCode(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable, got %v", err)
+	}
+
+	// The token still covers the first write of the session, which node 3 has
+	// seen.
+	h.Run(clustertest.ExpectValue(3, "key", "value", read))
+}
+
+func TestQueryIndex(t *testing.T) {
+	namespaces := namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{
+		Name:              "users",
+		ReadLevel:         consistency.Quorum,
+		WriteLevel:        consistency.Quorum,
+		ReplicationFactor: 2,
+		Indexes:           []namespace.Index{{Field: "address.city"}},
+	})
+
+	h := clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+	ctx := context.Background()
+	opts := nodeapi.KeyOpts{Namespace: "users"}
+
+	for key, city := range map[string]string{"alice": "Berlin", "bob": "Amsterdam", "carol": "Berlin", "dave": "Paris"} {
+		value := fmt.Sprintf(`{"address": {"city": %q}}`, city)
+		if _, err := h.Node(1).Client().PutKey(ctx, key, []byte(value), "", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := h.Node(1).Client().GetKey(ctx, "dave", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Node(1).Client().DeleteKey(ctx, "dave", res.Version, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(via membership.NodeID, q nodeapi.IndexQuery) ([]string, error) {
+		q.Namespace, q.Field = "users", "address.city"
+
+		res, err := h.Node(via).Client().QueryIndex(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, len(res.Items))
+		for i, item := range res.Items {
+			if len(item.Values) != 1 {
+				t.Errorf("key %s has %d values", item.Key, len(item.Values))
+			}
+
+			keys[i] = item.Key
+		}
+
+		return keys, nil
+	}
+
+	// Every key is stored on two of the nodes, but is returned once.
+	keys, err := query(2, nodeapi.IndexQuery{Value: []byte(`"Berlin"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(keys, []string{"alice", "carol"}) {
+		t.Errorf("city = Berlin: %v", keys)
+	}
+
+	// The deleted key is no longer indexed.
+	keys, err = query(2, nodeapi.IndexQuery{Min: []byte(`"B"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(keys, []string{"alice", "carol"}) {
+		t.Errorf("city >= B: %v", keys)
+	}
+
+	// A single node holds a replica of every key the others do not.
+	h.Isolate(3)
+
+	keys, err = query(1, nodeapi.IndexQuery{Max: []byte(`"Berlin"`), Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(keys, []string{"bob", "alice"}) {
+		t.Errorf("city <= Berlin: %v", keys)
+	}
+
+	if _, err := query(1, nodeapi.IndexQuery{Value: []byte(`{}`)}); grpcutil.ErrorCode(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an object, got %v", err)
+	}
+
+	if _, err := h.Node(1).Client().QueryIndex(ctx, nodeapi.IndexQuery{Namespace: "users", Field: "name", Value: []byte(`"bob"`)}); grpcutil.ErrorCode(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a field without index, got %v", err)
+	}
+}
+
+func TestQueryIndexACL(t *testing.T) {
+	namespaces := namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{
+		Name:              "users",
+		ReplicationFactor: 2,
+		Indexes:           []namespace.Index{{Field: "city"}},
+	})
+
+	h := clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+	ctx := context.Background()
+	opts := nodeapi.KeyOpts{Namespace: "users"}
+
+	for _, key := range []string{"public:alice", "public:bob", "secret:carol"} {
+		if _, err := h.Node(1).Client().PutKey(ctx, key, []byte(`{"city": "Berlin"}`), "", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The client may query the namespace, but not read the secret keys.
+	id := &auth.Identity{
+		Subject: "reader",
+		Rules: []auth.Rule{
+			{Namespace: "users", Perms: auth.PermRead},
+			{Namespace: "users", Prefix: "secret:"},
+		},
+	}
+
+	res, err := h.Node(1).Replication.QueryIndex(auth.NewContext(ctx, "token", id), &proto.QueryIndexRequest{
+		Namespace: "users",
+		Field:     "city",
+		Value:     []byte(`"Berlin"`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, len(res.Items))
+	for i, item := range res.Items {
+		keys[i] = item.Key
+	}
+
+	if !slices.Equal(keys, []string{"public:alice", "public:bob"}) {
+		t.Errorf("expected the secret keys to be left out, got %v", keys)
+	}
+}
+
+func TestChunkedValue(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+	ctx := context.Background()
+	opts := nodeapi.KeyOpts{Level: "quorum"}
+
+	// The first two chunks are identical, and are stored once.
+	value := make([]byte, 2*chunk.Size+1000)
+	for i := 2 * chunk.Size; i < len(value); i++ {
+		value[i] = byte(i)
+	}
+
+	if _, err := h.Node(1).Client().PutKeyStream(ctx, "blob", bytes.NewReader(value), "", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		got     []byte
+		version string
+	)
+
+	err := h.Node(2).Client().GetKeyStream(ctx, "blob", opts, func(part nodeapi.KeyPart) error {
+		if part.Index != 0 || (part.Count != 0 && part.Count != 1) {
+			t.Errorf("unexpected part %d of %d values", part.Index, part.Count)
+		}
+
+		if part.Version != "" {
+			version = part.Version
+		}
+
+		got = append(got, part.Data...)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, value) {
+		t.Fatalf("streamed %d bytes, expected %d", len(got), len(value))
+	}
+
+	if _, err := h.Node(2).Client().GetKey(ctx, "blob", opts); grpcutil.ErrorCode(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a chunked value, got %v", err)
+	}
+
+	if _, err := h.Node(2).Client().GetKey(ctx, chunk.Hash(value[2*chunk.Size:]), nodeapi.KeyOpts{Namespace: namespace.Chunks}); grpcutil.ErrorCode(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for the chunk namespace, got %v", err)
+	}
+
+	liveChunks := func(node *clustertest.Node) int {
+		live := 0
+
+		for _, hash := range []string{chunk.Hash(value[:chunk.Size]), chunk.Hash(value[2*chunk.Size:])} {
+			values, _ := node.Engine.Get(namespace.Key(namespace.Chunks, hash))
+			for _, v := range values {
+				if !v.Tombstone {
+					live++
+					break
+				}
+			}
+		}
+
+		return live
+	}
+
+	if n := liveChunks(h.Node(3)); n != 2 {
+		t.Fatalf("node 3 stores %d chunks", n)
+	}
+
+	// The chunks are kept as long as the value refers to them, and removed on
+	// the second collection after the value is deleted.
+	collectors := make(map[membership.NodeID]*chunk.Collector)
+
+	for _, node := range h.Nodes() {
+		collectors[node.ID] = chunk.NewCollector(node.Cluster, node.Engine, 0, kitlog.NewNopLogger())
+
+		if removed, err := collectors[node.ID].Collect(ctx); err != nil || removed != 0 {
+			t.Fatalf("collect on node %d: removed %d, %v", node.ID, removed, err)
+		}
+	}
+
+	if _, err := h.Node(1).Client().DeleteKey(ctx, "blob", version, nodeapi.KeyOpts{Level: "all"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 0; round < 2; round++ {
+		for _, node := range h.Nodes() {
+			if _, err := collectors[node.ID].Collect(ctx); err != nil {
+				t.Fatalf("collect on node %d: %v", node.ID, err)
+			}
+		}
+	}
+
+	for _, node := range h.Nodes() {
+		if n := liveChunks(node); n != 0 {
+			t.Errorf("node %d still stores %d chunks", node.ID, n)
+		}
+	}
+
+	// The same content is stored again once referred to by a new value.
+	if _, err := h.Node(1).Client().PutKeyStream(ctx, "again", bytes.NewReader(value), "", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	got = got[:0]
+
+	err = h.Node(3).Client().GetKeyStream(ctx, "again", opts, func(part nodeapi.KeyPart) error {
+		got = append(got, part.Data...)
+		return nil
+	})
+
+	if err != nil || !bytes.Equal(got, value) {
+		t.Errorf("streamed %d bytes after collection: %v", len(got), err)
+	}
+}
+
+// staleRefsCluster makes the collector see the chunk references as they were
+// before a value was written, and lets the test write it during the collection.
+type staleRefsCluster struct {
+	membership.Cluster
+	during func()
+}
+
+func (c *staleRefsCluster) ConnContext(ctx context.Context, id membership.NodeID) (nodeapi.Client, error) {
+	conn, err := c.Cluster.ConnContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return staleRefsConn{Client: conn, cluster: c}, nil
+}
+
+type staleRefsConn struct {
+	nodeapi.Client
+	cluster *staleRefsCluster
+}
+
+func (c staleRefsConn) StorageChunkRefs(context.Context) (*nodeapi.StorageChunkRefsResult, error) {
+	if during := c.cluster.during; during != nil {
+		c.cluster.during = nil
+		during()
+	}
+
+	return &nodeapi.StorageChunkRefsResult{}, nil
+}
+
+func TestChunkReusedDuringCollection(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 1})
+	ctx := context.Background()
+	opts := nodeapi.KeyOpts{Level: "all"}
+
+	value := make([]byte, 2*chunk.Size)
+	for i := range value {
+		value[i] = byte(i)
+	}
+
+	put := func(key string) string {
+		res, err := h.Node(1).Client().PutKeyStream(ctx, key, bytes.NewReader(value), "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res.Version
+	}
+
+	readable := func(key string) bool {
+		var got []byte
+
+		err := h.Node(1).Client().GetKeyStream(ctx, key, opts, func(part nodeapi.KeyPart) error {
+			got = append(got, part.Data...)
+			return nil
+		})
+
+		return err == nil && bytes.Equal(got, value)
+	}
+
+	// The chunk is orphaned once the value is deleted.
+	if _, err := h.Node(1).Client().DeleteKey(ctx, "old", put("old"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := &staleRefsCluster{Cluster: h.Node(1).Cluster}
+	collector := chunk.NewCollector(cluster, h.Node(1).Engine, 0, kitlog.NewNopLogger())
+
+	if _, err := collector.Collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The chunk reused before the collection starts is kept, even if the
+	// manifest referring to it is written after the references are collected.
+	version := put("new")
+
+	if removed, err := collector.Collect(ctx); err != nil || removed != 0 {
+		t.Fatalf("collect after the chunk is reused: removed %d, %v", removed, err)
+	}
+
+	if !readable("new") {
+		t.Fatal("the value reused before the collection is not readable")
+	}
+
+	// The chunk reused while the collection is running is kept as well.
+	if _, err := h.Node(1).Client().DeleteKey(ctx, "new", version, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster.during = func() { put("newer") }
+
+	if _, err := collector.Collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !readable("newer") {
+		t.Fatal("the value reused during the collection is not readable")
+	}
+}
+
+func TestChunkedValueQuota(t *testing.T) {
+	namespaces := namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{
+		Name:              "media",
+		ReadLevel:         consistency.Quorum,
+		WriteLevel:        consistency.Quorum,
+		ReplicationFactor: 3,
+		MaxBytes:          chunk.Size + chunk.Size/2,
+	})
+
+	h := clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+	ctx := context.Background()
+	opts := nodeapi.KeyOpts{Namespace: "media"}
+
+	value := make([]byte, 2*chunk.Size+1000)
+	for i := range value {
+		value[i] = byte(i / chunk.Size)
+	}
+
+	// The stream is rejected once it exceeds the quota, before its second chunk
+	// is written.
+	_, err := h.Node(1).Client().PutKeyStream(ctx, "big", bytes.NewReader(value), "", opts)
+	if grpcutil.ErrorCode(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	for _, node := range h.Nodes() {
+		if values, _ := node.Engine.Get(namespace.Key(namespace.Chunks, chunk.Hash(value[chunk.Size:2*chunk.Size]))); len(values) != 0 {
+			t.Errorf("node %d stores the chunk beyond the quota", node.ID)
+		}
+
+		if values, _ := node.Engine.Get(namespace.Key("media", "big")); len(values) != 0 {
+			t.Errorf("node %d stores the rejected value", node.ID)
+		}
+	}
+
+	// A value within the quota is accounted with its whole size, not with the
+	// size of its manifest.
+	if _, err := h.Node(1).Client().PutKeyStream(ctx, "small", bytes.NewReader(value[:chunk.Size+1000]), "", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := h.Node(2).Client().StorageUsage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := usage.Namespaces["media"].Bytes; size != chunk.Size+1000 {
+		t.Errorf("expected the value to be accounted with %d bytes, got %d", chunk.Size+1000, size)
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	var (
+		ctx = context.Background()
+		one = nodeapi.KeyOpts{Level: "one"}
+		all = nodeapi.KeyOpts{Level: "all"}
+	)
+
+	h := clustertest.New(t, clustertest.Options{
+		Nodes: 3,
+		ConfigureService: func(svc *service.ReplicationService) {
+			// Every read is sampled, and a single read makes a key hot.
+			svc.SetHotKeys(hotkeys.New(hotkeys.Config{SampleRate: 1, Threshold: 0.01}, env.Real()))
+			svc.SetReadCache(time.Minute, 16)
+		},
+	})
+
+	res, err := h.Node(1).Client().PutKey(ctx, "key", []byte("old"), "", all)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Run(clustertest.ExpectValue(1, "key", "old", one))
+
+	// The replicas are written behind the back of the coordinator, which keeps
+	// serving the reads at level one from the cache, but not the others.
+	version := vclock.MustDecode(res.Version)
+	version.Increment(2)
+
+	for _, node := range h.Nodes() {
+		_, err := node.Client().StoragePut(ctx, namespace.Key(namespace.Default, "key"), nodeapi.VersionedValue{
+			Version: vclock.Encode(version),
+			Data:    []byte("new"),
+		}, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.Run(
+		clustertest.ExpectValue(1, "key", "old", one),
+		clustertest.ExpectValue(1, "key", "new", all),
+		clustertest.ExpectValue(2, "key", "new", one),
+	)
+
+	// A write through the coordinator invalidates the cached value.
+	if _, err := h.Node(1).Client().PutKey(ctx, "key", []byte("newer"), vclock.Encode(version), all); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Run(clustertest.ExpectValue(1, "key", "newer", one))
+
+	if stats := h.Node(1).Replication.CacheStats(); stats.Hits != 1 || stats.Entries != 1 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/internal/vclock"
+)
+
+// maxSessionKeys is the number of keys whose last writes a session token
+// covers. The writes of the keys written the longest ago are dropped first.
+const maxSessionKeys = 64
+
+var (
+	errInvalidSession      = status.Error(codes.InvalidArgument, "invalid session token")
+	errSessionNotSatisfied = status.Error(codes.Unavailable, "no replica has seen the writes of the session")
+)
+
+// sessionWrite is the last write of a key made in a session.
+type sessionWrite struct {
+	// Key is the hash of the key, so that the token does not disclose the keys.
+	Key     string `json:"k"`
+	Version string `json:"v"`
+}
+
+// newSessionToken returns the token of the session after the write of the
+// version to the key. The token is opaque to the clients, and covers the last
+// writes of the keys written in the session, the one of the key replacing the
+// earlier one. An empty token starts a new session.
+func newSessionToken(token, key, version string) (string, error) {
+	writes, err := decodeSession(token)
+	if err != nil {
+		return "", err
+	}
+
+	hash := keyHash(key)
+
+	writes = slices.DeleteFunc(writes, func(w sessionWrite) bool {
+		return w.Key == hash
+	})
+
+	if len(writes) >= maxSessionKeys {
+		writes = writes[len(writes)-maxSessionKeys+1:]
+	}
+
+	data, err := json.Marshal(append(writes, sessionWrite{Key: hash, Version: version}))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// parseSessionToken returns the version of the last write of the key made in
+// the session, or nil if the key has not been written in the session.
+func parseSessionToken(token, key string) (vclock.Version, error) {
+	writes, err := decodeSession(token)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := keyHash(key)
+
+	for _, w := range writes {
+		if w.Key != hash {
+			continue
+		}
+
+		vc, err := vclock.Decode(w.Version)
+		if err != nil {
+			return nil, errInvalidSession
+		}
+
+		return vc, nil
+	}
+
+	return nil, nil
+}
+
+// decodeSession returns the writes covered by the token, oldest first.
+func decodeSession(token string) ([]sessionWrite, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidSession
+	}
+
+	var writes []sessionWrite
+	if err := json.Unmarshal(data, &writes); err != nil {
+		return nil, errInvalidSession
+	}
+
+	return writes, nil
+}
+
+func keyHash(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key)) //nolint:errcheck
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// seenSession returns true if any of the values, including the tombstones, is
+// the write of the session or a later one.
+func seenSession(values []nodeValue, session vclock.Version) bool {
+	for _, v := range values {
+		version, err := vclock.Decode(v.Version)
+		if err != nil {
+			continue
+		}
+
+		if c := vclock.Compare(version, session); c == vclock.After || c == vclock.Equal {
+			return true
+		}
+	}
+
+	return false
+}
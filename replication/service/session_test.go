@@ -0,0 +1,39 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sadath-12/keywave/internal/vclock"
+)
+
+func TestSessionTokenCoversLastKeys(t *testing.T) {
+	var (
+		token string
+		err   error
+	)
+
+	for i := 0; i <= maxSessionKeys; i++ {
+		if token, err = newSessionToken(token, fmt.Sprint("key", i), vclock.Encode(vclock.Version{1: uint64(i + 1)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A later write of a key replaces the earlier one.
+	if token, err = newSessionToken(token, "key1", vclock.Encode(vclock.Version{1: 100})); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write of the key written the longest ago is dropped first.
+	if version, err := parseSessionToken(token, "key0"); err != nil || version != nil {
+		t.Errorf("expected the first key to be dropped, got %v, %v", version, err)
+	}
+
+	if version, err := parseSessionToken(token, "key1"); err != nil || version[1] != 100 {
+		t.Errorf("expected the last write of the key, got %v, %v", version, err)
+	}
+
+	if version, err := parseSessionToken(token, fmt.Sprint("key", maxSessionKeys)); err != nil || version == nil {
+		t.Errorf("expected the last key to be covered, got %v, %v", version, err)
+	}
+}
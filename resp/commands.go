@@ -0,0 +1,583 @@
+package resp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/internal/frontend"
+	"github.com/sadath-12/keywave/internal/grpcutil"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication/consistency"
+)
+
+// command is a command handler along with the number of arguments it takes,
+// including the name of the command. A negative arity is the minimum number
+// of arguments.
+type command struct {
+	handler func(c *client, args [][]byte)
+	arity   int
+	// noAuth is set for the commands allowed before the client authenticates.
+	noAuth bool
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":    {handler: (*client).ping, arity: -1, noAuth: true},
+		"ECHO":    {handler: (*client).echo, arity: 2, noAuth: true},
+		"QUIT":    {handler: (*client).quitCmd, arity: 1, noAuth: true},
+		"AUTH":    {handler: (*client).auth, arity: -2, noAuth: true},
+		"SELECT":  {handler: (*client).selectDB, arity: 2},
+		"CLIENT":  {handler: (*client).ok, arity: -2},
+		"COMMAND": {handler: (*client).commandCmd, arity: -1},
+
+		"GET":     {handler: (*client).get, arity: 2},
+		"SET":     {handler: (*client).set, arity: -3},
+		"DEL":     {handler: (*client).del, arity: -2},
+		"EXISTS":  {handler: (*client).exists, arity: -2},
+		"MGET":    {handler: (*client).mget, arity: -2},
+		"MSET":    {handler: (*client).mset, arity: -3},
+		"EXPIRE":  {handler: (*client).expire, arity: 3},
+		"PEXPIRE": {handler: (*client).expire, arity: 3},
+		"SCAN":    {handler: (*client).scan, arity: -2},
+
+		"KW.LEVEL":     {handler: (*client).kwLevel, arity: -2},
+		"KW.NAMESPACE": {handler: (*client).kwNamespace, arity: -1},
+		"KW.GET":       {handler: (*client).kwGet, arity: 2},
+		"KW.SET":       {handler: (*client).kwSet, arity: -4},
+		"KW.DEL":       {handler: (*client).kwDel, arity: 3},
+	}
+}
+
+// client is the state of a connection.
+type client struct {
+	server *Server
+	ctx    context.Context
+	r      *reader
+	w      *writer
+	quit   bool
+
+	// authCtx carries the token of the client once it has authenticated.
+	authCtx   context.Context
+	identity  *auth.Identity
+	namespace string
+	// readLevel and writeLevel are the consistency levels of the commands.
+	// Empty means the default levels of the namespace.
+	readLevel  string
+	writeLevel string
+}
+
+func (c *client) handle(args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.Error("ERR unknown command '" + string(args[0]) + "'")
+		return
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.Error("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		return
+	}
+
+	if !cmd.noAuth && c.server.authn != nil && c.identity == nil {
+		c.w.Error("NOAUTH Authentication required.")
+		return
+	}
+
+	cmd.handler(c, args)
+}
+
+// callContext returns the context of a call to the replication API, carrying
+// the token of the client.
+func (c *client) callContext() context.Context {
+	if c.authCtx == nil {
+		return c.ctx
+	}
+
+	return auth.OutgoingContext(c.authCtx)
+}
+
+func (c *client) readOpts() nodeapi.KeyOpts {
+	return nodeapi.KeyOpts{Namespace: c.namespace, Level: c.readLevel}
+}
+
+func (c *client) writeOpts() nodeapi.KeyOpts {
+	return nodeapi.KeyOpts{Namespace: c.namespace, Level: c.writeLevel}
+}
+
+// writeError converts an error returned by the replication API into an error
+// reply, with the error code the Redis clients expect where there is one.
+func (c *client) writeError(err error) {
+	msg := status.Convert(err).Message()
+
+	if _, ok := admission.RetryAfter(err); ok {
+		c.w.Error("TRYAGAIN " + msg)
+		return
+	}
+
+	switch grpcutil.ErrorCode(err) {
+	case codes.Unauthenticated:
+		c.w.Error("NOAUTH " + msg)
+	case codes.PermissionDenied:
+		c.w.Error("NOPERM " + msg)
+	case codes.AlreadyExists:
+		c.w.Error("CONFLICT " + msg)
+	case codes.ResourceExhausted:
+		c.w.Error("OOM " + msg)
+	case codes.Unavailable, codes.DeadlineExceeded:
+		c.w.Error("TRYAGAIN " + msg)
+	default:
+		c.w.Error("ERR " + msg)
+	}
+}
+
+// writeConflict is the reply to a command that expects a single value of a key
+// having several concurrent ones.
+func (c *client) writeConflict(n int) {
+	c.w.Error("CONFLICT key has " + strconv.Itoa(n) + " concurrent values, use KW.GET and KW.SET to resolve them")
+}
+
+// readKey returns the values and the version of the key.
+func (c *client) readKey(key string) ([][]byte, string, error) {
+	return frontend.ReadKey(c.callContext(), c.server.conns.LocalConn(), key, c.readOpts())
+}
+
+// writeKey writes the value of the key over the given version.
+func (c *client) writeKey(key string, value []byte, version string, ttl time.Duration) (string, error) {
+	opts := c.writeOpts()
+	opts.TTL = ttl
+
+	return frontend.WriteKey(c.callContext(), c.server.conns.LocalConn(), key, value, version, opts)
+}
+
+func (c *client) ok([][]byte) {
+	c.w.SimpleString("OK")
+}
+
+func (c *client) ping(args [][]byte) {
+	if len(args) > 1 {
+		c.w.Bulk(args[1])
+		return
+	}
+
+	c.w.SimpleString("PONG")
+}
+
+func (c *client) echo(args [][]byte) {
+	c.w.Bulk(args[1])
+}
+
+func (c *client) quitCmd([][]byte) {
+	c.w.SimpleString("OK")
+	c.quit = true
+}
+
+// auth takes the token as the password, and the user name, if given, is
+// ignored, since the token identifies the client.
+func (c *client) auth(args [][]byte) {
+	if len(args) > 3 {
+		c.w.Error("ERR syntax error")
+		return
+	}
+
+	if c.server.authn == nil {
+		c.w.Error("ERR AUTH called without any authentication configured")
+		return
+	}
+
+	token := string(args[len(args)-1])
+
+	id, err := c.server.authn.Authenticate(token)
+	if err != nil {
+		c.w.Error("WRONGPASS invalid token")
+		return
+	}
+
+	c.authCtx = auth.NewContext(c.ctx, token, id)
+	c.identity = id
+	c.w.SimpleString("OK")
+}
+
+// selectDB only accepts the database 0, as the namespaces are selected with
+// KW.NAMESPACE instead.
+func (c *client) selectDB(args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.Error("ERR DB index is out of range")
+		return
+	}
+
+	c.w.SimpleString("OK")
+}
+
+// commandCmd replies with no command details, which the clients treat as the
+// server not describing its commands.
+func (c *client) commandCmd([][]byte) {
+	c.w.Array(0)
+}
+
+func (c *client) get(args [][]byte) {
+	values, _, err := c.readKey(string(args[1]))
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	switch len(values) {
+	case 0:
+		c.w.Nil()
+	case 1:
+		c.w.Bulk(values[0])
+	default:
+		c.writeConflict(len(values))
+	}
+}
+
+// set overwrites the key with the value, replacing all of its concurrent
+// values. The options are EX and PX to set the lifetime of the value, NX to
+// only set a missing key and XX to only set an existing one.
+func (c *client) set(args [][]byte) {
+	var (
+		key     = string(args[1])
+		value   = args[2]
+		ttl     time.Duration
+		nx, xx  bool
+		timeSet bool
+	)
+
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if timeSet || i+1 == len(args) {
+				c.w.Error("ERR syntax error")
+				return
+			}
+
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				c.w.Error("ERR invalid expire time in 'set' command")
+				return
+			}
+
+			if ttl = time.Duration(n) * time.Millisecond; opt == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+
+			timeSet = true
+			i++
+		default:
+			c.w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	if nx && xx {
+		c.w.Error("ERR syntax error")
+		return
+	}
+
+	// The version is read first, as a write without it is rejected by the
+	// replicas that have seen an earlier write of the key.
+	values, version, err := c.readKey(key)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	if (nx && len(values) > 0) || (xx && len(values) == 0) {
+		c.w.Nil()
+		return
+	}
+
+	if _, err := c.writeKey(key, value, version, ttl); err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.SimpleString("OK")
+}
+
+// deleteKey deletes the key, and reports whether it had any value.
+func (c *client) deleteKey(key string) (bool, error) {
+	values, version, err := c.readKey(key)
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+
+	if _, err := c.server.conns.LocalConn().DeleteKey(c.callContext(), key, version, c.writeOpts()); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (c *client) del(args [][]byte) {
+	var deleted int64
+
+	for _, key := range args[1:] {
+		ok, err := c.deleteKey(string(key))
+		if err != nil {
+			c.writeError(err)
+			return
+		}
+
+		if ok {
+			deleted++
+		}
+	}
+
+	c.w.Int(deleted)
+}
+
+func (c *client) exists(args [][]byte) {
+	var found int64
+
+	for _, key := range args[1:] {
+		values, _, err := c.readKey(string(key))
+		if err != nil {
+			c.writeError(err)
+			return
+		}
+
+		if len(values) > 0 {
+			found++
+		}
+	}
+
+	c.w.Int(found)
+}
+
+// mget replies with the value of each key, or an error in place of the value
+// if it cannot be read.
+func (c *client) mget(args [][]byte) {
+	c.w.Array(len(args) - 1)
+
+	for _, key := range args[1:] {
+		values, _, err := c.readKey(string(key))
+
+		switch {
+		case err != nil:
+			c.writeError(err)
+		case len(values) == 0:
+			c.w.Nil()
+		case len(values) == 1:
+			c.w.Bulk(values[0])
+		default:
+			c.writeConflict(len(values))
+		}
+	}
+}
+
+// mset sets the keys one by one, so unlike in Redis, the keys set before a
+// failure keep their new values.
+func (c *client) mset(args [][]byte) {
+	if len(args)%2 != 1 {
+		c.w.Error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		key := string(args[i])
+
+		_, version, err := c.readKey(key)
+		if err != nil {
+			c.writeError(err)
+			return
+		}
+
+		if _, err := c.writeKey(key, args[i+1], version, 0); err != nil {
+			c.writeError(err)
+			return
+		}
+	}
+
+	c.w.SimpleString("OK")
+}
+
+// expire rewrites the value of the key with the new lifetime, as the lifetime
+// is bound to the value. A lifetime that is not positive deletes the key.
+func (c *client) expire(args [][]byte) {
+	key := string(args[1])
+
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.Error("ERR value is not an integer or out of range")
+		return
+	}
+
+	ttl := time.Duration(n) * time.Millisecond
+	if strings.EqualFold(string(args[0]), "EXPIRE") {
+		ttl = time.Duration(n) * time.Second
+	}
+
+	values, version, err := c.readKey(key)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	if len(values) == 0 {
+		c.w.Int(0)
+		return
+	}
+
+	if len(values) > 1 {
+		c.writeConflict(len(values))
+		return
+	}
+
+	if ttl <= 0 {
+		_, err = c.server.conns.LocalConn().DeleteKey(c.callContext(), key, version, c.writeOpts())
+	} else {
+		_, err = c.writeKey(key, values[0], version, ttl)
+	}
+
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.Int(1)
+}
+
+// kwLevel sets the consistency level of the reads, the writes, or both if
+// neither is given. The level "default" restores the levels of the namespace.
+func (c *client) kwLevel(args [][]byte) {
+	var read, write bool
+
+	switch {
+	case len(args) == 2:
+		read, write = true, true
+	case len(args) == 3 && strings.EqualFold(string(args[1]), "READ"):
+		read = true
+	case len(args) == 3 && strings.EqualFold(string(args[1]), "WRITE"):
+		write = true
+	default:
+		c.w.Error("ERR syntax error")
+		return
+	}
+
+	name := strings.ToLower(string(args[len(args)-1]))
+
+	if name == "default" {
+		name = ""
+	} else if _, ok := consistency.FromString(name); !ok {
+		c.w.Error("ERR unknown consistency level '" + name + "'")
+		return
+	}
+
+	if read {
+		c.readLevel = name
+	}
+
+	if write {
+		c.writeLevel = name
+	}
+
+	c.w.SimpleString("OK")
+}
+
+// kwNamespace selects the namespace of the keys, or replies with the current
+// one if no name is given.
+func (c *client) kwNamespace(args [][]byte) {
+	if len(args) == 1 {
+		c.w.BulkString(c.namespaceName())
+		return
+	}
+
+	if len(args) > 2 {
+		c.w.Error("ERR wrong number of arguments for 'kw.namespace' command")
+		return
+	}
+
+	name := string(args[1])
+	if !namespace.ValidName(name) || namespace.Internal(name) {
+		c.w.Error("ERR invalid namespace name")
+		return
+	}
+
+	c.namespace = name
+	c.w.SimpleString("OK")
+}
+
+func (c *client) namespaceName() string {
+	if c.namespace == "" {
+		return namespace.Default
+	}
+
+	return c.namespace
+}
+
+// kwGet replies with the version of the key followed by all of its values.
+func (c *client) kwGet(args [][]byte) {
+	values, version, err := c.readKey(string(args[1]))
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.Array(len(values) + 1)
+	c.w.BulkString(version)
+
+	for _, v := range values {
+		c.w.Bulk(v)
+	}
+}
+
+// kwSet writes the value over the version returned by KW.GET, and replies with
+// the new version. The write fails with a conflict if the version is obsolete.
+// The lifetime of the value may be given with EX or PX.
+func (c *client) kwSet(args [][]byte) {
+	var ttl time.Duration
+
+	switch {
+	case len(args) == 4:
+	case len(args) == 6 && (strings.EqualFold(string(args[4]), "EX") || strings.EqualFold(string(args[4]), "PX")):
+		n, err := strconv.ParseInt(string(args[5]), 10, 64)
+		if err != nil || n <= 0 {
+			c.w.Error("ERR invalid expire time in 'kw.set' command")
+			return
+		}
+
+		if ttl = time.Duration(n) * time.Millisecond; strings.EqualFold(string(args[4]), "EX") {
+			ttl = time.Duration(n) * time.Second
+		}
+	default:
+		c.w.Error("ERR syntax error")
+		return
+	}
+
+	version, err := c.writeKey(string(args[1]), args[2], string(args[3]), ttl)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.BulkString(version)
+}
+
+// kwDel deletes the key at the version returned by KW.GET, and replies with the
+// version of the deletion.
+func (c *client) kwDel(args [][]byte) {
+	res, err := c.server.conns.LocalConn().DeleteKey(c.callContext(), string(args[1]), string(args[2]), c.writeOpts())
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	c.w.BulkString(res.Version)
+}
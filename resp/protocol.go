@@ -0,0 +1,184 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBulkLen limits the size of a single argument of a command.
+	maxBulkLen = 64 << 20
+	// maxArgs limits the number of arguments of a command.
+	maxArgs = 1 << 20
+	// maxInlineLen limits the length of an inline command.
+	maxInlineLen = 64 << 10
+)
+
+var errProtocol = errors.New("protocol error")
+
+// reader reads the commands sent by a client. A command is either an array of
+// bulk strings, which is what the client libraries send, or an inline command
+// with the arguments separated by spaces, as typed in a telnet session.
+type reader struct {
+	r *bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// Buffered reports whether the client has already sent more data, in which
+// case the replies are not flushed until the pipelined commands are handled.
+func (r *reader) Buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+// ReadCommand returns the arguments of the next command. An empty command,
+// e.g. a blank inline line, is returned as no arguments.
+func (r *reader) ReadCommand() ([][]byte, error) {
+	prefix, err := r.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix[0] != '*' {
+		return r.readInline()
+	}
+
+	n, err := r.readLength('*')
+	if err != nil {
+		return nil, err
+	}
+
+	if n > maxArgs {
+		return nil, fmt.Errorf("%w: too many arguments", errProtocol)
+	}
+
+	args := make([][]byte, 0, max(n, 0))
+
+	for i := 0; i < n; i++ {
+		size, err := r.readLength('$')
+		if err != nil {
+			return nil, err
+		}
+
+		if size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r.r, buf); err != nil {
+			return nil, err
+		}
+
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated", errProtocol)
+		}
+
+		args = append(args, buf[:size])
+	}
+
+	return args, nil
+}
+
+func (r *reader) readLine() (string, error) {
+	line, err := r.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return "", fmt.Errorf("%w: line too long", errProtocol)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (r *reader) readLength(prefix byte) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(line) == 0 || line[0] != prefix {
+		return 0, fmt.Errorf("%w: expected '%c'", errProtocol, prefix)
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid length", errProtocol)
+	}
+
+	return n, nil
+}
+
+func (r *reader) readInline() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) > maxInlineLen {
+		return nil, fmt.Errorf("%w: inline command too long", errProtocol)
+	}
+
+	fields := strings.Fields(line)
+	args := make([][]byte, len(fields))
+
+	for i, f := range fields {
+		args[i] = []byte(f)
+	}
+
+	return args, nil
+}
+
+// writer writes the replies in the RESP2 format, which all clients understand.
+type writer struct {
+	w *bufio.Writer
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w)}
+}
+
+func (w *writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *writer) SimpleString(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+// Error writes an error reply. The message starts with the error code, such as
+// ERR or WRONGTYPE, and must not contain line breaks.
+func (w *writer) Error(msg string) {
+	w.w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(msg) + "\r\n")
+}
+
+func (w *writer) Int(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *writer) Bulk(data []byte) {
+	w.w.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+	w.w.Write(data)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) BulkString(s string) {
+	w.Bulk([]byte(s))
+}
+
+// Nil writes the null bulk string, which is the reply for a missing key.
+func (w *writer) Nil() {
+	w.w.WriteString("$-1\r\n")
+}
+
+// Array writes the header of an array, which is followed by its n elements.
+func (w *writer) Array(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,342 @@
+package resp
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+const (
+	defaultScanCount = 10
+	maxScanCount     = 10000
+	// maxCursors is the number of scans the server keeps the position of. The
+	// oldest cursor is forgotten when a new scan starts beyond that number.
+	maxCursors = 4096
+)
+
+var errScanLimit = errors.New("scan limit reached")
+
+// cursorTable maps the numeric cursors returned to the clients to the key the
+// scan stopped at, as the clients expect the cursors to be numbers.
+type cursorTable struct {
+	mut   sync.Mutex
+	next  uint64
+	keys  map[uint64]string
+	order []uint64
+	size  int
+}
+
+func newCursorTable(size int) *cursorTable {
+	return &cursorTable{
+		next: 1,
+		keys: make(map[uint64]string, size),
+		size: size,
+	}
+}
+
+// Add returns a new cursor pointing at the key.
+func (t *cursorTable) Add(key string) uint64 {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if len(t.order) == t.size {
+		delete(t.keys, t.order[0])
+		t.order = t.order[1:]
+	}
+
+	cursor := t.next
+	t.next++
+
+	t.keys[cursor] = key
+	t.order = append(t.order, cursor)
+
+	return cursor
+}
+
+// Get returns the key the cursor points at.
+func (t *cursorTable) Get(cursor uint64) (string, bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	key, ok := t.keys[cursor]
+
+	return key, ok
+}
+
+// scan lists the live keys of the namespace in the order of the keys. Each call
+// examines up to COUNT keys following the cursor, and replies with those
+// matching the MATCH pattern, so it may return fewer keys than COUNT, or none,
+// before the scan is complete, as in Redis.
+func (c *client) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.w.Error("ERR invalid cursor")
+		return
+	}
+
+	var (
+		pattern string
+		count   = defaultScanCount
+	)
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.w.Error("ERR syntax error")
+			return
+		}
+
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			n, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || n < 1 {
+				c.w.Error("ERR value is not an integer or out of range")
+				return
+			}
+
+			count = min(n, maxScanCount)
+		default:
+			c.w.Error("ERR syntax error")
+			return
+		}
+	}
+
+	var after string
+
+	if cursor != 0 {
+		var ok bool
+
+		if after, ok = c.server.cursors.Get(cursor); !ok {
+			c.w.Error("ERR invalid cursor")
+			return
+		}
+	}
+
+	keys, more, err := c.scanKeys(after, count)
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+
+	var next uint64
+	if more {
+		next = c.server.cursors.Add(keys[len(keys)-1])
+	}
+
+	matched := keys[:0]
+
+	for _, key := range keys {
+		if pattern != "" && !matchGlob(pattern, key) {
+			continue
+		}
+
+		// The storage API is not subject to access control, so the keys the
+		// client cannot read are left out here.
+		if c.identity != nil && !c.identity.Allowed(auth.PermRead, c.namespaceName(), key) {
+			continue
+		}
+
+		matched = append(matched, key)
+	}
+
+	c.w.Array(2)
+	c.w.BulkString(strconv.FormatUint(next, 10))
+	c.w.Array(len(matched))
+
+	for _, key := range matched {
+		c.w.BulkString(key)
+	}
+}
+
+// scanKeys returns the first keys of the namespace following the given one, up
+// to the count, and whether there may be more. Since none of the nodes
+// necessarily stores all keys, each of them is scanned for the first keys it
+// has, and the smallest ones of all nodes are kept.
+func (c *client) scanKeys(after string, count int) ([]string, bool, error) {
+	var (
+		ns      = c.namespaceName()
+		nodes   = c.server.cluster.Nodes()
+		results = make([][]string, len(nodes))
+		full    = make([]bool, len(nodes))
+		now     = time.Now().UnixMilli()
+	)
+
+	errg, ctx := errgroup.WithContext(c.ctx)
+
+	for i, node := range nodes {
+		if node.Status == membership.StatusLeft {
+			continue
+		}
+
+		i, node := i, node
+
+		errg.Go(func() error {
+			conn, err := c.server.cluster.ConnContext(ctx, node.ID)
+			if err != nil {
+				return err
+			}
+
+			start := namespace.Key(ns, after)
+
+			err = conn.StorageScan(ctx, start, func(item nodeapi.StorageScanItem) error {
+				itemNS, key, ok := namespace.SplitKey(item.Key)
+				if !ok || itemNS != ns {
+					return errScanLimit
+				}
+
+				if (after != "" && key <= after) || !live(item.Versions, now) {
+					return nil
+				}
+
+				results[i] = append(results[i], key)
+
+				if len(results[i]) == count {
+					full[i] = true
+					return errScanLimit
+				}
+
+				return nil
+			})
+
+			if err != nil && !errors.Is(err, errScanLimit) {
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	if err := errg.Wait(); err != nil {
+		return nil, false, err
+	}
+
+	var keys []string
+	for _, res := range results {
+		keys = append(keys, res...)
+	}
+
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	more := slices.Contains(full, true) || len(keys) > count
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+
+	return keys, more && len(keys) > 0, nil
+}
+
+// live reports whether any of the versions of a key is a value that has not
+// expired.
+func live(versions []nodeapi.VersionedValue, nowMillis int64) bool {
+	for _, v := range versions {
+		if !v.Tombstone && (v.ExpiresAt == 0 || v.ExpiresAt > nowMillis) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob reports whether the string matches the glob-style pattern of the
+// Redis commands, where * matches any sequence of characters, ? any single
+// character, [...] any character of the set, with ^ negating it and - giving a
+// range, and \ escapes the character following it.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			rest, ok := matchClass(pattern[1:], s[0])
+			if !ok {
+				return false
+			}
+
+			s = s[1:]
+			pattern = rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+
+	return len(s) == 0
+}
+
+// matchClass matches the character against the set of characters the pattern
+// starts with, and returns the pattern following the set.
+func matchClass(pattern string, ch byte) (string, bool) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			matched = matched || pattern[1] == ch
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+			matched = matched || (ch >= lo && ch <= hi)
+			pattern = pattern[3:]
+		default:
+			matched = matched || pattern[0] == ch
+			pattern = pattern[1:]
+		}
+	}
+
+	// An unterminated set matches up to the end of the pattern, as in Redis.
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+
+	return pattern, matched != negate
+}
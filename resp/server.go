@@ -0,0 +1,110 @@
+// Package resp implements a front-end speaking the Redis serialization protocol,
+// so that the existing Redis clients can read and write the keys of the cluster.
+// The commands are mapped onto the replication API of the local node, and are
+// subject to the same consistency levels and access control as the other
+// clients.
+//
+// The keys are strings, as in Redis, and the other data types are not
+// supported. A key having several concurrent values cannot be read with GET,
+// as the Redis clients only expect one, so the KW.* commands expose the values
+// along with the version of the key, for the clients aware of the versions.
+package resp
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/internal/frontend"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/nodeapi"
+)
+
+// ErrServerClosed is returned by Serve after the server is shut down.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Server accepts the connections of the Redis clients.
+type Server struct {
+	cluster membership.Cluster
+	authn   auth.Authenticator
+	logger  kitlog.Logger
+	cursors *cursorTable
+	conns   *frontend.Server
+}
+
+// NewServer creates a server forwarding the commands to the local node of the
+// cluster. The authenticator is nil if the authentication is disabled.
+func NewServer(cluster membership.Cluster, authn auth.Authenticator, logger kitlog.Logger) *Server {
+	return &Server{
+		cluster: cluster,
+		authn:   authn,
+		logger:  logger,
+		cursors: newCursorTable(maxCursors),
+		conns:   frontend.NewServer(cluster, ErrServerClosed),
+	}
+}
+
+// SetLocalConn sets the connection the commands are sent over, in place of the
+// connection of the cluster to the local node. The commands are made on behalf
+// of the address of the client, which is only trusted by the admission control
+// on a connection with the admission.Controller.GatewayCredentials, so that the
+// clients are not all limited together.
+func (s *Server) SetLocalConn(conn nodeapi.Client) {
+	s.conns.SetLocalConn(conn)
+}
+
+// Serve accepts the connections on the listener until the server is shut down,
+// handling each of them in its own goroutine.
+func (s *Server) Serve(l net.Listener) error {
+	return s.conns.Serve(l, s.serveConn)
+}
+
+// Shutdown stops accepting connections and closes the open ones, waiting for
+// the commands in progress to complete or for the context to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.conns.Shutdown(ctx)
+}
+
+// serveConn reads the commands of the connection until it is closed. The
+// replies are buffered while the client has sent more commands, so that the
+// pipelined commands are answered with a single write.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	c := &client{
+		server: s,
+		ctx:    ctx,
+		r:      newReader(conn),
+		w:      newWriter(conn),
+	}
+
+	for {
+		args, err := c.r.ReadCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.Error("ERR " + err.Error())
+				c.w.Flush()
+			}
+
+			return
+		}
+
+		if len(args) > 0 {
+			c.handle(args)
+		}
+
+		if c.quit {
+			c.w.Flush()
+			return
+		}
+
+		if !c.r.Buffered() {
+			if err := c.w.Flush(); err != nil {
+				level.Debug(s.logger).Log("msg", "failed to write reply", "remote", conn.RemoteAddr(), "err", err)
+				return
+			}
+		}
+	}
+}
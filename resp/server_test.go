@@ -0,0 +1,367 @@
+package resp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"google.golang.org/grpc"
+
+	"github.com/sadath-12/keywave/admission"
+	"github.com/sadath-12/keywave/auth"
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/internal/clustertest"
+	"github.com/sadath-12/keywave/internal/env"
+	"github.com/sadath-12/keywave/internal/frontend/frontendtest"
+	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/nodeapi"
+	"github.com/sadath-12/keywave/replication/service"
+)
+
+// redisError is an error reply.
+type redisError string
+
+type testClient struct {
+	*frontendtest.Conn
+}
+
+func dialServer(t *testing.T, addr string) *testClient {
+	t.Helper()
+
+	return &testClient{frontendtest.Dial(t, addr)}
+}
+
+func encodeCommand(args ...string) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	return buf.Bytes()
+}
+
+// Do sends the command and returns the reply, where the bulk strings are
+// strings, the null bulk string is nil and the arrays are slices.
+func (c *testClient) Do(args ...string) any {
+	c.T.Helper()
+
+	if _, err := c.Write(encodeCommand(args...)); err != nil {
+		c.T.Fatal(err)
+	}
+
+	return c.read()
+}
+
+func (c *testClient) read() any {
+	c.T.Helper()
+
+	line, err := c.R.ReadString('\n')
+	if err != nil {
+		c.T.Fatal(err)
+	}
+
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return redisError(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.R, buf); err != nil {
+			c.T.Fatal(err)
+		}
+
+		return string(buf[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]any, n)
+
+		for i := range items {
+			items[i] = c.read()
+		}
+
+		return items
+	default:
+		c.T.Fatalf("unexpected reply %q", line)
+		return nil
+	}
+}
+
+func (c *testClient) expect(want any, args ...string) {
+	c.T.Helper()
+
+	got := c.Do(args...)
+
+	if e, ok := want.(redisError); ok {
+		if msg, isErr := got.(redisError); !isErr || !bytes.HasPrefix([]byte(msg), []byte(e)) {
+			c.T.Errorf("%v: expected error %q, got %#v", args, e, got)
+		}
+
+		return
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		c.T.Errorf("%v: expected %#v, got %#v", args, want, got)
+	}
+}
+
+func startServer(t *testing.T, h *clustertest.Harness, authn auth.Authenticator) string {
+	t.Helper()
+
+	return frontendtest.Serve(t, NewServer(h.Node(1).Cluster, authn, kitlog.NewNopLogger()), ErrServerClosed)
+}
+
+func TestCommands(t *testing.T) {
+	h := clustertest.New(t, clustertest.Options{Nodes: 3})
+	c := dialServer(t, startServer(t, h, nil))
+
+	c.expect("PONG", "PING")
+	c.expect(redisError("ERR AUTH"), "AUTH", "token")
+	c.expect(redisError("ERR unknown command"), "HELLO", "3")
+	c.expect(redisError("ERR wrong number of arguments"), "GET")
+
+	c.expect(nil, "GET", "a")
+	c.expect("OK", "SET", "a", "1")
+	c.expect("1", "GET", "a")
+	c.expect("OK", "SET", "a", "2")
+	c.expect("2", "GET", "a")
+	c.expect(nil, "SET", "a", "3", "NX")
+	c.expect(nil, "SET", "b", "3", "XX")
+	c.expect("OK", "SET", "b", "3", "NX")
+	c.expect(redisError("ERR syntax error"), "SET", "b", "3", "NX", "XX")
+
+	c.expect("OK", "MSET", "c", "4", "d", "5")
+	c.expect([]any{"2", nil, "4", "5"}, "MGET", "a", "x", "c", "d")
+	c.expect(int64(3), "EXISTS", "a", "x", "c", "a")
+	c.expect(int64(2), "DEL", "c", "d", "x")
+	c.expect(int64(0), "EXISTS", "c", "d")
+	c.expect("OK", "SET", "c", "again")
+	c.expect("again", "GET", "c")
+
+	// The values are written over the deletions and expire with their TTL.
+	c.expect(int64(0), "PEXPIRE", "x", "100")
+	c.expect(int64(1), "PEXPIRE", "c", "100")
+	c.expect("again", "GET", "c")
+	c.expect("OK", "SET", "e", "5", "PX", "100")
+
+	time.Sleep(150 * time.Millisecond)
+
+	c.expect(nil, "GET", "c")
+	c.expect(nil, "GET", "e")
+	c.expect(int64(1), "EXPIRE", "b", "0")
+	c.expect(nil, "GET", "b")
+
+	// The values larger than a request are stored in chunks.
+	large := string(bytes.Repeat([]byte("0123456789"), chunk.Size/5))
+	c.expect("OK", "SET", "large", large)
+	c.expect(large, "GET", "large")
+
+	// Replies to pipelined commands.
+	pipeline := append(encodeCommand("SET", "p", "1"), encodeCommand("GET", "p")...)
+	if _, err := c.Write(append(pipeline, "PING\r\n"...)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []any{"OK", "1", "PONG"} {
+		if got := c.read(); got != want {
+			t.Errorf("pipeline: expected %#v, got %#v", want, got)
+		}
+	}
+
+	c.expect("OK", "QUIT")
+}
+
+func TestVersions(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		namespaces = namespace.NewRegistry(service.DefaultNamespace(), namespace.Config{Name: "users"})
+		h          = clustertest.New(t, clustertest.Options{Nodes: 3, Namespaces: namespaces})
+		c          = dialServer(t, startServer(t, h, nil))
+	)
+
+	// Concurrent values of the same key on every replica.
+	for _, node := range h.Nodes() {
+		for _, id := range []membership.NodeID{1, 2} {
+			_, err := node.Client().StoragePut(ctx, namespace.Key("users", "k"), nodeapi.VersionedValue{
+				Version: vclock.Encode(vclock.Version{uint32(id): 1}),
+				Data:    []byte{'a' + byte(id)},
+			}, false)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c.expect(nil, "GET", "k")
+	c.expect("default", "KW.NAMESPACE")
+	c.expect(redisError("ERR invalid namespace"), "KW.NAMESPACE", "_chunks")
+	c.expect("OK", "KW.NAMESPACE", "users")
+	c.expect(redisError("ERR unknown consistency level"), "KW.LEVEL", "most")
+	c.expect("OK", "KW.LEVEL", "READ", "all")
+
+	c.expect(redisError("CONFLICT"), "GET", "k")
+	c.expect(redisError("CONFLICT"), "EXPIRE", "k", "10")
+
+	reply, _ := c.Do("KW.GET", "k").([]any)
+	if len(reply) != 3 {
+		t.Fatalf("expected the version and 2 values, got %#v", reply)
+	}
+
+	version := reply[0].(string)
+
+	newVersion, ok := c.Do("KW.SET", "k", "resolved", version).(string)
+	if !ok {
+		t.Fatalf("failed to resolve the conflict")
+	}
+
+	c.expect("resolved", "GET", "k")
+	c.expect(redisError("CONFLICT"), "KW.SET", "k", "stale", version)
+
+	deleted, ok := c.Do("KW.DEL", "k", newVersion).(string)
+	if !ok {
+		t.Fatalf("failed to delete the key")
+	}
+
+	c.expect([]any{deleted}, "KW.GET", "k")
+}
+
+func TestScan(t *testing.T) {
+	var (
+		h        = clustertest.New(t, clustertest.Options{Nodes: 3})
+		identity = &auth.Identity{Rules: []auth.Rule{
+			{Perms: auth.PermRead | auth.PermWrite | auth.PermDelete},
+			{Prefix: "secret:", Perms: auth.PermWrite},
+		}}
+		c = dialServer(t, startServer(t, h, frontendtest.StaticAuth{"token": identity}))
+	)
+
+	c.expect(redisError("NOAUTH"), "SET", "a", "1")
+	c.expect(redisError("WRONGPASS"), "AUTH", "wrong")
+	c.expect("OK", "AUTH", "user", "token")
+
+	var want []any
+
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key:%02d", i)
+		c.expect("OK", "SET", key, "v")
+
+		want = append(want, key)
+	}
+
+	c.expect("OK", "SET", "secret:1", "v")
+	c.expect("OK", "SET", "deleted", "v")
+	c.expect(int64(1), "DEL", "deleted")
+
+	var (
+		got    []any
+		cursor = "0"
+	)
+
+	for i := 0; ; i++ {
+		reply := c.Do("SCAN", cursor, "COUNT", "7").([]any)
+		cursor = reply[0].(string)
+		got = append(got, reply[1].([]any)...)
+
+		if cursor == "0" {
+			break
+		}
+
+		if i > 10 {
+			t.Fatal("scan does not end")
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %v", got)
+	}
+
+	c.expect([]any{"0", []any{"key:01", "key:11", "key:21"}}, "SCAN", "0", "MATCH", "key:?1", "COUNT", "100")
+	c.expect(redisError("ERR invalid cursor"), "SCAN", "12345")
+}
+
+func TestClientsRateLimited(t *testing.T) {
+	var (
+		controller = admission.New(admission.Config{ClientRate: 1, ClientBurst: 1}, env.NewSimClock(time.Unix(1_700_000_000, 0)))
+		methods    = map[string]struct{}{"/replication.Replication/Put": {}}
+		h          = clustertest.New(t, clustertest.Options{
+			Nodes: 1,
+			ServerOptions: []grpc.ServerOption{
+				grpc.ChainUnaryInterceptor(admission.UnaryServerInterceptor(controller, methods)),
+			},
+		})
+	)
+
+	conn, err := h.Node(1).Dial(context.Background(), grpc.WithPerRPCCredentials(controller.GatewayCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	s := NewServer(h.Node(1).Cluster, nil, kitlog.NewNopLogger())
+	s.SetLocalConn(conn)
+
+	var (
+		addr   = frontendtest.Serve(t, s, ErrServerClosed)
+		first  = &testClient{frontendtest.DialFrom(t, addr, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})}
+		second = &testClient{frontendtest.DialFrom(t, addr, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)})}
+	)
+
+	// Each client is limited on its own, rather than with all the clients of
+	// the server.
+	first.expect("OK", "SET", "a", "1")
+	first.expect(redisError("TRYAGAIN"), "SET", "b", "1")
+	second.expect("OK", "SET", "c", "1")
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"user:**:name", "user:1:name", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("matchGlob(%q, %q) = %v", tt.pattern, tt.s, got)
+		}
+	}
+}
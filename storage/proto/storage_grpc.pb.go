@@ -25,6 +25,9 @@ type StorageServiceClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
 	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (StorageService_ScanClient, error)
+	Usage(ctx context.Context, in *UsageRequest, opts ...grpc.CallOption) (*UsageResponse, error)
+	QueryIndex(ctx context.Context, in *QueryIndexRequest, opts ...grpc.CallOption) (*QueryIndexResponse, error)
+	ChunkRefs(ctx context.Context, in *ChunkRefsRequest, opts ...grpc.CallOption) (*ChunkRefsResponse, error)
 }
 
 type storageServiceClient struct {
@@ -85,6 +88,33 @@ func (x *storageServiceScanClient) Recv() (*ScanResponse, error) {
 	return m, nil
 }
 
+func (c *storageServiceClient) Usage(ctx context.Context, in *UsageRequest, opts ...grpc.CallOption) (*UsageResponse, error) {
+	out := new(UsageResponse)
+	err := c.cc.Invoke(ctx, "/storage.StorageService/Usage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) QueryIndex(ctx context.Context, in *QueryIndexRequest, opts ...grpc.CallOption) (*QueryIndexResponse, error) {
+	out := new(QueryIndexResponse)
+	err := c.cc.Invoke(ctx, "/storage.StorageService/QueryIndex", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageServiceClient) ChunkRefs(ctx context.Context, in *ChunkRefsRequest, opts ...grpc.CallOption) (*ChunkRefsResponse, error) {
+	out := new(ChunkRefsResponse)
+	err := c.cc.Invoke(ctx, "/storage.StorageService/ChunkRefs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StorageServiceServer is the server API for StorageService service.
 // All implementations must embed UnimplementedStorageServiceServer
 // for forward compatibility
@@ -92,6 +122,9 @@ type StorageServiceServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Put(context.Context, *PutRequest) (*PutResponse, error)
 	Scan(*ScanRequest, StorageService_ScanServer) error
+	Usage(context.Context, *UsageRequest) (*UsageResponse, error)
+	QueryIndex(context.Context, *QueryIndexRequest) (*QueryIndexResponse, error)
+	ChunkRefs(context.Context, *ChunkRefsRequest) (*ChunkRefsResponse, error)
 	mustEmbedUnimplementedStorageServiceServer()
 }
 
@@ -108,6 +141,15 @@ func (UnimplementedStorageServiceServer) Put(context.Context, *PutRequest) (*Put
 func (UnimplementedStorageServiceServer) Scan(*ScanRequest, StorageService_ScanServer) error {
 	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
 }
+func (UnimplementedStorageServiceServer) Usage(context.Context, *UsageRequest) (*UsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Usage not implemented")
+}
+func (UnimplementedStorageServiceServer) QueryIndex(context.Context, *QueryIndexRequest) (*QueryIndexResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryIndex not implemented")
+}
+func (UnimplementedStorageServiceServer) ChunkRefs(context.Context, *ChunkRefsRequest) (*ChunkRefsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChunkRefs not implemented")
+}
 func (UnimplementedStorageServiceServer) mustEmbedUnimplementedStorageServiceServer() {}
 
 // UnsafeStorageServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -178,6 +220,60 @@ func (x *storageServiceScanServer) Send(m *ScanResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _StorageService_Usage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).Usage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.StorageService/Usage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).Usage(ctx, req.(*UsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_QueryIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryIndexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).QueryIndex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.StorageService/QueryIndex",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).QueryIndex(ctx, req.(*QueryIndexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StorageService_ChunkRefs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChunkRefsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServiceServer).ChunkRefs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storage.StorageService/ChunkRefs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServiceServer).ChunkRefs(ctx, req.(*ChunkRefsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StorageService_ServiceDesc is the grpc.ServiceDesc for StorageService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -193,6 +289,18 @@ var StorageService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Put",
 			Handler:    _StorageService_Put_Handler,
 		},
+		{
+			MethodName: "Usage",
+			Handler:    _StorageService_Usage_Handler,
+		},
+		{
+			MethodName: "QueryIndex",
+			Handler:    _StorageService_QueryIndex_Handler,
+		},
+		{
+			MethodName: "ChunkRefs",
+			Handler:    _StorageService_ChunkRefs_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
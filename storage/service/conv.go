@@ -16,6 +16,8 @@ func toProtoValues(values []storage.Value) []*proto.VersionedValue {
 			Version:   vclock.Encode(value.Version),
 			Tombstone: value.Tombstone,
 			Data:      value.Data,
+			ExpiresAt: value.ExpiresAt,
+			Chunked:   value.Chunked,
 		})
 	}
 
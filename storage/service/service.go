@@ -5,7 +5,13 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/index"
+	"github.com/sadath-12/keywave/internal/generic"
 	"github.com/sadath-12/keywave/internal/vclock"
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/replication"
 	"github.com/sadath-12/keywave/storage"
 	"github.com/sadath-12/keywave/storage/proto"
 
@@ -67,6 +73,8 @@ func (s *StorageService) Put(ctx context.Context, req *proto.PutRequest) (*proto
 		Version:   version,
 		Data:      req.Value.Data,
 		Tombstone: req.Value.Tombstone,
+		ExpiresAt: req.Value.ExpiresAt,
+		Chunked:   req.Value.Chunked,
 	}
 
 	err = s.storage.Put(req.Key, value)
@@ -75,6 +83,10 @@ func (s *StorageService) Put(ctx context.Context, req *proto.PutRequest) (*proto
 			return nil, status.New(codes.AlreadyExists, "obsolete write").Err()
 		}
 
+		if errors.Is(err, storage.ErrReadOnly) {
+			return nil, status.New(codes.Unavailable, "storage is read-only").Err()
+		}
+
 		return nil, status.New(
 			codes.Internal, fmt.Sprintf("storage put failed: %s", err),
 		).Err()
@@ -85,6 +97,81 @@ func (s *StorageService) Put(ctx context.Context, req *proto.PutRequest) (*proto
 	}, nil
 }
 
+func (s *StorageService) Usage(ctx context.Context, req *proto.UsageRequest) (*proto.UsageResponse, error) {
+	r, ok := s.storage.(namespace.Reporter)
+	if !ok {
+		return nil, errNotSupported
+	}
+
+	usage := r.Usage()
+	resp := &proto.UsageResponse{
+		Namespaces: make(map[string]*proto.NamespaceUsage, len(usage)),
+	}
+
+	for ns, u := range usage {
+		resp.Namespaces[ns] = &proto.NamespaceUsage{
+			Keys:  u.Keys,
+			Bytes: u.Bytes,
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *StorageService) QueryIndex(ctx context.Context, req *proto.QueryIndexRequest) (*proto.QueryIndexResponse, error) {
+	q, ok := s.storage.(index.Querier)
+	if !ok {
+		return nil, errNotSupported
+	}
+
+	r, err := index.NewRange(req.Value, req.Min, req.Max)
+	if err != nil {
+		return nil, status.New(codes.InvalidArgument, err.Error()).Err()
+	}
+
+	items, err := q.Query(req.Namespace, req.Field, r, int(req.Limit))
+	if err != nil {
+		if errors.Is(err, index.ErrNoIndex) {
+			return nil, status.New(codes.NotFound, err.Error()).Err()
+		}
+
+		return nil, status.New(
+			codes.Internal, fmt.Sprintf("index query failed: %s", err),
+		).Err()
+	}
+
+	resp := &proto.QueryIndexResponse{
+		Items: make([]*proto.ScanResponse, 0, len(items)),
+	}
+
+	for _, item := range items {
+		resp.Items = append(resp.Items, &proto.ScanResponse{
+			Key:   item.Key,
+			Value: toProtoValues(item.Values),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *StorageService) ChunkRefs(ctx context.Context, req *proto.ChunkRefsRequest) (*proto.ChunkRefsResponse, error) {
+	st, ok := s.storage.(storage.Scannable)
+	if !ok {
+		return nil, errNotSupported
+	}
+
+	refs, err := chunk.Refs(st)
+	if err != nil {
+		return nil, status.New(
+			codes.Internal, fmt.Sprintf("chunk refs failed: %s", err),
+		).Err()
+	}
+
+	return &proto.ChunkRefsResponse{
+		Hashes: generic.MapKeys(refs),
+	}, nil
+}
+
 func (s *StorageService) Scan(req *proto.ScanRequest, stream proto.StorageService_ScanServer) error {
 	st, ok := s.storage.(storage.Scannable)
 	if !ok {
@@ -92,6 +179,7 @@ func (s *StorageService) Scan(req *proto.ScanRequest, stream proto.StorageServic
 	}
 
 	it := st.Scan(req.StartKey)
+	owned := replicaFilter(req)
 
 	for {
 		if err := it.Next(); err != nil {
@@ -103,6 +191,10 @@ func (s *StorageService) Scan(req *proto.ScanRequest, stream proto.StorageServic
 		}
 
 		key, values := it.Item()
+		if !owned(key) {
+			continue
+		}
+
 		resp := &proto.ScanResponse{
 			Value: toProtoValues(values),
 			Key:   key,
@@ -113,3 +205,43 @@ func (s *StorageService) Scan(req *proto.ScanRequest, stream proto.StorageServic
 		}
 	}
 }
+
+// replicaFilter returns the function reporting whether the key is to be sent
+// by the scan, which is every key unless the scan is limited to the keys of a
+// replica.
+func replicaFilter(req *proto.ScanRequest) func(key string) bool {
+	if req.ReplicaOf == 0 || req.Placement == nil {
+		return func(string) bool { return true }
+	}
+
+	var (
+		self    = membership.NodeID(req.ReplicaOf)
+		factors = req.Placement.ReplicationFactors
+		nodes   = make([]membership.Node, len(req.Placement.Nodes))
+	)
+
+	for i, node := range req.Placement.Nodes {
+		nodes[i] = membership.Node{
+			ID:     membership.NodeID(node.Id),
+			Status: membership.StatusHealthy,
+			Tags:   node.Tags,
+		}
+	}
+
+	return func(key string) bool {
+		name, _, _ := namespace.SplitKey(key)
+
+		n, ok := factors[name]
+		if !ok {
+			n = factors[namespace.Default]
+		}
+
+		for _, node := range replication.ReplicaSet(nodes, key, int(n)) {
+			if node.ID == self {
+				return true
+			}
+		}
+
+		return false
+	}
+}
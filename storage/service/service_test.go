@@ -0,0 +1,63 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sadath-12/keywave/membership"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/replication"
+	"github.com/sadath-12/keywave/storage/proto"
+)
+
+func TestReplicaFilter(t *testing.T) {
+	if owned := replicaFilter(&proto.ScanRequest{}); !owned("key") {
+		t.Fatal("expected a scan without a replica to send every key")
+	}
+
+	var (
+		nodes []membership.Node
+		req   = &proto.ScanRequest{
+			ReplicaOf: 3,
+			Placement: &proto.Placement{
+				ReplicationFactors: map[string]int32{namespace.Default: 2, "all": 4},
+			},
+		}
+	)
+
+	for id := uint32(1); id <= 4; id++ {
+		nodes = append(nodes, membership.Node{ID: membership.NodeID(id), Status: membership.StatusHealthy})
+		req.Placement.Nodes = append(req.Placement.Nodes, &proto.PlacementNode{Id: id})
+	}
+
+	owned := replicaFilter(req)
+
+	var sent int
+
+	for i := 0; i < 100; i++ {
+		key := namespace.Key(namespace.Default, fmt.Sprint("key", i))
+
+		var replica bool
+
+		for _, node := range replication.ReplicaSet(nodes, key, 2) {
+			replica = replica || node.ID == 3
+		}
+
+		if owned(key) != replica {
+			t.Fatalf("expected the key %s to be sent: %v", key, replica)
+		}
+
+		if replica {
+			sent++
+		}
+
+		// Every node is a replica of the keys of the namespace.
+		if !owned(namespace.Key("all", fmt.Sprint("key", i))) {
+			t.Fatalf("expected the key %d of the namespace to be sent", i)
+		}
+	}
+
+	if sent == 0 || sent == 100 {
+		t.Errorf("expected only a part of the keys to be sent, got %d", sent)
+	}
+}
@@ -0,0 +1,63 @@
+// Package stack assembles the storage engine of a node from the engine storing
+// the data and the layers on top of it: the compression of the values, the
+// tracking of the usage of the namespaces and the secondary indexes.
+package stack
+
+import (
+	"sync"
+
+	"github.com/sadath-12/keywave/chunk"
+	"github.com/sadath-12/keywave/compression"
+	"github.com/sadath-12/keywave/index"
+	"github.com/sadath-12/keywave/namespace"
+	"github.com/sadath-12/keywave/storage"
+)
+
+// Engine is the storage engine of a node. It provides the interfaces of the
+// layers, such as the queries of the indexes and the usage of the namespaces.
+type Engine struct {
+	*index.Indexer
+	compressor *compression.Compressor
+
+	mut      sync.RWMutex
+	readOnly bool
+}
+
+// New returns the engine storing the data in the given one. The values are
+// compressed below the usage tracker, so the namespace quotas apply to the size
+// of the values before compression, and the chunked values are charged with
+// the size of their content.
+func New(base storage.Engine, namespaces *namespace.Registry) *Engine {
+	compressor := compression.New(base, namespaces)
+
+	return &Engine{
+		Indexer:    index.New(namespace.NewTracker(compressor, chunk.ValueSize), namespaces),
+		compressor: compressor,
+	}
+}
+
+// CompressionStats returns the statistics of the compression of the values.
+func (e *Engine) CompressionStats() compression.Stats {
+	return e.compressor.Stats()
+}
+
+// Put writes the value unless the engine is read-only.
+func (e *Engine) Put(key string, value storage.Value) error {
+	e.mut.RLock()
+	defer e.mut.RUnlock()
+
+	if e.readOnly {
+		return storage.ErrReadOnly
+	}
+
+	return e.Indexer.Put(key, value)
+}
+
+// SetReadOnly makes the engine reject the writes, or accept them again. The
+// writes in progress are complete once it returns.
+func (e *Engine) SetReadOnly(readOnly bool) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.readOnly = readOnly
+}
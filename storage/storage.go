@@ -16,6 +16,9 @@ var (
 	ErrObsolete = errors.New("obsolete write")
 	// ErrNoMoreItems is returned when there are no more items in the iterator.
 	ErrNoMoreItems = errors.New("no more items in the iterator")
+	// ErrReadOnly is returned when a write operation is performed on a storage
+	// that no longer accepts writes.
+	ErrReadOnly = errors.New("storage is read-only")
 )
 
 // Value represents a single value associated with a key.
@@ -23,6 +26,16 @@ type Value struct {
 	Version   vclock.Version
 	Data      []byte
 	Tombstone bool
+	// ExpiresAt is the unix time in milliseconds after which the value is considered
+	// deleted. Zero means that the value never expires.
+	ExpiresAt int64
+	// Chunked is set if the data is the manifest of a value stored in chunks.
+	Chunked bool
+}
+
+// Expired reports whether the value has expired at the given unix time in milliseconds.
+func (v *Value) Expired(nowMillis int64) bool {
+	return v.ExpiresAt != 0 && v.ExpiresAt <= nowMillis
 }
 
 // Engine is the interface that wraps the basic storage operations. It is implemented by
@@ -43,6 +56,13 @@ type Scannable interface {
 	Scan(key string) ScanIterator
 }
 
+// Freezable is a storage that can stop accepting writes, e.g. while the node hands
+// its data off to the other nodes before leaving the cluster. Once frozen, the
+// writes fail with ErrReadOnly.
+type Freezable interface {
+	SetReadOnly(readOnly bool)
+}
+
 // ScanIterator is the interface for iterating over the key-value pairs in the storage,
 // in lexicographical order. It is not usually safe for concurrent use, so we must create
 // a new iterator for each goroutine.